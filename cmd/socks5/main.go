@@ -0,0 +1,275 @@
+// Command socks5 runs a SOCKS5 proxy server allowing CONNECT and BIND.
+//
+//	socks5 -address :1080 -auth userpass -users users.txt
+//
+// The users file holds one "username:password" pair per line; blank lines and
+// lines starting with '#' are ignored. Passwords are stored in plain text.
+//
+// SIGINT and SIGTERM stop accepting new connections; open connections are
+// given up to -shutdown-timeout to finish before the command exits.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+var errInvalidCredentials = errors.New("invalid username or password")
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	if err := run(os.Args[1:], logger); err != nil {
+		logger.Error("exiting", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run parses args, serves until SIGINT or SIGTERM and waits for open
+// connections to finish.
+func run(args []string, logger *slog.Logger) error {
+	fs := flag.NewFlagSet("socks5", flag.ContinueOnError)
+	network := fs.String("network", "tcp", "network to listen on")
+	address := fs.String("address", ":1080", "address to listen on")
+	auth := fs.String("auth", "none", "authentication method: none or userpass")
+	usersPath := fs.String("users", "", "file of username:password lines for -auth=userpass")
+	bindTimeout := fs.Duration("bind-timeout", 10*time.Second, "how long BIND waits for the incoming connection")
+	relayTimeout := fs.Duration("relay-timeout", 60*time.Second, "how long a relayed connection may stay idle")
+	maxConns := fs.Int("max-conns", 0, "maximum number of concurrent connections (0=unlimited)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 10*time.Second, "how long to wait for open connections on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	base := &socks5.BaseServerHandler{
+		RequestTimeout:     10 * time.Second,
+		BindAcceptTimeout:  *bindTimeout,
+		BindConnTimeout:    *relayTimeout,
+		ConnectConnTimeout: *relayTimeout,
+		AllowConnect:       true,
+		AllowBind:          true,
+		Logger:             slog.New(slog.DiscardHandler), // events are logged by handler
+	}
+
+	switch *auth {
+	case "none":
+		base.SupportedMethods = []byte{socks5.MethodNoAuth}
+	case "userpass":
+		if *usersPath == "" {
+			return errors.New("-auth=userpass requires -users")
+		}
+		users, err := loadUsers(*usersPath)
+		if err != nil {
+			return err
+		}
+		base.SupportedMethods = []byte{socks5.MethodUserPass}
+		base.UserPassAuthenticator = users.authenticate
+	default:
+		return fmt.Errorf("unknown -auth %q (want none or userpass)", *auth)
+	}
+
+	h := &handler{BaseServerHandler: base, logger: logger}
+	base.ConnectHandler = h.connect
+
+	ln, err := net.Listen(*network, *address)
+	if err != nil {
+		return err
+	}
+	tl := newTrackingListener(ln, *maxConns)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("listening", "network", ln.Addr().Network(), "address", ln.Addr().String())
+	if err := socks5.Serve(ctx, tl, h); err != nil {
+		return err
+	}
+
+	logger.Info("shutting down", "open_conns", tl.open.Load())
+	if !tl.wait(*shutdownTimeout) {
+		logger.Warn("shutdown timeout reached", "open_conns", tl.open.Load())
+	}
+	return nil
+}
+
+// users maps usernames to passwords.
+type users map[string]string
+
+// loadUsers reads a file of "username:password" lines.
+func loadUsers(path string) (users, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	u := make(users)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		name, pass, ok := strings.Cut(text, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%s:%d: expected username:password", path, line)
+		}
+		u[name] = pass
+	}
+	return u, sc.Err()
+}
+
+// authenticate is a BaseServerHandler.UserPassAuthenticator.
+func (u users) authenticate(ctx context.Context, username, password string) error {
+	want, ok := u[username]
+	if subtle.ConstantTimeCompare([]byte(want), []byte(password)) != 1 || !ok {
+		return errInvalidCredentials
+	}
+	return nil
+}
+
+// handler logs connection events with the connection ID assigned by
+// trackingListener.
+type handler struct {
+	*socks5.BaseServerHandler
+	logger *slog.Logger
+}
+
+func (h *handler) OnAccept(ctx context.Context, conn net.Conn) error {
+	h.logger.InfoContext(ctx, "accepted", "conn", connID(conn), "from", conn.RemoteAddr())
+	return h.BaseServerHandler.OnAccept(ctx, conn)
+}
+
+func (h *handler) OnRequest(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+	user, _ := socks5.UsernameFromContext(ctx)
+	h.logger.InfoContext(ctx, "request", "conn", connID(conn), "command", socks5.Command(req.Command), "target", req.Addr(), "user", user)
+	return h.BaseServerHandler.OnRequest(ctx, conn, req)
+}
+
+// connect is the BaseServerHandler.ConnectHandler.
+func (h *handler) connect(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+	start := time.Now()
+	err := h.DefaultConnect(ctx, conn, req)
+	h.logger.InfoContext(ctx, "connect finished", "conn", connID(conn), "target", req.Addr(), "duration", time.Since(start), "error", err)
+	return err
+}
+
+func (h *handler) OnError(ctx context.Context, conn net.Conn, err error) {
+	h.logger.ErrorContext(ctx, "error", "conn", connID(conn), "error", err)
+}
+
+func (h *handler) OnPanic(ctx context.Context, conn net.Conn, r any) {
+	h.logger.ErrorContext(ctx, "panic", "conn", connID(conn), "panic", r)
+}
+
+// trackingListener numbers accepted connections, limits how many are open at
+// once and lets shutdown wait for them to close.
+type trackingListener struct {
+	net.Listener
+	sem    chan struct{} // nil=unlimited
+	nextID atomic.Uint64
+	open   atomic.Int64
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func newTrackingListener(ln net.Listener, maxConns int) *trackingListener {
+	l := &trackingListener{Listener: ln, done: make(chan struct{})}
+	if maxConns > 0 {
+		l.sem = make(chan struct{}, maxConns)
+	}
+	return l
+}
+
+// Accept waits for a free connection slot, then for the next connection.
+func (l *trackingListener) Accept() (net.Conn, error) {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-l.done:
+			return nil, net.ErrClosed
+		}
+	}
+
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		if l.sem != nil {
+			<-l.sem
+		}
+		return nil, err
+	}
+
+	l.wg.Add(1)
+	l.open.Add(1)
+	return &trackedConn{Conn: conn, id: l.nextID.Add(1), l: l}, nil
+}
+
+func (l *trackingListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// wait waits up to timeout for all accepted connections to close and reports
+// whether they did.
+func (l *trackingListener) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// trackedConn is a connection accepted by trackingListener.
+type trackedConn struct {
+	net.Conn
+	id        uint64
+	l         *trackingListener
+	closeOnce sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.l.open.Add(-1)
+		if c.l.sem != nil {
+			<-c.l.sem
+		}
+		c.l.wg.Done()
+	})
+	return err
+}
+
+// connID returns the ID of a connection accepted by trackingListener, or 0.
+func connID(conn net.Conn) uint64 {
+	if c, ok := conn.(*trackedConn); ok {
+		return c.id
+	}
+	return 0
+}
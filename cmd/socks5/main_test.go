@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// TestMain runs the command instead of the tests when the test binary is
+// re-executed by startCommand.
+func TestMain(m *testing.M) {
+	if os.Getenv("SOCKS5_CMD_RUN") == "1" {
+		if err := run(os.Args[1:], slog.New(slog.NewTextHandler(os.Stderr, nil))); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// command is the command running in a subprocess.
+type command struct {
+	cmd  *exec.Cmd
+	addr string        // address the command listens on
+	logs *syncBuffer   // log output
+	eof  chan struct{} // closed once all log output has been read
+}
+
+// startCommand runs the command with args in a subprocess.
+func startCommand(t *testing.T, args ...string) *command {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], append([]string{"-address", "127.0.0.1:0"}, args...)...)
+	cmd.Env = append(os.Environ(), "SOCKS5_CMD_RUN=1")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatalf("stderr pipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addrCh := make(chan string, 1)
+	c := &command{cmd: cmd, logs: &syncBuffer{}, eof: make(chan struct{})}
+	go func() {
+		defer close(c.eof)
+
+		sc := bufio.NewScanner(stderr)
+		for sc.Scan() {
+			line := sc.Text()
+			c.logs.Write([]byte(line + "\n"))
+			if strings.Contains(line, "msg=listening") {
+				for _, field := range strings.Fields(line) {
+					if addr, ok := strings.CutPrefix(field, "address="); ok {
+						addrCh <- addr
+					}
+				}
+			}
+		}
+	}()
+
+	select {
+	case c.addr = <-addrCh:
+		return c
+	case <-time.After(10 * time.Second):
+		t.Fatalf("command did not start listening; output:\n%s", c.logs)
+		return nil
+	}
+}
+
+// stop sends SIGTERM and waits for the command to exit cleanly.
+func (c *command) stop(t *testing.T) {
+	t.Helper()
+
+	if err := c.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("signal: %v", err)
+	}
+
+	select {
+	case <-c.eof:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("command did not exit after SIGTERM; output:\n%s", c.logs)
+	}
+	if err := c.cmd.Wait(); err != nil {
+		t.Fatalf("command exited with %v; output:\n%s", err, c.logs)
+	}
+}
+
+// echoTarget starts a TCP echo server and returns its address.
+func echoTarget(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func echo(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("expected ping, got %q", buf)
+	}
+}
+
+func TestCommand_NoAuth(t *testing.T) {
+	target := echoTarget(t)
+	c := startCommand(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := socks5.NewDialer(c.addr, nil, nil).DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatalf("dial through command: %v", err)
+	}
+	echo(t, conn)
+	conn.Close()
+
+	c.stop(t)
+
+	out := c.logs.String()
+	for _, want := range []string{"msg=accepted conn=1", "msg=request conn=1 command=CONNECT", "msg=\"connect finished\" conn=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCommand_UserPass(t *testing.T) {
+	users := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(users, []byte("# proxy users\nalice:secret\n\nbob:hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	target := echoTarget(t)
+	c := startCommand(t, "-auth", "userpass", "-users", users)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := socks5.NewDialer(c.addr, &socks5.Auth{Username: "alice", Password: "secret"}, nil).DialContext(ctx, "tcp", target)
+	if err != nil {
+		t.Fatalf("dial as alice: %v", err)
+	}
+	echo(t, conn)
+	conn.Close()
+
+	if _, err := socks5.NewDialer(c.addr, &socks5.Auth{Username: "alice", Password: "wrong"}, nil).DialContext(ctx, "tcp", target); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	if _, err := socks5.NewDialer(c.addr, nil, nil).DialContext(ctx, "tcp", target); err == nil {
+		t.Fatal("expected unauthenticated dial to be rejected")
+	}
+
+	c.stop(t)
+}
+
+func TestCommand_InvalidFlags(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	for _, args := range [][]string{
+		{"-auth", "gssapi"},
+		{"-auth", "userpass"},
+		{"-auth", "userpass", "-users", filepath.Join(t.TempDir(), "missing")},
+	} {
+		if err := run(args, logger); err == nil {
+			t.Errorf("run(%q): expected error", args)
+		}
+	}
+}
+
+func TestLoadUsers_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, []byte("alice:secret\nbob\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadUsers(path); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Fatalf("expected error for line 2, got %v", err)
+	}
+}
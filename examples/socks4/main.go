@@ -1,19 +1,41 @@
 // Simple SOCKS4 server using the base handler allowing CONNECT and BIND commands with default timeouts and buffer size.
+// Pass -audit FILE to append a JSON audit record per connection.
 package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
 
+	socksnet "github.com/33TU/socks/net"
 	"github.com/33TU/socks/socks4"
 )
 
 func main() {
+	auditPath := flag.String("audit", "", "append a JSON line per connection to this file")
+	flag.Parse()
+
 	handler := &socks4.BaseServerHandler{
 		AllowConnect: true,
 		AllowBind:    true,
 	}
 
+	if *auditPath != "" {
+		f, err := os.OpenFile(*auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		audit := socksnet.NewAuditLog(f)
+		handler.OnAudit = func(ctx context.Context, rec *socks4.AuditRecord) {
+			if err := audit.Write(rec); err != nil {
+				log.Printf("audit: %v", err)
+			}
+		}
+	}
+
 	log.Println("SOCKS4 listening on 127.0.0.1:1080")
 
 	if err := socks4.ListenAndServe(context.Background(), "tcp", "127.0.0.1:1080", handler); err != nil {
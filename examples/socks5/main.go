@@ -1,14 +1,21 @@
 // Simple SOCKS5 server using the base handler allowing CONNECT, BIND, UDP ASSOCIATE, and RESOLVE commands with default timeouts and buffer size.
+// Pass -audit FILE to append a JSON audit record per connection.
 package main
 
 import (
 	"context"
+	"flag"
 	"log"
+	"os"
 
+	socksnet "github.com/33TU/socks/net"
 	"github.com/33TU/socks/socks5"
 )
 
 func main() {
+	auditPath := flag.String("audit", "", "append a JSON line per connection to this file")
+	flag.Parse()
+
 	handler := &socks5.BaseServerHandler{
 		AllowConnect:      true,
 		AllowBind:         true,
@@ -16,6 +23,21 @@ func main() {
 		AllowResolve:      true,
 	}
 
+	if *auditPath != "" {
+		f, err := os.OpenFile(*auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+
+		audit := socksnet.NewAuditLog(f)
+		handler.OnAudit = func(ctx context.Context, rec *socks5.AuditRecord) {
+			if err := audit.Write(rec); err != nil {
+				log.Printf("audit: %v", err)
+			}
+		}
+	}
+
 	log.Println("SOCKS5 listening on 127.0.0.1:1080")
 
 	if err := socks5.ListenAndServe(context.Background(), "tcp", "127.0.0.1:1080", handler); err != nil {
@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrTrailingData is returned by UnmarshalBinary when data continues past the end of the message.
+var ErrTrailingData = errors.New("trailing data after message")
+
+// MarshalBinary returns the bytes m writes with WriteTo.
+func MarshalBinary(m io.WriterTo) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes data with m's ReadFrom. The message must span all of data;
+// a short message is io.ErrUnexpectedEOF and a longer input is ErrTrailingData.
+func UnmarshalBinary(m io.ReaderFrom, data []byte) error {
+	n, err := m.ReadFrom(bytes.NewReader(data))
+	if err != nil {
+		return UnexpectedEOF(err)
+	}
+	if n != int64(len(data)) {
+		return ErrTrailingData
+	}
+	return nil
+}
@@ -55,6 +55,15 @@ func PutBytes(b []byte) {
 	bytesPool[i].Put(b[:1<<i])
 }
 
+// ReuseBytes returns a slice of n bytes, reusing the capacity of b if b is
+// empty. A non-empty b may be shared with the caller and is never written to.
+func ReuseBytes(b []byte, n int) []byte {
+	if len(b) == 0 && cap(b) >= n {
+		return b[:n]
+	}
+	return make([]byte, n)
+}
+
 func ceilLog2(n int) int {
 	if n <= 1 {
 		return 0
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidDomainName reports whether name is a DNS host name as RFC 1035 and
+// RFC 1123 define it: at most 253 bytes plus an optional trailing dot, made of
+// dot-separated labels of 1-63 letters, digits and hyphens that neither start
+// nor end with a hyphen. allowUnderscore also accepts '_' within labels.
+func ValidDomainName(name string, allowUnderscore bool) bool {
+	name = strings.TrimSuffix(name, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+
+	labelLen := 0
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '.':
+			if labelLen == 0 || name[i-1] == '-' {
+				return false
+			}
+			labelLen = 0
+			continue
+		case c == '-':
+			if labelLen == 0 {
+				return false
+			}
+		case c == '_' && allowUnderscore:
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		default:
+			return false
+		}
+
+		if labelLen++; labelLen > 63 {
+			return false
+		}
+	}
+
+	return name[len(name)-1] != '-'
+}
+
+// DomainToASCII converts the non-ASCII labels of name to their IDNA ASCII form
+// ("xn--" followed by the punycode encoding of the lower-cased label, RFC 3492).
+// Unicode full stops are accepted as label separators. Names that are already
+// ASCII are returned unchanged. No Unicode normalization is applied, and the
+// result still needs validating with ValidDomainName.
+func DomainToASCII(name string) string {
+	if isASCII(name) {
+		return name
+	}
+
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '。', '．', '｡': // ideographic, fullwidth and halfwidth full stops
+			return '.'
+		}
+		return r
+	}, strings.ToLower(name))
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if !isASCII(label) {
+			labels[i] = "xn--" + punycodeEncode(label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// isASCII reports whether s contains only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Punycode parameters (RFC 3492 §5).
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punycodeEncode returns the punycode encoding of s (RFC 3492 §6.3).
+func punycodeEncode(s string) string {
+	runes := []rune(s)
+
+	out := make([]byte, 0, len(s)+8)
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+
+	b := len(out)
+	if b > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for h := b; h < len(runes); {
+		// the smallest code point not yet handled
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+
+		delta += (m - n) * (h + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := min(max(k-bias, punyTMin), punyTMax)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+
+			bias = punyAdapt(delta, h+1, h == b)
+			delta = 0
+			h++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(out)
+}
+
+// punyAdapt is the bias adaptation function (RFC 3492 §6.1).
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// punyDigit returns the basic code point for digit d (0-35).
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidDomainName(t *testing.T) {
+	tests := []struct {
+		name       string
+		valid      bool
+		underscore bool // valid when underscores are allowed
+	}{
+		{"example.com", true, true},
+		{"example.com.", true, true},
+		{"a", true, true},
+		{"123.example", true, true},
+		{"xn--bcher-kva.example", true, true},
+		{"a-b.c-d", true, true},
+		{strings.Repeat("a", 63) + ".com", true, true},
+		{strings.Repeat("a", 64) + ".com", false, false},
+		{strings.Repeat("a.", 126) + "a", true, true},
+		{strings.Repeat("a.", 127) + "a", false, false},
+		{"", false, false},
+		{".", false, false},
+		{"a..b", false, false},
+		{".a", false, false},
+		{"-a.com", false, false},
+		{"a-.com", false, false},
+		{"a.com-", false, false},
+		{"ex ample.com", false, false},
+		{"exa\x00mple.com", false, false},
+		{"bücher.example", false, false},
+		{"_dmarc.example.com", false, true},
+		{"my_host.local", false, true},
+	}
+
+	for _, tt := range tests {
+		if got := ValidDomainName(tt.name, false); got != tt.valid {
+			t.Errorf("ValidDomainName(%q, false) = %v, want %v", tt.name, got, tt.valid)
+		}
+		if got := ValidDomainName(tt.name, true); got != tt.underscore {
+			t.Errorf("ValidDomainName(%q, true) = %v, want %v", tt.name, got, tt.underscore)
+		}
+	}
+}
+
+func TestDomainToASCII(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM", "Example.COM"}, // ASCII names are left alone
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"München.de", "xn--mnchen-3ya.de"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"例え。テスト", "xn--r8jz45g.xn--zckzah"},
+		{"ليهمابتكلموشعربي؟", "xn--egbpdaj6bu4bxfgehfvwxn"},
+		{"他们为什么不说中文", "xn--ihqwcrb4cv8a8dqg056pqjye"},
+	}
+
+	for _, tt := range tests {
+		if got := DomainToASCII(tt.in); got != tt.want {
+			t.Errorf("DomainToASCII(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,26 +1,46 @@
 package internal
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 // A LimitedReader reads from R but limits the amount of
 // data returned to just N bytes. Each call to Read
 // updates N to reflect the new amount remaining.
-// Read returns EOF when N <= 0 or when the underlying R returns EOF.
-// See std io.LimitedReader for details.
+// Read returns EOF when N <= 0 or when the underlying R returns EOF;
+// Exceeded tells the two apart. See std io.LimitedReader for details.
 type LimitedReader struct {
 	R io.Reader // underlying reader
 	N int64     // max bytes remaining
+
+	exceeded bool
 }
 
 // Init initializes a LimitedReader.
 func (r *LimitedReader) Init(src io.Reader, n int64) {
 	r.R = src
+	r.ResetN(n)
+}
+
+// ResetN sets the remaining budget to n and clears Exceeded, keeping R. It lets
+// one LimitedReader bound several consecutive fields of a message.
+func (r *LimitedReader) ResetN(n int64) {
 	r.N = n
+	r.exceeded = false
+}
+
+// Exceeded reports whether a Read was refused because the budget was used up,
+// i.e. the caller wanted more than N bytes. It stays false when R ends first,
+// including when R ends exactly at the limit and no further Read is made.
+func (l *LimitedReader) Exceeded() bool {
+	return l.exceeded
 }
 
 // Read reads up to len(p) bytes from the reader into p.
 func (l *LimitedReader) Read(p []byte) (n int, err error) {
 	if l.N <= 0 {
+		l.exceeded = true
 		return 0, io.EOF
 	}
 	if int64(len(p)) > l.N {
@@ -30,3 +50,42 @@ func (l *LimitedReader) Read(p []byte) (n int, err error) {
 	l.N -= int64(n)
 	return
 }
+
+// readResult is the outcome of a Read performed in the background.
+type readResult struct {
+	b   []byte
+	err error
+}
+
+// ReadContext is like Read but returns ctx.Err() as soon as ctx is done, even if
+// the underlying Read is still blocked. The blocked Read completes in the background
+// and its data is discarded.
+func (l *LimitedReader) ReadContext(ctx context.Context, p []byte) (n int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if l.N <= 0 {
+		l.exceeded = true
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.N {
+		p = p[0:l.N]
+	}
+
+	// Read into a private buffer so p is never written after we return.
+	ch := make(chan readResult, 1)
+	go func(r io.Reader, size int) {
+		b := make([]byte, size)
+		n, err := r.Read(b)
+		ch <- readResult{b[:n], err}
+	}(l.R, len(p))
+
+	select {
+	case res := <-ch:
+		n = copy(p, res.b)
+		l.N -= int64(n)
+		return n, res.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader blocks for delay before each Read.
+type slowReader struct {
+	delay time.Duration
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return copy(p, "data"), nil
+}
+
+func TestLimitedReader_ReadContext(t *testing.T) {
+	var lr LimitedReader
+	lr.Init(strings.NewReader("hello world"), 5)
+
+	buf := make([]byte, 16)
+	n, err := lr.ReadContext(context.Background(), buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("ReadContext = (%q, %v), want (\"hello\", nil)", buf[:n], err)
+	}
+	if _, err := lr.ReadContext(context.Background(), buf); err != io.EOF {
+		t.Fatalf("expected io.EOF after limit, got %v", err)
+	}
+}
+
+func TestLimitedReader_ReadContext_Cancel(t *testing.T) {
+	var lr LimitedReader
+	lr.Init(slowReader{delay: 500 * time.Millisecond}, 64)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var cancelled time.Time
+	time.AfterFunc(20*time.Millisecond, func() {
+		cancelled = time.Now()
+		cancel()
+	})
+
+	buf := make([]byte, 16)
+	n, err := lr.ReadContext(ctx, buf)
+	returned := time.Now()
+
+	if !errors.Is(err, context.Canceled) || n != 0 {
+		t.Fatalf("ReadContext = (%d, %v), want (0, context.Canceled)", n, err)
+	}
+	if d := returned.Sub(cancelled); d > 10*time.Millisecond {
+		t.Errorf("ReadContext returned %v after cancel, want within 10ms", d)
+	}
+	if lr.N != 64 {
+		t.Errorf("N = %d, want 64 after cancelled read", lr.N)
+	}
+}
+
+func TestLimitedReader_Exceeded(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		n        int64
+		want     string
+		exceeded bool
+	}{
+		{"source shorter than limit", "abc", 5, "abc", false},
+		{"source ends at limit", "abcde", 5, "abcde", true},
+		{"source longer than limit", "abcdefgh", 5, "abcde", true},
+		{"empty source", "", 5, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lr LimitedReader
+			lr.Init(strings.NewReader(tt.src), tt.n)
+
+			b, err := io.ReadAll(&lr)
+			if err != nil || string(b) != tt.want {
+				t.Fatalf("ReadAll = (%q, %v), want (%q, nil)", b, err, tt.want)
+			}
+			if lr.Exceeded() != tt.exceeded {
+				t.Fatalf("Exceeded = %v, want %v", lr.Exceeded(), tt.exceeded)
+			}
+		})
+	}
+
+	// reading exactly up to a limit the source also ends at is not exceeding it
+	var lr LimitedReader
+	lr.Init(strings.NewReader("abcde"), 5)
+	if _, err := io.ReadFull(&lr, make([]byte, 5)); err != nil || lr.Exceeded() {
+		t.Fatalf("ReadFull = %v, Exceeded = %v, want nil and false", err, lr.Exceeded())
+	}
+}
+
+func TestLimitedReader_ResetN(t *testing.T) {
+	var lr LimitedReader
+	lr.Init(strings.NewReader("abcdefgh"), 3)
+
+	buf := make([]byte, 8)
+	if n, _ := io.ReadFull(&lr, buf); n != 3 || !lr.Exceeded() {
+		t.Fatalf("first field: read %d bytes, Exceeded = %v, want 3 and true", n, lr.Exceeded())
+	}
+
+	lr.ResetN(10)
+	if lr.Exceeded() {
+		t.Fatal("Exceeded still set after ResetN")
+	}
+	b, err := io.ReadAll(&lr)
+	if err != nil || string(b) != "defgh" || lr.Exceeded() {
+		t.Fatalf("second field = (%q, %v), Exceeded = %v, want (\"defgh\", nil) and false", b, err, lr.Exceeded())
+	}
+}
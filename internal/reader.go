@@ -25,3 +25,31 @@ func PutReader(r *bufio.Reader) {
 	r.Reset(nil)
 	readerPool.Put(r)
 }
+
+// UnexpectedEOF returns io.ErrUnexpectedEOF if err is io.EOF, and err otherwise.
+// Use it for reads after the first byte of a message, where EOF means the message was truncated.
+func UnexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// ReadN reads exactly n bytes from src with io.ReadFull semantics, returning the
+// bytes read even on error. From a *bufio.Reader the bytes are peeked in place
+// without allocating and stay valid only until its next read; other readers get
+// a new slice.
+func ReadN(src io.Reader, n int) ([]byte, error) {
+	if br, ok := src.(*bufio.Reader); ok && n <= br.Size() {
+		b, err := br.Peek(n)
+		br.Discard(len(b))
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return b, err
+	}
+
+	b := make([]byte, n)
+	m, err := io.ReadFull(src, b)
+	return b[:m], err
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadN(t *testing.T) {
+	readers := map[string]func(s string) io.Reader{
+		"bufio":  func(s string) io.Reader { return bufio.NewReaderSize(strings.NewReader(s), 16) },
+		"reader": func(s string) io.Reader { return strings.NewReader(s) },
+	}
+
+	for name, newReader := range readers {
+		t.Run(name, func(t *testing.T) {
+			src := newReader("hello world")
+
+			b, err := ReadN(src, 5)
+			if err != nil || string(b) != "hello" {
+				t.Fatalf("ReadN = (%q, %v), want (\"hello\", nil)", b, err)
+			}
+			if b, err = ReadN(src, 10); err != io.ErrUnexpectedEOF || string(b) != " world" {
+				t.Fatalf("short ReadN = (%q, %v), want (\" world\", io.ErrUnexpectedEOF)", b, err)
+			}
+			if b, err = ReadN(src, 1); err != io.EOF || len(b) != 0 {
+				t.Fatalf("ReadN at EOF = (%q, %v), want (\"\", io.EOF)", b, err)
+			}
+		})
+	}
+
+	// reads larger than the bufio.Reader buffer fall back to copying
+	src := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 40)), 16)
+	if b, err := ReadN(src, 40); err != nil || len(b) != 40 {
+		t.Fatalf("ReadN beyond buffer = (%d bytes, %v), want (40, nil)", len(b), err)
+	}
+}
@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// RedactIdentifier returns a short, stable hash of id for logs, or "" if id is empty.
+// Equal identifiers hash equally, so log lines can still be correlated.
+func RedactIdentifier(id string) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// writerPool is a pool of bufio.Writer.
+var writerPool = sync.Pool{
+	New: func() any {
+		return bufio.NewWriterSize(nil, 4096)
+	},
+}
+
+// GetWriter returns a writer from the pool and resets it to the provided writer.
+func GetWriter(w io.Writer) *bufio.Writer {
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+// PutWriter returns a writer to the pool and resets it.
+// Buffered data that was not flushed is discarded.
+func PutWriter(w *bufio.Writer) {
+	w.Reset(nil)
+	writerPool.Put(w)
+}
+
+// FlushWriter flushes w and returns the number of bytes written to the underlying writer.
+func FlushWriter(w *bufio.Writer) (int64, error) {
+	n := w.Buffered()
+	err := w.Flush()
+	return int64(n - w.Buffered()), err
+}
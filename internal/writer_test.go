@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := GetWriter(&buf)
+	if w.Size() != 4096 {
+		t.Fatalf("Size() = %d, want 4096", w.Size())
+	}
+	w.WriteString("hello")
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	if buf.String() != "hello" {
+		t.Fatalf("got %q, want %q", buf.String(), "hello")
+	}
+	PutWriter(w)
+}
+
+func TestPutWriter_Reset(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := GetWriter(&buf)
+	w.WriteString("pending")
+	PutWriter(w)
+
+	// unflushed data is discarded
+	if w.Buffered() != 0 {
+		t.Fatalf("Buffered() = %d after PutWriter, want 0", w.Buffered())
+	}
+
+	// the writer no longer refers to buf: flushing new data hits the nil writer
+	w.WriteString("late")
+	func() {
+		defer func() { recover() }()
+		w.Flush()
+	}()
+	if buf.Len() != 0 {
+		t.Fatalf("underlying writer received %q after PutWriter", buf.String())
+	}
+}
@@ -0,0 +1,181 @@
+//go:build interop
+
+package interop_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds every exchange with the proxy under test.
+const testTimeout = 10 * time.Second
+
+// proxyConfig is a proxy under test, parsed from an environment variable.
+type proxyConfig struct {
+	Addr     string
+	User     string
+	Password string
+	HasAuth  bool
+}
+
+// proxyFromEnv returns the proxy configured in the named variable, or skips the test.
+func proxyFromEnv(t *testing.T, name string) proxyConfig {
+	t.Helper()
+
+	raw := os.Getenv(name)
+	if raw == "" {
+		t.Skipf("%s not set", name)
+	}
+	if !strings.Contains(raw, "://") {
+		return proxyConfig{Addr: raw}
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if u.Port() == "" {
+		t.Fatalf("%s: missing port in %q", name, raw)
+	}
+
+	cfg := proxyConfig{Addr: u.Host}
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		cfg.Password, cfg.HasAuth = u.User.Password()
+	}
+	return cfg
+}
+
+// targetHost returns the address the proxy uses to reach the test's listeners.
+func targetHost() string {
+	if h := os.Getenv("SOCKS_INTEROP_TARGET_HOST"); h != "" {
+		return h
+	}
+	return "127.0.0.1"
+}
+
+// listenHost returns the local address the test's listeners bind to.
+func listenHost() string {
+	if ip := net.ParseIP(targetHost()); ip != nil && ip.IsLoopback() {
+		return "127.0.0.1"
+	}
+	return "" // all interfaces, so a remote proxy can connect
+}
+
+// targetAddr returns the proxy-side address of a local listener.
+func targetAddr(addr net.Addr) string {
+	_, port, _ := net.SplitHostPort(addr.String())
+	return net.JoinHostPort(targetHost(), port)
+}
+
+// startTCPEcho starts a TCP echo server and returns its proxy-side address.
+func startTCPEcho(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(listenHost(), "0"))
+	if err != nil {
+		t.Fatalf("listen echo: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	return targetAddr(ln.Addr())
+}
+
+// startUDPEcho starts a UDP echo server and returns it with its proxy-side address.
+func startUDPEcho(t *testing.T) (*net.UDPConn, string) {
+	t.Helper()
+
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(listenHost())})
+	if err != nil {
+		t.Fatalf("listen UDP echo: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	return pc, targetAddr(pc.LocalAddr())
+}
+
+// closedPort returns a proxy-side address on which nothing is listening.
+func closedPort(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(listenHost(), "0"))
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := targetAddr(ln.Addr())
+	ln.Close()
+	return addr
+}
+
+// testContext returns a context bounded by testTimeout.
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// randomPayload returns n random bytes.
+func randomPayload(n int) []byte {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return buf
+}
+
+// echoRoundTrip writes size random bytes through conn and verifies they are echoed back.
+// Writing and reading run concurrently so payloads larger than the socket buffers work.
+func echoRoundTrip(t *testing.T, conn net.Conn, size int) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	payload := randomPayload(size)
+	werr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(payload)
+		werr <- err
+	}()
+
+	got := make([]byte, size)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	if err := <-werr; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("echo mismatch after %d bytes", size)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build interop
+
+package interop_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/33TU/socks/socks4"
+)
+
+// socks4Dialer returns a dialer for SOCKS4_PROXY, sending the URL user as USERID.
+func socks4Dialer(t *testing.T) *socks4.Dialer {
+	t.Helper()
+
+	cfg := proxyFromEnv(t, "SOCKS4_PROXY")
+	return socks4.NewDialer(cfg.Addr, cfg.User, nil)
+}
+
+func TestSOCKS4_Connect(t *testing.T) {
+	d := socks4Dialer(t)
+	target := startTCPEcho(t)
+
+	conn, err := d.DialContext(testContext(t), "tcp", target)
+	if err != nil {
+		t.Fatalf("CONNECT %s: %v", target, err)
+	}
+	defer conn.Close()
+
+	echoRoundTrip(t, conn, 4)
+	echoRoundTrip(t, conn, 1<<20)
+}
+
+// A domain target is sent with the SOCKS4a 0.0.0.x marker and resolved by the proxy.
+func TestSOCKS4a_Connect_Domain(t *testing.T) {
+	d := socks4Dialer(t)
+	if ip := net.ParseIP(targetHost()); ip == nil || !ip.IsLoopback() {
+		t.Skip("domain target needs the proxy on the test host")
+	}
+
+	target := startTCPEcho(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	conn, err := d.DialContext(testContext(t), "tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("CONNECT localhost:%s: %v", port, err)
+	}
+	defer conn.Close()
+
+	echoRoundTrip(t, conn, 512)
+}
+
+func TestSOCKS4_Connect_Refused(t *testing.T) {
+	d := socks4Dialer(t)
+	target := closedPort(t)
+
+	conn, err := d.DialContext(testContext(t), "tcp", target)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("CONNECT to closed port %s succeeded", target)
+	}
+
+	var code socks4.ReplyCode
+	if !errors.As(err, &code) {
+		t.Fatalf("expected a reply code, got %v", err)
+	}
+	t.Logf("closed port reported as %v", code)
+}
@@ -0,0 +1,175 @@
+//go:build interop
+
+package interop_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// socks5Dialer returns a dialer for SOCKS5_PROXY with its credentials, if any.
+func socks5Dialer(t *testing.T) (*socks5.Dialer, proxyConfig) {
+	t.Helper()
+
+	cfg := proxyFromEnv(t, "SOCKS5_PROXY")
+	var auth *socks5.Auth
+	if cfg.HasAuth {
+		auth = &socks5.Auth{Username: cfg.User, Password: cfg.Password}
+	}
+	return socks5.NewDialer(cfg.Addr, auth, nil), cfg
+}
+
+func TestSOCKS5_Connect(t *testing.T) {
+	d, _ := socks5Dialer(t)
+	target := startTCPEcho(t)
+
+	conn, err := d.DialContext(testContext(t), "tcp", target)
+	if err != nil {
+		t.Fatalf("CONNECT %s: %v", target, err)
+	}
+	defer conn.Close()
+
+	echoRoundTrip(t, conn, 4)
+}
+
+// Several megabytes through the relay exercise partial reads and writes on both sides.
+func TestSOCKS5_Connect_LargeTransfer(t *testing.T) {
+	d, _ := socks5Dialer(t)
+	target := startTCPEcho(t)
+
+	conn, err := d.DialContext(testContext(t), "tcp", target)
+	if err != nil {
+		t.Fatalf("CONNECT %s: %v", target, err)
+	}
+	defer conn.Close()
+
+	echoRoundTrip(t, conn, 4<<20)
+}
+
+// Back-to-back connections catch servers that mis-frame the reply, leaving
+// bytes that would corrupt the first read of relayed data.
+func TestSOCKS5_Connect_Repeated(t *testing.T) {
+	d, _ := socks5Dialer(t)
+	target := startTCPEcho(t)
+
+	for i := range 20 {
+		conn, err := d.DialContext(testContext(t), "tcp", target)
+		if err != nil {
+			t.Fatalf("CONNECT #%d: %v", i, err)
+		}
+		echoRoundTrip(t, conn, 1+i*97)
+		conn.Close()
+	}
+}
+
+// A domain target is resolved by the proxy (ATYP=DOMAIN).
+func TestSOCKS5_Connect_Domain(t *testing.T) {
+	d, _ := socks5Dialer(t)
+	if ip := net.ParseIP(targetHost()); ip == nil || !ip.IsLoopback() {
+		t.Skip("domain target needs the proxy on the test host")
+	}
+
+	target := startTCPEcho(t)
+	_, port, _ := net.SplitHostPort(target)
+
+	conn, err := d.DialContext(testContext(t), "tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("CONNECT localhost:%s: %v", port, err)
+	}
+	defer conn.Close()
+
+	echoRoundTrip(t, conn, 512)
+}
+
+func TestSOCKS5_Connect_Refused(t *testing.T) {
+	d, _ := socks5Dialer(t)
+	target := closedPort(t)
+
+	conn, err := d.DialContext(testContext(t), "tcp", target)
+	if err == nil {
+		conn.Close()
+		t.Fatalf("CONNECT to closed port %s succeeded", target)
+	}
+
+	// servers differ in the code they pick, but it must be a well-formed failure reply
+	var code socks5.ReplyCode
+	if !errors.As(err, &code) {
+		t.Fatalf("expected a reply code, got %v", err)
+	}
+	t.Logf("closed port reported as %v", code)
+}
+
+func TestSOCKS5_UserPass(t *testing.T) {
+	d, cfg := socks5Dialer(t)
+	if !cfg.HasAuth {
+		t.Skip("SOCKS5_PROXY has no credentials")
+	}
+	target := startTCPEcho(t)
+
+	conn, err := d.DialContext(testContext(t), "tcp", target)
+	if err != nil {
+		t.Fatalf("CONNECT with credentials: %v", err)
+	}
+	echoRoundTrip(t, conn, 64)
+	conn.Close()
+
+	// wrong credentials must fail during authentication
+	bad := socks5.NewDialer(cfg.Addr, &socks5.Auth{Username: cfg.User, Password: cfg.Password + "-wrong"}, nil)
+	if conn, err := bad.DialContext(testContext(t), "tcp", target); err == nil {
+		conn.Close()
+		t.Fatal("CONNECT with a wrong password succeeded")
+	}
+}
+
+func TestSOCKS5_UDPAssociate(t *testing.T) {
+	d, _ := socks5Dialer(t)
+	if os.Getenv("SOCKS_INTEROP_NO_UDP") != "" {
+		t.Skip("SOCKS_INTEROP_NO_UDP set")
+	}
+	_, target := startUDPEcho(t)
+
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		t.Fatalf("resolve %s: %v", target, err)
+	}
+
+	pc, err := d.ListenPacket(testContext(t), "tcp", nil)
+	if err != nil {
+		var code socks5.ReplyCode
+		if errors.As(err, &code) && code == socks5.RepCommandNotSupported {
+			t.Skip("proxy does not support UDP ASSOCIATE")
+		}
+		t.Fatalf("UDP ASSOCIATE: %v", err)
+	}
+	defer pc.Close()
+
+	buf := make([]byte, 64*1024)
+	for _, size := range []int{1, 512, 1400} {
+		payload := randomPayload(size)
+
+		// UDP may drop datagrams, so allow a few attempts per size
+		var n int
+		for attempt := 0; ; attempt++ {
+			if _, err := pc.WriteTo(payload, targetAddr); err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			pc.SetReadDeadline(time.Now().Add(time.Second))
+			if n, _, err = pc.ReadFrom(buf); err == nil {
+				break
+			}
+			if attempt == 2 {
+				t.Fatalf("no echo for %d-byte datagram: %v", size, err)
+			}
+		}
+
+		if !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("UDP echo mismatch for %d-byte datagram: got %d bytes", size, n)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package net
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes one proxied client connection. Servers assemble it when
+// the connection ends and pass it to the handler's audit hook. Credentials are
+// never recorded; User is the authenticated username, GSSAPI client name, or
+// SOCKS4 user ID.
+type AuditRecord struct {
+	Time      time.Time     `json:"time"`             // When the connection was accepted
+	ID        string        `json:"id"`               // Random identifier of the connection
+	Version   int           `json:"version"`          // SOCKS version, 4 or 5
+	Client    string        `json:"client"`           // Client address
+	Command   string        `json:"cmd,omitempty"`    // Request command, e.g. "CONNECT" (empty if no request was read)
+	Target    string        `json:"target,omitempty"` // Requested host:port
+	User      string        `json:"user,omitempty"`   // Authenticated user
+	Reply     string        `json:"reply,omitempty"`  // Reply code sent to the client (empty if none)
+	BytesUp   int64         `json:"bytes_up"`         // Client-to-target bytes after the reply
+	BytesDown int64         `json:"bytes_down"`       // Target-to-client bytes after the reply
+	Duration  time.Duration `json:"duration_ns"`      // Connection lifetime
+	Error     string        `json:"error,omitempty"`  // Error that ended the connection, if any
+	Phase     string        `json:"phase,omitempty"`  // Phase the Error occurred in, e.g. "auth" (see Phase)
+}
+
+// NewAuditID returns a random identifier for an AuditRecord.
+func NewAuditID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// AuditLog writes AuditRecords to w as JSON lines. It is safe for concurrent use.
+type AuditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewAuditLog returns an AuditLog writing to w, typically a file opened with os.O_APPEND.
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{enc: json.NewEncoder(w)}
+}
+
+// Write appends rec as a single line.
+func (l *AuditLog) Write(rec *AuditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enc.Encode(rec)
+}
@@ -0,0 +1,44 @@
+package net
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// AuditConn wraps a client connection to record the reply code sent to it and
+// the bytes relayed after the reply. The first Replies writes are taken to be
+// protocol replies, which carry their code in the second byte in both SOCKS4
+// and SOCKS5, and are not counted; Snapshot returns the relayed bytes.
+type AuditConn struct {
+	CountingConn
+	Replies int // Number of replies the command sends (BIND sends two)
+
+	writes int
+	code   atomic.Int32 // reply code + 1, or 0 if no reply was written
+}
+
+// NewAuditConn returns conn wrapped in an AuditConn expecting one reply.
+func NewAuditConn(conn net.Conn) *AuditConn {
+	return &AuditConn{CountingConn: CountingConn{Conn: conn}, Replies: 1}
+}
+
+// Reply returns the code of the last reply written, if any.
+func (c *AuditConn) Reply() (byte, bool) {
+	code := c.code.Load()
+	return byte(code - 1), code != 0
+}
+
+// Write passes p through, recording it as a reply or counting it as relayed data.
+// Writes to the client are not concurrent, so writes needs no locking.
+func (c *AuditConn) Write(p []byte) (int, error) {
+	if c.writes >= c.Replies {
+		return c.CountingConn.Write(p)
+	}
+
+	c.writes++
+	n, err := c.Conn.Write(p)
+	if n >= 2 {
+		c.code.Store(int32(p[1]) + 1)
+	}
+	return n, err
+}
@@ -0,0 +1,37 @@
+package net_test
+
+import (
+	"io"
+	"testing"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestAuditConn_ReplyAndCounts(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	ac := socksnet.NewAuditConn(server)
+	ac.Replies = 2
+
+	// two replies (BIND), then relayed data
+	ac.Write([]byte{0x05, 0x00, 0x00})
+	ac.Write([]byte{0x05, 0x04, 0x00})
+	ac.Write([]byte("payload"))
+	client.Write([]byte("ping"))
+
+	if _, err := io.ReadFull(client, make([]byte, 3+3+len("payload"))); err != nil {
+		t.Fatalf("read writes: %v", err)
+	}
+	if _, err := io.ReadFull(ac, make([]byte, 4)); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if code, ok := ac.Reply(); !ok || code != 0x04 {
+		t.Fatalf("Reply = (%#x, %v), want (0x04, true)", code, ok)
+	}
+	if read, written := ac.Snapshot(); read != 4 || written != int64(len("payload")) {
+		t.Fatalf("Snapshot = (%d, %d), want (4, %d)", read, written, len("payload"))
+	}
+}
@@ -0,0 +1,49 @@
+package net_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	log := socksnet.NewAuditLog(&buf)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			log.Write(&socksnet.AuditRecord{
+				Time:     time.Now(),
+				ID:       socksnet.NewAuditID(),
+				Version:  5,
+				Command:  "CONNECT",
+				Reply:    "SUCCESS",
+				Duration: time.Second,
+			})
+		})
+	}
+	wg.Wait()
+
+	// one complete record per line, with distinct IDs
+	ids := make(map[string]bool)
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var rec socksnet.AuditRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		if len(rec.ID) != 16 || rec.Command != "CONNECT" || rec.Duration != time.Second {
+			t.Fatalf("unexpected record: %+v", rec)
+		}
+		ids[rec.ID] = true
+	}
+	if len(ids) != 10 {
+		t.Fatalf("got %d distinct records, want 10", len(ids))
+	}
+}
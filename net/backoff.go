@@ -0,0 +1,79 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Accept backoff bounds.
+const (
+	MinAcceptBackoff     = 5 * time.Millisecond
+	DefaultAcceptBackoff = 1 * time.Second
+)
+
+// AcceptBackoff implements exponential backoff for temporary Accept errors,
+// such as running out of file descriptors.
+type AcceptBackoff struct {
+	Max time.Duration // maximum delay (0=DefaultAcceptBackoff)
+
+	delay time.Duration
+}
+
+// Wait sleeps before the next Accept if err is temporary, doubling the delay
+// on each consecutive call up to Max. It reports false without sleeping if
+// err is permanent, and false if ctx is done while waiting.
+func (b *AcceptBackoff) Wait(ctx context.Context, err error) bool {
+	if !IsTemporary(err) {
+		return false
+	}
+
+	maxDelay := b.Max
+	if maxDelay <= 0 {
+		maxDelay = DefaultAcceptBackoff
+	}
+
+	if b.delay == 0 {
+		b.delay = MinAcceptBackoff
+	} else {
+		b.delay *= 2
+	}
+	b.delay = min(b.delay, maxDelay)
+
+	t := time.NewTimer(b.delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Reset clears the delay after a successful Accept.
+func (b *AcceptBackoff) Reset() {
+	b.delay = 0
+}
+
+// Delay returns the most recent backoff delay.
+func (b *AcceptBackoff) Delay() time.Duration {
+	return b.delay
+}
+
+// IsTemporary reports whether err is a temporary or timeout network error
+// after which Accept may be retried.
+func IsTemporary(err error) bool {
+	var ne net.Error
+	if !errors.As(err, &ne) {
+		return false
+	}
+	if ne.Timeout() {
+		return true
+	}
+
+	// Temporary is deprecated, but it is the only signal for errors like EMFILE.
+	te, ok := ne.(interface{ Temporary() bool })
+	return ok && te.Temporary()
+}
@@ -1,11 +1,9 @@
 package net
 
 import (
-	"io"
+	"context"
 	"net"
 	"time"
-
-	"github.com/33TU/socks/internal"
 )
 
 // CloseWriter is an interface that wraps the CloseWrite method, which is used to close the write side of a connection.
@@ -14,43 +12,41 @@ type CloseWriter interface {
 	CloseWrite() error
 }
 
-// CopyConn copies data between src and dst with a timeout and buffer size.
-func CopyConn(dst, src net.Conn, timeout time.Duration, bufSize int) error {
-	defer func() {
-		if c, ok := dst.(CloseWriter); ok {
-			c.CloseWrite()
-		} else {
-			dst.Close()
-		}
-	}()
-
-	if timeout == 0 {
-		_, err := io.Copy(dst, src)
-		return err
+// CloseWrite closes the write side of conn if it is a CloseWriter, or the
+// whole connection otherwise. Wrapping conns implement CloseWrite with it.
+func CloseWrite(conn net.Conn) error {
+	if cw, ok := conn.(CloseWriter); ok {
+		return cw.CloseWrite()
 	}
+	return conn.Close()
+}
+
+// Relay copy buffer sizes. Buffers are pooled in power-of-two buckets, so each
+// configured size shares a pool with sizes rounding up to the same bucket.
+const (
+	DefaultCopyBufferSize = 32 * 1024
+	MinCopyBufferSize     = 4 * 1024
+	MaxCopyBufferSize     = 4 * 1024 * 1024
+)
 
+// CopyBufferSize returns the buffer size CopyConn uses for a requested bufSize:
+// DefaultCopyBufferSize if bufSize <= 0, otherwise bufSize clamped to
+// [MinCopyBufferSize, MaxCopyBufferSize].
+func CopyBufferSize(bufSize int) int {
 	if bufSize <= 0 {
-		bufSize = 1024 * 32 // default buffer size for io.CopyBuffer
+		return DefaultCopyBufferSize
 	}
+	return min(max(bufSize, MinCopyBufferSize), MaxCopyBufferSize)
+}
 
-	buf := internal.GetBytes(bufSize)
-	defer internal.PutBytes(buf)
-
-	for {
-		if err := src.SetDeadline(time.Now().Add(timeout)); err != nil {
-			return err
-		}
-
-		n, err := src.Read(buf)
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
+// CopyConn copies data between src and dst with a timeout and buffer size.
+// Larger buffers mean fewer read and write calls on bulk transfers; see CopyBufferSize.
+func CopyConn(dst, src net.Conn, timeout time.Duration, bufSize int) error {
+	_, err := CopyConnN(dst, src, timeout, bufSize)
+	return err
+}
 
-		if _, err := dst.Write(buf[:n]); err != nil {
-			return err
-		}
-	}
+// CopyConnN is CopyConn, also returning the number of bytes written to dst.
+func CopyConnN(dst, src net.Conn, timeout time.Duration, bufSize int) (written int64, err error) {
+	return copyHalf(context.Background(), dst, src, timeout, bufSize, nil, nil)
 }
@@ -0,0 +1,119 @@
+package net_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestCopyBufferSize(t *testing.T) {
+	tests := []struct {
+		in, want int
+	}{
+		{0, socksnet.DefaultCopyBufferSize},
+		{-1, socksnet.DefaultCopyBufferSize},
+		{1, socksnet.MinCopyBufferSize},
+		{256 * 1024, 256 * 1024},
+		{1 << 30, socksnet.MaxCopyBufferSize},
+	}
+
+	for _, tt := range tests {
+		if got := socksnet.CopyBufferSize(tt.in); got != tt.want {
+			t.Errorf("CopyBufferSize(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+// tcpPair returns both ends of a loopback TCP connection.
+func tcpPair(tb testing.TB) (client, server net.Conn) {
+	tb.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := ln.Accept()
+		accepted <- c
+	}()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		tb.Fatalf("dial: %v", err)
+	}
+	server = <-accepted
+	if server == nil {
+		tb.Fatal("accept failed")
+	}
+	return client, server
+}
+
+func TestCopyConnN(t *testing.T) {
+	for _, timeout := range []time.Duration{0, 5 * time.Second} {
+		t.Run(fmt.Sprintf("timeout=%v", timeout), func(t *testing.T) {
+			srcClient, srcServer := tcpPair(t)
+			dstClient, dstServer := tcpPair(t)
+			defer srcClient.Close()
+			defer dstClient.Close()
+
+			payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+			go func() {
+				srcClient.Write(payload)
+				srcClient.Close()
+			}()
+
+			done := make(chan int64, 1)
+			go func() {
+				n, err := socksnet.CopyConnN(dstServer, srcServer, timeout, 256*1024)
+				if err != nil {
+					t.Errorf("CopyConnN: %v", err)
+				}
+				done <- n
+			}()
+
+			// dst is half-closed once src hits EOF
+			got, err := io.ReadAll(dstClient)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if n := <-done; n != int64(len(payload)) || !bytes.Equal(got, payload) {
+				t.Fatalf("copied %d bytes (%d received), want %d", n, len(got), len(payload))
+			}
+		})
+	}
+}
+
+// BenchmarkCopyConnN measures relay throughput over loopback TCP with the
+// default buffer and a larger one suited to bulk transfers.
+func BenchmarkCopyConnN(b *testing.B) {
+	const chunk = 1024 * 1024
+
+	for _, size := range []int{32 * 1024, 256 * 1024} {
+		b.Run(fmt.Sprintf("buf=%dKB", size/1024), func(b *testing.B) {
+			srcClient, srcServer := tcpPair(b)
+			dstClient, dstServer := tcpPair(b)
+			defer dstClient.Close()
+
+			go socksnet.CopyConnN(dstServer, srcServer, time.Minute, size)
+			go io.Copy(io.Discard, dstClient)
+
+			data := make([]byte, chunk)
+			b.SetBytes(chunk)
+
+			for b.Loop() {
+				if _, err := srcClient.Write(data); err != nil {
+					b.Fatalf("write: %v", err)
+				}
+			}
+			srcClient.Close()
+		})
+	}
+}
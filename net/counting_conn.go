@@ -0,0 +1,47 @@
+package net
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// CountingConn counts the bytes read from and written to a net.Conn. It is
+// safe for concurrent reads and writes.
+//
+// It deliberately does not implement syscall.Conn, io.ReaderFrom or
+// io.WriterTo even if the wrapped connection does: copies through it take the
+// Read and Write path, so no bytes bypass the counters.
+type CountingConn struct {
+	net.Conn
+
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+// NewCountingConn returns conn wrapped in a CountingConn.
+func NewCountingConn(conn net.Conn) *CountingConn {
+	return &CountingConn{Conn: conn}
+}
+
+// Snapshot returns the bytes read and written so far.
+func (c *CountingConn) Snapshot() (read, written int64) {
+	return c.read.Load(), c.written.Load()
+}
+
+func (c *CountingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *CountingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite closes the write side of the connection if supported, or the
+// whole connection otherwise.
+func (c *CountingConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
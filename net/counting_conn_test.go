@@ -0,0 +1,59 @@
+package net_test
+
+import (
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestCountingConn_Concurrent(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	cc := socksnet.NewCountingConn(server)
+
+	const writers, chunk = 8, 4096
+	var wg sync.WaitGroup
+	for range writers {
+		wg.Go(func() {
+			cc.Write(make([]byte, chunk))
+		})
+	}
+
+	// the peer echoes what it receives back to cc
+	wg.Go(func() {
+		io.CopyN(client, client, writers*chunk)
+	})
+	if _, err := io.CopyN(io.Discard, cc, writers*chunk); err != nil {
+		t.Fatalf("read echo: %v", err)
+	}
+	wg.Wait()
+
+	if read, written := cc.Snapshot(); read != writers*chunk || written != writers*chunk {
+		t.Fatalf("Snapshot = (%d, %d), want %d each", read, written, writers*chunk)
+	}
+}
+
+func TestCountingConn_NoSyscallConn(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	if _, ok := server.(syscall.Conn); !ok {
+		t.Fatal("test needs a conn implementing syscall.Conn")
+	}
+	// raw access would let copies bypass the counters
+	if _, ok := any(socksnet.NewCountingConn(server)).(syscall.Conn); ok {
+		t.Fatal("CountingConn exposes syscall.Conn")
+	}
+	if _, ok := any(socksnet.NewCountingConn(server)).(io.ReaderFrom); ok {
+		t.Fatal("CountingConn exposes io.ReaderFrom")
+	}
+	if _, ok := any(socksnet.NewCountingConn(server)).(socksnet.CloseWriter); !ok {
+		t.Fatal("CountingConn does not implement CloseWriter")
+	}
+}
@@ -3,17 +3,58 @@ package net
 import (
 	"context"
 	"net"
+	"time"
 )
 
-// DefaultDialer is the default Dialer using net.Dialer.
-var DefaultDialer Dialer = &net.Dialer{}
+// Default timeouts of NewDefaultDialer.
+const (
+	DefaultDialTimeout = 30 * time.Second
+	DefaultKeepAlive   = 30 * time.Second
+)
+
+// DefaultDialer is the Dialer used when none is configured, both for reaching
+// the proxy and for the servers' upstream connections. It is a NewDefaultDialer,
+// so dials give up after DefaultDialTimeout instead of the OS connect timeout.
+// Set a Dialer such as &net.Dialer{} explicitly to dial without a timeout.
+var DefaultDialer Dialer = NewDefaultDialer()
+
+// NewDefaultDialer returns a net.Dialer with a DefaultDialTimeout connect timeout
+// and DefaultKeepAlive TCP keep-alives. IPv4 and IPv6 addresses are raced as
+// described in RFC 6555 (Happy Eyeballs), which net.Dialer does by default.
+func NewDefaultDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   DefaultDialTimeout,
+		KeepAlive: DefaultKeepAlive,
+	}
+}
 
 // Dialer represents a type capable of creating network connections.
 type Dialer interface {
 	DialContext(ctx context.Context, network, address string) (net.Conn, error)
 }
 
+// Resolver represents a type capable of resolving host names, such as *net.Resolver.
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
 // ConnDialer represents a type capable of upgrading an existing connection.
 type ConnDialer interface {
 	DialConnContext(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error)
 }
+
+// DialFunc is an adapter that allows an ordinary function to be used as a Dialer.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// DialContext implements [Dialer].
+func (f DialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// LookupIPFunc is an adapter that allows an ordinary function to be used as a Resolver.
+type LookupIPFunc func(ctx context.Context, network, host string) ([]net.IP, error)
+
+// LookupIP implements [Resolver].
+func (f LookupIPFunc) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return f(ctx, network, host)
+}
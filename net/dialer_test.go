@@ -0,0 +1,43 @@
+package net_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestNewDefaultDialer(t *testing.T) {
+	d := socksnet.NewDefaultDialer()
+	if d.Timeout != socksnet.DefaultDialTimeout {
+		t.Errorf("expected Timeout %v, got %v", socksnet.DefaultDialTimeout, d.Timeout)
+	}
+	if d.KeepAlive != socksnet.DefaultKeepAlive {
+		t.Errorf("expected KeepAlive %v, got %v", socksnet.DefaultKeepAlive, d.KeepAlive)
+	}
+
+	def, ok := socksnet.DefaultDialer.(*net.Dialer)
+	if !ok || def.Timeout != socksnet.DefaultDialTimeout {
+		t.Fatalf("expected DefaultDialer to time out after %v, got %#v", socksnet.DefaultDialTimeout, socksnet.DefaultDialer)
+	}
+}
+
+func TestDefaultDialer_NonRoutable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the dial timeout")
+	}
+
+	// 198.51.100.0/24 is reserved for documentation (RFC 5737) and not routed
+	start := time.Now()
+	conn, err := socksnet.DefaultDialer.DialContext(context.Background(), "tcp", "198.51.100.1:80")
+	if err == nil {
+		conn.Close()
+		t.Skip("198.51.100.1 is reachable from this network")
+	}
+
+	if elapsed := time.Since(start); elapsed > socksnet.DefaultDialTimeout+5*time.Second {
+		t.Fatalf("dial failed after %v, expected within %v", elapsed, socksnet.DefaultDialTimeout+5*time.Second)
+	}
+}
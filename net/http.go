@@ -0,0 +1,51 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DialTLSContext connects to address using d and performs a TLS client handshake over the connection.
+// If config is nil or has no ServerName, the host part of address is used as the server name.
+func DialTLSContext(ctx context.Context, d Dialer, config *tls.Config, network, address string) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// NewHTTPTransport returns an http.Transport that dials through dial and dialTLS,
+// using the same pool and timeout settings as http.DefaultTransport.
+func NewHTTPTransport(dial, dialTLS DialFunc) *http.Transport {
+	return &http.Transport{
+		DialContext:           dial,
+		DialTLSContext:        dialTLS,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
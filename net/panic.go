@@ -0,0 +1,73 @@
+package net
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicEntry describes a panic recovered while serving a connection.
+type PanicEntry struct {
+	PanicValue any    // Value passed to panic
+	Stack      []byte // Stack trace of the panicking goroutine, as from runtime/debug.Stack
+	ConnID     uint64 // Number of the connection, see NewConnID
+}
+
+// NewPanicEntry returns a PanicEntry for the recovered value r, capturing the
+// current goroutine's stack. It must be called from the deferred function
+// that recovered r.
+func NewPanicEntry(r any, connID uint64) *PanicEntry {
+	return &PanicEntry{
+		PanicValue: r,
+		Stack:      debug.Stack(),
+		ConnID:     connID,
+	}
+}
+
+var connIDs atomic.Uint64
+
+// NewConnID returns a number identifying a served connection, unique within
+// the process and increasing in the order connections were accepted.
+func NewConnID() uint64 {
+	return connIDs.Add(1)
+}
+
+// DefaultPanicLogger returns a panic hook that logs each PanicEntry to logger
+// at error level, with the stack trimmed to start at the panicking function
+// (nil logger=slog.Default()).
+func DefaultPanicLogger(logger *slog.Logger) func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+	return func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.ErrorContext(ctx, "panic occurred",
+			"from", conn.RemoteAddr(),
+			"conn_id", e.ConnID,
+			"error", e.PanicValue,
+			"stack", string(trimStack(e.Stack)),
+		)
+	}
+}
+
+// trimStack drops the goroutine header and the frames of debug.Stack, the
+// recovering function and the runtime's panic machinery from stack.
+func trimStack(stack []byte) []byte {
+	// The panicking function is the frame below the last call to panic.
+	i := bytes.LastIndex(stack, []byte("\npanic("))
+	if i < 0 {
+		return stack
+	}
+	rest := stack[i+1:]
+	for range 2 { // the panic frame's function and file lines
+		j := bytes.IndexByte(rest, '\n')
+		if j < 0 {
+			return stack
+		}
+		rest = rest[j+1:]
+	}
+	return rest
+}
@@ -0,0 +1,37 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestDefaultPanicLogger(t *testing.T) {
+	var entry *socksnet.PanicEntry
+	func() {
+		defer func() {
+			entry = socksnet.NewPanicEntry(recover(), socksnet.NewConnID())
+		}()
+		panic("boom")
+	}()
+
+	var buf bytes.Buffer
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	socksnet.DefaultPanicLogger(slog.New(slog.NewTextHandler(&buf, nil)))(context.Background(), c1, entry)
+
+	out := buf.String()
+	if !strings.Contains(out, "boom") || !strings.Contains(out, "TestDefaultPanicLogger") {
+		t.Fatalf("log line lacks the panic value or panicking function: %s", out)
+	}
+	// the stack starts at the panicking function
+	if strings.Contains(out, "runtime/debug.Stack") || strings.Contains(out, "NewPanicEntry") {
+		t.Fatalf("stack is not trimmed: %s", out)
+	}
+}
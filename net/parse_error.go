@@ -0,0 +1,44 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ParseError is returned by the ReadFrom methods of the socks4 and socks5
+// messages when a message is malformed or truncated. It records where parsing
+// stopped and unwraps to the underlying error, so errors.Is still matches
+// sentinels such as io.ErrUnexpectedEOF or socks5.ErrInvalidVersion.
+type ParseError struct {
+	Message string // Message being read, e.g. "socks5 request"
+	Field   string // Field that failed, e.g. "ATYP" (empty if not known)
+	Offset  int64  // Bytes of the message read before the error
+	Err     error  // Underlying error
+}
+
+func (e *ParseError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: at offset %d: %v", e.Message, e.Offset, e.Err)
+	}
+	return fmt.Sprintf("%s: %s at offset %d: %v", e.Message, e.Field, e.Offset, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// NewParseError wraps err in a *ParseError. It returns nil for a nil err, and
+// returns err unchanged if it is already a *ParseError or is io.EOF before any
+// byte was read, which marks a cleanly closed stream rather than a bad message.
+func NewParseError(message, field string, offset int64, err error) error {
+	if err == nil || (offset == 0 && err == io.EOF) {
+		return err
+	}
+
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &ParseError{Message: message, Field: field, Offset: offset, Err: err}
+}
@@ -0,0 +1,76 @@
+package net
+
+import "errors"
+
+// Phase is the stage of a server connection in which an error occurred.
+type Phase int
+
+// Connection phases reported in PhaseError.
+const (
+	PhaseAccept    Phase = iota + 1 // accepting the connection, OnAccept
+	PhaseHandshake                  // method negotiation (SOCKS5)
+	PhaseAuth                       // authentication, or user ID validation (SOCKS4)
+	PhaseRequest                    // reading and handling the request
+	PhaseDial                       // connecting to the target
+	PhaseRelay                      // relaying data between client and target
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseAccept:
+		return "accept"
+	case PhaseHandshake:
+		return "handshake"
+	case PhaseAuth:
+		return "auth"
+	case PhaseRequest:
+		return "request"
+	case PhaseDial:
+		return "dial"
+	case PhaseRelay:
+		return "relay"
+	default:
+		return "unknown"
+	}
+}
+
+// PhaseError is passed to OnError, OnClose and the audit hook by the SOCKS
+// servers. It tags an error with the phase it occurred in, so that timeouts,
+// malformed messages and unreachable targets can be told apart, and unwraps to
+// the underlying cause for errors.Is and errors.As.
+type PhaseError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return e.Phase.String() + ": " + e.Err.Error()
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// WithPhase wraps err in a *PhaseError for phase. It returns nil for a nil err,
+// and returns err unchanged if it already carries a phase, so that the phase
+// closest to the cause wins.
+func WithPhase(phase Phase, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pe *PhaseError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &PhaseError{Phase: phase, Err: err}
+}
+
+// ErrorPhase returns the phase of the first *PhaseError in err's chain.
+func ErrorPhase(err error) (Phase, bool) {
+	var pe *PhaseError
+	if errors.As(err, &pe) {
+		return pe.Phase, true
+	}
+	return 0, false
+}
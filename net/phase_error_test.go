@@ -0,0 +1,129 @@
+package net_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+	"github.com/33TU/socks/socks4"
+	"github.com/33TU/socks/socks5"
+)
+
+func TestWithPhase(t *testing.T) {
+	if socksnet.WithPhase(socksnet.PhaseDial, nil) != nil {
+		t.Fatalf("expected nil for a nil error")
+	}
+
+	cause := io.ErrUnexpectedEOF
+	err := socksnet.WithPhase(socksnet.PhaseHandshake, cause)
+	if err.Error() != "handshake: unexpected EOF" {
+		t.Fatalf("unexpected message %q", err)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("errors.Is does not reach the cause")
+	}
+
+	// the innermost phase wins
+	outer := socksnet.WithPhase(socksnet.PhaseRequest, socksnet.WithPhase(socksnet.PhaseDial, cause))
+	if phase, ok := socksnet.ErrorPhase(outer); !ok || phase != socksnet.PhaseDial {
+		t.Fatalf("expected PhaseDial, got %v", phase)
+	}
+
+	if _, ok := socksnet.ErrorPhase(cause); ok {
+		t.Fatalf("expected no phase for a plain error")
+	}
+}
+
+type phaseRecorder struct {
+	errs chan error
+}
+
+func (r *phaseRecorder) next(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-r.errs:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OnError not called")
+		return nil
+	}
+}
+
+type socks5PhaseHandler struct {
+	*socks5.BaseServerHandler
+	*phaseRecorder
+}
+
+func (h *socks5PhaseHandler) OnError(ctx context.Context, conn net.Conn, err error) {
+	h.errs <- err
+}
+
+type socks4PhaseHandler struct {
+	*socks4.BaseServerHandler
+	*phaseRecorder
+}
+
+func (h *socks4PhaseHandler) OnError(ctx context.Context, conn net.Conn, err error) {
+	h.errs <- err
+}
+
+func TestPhaseError_BothServers(t *testing.T) {
+	// a port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	closed := ln.Addr().String()
+	ln.Close()
+
+	rec := &phaseRecorder{errs: make(chan error, 4)}
+	socks5Addr := listen(t, func(ctx context.Context, ln net.Listener) error {
+		return socks5.Serve(ctx, ln, &socks5PhaseHandler{&socks5.BaseServerHandler{
+			AllowConnect:     true,
+			SupportedMethods: []byte{socks5.MethodNoAuth},
+			Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}, rec})
+	})
+	socks4Addr := listen(t, func(ctx context.Context, ln net.Listener) error {
+		return socks4.Serve(ctx, ln, &socks4PhaseHandler{&socks4.BaseServerHandler{AllowConnect: true}, rec})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	raw := func(addr string, b []byte) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		conn.Write(b)
+		io.Copy(io.Discard, conn)
+	}
+
+	tests := []struct {
+		name  string
+		run   func()
+		phase socksnet.Phase
+	}{
+		{"socks5 malformed handshake", func() { raw(socks5Addr, []byte{5, 0}) }, socksnet.PhaseHandshake},
+		{"socks5 unreachable target", func() { socks5.NewDialer(socks5Addr, nil, nil).DialContext(ctx, "tcp", closed) }, socksnet.PhaseDial},
+		{"socks4 invalid command", func() { raw(socks4Addr, []byte{4, 9, 0, 80, 127, 0, 0, 1, 0}) }, socksnet.PhaseRequest},
+		{"socks4 unreachable target", func() { socks4.NewDialer(socks4Addr, "", nil).DialContext(ctx, "tcp", closed) }, socksnet.PhaseDial},
+	}
+
+	for _, tt := range tests {
+		tt.run()
+		err := rec.next(t)
+
+		var pe *socksnet.PhaseError
+		if !errors.As(err, &pe) || pe.Phase != tt.phase {
+			t.Errorf("%s: expected %v phase, got %v", tt.name, tt.phase, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package net
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// PrefixConn is a net.Conn whose reads return prefix before the data of the
+// connection, e.g. bytes already read for protocol detection or left over in
+// a bufio.Reader. Writes, deadlines and Close go straight to the connection.
+//
+// Unlike CountingConn and TeeConn it implements io.WriterTo and io.ReaderFrom:
+// once the prefix is written out, copies use the fast paths of the wrapped
+// connection, so relaying through it can still splice.
+type PrefixConn struct {
+	net.Conn
+
+	mu     sync.Mutex
+	prefix []byte
+}
+
+// NewPrefixConn returns conn with prefix pushed back in front of its data.
+// prefix is not copied and must not be modified afterwards; it may be empty.
+func NewPrefixConn(conn net.Conn, prefix []byte) *PrefixConn {
+	return &PrefixConn{Conn: conn, prefix: prefix}
+}
+
+// Read serves the remaining prefix, if any, and otherwise reads the connection.
+// A Read never returns bytes from both.
+func (c *PrefixConn) Read(p []byte) (int, error) {
+	if n, ok := c.readPrefix(p); ok {
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// readPrefix copies the remaining prefix into p. It reports false once the
+// prefix has been drained.
+func (c *PrefixConn) readPrefix(p []byte) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.prefix) == 0 || len(p) == 0 {
+		return 0, len(c.prefix) != 0
+	}
+	n := copy(p, c.prefix)
+	c.prefix = c.prefix[n:]
+	if len(c.prefix) == 0 {
+		c.prefix = nil // release the caller's buffer
+	}
+	return n, true
+}
+
+// WriteTo writes the remaining prefix to w, then copies the connection to w
+// until EOF. Implements io.WriterTo.
+func (c *PrefixConn) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	prefix := c.prefix
+	c.prefix = nil
+	c.mu.Unlock()
+
+	var written int64
+	if len(prefix) > 0 {
+		n, err := w.Write(prefix)
+		written = int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	n, err := io.Copy(w, c.Conn)
+	return written + n, err
+}
+
+// ReadFrom copies r to the connection, using its io.ReaderFrom if it has one.
+// Implements io.ReaderFrom.
+func (c *PrefixConn) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(c.Conn, r)
+}
+
+// CloseWrite closes the write side of the connection if supported, or the
+// whole connection otherwise.
+func (c *PrefixConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
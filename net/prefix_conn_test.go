@@ -0,0 +1,120 @@
+package net_test
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestPrefixConn_PartialReads(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	pc := socksnet.NewPrefixConn(server, []byte("hello"))
+	client.Write([]byte(" world"))
+	client.(*net.TCPConn).CloseWrite()
+
+	// the prefix is served in pieces, never merged with connection data
+	buf := make([]byte, 3)
+	for _, want := range []string{"hel", "lo"} {
+		n, err := pc.Read(buf)
+		if err != nil || string(buf[:n]) != want {
+			t.Fatalf("Read = (%q, %v), want %q", buf[:n], err, want)
+		}
+	}
+
+	rest, err := io.ReadAll(pc)
+	if err != nil || string(rest) != " world" {
+		t.Fatalf("ReadAll after prefix = (%q, %v), want %q", rest, err, " world")
+	}
+
+	if n, err := pc.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read past the end = (%d, %v), want (0, EOF)", n, err)
+	}
+}
+
+func TestPrefixConn_EmptyPrefix(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	for _, prefix := range [][]byte{nil, {}} {
+		pc := socksnet.NewPrefixConn(server, prefix)
+		client.Write([]byte("data"))
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(pc, buf); err != nil || string(buf) != "data" {
+			t.Fatalf("Read with prefix %#v = (%q, %v), want %q", prefix, buf, err, "data")
+		}
+	}
+
+	// a zero-length Read with a prefix pending consumes nothing
+	pc := socksnet.NewPrefixConn(server, []byte("x"))
+	if n, err := pc.Read(nil); n != 0 || err != nil {
+		t.Fatalf("Read(nil) = (%d, %v), want (0, nil)", n, err)
+	}
+	buf := make([]byte, 1)
+	if n, _ := pc.Read(buf); n != 1 || buf[0] != 'x' {
+		t.Fatalf("Read after Read(nil) = %q, want %q", buf[:n], "x")
+	}
+}
+
+func TestPrefixConn_WriteTo(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	pc := socksnet.NewPrefixConn(server, []byte("GET "))
+	payload := bytes.Repeat([]byte("p"), 64*1024)
+	go func() {
+		client.Write(payload)
+		client.(*net.TCPConn).CloseWrite()
+	}()
+
+	var out bytes.Buffer
+	n, err := io.Copy(&out, pc)
+	if err != nil || n != int64(4+len(payload)) {
+		t.Fatalf("Copy = (%d, %v), want %d bytes", n, err, 4+len(payload))
+	}
+	if !bytes.Equal(out.Bytes(), append([]byte("GET "), payload...)) {
+		t.Fatal("copied data is not the prefix followed by the connection data")
+	}
+}
+
+func TestPrefixConn_Interfaces(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	pc := socksnet.NewPrefixConn(server, []byte("x"))
+	if _, ok := any(pc).(io.WriterTo); !ok {
+		t.Fatal("PrefixConn does not implement io.WriterTo")
+	}
+	if _, ok := any(pc).(io.ReaderFrom); !ok {
+		t.Fatal("PrefixConn does not implement io.ReaderFrom")
+	}
+
+	// deadlines reach the connection
+	pc.SetReadDeadline(time.Now().Add(-time.Second))
+	pc.Read(make([]byte, 1)) // the prefix is still served
+	if _, err := pc.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read after the prefix ignored the expired deadline")
+	}
+	pc.SetReadDeadline(time.Time{})
+
+	// writes go straight through, and CloseWrite ends them
+	if _, err := pc.ReadFrom(bytes.NewReader([]byte("out"))); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if err := pc.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+	if got, err := io.ReadAll(client); err != nil || string(got) != "out" {
+		t.Fatalf("peer read (%q, %v), want %q then EOF", got, err, "out")
+	}
+}
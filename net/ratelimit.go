@@ -0,0 +1,173 @@
+package net
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// DefaultRateLimitChunk is the largest number of bytes waited for at once with
+// a RateLimiter that does not report its burst.
+const DefaultRateLimitChunk = 16 * 1024
+
+// RateLimiter limits a byte rate. WaitN blocks until n more bytes may pass or
+// ctx ends. *rate.Limiter from golang.org/x/time/rate implements it; other
+// token buckets can be adapted with RateLimiterFunc.
+//
+// Waits are split into chunks of at most DefaultRateLimitChunk bytes so that
+// large transfers are spread out rather than sent in one burst. If the limiter
+// has a Burst() int method, as *rate.Limiter does, chunks are also at most
+// that size, so WaitN is never asked for more than the burst.
+type RateLimiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// RateLimiterFunc adapts a function to RateLimiter.
+type RateLimiterFunc func(ctx context.Context, n int) error
+
+// WaitN calls f(ctx, n).
+func (f RateLimiterFunc) WaitN(ctx context.Context, n int) error {
+	return f(ctx, n)
+}
+
+// WaitN waits until l allows n bytes, in chunks (see RateLimiter), e.g. for a
+// datagram that cannot itself be split. A nil l allows everything.
+func WaitN(ctx context.Context, l RateLimiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	chunk := rateLimitChunk(l)
+	for n > 0 {
+		c := min(n, chunk)
+		if err := l.WaitN(ctx, c); err != nil {
+			return err
+		}
+		n -= c
+	}
+	return nil
+}
+
+// rateLimitChunk returns the largest wait to ask of l.
+func rateLimitChunk(l RateLimiter) int {
+	if b, ok := l.(interface{ Burst() int }); ok && b.Burst() > 0 {
+		return min(b.Burst(), DefaultRateLimitChunk)
+	}
+	return DefaultRateLimitChunk
+}
+
+// RateLimitedReader limits the rate of reads from a Reader. Each Read reads at
+// most one chunk and then waits for the bytes read.
+type RateLimitedReader struct {
+	r     io.Reader
+	l     RateLimiter
+	ctx   context.Context
+	chunk int
+}
+
+// NewRateLimitedReader returns a Reader reading from r at the rate allowed by
+// l. Waits end with an error when ctx ends.
+func NewRateLimitedReader(ctx context.Context, r io.Reader, l RateLimiter) *RateLimitedReader {
+	return &RateLimitedReader{r: r, l: l, ctx: ctx, chunk: rateLimitChunk(l)}
+}
+
+func (r *RateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// RateLimitedWriter limits the rate of writes to a Writer. Each Write is
+// split into chunks, each waited for before it is written.
+type RateLimitedWriter struct {
+	w     io.Writer
+	l     RateLimiter
+	ctx   context.Context
+	chunk int
+}
+
+// NewRateLimitedWriter returns a Writer writing to w at the rate allowed by l.
+// Waits end with an error when ctx ends.
+func NewRateLimitedWriter(ctx context.Context, w io.Writer, l RateLimiter) *RateLimitedWriter {
+	return &RateLimitedWriter{w: w, l: l, ctx: ctx, chunk: rateLimitChunk(l)}
+}
+
+func (w *RateLimitedWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		c := p[:min(len(p), w.chunk)]
+		if err := w.l.WaitN(w.ctx, len(c)); err != nil {
+			return written, err
+		}
+
+		n, err := w.w.Write(c)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// RateLimitedConn limits the rates of reads from and writes to a net.Conn.
+// Closing it ends pending waits.
+//
+// Like CountingConn, it does not implement syscall.Conn, io.ReaderFrom or
+// io.WriterTo, so copies through it cannot bypass the limits.
+type RateLimitedConn struct {
+	net.Conn
+
+	r io.Reader
+	w io.Writer
+
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewRateLimitedConn returns conn with reads limited by read and writes by
+// write. A nil limiter leaves that direction unlimited.
+func NewRateLimitedConn(conn net.Conn, read, write RateLimiter) *RateLimitedConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &RateLimitedConn{Conn: conn, r: conn, w: conn, cancel: cancel}
+	if read != nil {
+		c.r = NewRateLimitedReader(ctx, conn, read)
+	}
+	if write != nil {
+		c.w = NewRateLimitedWriter(ctx, conn, write)
+	}
+	return c
+}
+
+func (c *RateLimitedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *RateLimitedConn) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+// Close ends pending waits and closes the connection.
+func (c *RateLimitedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
+
+// CloseWrite closes the write side of the connection if supported, or the
+// whole connection otherwise.
+func (c *RateLimitedConn) CloseWrite() error {
+	if _, ok := c.Conn.(CloseWriter); !ok {
+		return c.Close() // also ends pending waits
+	}
+	return CloseWrite(c.Conn)
+}
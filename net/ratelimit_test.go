@@ -0,0 +1,138 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// fakeClockLimiter is a token bucket on a fake clock: WaitN advances the clock
+// by the time n bytes take at rate instead of sleeping, and fails like
+// *rate.Limiter if n exceeds the burst.
+type fakeClockLimiter struct {
+	rate  int // bytes per second
+	burst int
+
+	mu      sync.Mutex
+	elapsed time.Duration
+	largest int
+}
+
+func (l *fakeClockLimiter) WaitN(ctx context.Context, n int) error {
+	if n > l.burst {
+		return fmt.Errorf("WaitN(%d) exceeds burst %d", n, l.burst)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.elapsed += time.Duration(n) * time.Second / time.Duration(l.rate)
+	l.largest = max(l.largest, n)
+	return nil
+}
+
+func (l *fakeClockLimiter) Burst() int { return l.burst }
+
+func TestRateLimitedWriter(t *testing.T) {
+	l := &fakeClockLimiter{rate: 100 * 1024, burst: 4096}
+	data := bytes.Repeat([]byte("w"), 1024*1024)
+
+	var out bytes.Buffer
+	n, err := socksnet.NewRateLimitedWriter(context.Background(), &out, l).Write(data)
+	if err != nil || n != len(data) || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("Write = (%d, %v), want all %d bytes written", n, err, len(data))
+	}
+
+	// 1 MiB at 100 KiB/s takes 10.24s, in bursts of at most 4096 bytes
+	if want := 10240 * time.Millisecond; l.elapsed != want {
+		t.Errorf("limiter clock advanced %v, want %v", l.elapsed, want)
+	}
+	if l.largest != 4096 {
+		t.Errorf("largest wait %d bytes, want the burst of 4096", l.largest)
+	}
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	l := &fakeClockLimiter{rate: 1000 * 1000, burst: 1 << 20}
+	data := bytes.Repeat([]byte("r"), 3*1000*1000)
+
+	got, err := io.ReadAll(socksnet.NewRateLimitedReader(context.Background(), bytes.NewReader(data), l))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("ReadAll = (%d bytes, %v), want all %d bytes", len(got), err, len(data))
+	}
+
+	// a large burst is still waited for in chunks of DefaultRateLimitChunk
+	if want := 3 * time.Second; l.elapsed != want {
+		t.Errorf("limiter clock advanced %v, want %v", l.elapsed, want)
+	}
+	if l.largest != socksnet.DefaultRateLimitChunk {
+		t.Errorf("largest wait %d bytes, want %d", l.largest, socksnet.DefaultRateLimitChunk)
+	}
+}
+
+func TestRateLimitedConn_CloseEndsWait(t *testing.T) {
+	a, b := tcpPair(t)
+	defer b.Close()
+
+	waiting := make(chan struct{})
+	blocked := socksnet.RateLimiterFunc(func(ctx context.Context, n int) error {
+		close(waiting)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	conn := socksnet.NewRateLimitedConn(a, nil, blocked)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("x"))
+		done <- err
+	}()
+
+	<-waiting
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Write = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Write still waiting after Close")
+	}
+}
+
+func TestWaitN(t *testing.T) {
+	l := &fakeClockLimiter{rate: 1000, burst: 100}
+	if err := socksnet.WaitN(context.Background(), l, 1050); err != nil {
+		t.Fatalf("WaitN failed: %v", err)
+	}
+	if l.elapsed != 1050*time.Millisecond || l.largest != 100 {
+		t.Fatalf("limiter advanced %v with largest wait %d, want 1.05s and 100", l.elapsed, l.largest)
+	}
+
+	if err := socksnet.WaitN(context.Background(), nil, 1050); err != nil {
+		t.Fatalf("WaitN with a nil limiter = %v, want nil", err)
+	}
+}
+
+// ExampleRateLimiterFunc adapts a token bucket that is not a RateLimiter, here
+// one that only counts the bytes it is asked for.
+func ExampleRateLimiterFunc() {
+	var taken int
+	take := func(n int) { taken += n }
+
+	limiter := socksnet.RateLimiterFunc(func(ctx context.Context, n int) error {
+		take(n)
+		return ctx.Err()
+	})
+
+	w := socksnet.NewRateLimitedWriter(context.Background(), io.Discard, limiter)
+	w.Write(make([]byte, 40000))
+	fmt.Println(taken)
+	// Output: 40000
+}
@@ -0,0 +1,167 @@
+package net
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/33TU/socks/internal"
+)
+
+// RelayOptions configures Relay. A nil *RelayOptions relays with
+// DefaultCopyBufferSize buffers and no idle timeout, limits or capture.
+type RelayOptions struct {
+	BufferSize  int           // Copy buffer per direction (see CopyBufferSize)
+	IdleTimeout time.Duration // Ends a direction after this long without data (0=none)
+
+	// Rates of a-to-b and b-to-a data (nil=unlimited). Data is read in
+	// chunks (see RateLimiter), each waited for before it is written.
+	LimitUp   RateLimiter
+	LimitDown RateLimiter
+
+	// Tee receives a copy of the data relayed in both directions, e.g. for
+	// traffic capture (nil=none). Writes are serialized; errors are ignored so
+	// capture never interrupts the relay.
+	Tee io.Writer
+}
+
+// RelayStats reports what Relay copied in each direction. Up is a to b.
+type RelayStats struct {
+	BytesUp      int64
+	BytesDown    int64
+	DurationUp   time.Duration // From the start of Relay until the a-to-b copy ended
+	DurationDown time.Duration // From the start of Relay until the b-to-a copy ended
+}
+
+// Relay copies data between a and b in both directions until both copies end.
+// When one side stops sending, the write half of the other is closed if it is
+// a CloseWriter, or the connection is closed otherwise, so half-closed
+// connections keep relaying the other direction. An error in either
+// direction, or cancelling ctx, closes a and b to end both. The first error is
+// returned; the end of input is not an error. Otherwise Relay leaves a and b
+// open when it returns.
+//
+// The socks4 and socks5 servers relay with context.WithoutCancel, so ending
+// the serve context does not cut established relays short and a graceful
+// shutdown can wait for them to drain.
+func Relay(ctx context.Context, a, b net.Conn, opts *RelayOptions) (RelayStats, error) {
+	var o RelayOptions
+	if opts != nil {
+		o = *opts
+	}
+
+	var (
+		wg       sync.WaitGroup
+		stats    RelayStats
+		start    = time.Now()
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	// fail records the first error and closes a and b to end both directions.
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		a.Close()
+		b.Close()
+	}
+	stop := context.AfterFunc(ctx, func() { fail(context.Cause(ctx)) })
+	defer stop()
+
+	var tee io.Writer
+	if o.Tee != nil {
+		tee = &lockedWriter{w: o.Tee}
+	}
+
+	wg.Go(func() {
+		var err error
+		stats.BytesUp, err = copyHalf(ctx, b, a, o.IdleTimeout, o.BufferSize, o.LimitUp, tee)
+		stats.DurationUp = time.Since(start)
+		if err != nil {
+			fail(err)
+		}
+	})
+
+	wg.Go(func() {
+		var err error
+		stats.BytesDown, err = copyHalf(ctx, a, b, o.IdleTimeout, o.BufferSize, o.LimitDown, tee)
+		stats.DurationDown = time.Since(start)
+		if err != nil {
+			fail(err)
+		}
+	})
+
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return stats, firstErr
+}
+
+// copyHalf copies src to dst until src ends, then closes the write half of
+// dst. With no timeout, limiter or tee it leaves the copy to io.CopyBuffer,
+// which splices when the conns support it.
+func copyHalf(ctx context.Context, dst, src net.Conn, timeout time.Duration, bufSize int, limiter RateLimiter, tee io.Writer) (written int64, err error) {
+	defer CloseWrite(dst)
+
+	buf := internal.GetBytes(CopyBufferSize(bufSize))
+	defer internal.PutBytes(buf)
+
+	if timeout == 0 && limiter == nil && tee == nil {
+		// buf is unused when the conns can splice or sendfile directly
+		return io.CopyBuffer(dst, src, buf)
+	}
+
+	if limiter != nil {
+		buf = buf[:min(len(buf), rateLimitChunk(limiter))] // wait for one chunk at a time
+	}
+
+	for {
+		if timeout != 0 {
+			if err := src.SetDeadline(time.Now().Add(timeout)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			if limiter != nil {
+				if err := limiter.WaitN(ctx, n); err != nil {
+					return written, err
+				}
+			}
+			if tee != nil {
+				tee.Write(buf[:n])
+			}
+
+			nw, werr := dst.Write(buf[:n])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,162 @@
+package net_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// countingLimiter records the bytes it was asked to allow.
+type countingLimiter struct {
+	n atomic.Int64
+}
+
+func (l *countingLimiter) WaitN(ctx context.Context, n int) error {
+	l.n.Add(int64(n))
+	return nil
+}
+
+// relayPair starts Relay between the server ends of two TCP pairs and returns
+// the client ends, a and b, with a channel receiving Relay's result.
+func relayPair(t *testing.T, ctx context.Context, opts *socksnet.RelayOptions) (a, b net.Conn, done chan relayResult) {
+	a, aServer := tcpPair(t)
+	b, bServer := tcpPair(t)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+		aServer.Close()
+		bServer.Close()
+	})
+
+	done = make(chan relayResult, 1)
+	go func() {
+		stats, err := socksnet.Relay(ctx, aServer, bServer, opts)
+		done <- relayResult{stats, err}
+	}()
+	return a, b, done
+}
+
+type relayResult struct {
+	stats socksnet.RelayStats
+	err   error
+}
+
+func waitRelay(t *testing.T, done chan relayResult) relayResult {
+	t.Helper()
+	select {
+	case res := <-done:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("Relay did not return")
+		return relayResult{}
+	}
+}
+
+func TestRelay_HalfClose(t *testing.T) {
+	for _, name := range []string{"default", "options"} {
+		t.Run(name, func(t *testing.T) {
+			var (
+				opts      *socksnet.RelayOptions
+				up, down  countingLimiter
+				captured  bytes.Buffer
+				requested = bytes.Repeat([]byte("q"), 1000)
+				response  = bytes.Repeat([]byte("r"), 3000)
+			)
+			if name == "options" {
+				opts = &socksnet.RelayOptions{
+					BufferSize:  4096,
+					IdleTimeout: 5 * time.Second,
+					LimitUp:     &up,
+					LimitDown:   &down,
+					Tee:         &captured,
+				}
+			}
+
+			a, b, done := relayPair(t, context.Background(), opts)
+
+			// a sends its request and closes its write half
+			a.Write(requested)
+			a.(*net.TCPConn).CloseWrite()
+
+			// b sees the request end, then answers over the still open direction
+			got, err := io.ReadAll(b)
+			if err != nil || !bytes.Equal(got, requested) {
+				t.Fatalf("b read (%d bytes, %v), want the %d byte request", len(got), err, len(requested))
+			}
+			b.Write(response)
+			b.Close()
+
+			if got, err = io.ReadAll(a); err != nil || !bytes.Equal(got, response) {
+				t.Fatalf("a read (%d bytes, %v), want the %d byte response", len(got), err, len(response))
+			}
+
+			res := waitRelay(t, done)
+			if res.err != nil {
+				t.Fatalf("Relay error = %v", res.err)
+			}
+			if res.stats.BytesUp != 1000 || res.stats.BytesDown != 3000 {
+				t.Fatalf("stats up=%d down=%d, want 1000 and 3000", res.stats.BytesUp, res.stats.BytesDown)
+			}
+			if res.stats.DurationUp <= 0 || res.stats.DurationDown < res.stats.DurationUp {
+				t.Fatalf("durations up=%v down=%v, want up ending first", res.stats.DurationUp, res.stats.DurationDown)
+			}
+
+			if name == "options" {
+				if up.n.Load() != 1000 || down.n.Load() != 3000 {
+					t.Fatalf("limiters allowed up=%d down=%d, want 1000 and 3000", up.n.Load(), down.n.Load())
+				}
+				if captured.Len() != 4000 {
+					t.Fatalf("Tee captured %d bytes, want 4000", captured.Len())
+				}
+			}
+		})
+	}
+}
+
+func TestRelay_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, _, done := relayPair(t, ctx, nil)
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if res := waitRelay(t, done); !errors.Is(res.err, context.Canceled) {
+		t.Fatalf("Relay error = %v, want context.Canceled", res.err)
+	}
+}
+
+func TestRelay_IdleTimeout(t *testing.T) {
+	_, _, done := relayPair(t, context.Background(), &socksnet.RelayOptions{IdleTimeout: 50 * time.Millisecond})
+
+	res := waitRelay(t, done)
+	var ne net.Error
+	if !errors.As(res.err, &ne) || !ne.Timeout() {
+		t.Fatalf("Relay error = %v, want a timeout", res.err)
+	}
+}
+
+func TestRelay_LimiterError(t *testing.T) {
+	errLimit := errors.New("limit")
+	a, _, done := relayPair(t, context.Background(), &socksnet.RelayOptions{
+		LimitUp: limiterFunc(func(ctx context.Context, n int) error { return errLimit }),
+	})
+
+	a.Write([]byte("x"))
+
+	if res := waitRelay(t, done); !errors.Is(res.err, errLimit) || res.stats.BytesUp != 0 {
+		t.Fatalf("Relay = (up %d, %v), want (0, %v)", res.stats.BytesUp, res.err, errLimit)
+	}
+}
+
+// limiterFunc adapts a function to RateLimiter.
+type limiterFunc func(ctx context.Context, n int) error
+
+func (f limiterFunc) WaitN(ctx context.Context, n int) error {
+	return f(ctx, n)
+}
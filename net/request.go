@@ -0,0 +1,17 @@
+package net
+
+import "context"
+
+// RequestInfo is a version-agnostic view of a SOCKS4 or SOCKS5 request, so that
+// one policy can serve both servers. The socks4 and socks5 packages provide it
+// through their NewRequestInfo functions.
+type RequestInfo interface {
+	Version() int     // SOCKS version, 4 or 5
+	Command() string  // Command name, e.g. "CONNECT"
+	Host() string     // Requested domain name or IP address
+	Port() uint16     // Requested port
+	Username() string // Authenticated user, GSSAPI client name or SOCKS4 user ID ("" if none)
+}
+
+// RuleFunc decides whether a request may proceed; a non-nil error rejects it.
+type RuleFunc func(ctx context.Context, req RequestInfo) error
@@ -0,0 +1,115 @@
+package net_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+	"github.com/33TU/socks/socks4"
+	"github.com/33TU/socks/socks5"
+)
+
+// listen starts serving on a local listener until the test ends.
+func listen(t *testing.T, serve func(ctx context.Context, ln net.Listener) error) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go serve(ctx, ln)
+	return ln.Addr().String()
+}
+
+func TestRuleFunc_BothServers(t *testing.T) {
+	target := listen(t, func(ctx context.Context, ln net.Listener) error {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			c.Close()
+		}
+	})
+	_, targetPort, _ := net.SplitHostPort(target)
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+
+	// one policy for both protocols: only "alice" may connect
+	rule := func(ctx context.Context, req socksnet.RequestInfo) error {
+		mu.Lock()
+		seen = append(seen, fmt.Sprintf("v%d %s %s:%d %s", req.Version(), req.Command(), req.Host(), req.Port(), req.Username()))
+		mu.Unlock()
+
+		if req.Username() != "alice" {
+			return errors.New("user not allowed")
+		}
+		return nil
+	}
+
+	socks5Addr := listen(t, func(ctx context.Context, ln net.Listener) error {
+		return socks5.Serve(ctx, ln, &socks5.BaseServerHandler{
+			AllowConnect:     true,
+			SupportedMethods: []byte{socks5.MethodUserPass},
+			Rule:             rule,
+			Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		})
+	})
+	socks4Addr := listen(t, func(ctx context.Context, ln net.Listener) error {
+		return socks4.Serve(ctx, ln, &socks4.BaseServerHandler{
+			AllowConnect: true,
+			Rule:         rule,
+		})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dialers := []struct {
+		name string
+		dial func(user string) (net.Conn, error)
+	}{
+		{"socks5", func(user string) (net.Conn, error) {
+			return socks5.NewDialer(socks5Addr, &socks5.Auth{Username: user, Password: "pw"}, nil).DialContext(ctx, "tcp", target)
+		}},
+		{"socks4", func(user string) (net.Conn, error) {
+			return socks4.NewDialer(socks4Addr, user, nil).DialContext(ctx, "tcp", target)
+		}},
+	}
+
+	for _, d := range dialers {
+		conn, err := d.dial("alice")
+		if err != nil {
+			t.Fatalf("%s: expected alice to be allowed, got %v", d.name, err)
+		}
+		conn.Close()
+
+		if _, err := d.dial("mallory"); err == nil {
+			t.Fatalf("%s: expected mallory to be rejected", d.name)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{
+		"v5 CONNECT 127.0.0.1:" + targetPort + " alice",
+		"v5 CONNECT 127.0.0.1:" + targetPort + " mallory",
+		"v4 CONNECT 127.0.0.1:" + targetPort + " alice",
+		"v4 CONNECT 127.0.0.1:" + targetPort + " mallory",
+	}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Fatalf("rule saw %q, want %q", seen, want)
+	}
+}
@@ -0,0 +1,55 @@
+package net
+
+import (
+	"bufio"
+	"fmt"
+)
+
+// Protocols reported in ErrNotSOCKS.Detected.
+const (
+	DetectedHTTP    = "http"
+	DetectedTLS     = "tls"
+	DetectedSOCKS4  = "socks4-on-socks5-port"
+	DetectedSOCKS5  = "socks5-on-socks4-port"
+	DetectedUnknown = "unknown"
+)
+
+// ErrNotSOCKS is returned by the SOCKS servers when the first byte from a client
+// is not the expected protocol version, typically a browser or tool speaking
+// HTTP or TLS to the SOCKS port. Detected is one of the Detected* constants.
+type ErrNotSOCKS struct {
+	Detected string
+}
+
+func (e *ErrNotSOCKS) Error() string {
+	return fmt.Sprintf("not a SOCKS client (detected %s)", e.Detected)
+}
+
+// SniffNotSOCKS peeks at the first byte from r without consuming it and returns
+// an *ErrNotSOCKS if it is not version. It returns nil if the byte matches or
+// cannot be read, leaving the error to the read that follows.
+func SniffNotSOCKS(r *bufio.Reader, version byte) error {
+	b, err := r.Peek(1)
+	if err != nil || b[0] == version {
+		return nil
+	}
+	return &ErrNotSOCKS{Detected: DetectProtocol(b[0], version)}
+}
+
+// DetectProtocol classifies the first byte of a connection that is not version,
+// returning one of the Detected* constants. A version of 0 expects either SOCKS
+// version, e.g. on a port serving both.
+func DetectProtocol(b, version byte) string {
+	switch {
+	case b == 0x16: // TLS handshake record
+		return DetectedTLS
+	case b == 0x04 && version == 0x05:
+		return DetectedSOCKS4
+	case b == 0x05 && version == 0x04:
+		return DetectedSOCKS5
+	case 'A' <= b && b <= 'Z': // HTTP methods are upper-case tokens
+		return DetectedHTTP
+	default:
+		return DetectedUnknown
+	}
+}
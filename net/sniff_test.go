@@ -0,0 +1,52 @@
+package net_test
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestSniffNotSOCKS(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		version byte
+		want    string // "" = no error
+	}{
+		{"socks5", "\x05\x01\x00", 0x05, ""},
+		{"socks4", "\x04\x01\x00\x50", 0x04, ""},
+		{"http GET", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", 0x05, socksnet.DetectedHTTP},
+		{"http CONNECT", "CONNECT example.com:443 HTTP/1.1\r\n\r\n", 0x04, socksnet.DetectedHTTP},
+		{"tls ClientHello", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", 0x05, socksnet.DetectedTLS},
+		{"socks4 on socks5 port", "\x04\x01\x00\x50\x7f\x00\x00\x01\x00", 0x05, socksnet.DetectedSOCKS4},
+		{"socks5 on socks4 port", "\x05\x01\x00", 0x04, socksnet.DetectedSOCKS5},
+		{"garbage", "\x00\xff", 0x05, socksnet.DetectedUnknown},
+		{"empty", "", 0x05, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.input))
+			err := socksnet.SniffNotSOCKS(r, tt.version)
+
+			var notSOCKS *socksnet.ErrNotSOCKS
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.want != "" && !errors.As(err, &notSOCKS):
+				t.Fatalf("expected *ErrNotSOCKS, got %v", err)
+			case tt.want != "" && notSOCKS.Detected != tt.want:
+				t.Fatalf("Detected = %q, want %q", notSOCKS.Detected, tt.want)
+			}
+
+			// nothing is consumed
+			if rest, _ := io.ReadAll(r); string(rest) != tt.input {
+				t.Fatalf("reader left %q, want %q", rest, tt.input)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package net
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// TeeConn mirrors the bytes read from and written to a net.Conn into
+// writers, e.g. a hex dump logger or a capture file. Mirror writes are
+// serialized, so Reads and Writes may share one writer, and their errors are
+// ignored so capture never fails the connection.
+//
+// Like CountingConn it deliberately does not implement syscall.Conn,
+// io.ReaderFrom or io.WriterTo, so all traffic passes through the mirrors.
+type TeeConn struct {
+	net.Conn
+
+	// Reads and Writes receive the bytes read and written (nil=not mirrored).
+	// They must not be changed while a Read or Write may be running.
+	Reads  io.Writer
+	Writes io.Writer
+
+	mu sync.Mutex
+}
+
+// NewTeeConn returns conn mirroring reads to reads and writes to writes.
+func NewTeeConn(conn net.Conn, reads, writes io.Writer) *TeeConn {
+	return &TeeConn{Conn: conn, Reads: reads, Writes: writes}
+}
+
+func (c *TeeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.Reads != nil {
+		c.mirror(c.Reads, p[:n])
+	}
+	return n, err
+}
+
+func (c *TeeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 && c.Writes != nil {
+		c.mirror(c.Writes, p[:n])
+	}
+	return n, err
+}
+
+// CloseWrite closes the write side of the connection if supported, or the
+// whole connection otherwise.
+func (c *TeeConn) CloseWrite() error {
+	return CloseWrite(c.Conn)
+}
+
+func (c *TeeConn) mirror(w io.Writer, p []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w.Write(p)
+}
@@ -0,0 +1,59 @@
+package net_test
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+func TestTeeConn_Concurrent(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	// reads and writes share one capture buffer
+	var capture bytes.Buffer
+	tc := socksnet.NewTeeConn(server, &capture, &capture)
+
+	out := bytes.Repeat([]byte("w"), 64*1024)
+	in := bytes.Repeat([]byte("r"), 64*1024)
+
+	var wg sync.WaitGroup
+	wg.Go(func() { tc.Write(out) })
+	wg.Go(func() { io.CopyN(io.Discard, client, int64(len(out))) })
+	wg.Go(func() { client.Write(in) })
+	if _, err := io.ReadFull(tc, make([]byte, len(in))); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	wg.Wait()
+
+	got := capture.Bytes()
+	if len(got) != len(in)+len(out) || bytes.Count(got, []byte("r")) != len(in) {
+		t.Fatalf("captured %d bytes (%d read), want %d (%d read)", len(got), bytes.Count(got, []byte("r")), len(in)+len(out), len(in))
+	}
+}
+
+func TestTeeConn_Directions(t *testing.T) {
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	var writes bytes.Buffer
+	tc := socksnet.NewTeeConn(server, nil, &writes)
+
+	tc.Write([]byte("out"))
+	client.Write([]byte("in"))
+	io.ReadFull(tc, make([]byte, 2))
+
+	if writes.String() != "out" {
+		t.Fatalf("captured %q, want only the written %q", writes.String(), "out")
+	}
+
+	if _, ok := any(tc).(syscall.Conn); ok {
+		t.Fatal("TeeConn exposes syscall.Conn")
+	}
+}
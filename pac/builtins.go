@@ -0,0 +1,134 @@
+package pac
+
+import (
+	"context"
+	"net"
+	"strings"
+)
+
+// newBuiltins returns the standard PAC helper functions backed by resolver.
+func newBuiltins(resolver *net.Resolver) map[string]builtin {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	// resolve returns the first IPv4 address of host, or nil if it cannot be resolved.
+	resolve := func(ctx context.Context, host string) net.IP {
+		if ip := net.ParseIP(host); ip != nil {
+			return ip
+		}
+		ips, err := resolver.LookupIP(ctx, "ip4", host)
+		if err != nil || len(ips) == 0 {
+			return nil
+		}
+		return ips[0]
+	}
+
+	return map[string]builtin{
+		"isPlainHostName": func(ctx context.Context, args []any) (any, error) {
+			return !strings.Contains(argString(args, 0), "."), nil
+		},
+
+		"dnsDomainIs": func(ctx context.Context, args []any) (any, error) {
+			return strings.HasSuffix(argString(args, 0), argString(args, 1)), nil
+		},
+
+		"localHostOrDomainIs": func(ctx context.Context, args []any) (any, error) {
+			host, hostdom := argString(args, 0), argString(args, 1)
+			if host == hostdom {
+				return true, nil
+			}
+			return !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+		},
+
+		"dnsDomainLevels": func(ctx context.Context, args []any) (any, error) {
+			return float64(strings.Count(argString(args, 0), ".")), nil
+		},
+
+		"shExpMatch": func(ctx context.Context, args []any) (any, error) {
+			return shExpMatch(argString(args, 0), argString(args, 1)), nil
+		},
+
+		"isResolvable": func(ctx context.Context, args []any) (any, error) {
+			return resolve(ctx, argString(args, 0)) != nil, nil
+		},
+
+		"dnsResolve": func(ctx context.Context, args []any) (any, error) {
+			if ip := resolve(ctx, argString(args, 0)); ip != nil {
+				return ip.String(), nil
+			}
+			return nil, nil
+		},
+
+		"isInNet": func(ctx context.Context, args []any) (any, error) {
+			ip := resolve(ctx, argString(args, 0)).To4()
+			pattern := net.ParseIP(argString(args, 1)).To4()
+			mask := net.ParseIP(argString(args, 2)).To4()
+			if ip == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			for i := range 4 {
+				if ip[i]&mask[i] != pattern[i]&mask[i] {
+					return false, nil
+				}
+			}
+			return true, nil
+		},
+
+		"myIPAddress": func(ctx context.Context, args []any) (any, error) {
+			return myIPAddress(), nil
+		},
+	}
+}
+
+// argString returns args[i] converted to a string, or "" if absent.
+func argString(args []any, i int) string {
+	if i >= len(args) || args[i] == nil {
+		return ""
+	}
+	return toString(args[i])
+}
+
+// shExpMatch reports whether s matches the shell expression pattern,
+// where '*' matches any sequence of characters and '?' any single character.
+func shExpMatch(s, pattern string) bool {
+	var si, pi int
+	star, match := -1, 0
+
+	for si < len(s) {
+		switch {
+		case pi < len(pattern) && (pattern[pi] == '?' || pattern[pi] == s[si]):
+			si++
+			pi++
+		case pi < len(pattern) && pattern[pi] == '*':
+			star, match = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			match++
+			si = match
+		default:
+			return false
+		}
+	}
+
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+	return pi == len(pattern)
+}
+
+// myIPAddress returns the IPv4 address of the interface used for outbound traffic.
+// No packets are sent; connecting a UDP socket only selects a route.
+func myIPAddress() string {
+	conn, err := net.Dial("udp4", "198.51.100.1:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.IP.String()
+	}
+	return "127.0.0.1"
+}
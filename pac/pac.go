@@ -0,0 +1,362 @@
+// Package pac implements Proxy Auto-Config (PAC) file support.
+//
+// A PAC file is evaluated by a small built-in interpreter covering the
+// JavaScript subset used by typical PAC files and the standard helper
+// functions (isInNet, myIPAddress, dnsResolve, shExpMatch, ...).
+package pac
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+	"github.com/33TU/socks/socks4"
+	"github.com/33TU/socks/socks5"
+)
+
+// Errors returned by PAC configuration and dialing.
+var (
+	ErrInvalidResult   = errors.New("pac: invalid FindProxyForURL result")
+	ErrUnsupportedURL  = errors.New("pac: unsupported PAC URL scheme")
+	ErrNoProxyResolved = errors.New("pac: no proxy could be used")
+)
+
+// maxScriptSize limits the size of a fetched PAC file.
+const maxScriptSize = 1 << 20
+
+// fetchTimeout bounds fetching a PAC file over http or https, including the
+// body, whatever the deadline of the context.
+const fetchTimeout = 30 * time.Second
+
+// Proxy types returned by FindProxyForURL.
+const (
+	TypeDirect = "DIRECT"
+	TypeProxy  = "PROXY"
+	TypeSOCKS4 = "SOCKS4"
+	TypeSOCKS5 = "SOCKS5"
+)
+
+// Proxy is a single entry of a FindProxyForURL result.
+type Proxy struct {
+	Type string // DIRECT, PROXY, SOCKS4 or SOCKS5
+	Addr string // host:port; empty for DIRECT
+}
+
+// String returns the proxy in PAC result notation.
+func (p Proxy) String() string {
+	if p.Type == TypeDirect {
+		return TypeDirect
+	}
+	return p.Type + " " + p.Addr
+}
+
+// PACConfig is a parsed PAC file.
+type PACConfig struct {
+	URL    string // source URL, if fetched
+	Script string // PAC script source
+
+	// Resolver is used by the DNS helper functions (nil=net.DefaultResolver).
+	Resolver *net.Resolver
+
+	// Dialer is used for DIRECT connections and to reach proxies (nil=DefaultDialer).
+	Dialer socksnet.Dialer
+
+	funcs map[string]*function
+	top   []stmt
+}
+
+// ParsePAC fetches and parses the PAC file at rawURL.
+// Supported schemes are http, https and file; a plain path is read from disk.
+func ParsePAC(rawURL string) (*PACConfig, error) {
+	return ParsePACContext(context.Background(), rawURL)
+}
+
+// ParsePACContext is ParsePAC with a context bounding the fetch. An http or
+// https fetch is also limited to 30 seconds.
+func ParsePACContext(ctx context.Context, rawURL string) (*PACConfig, error) {
+	src, err := fetch(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := ParsePACScript(src)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.URL = rawURL
+	return cfg, nil
+}
+
+// ParsePACScript parses PAC script source.
+func ParsePACScript(src string) (*PACConfig, error) {
+	funcs, top, err := parseProgram(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := funcs["FindProxyForURL"]; !ok {
+		return nil, ErrNoFindProxy
+	}
+
+	return &PACConfig{
+		Script: src,
+		funcs:  funcs,
+		top:    top,
+	}, nil
+}
+
+// FindProxyForURL evaluates the PAC script and returns its raw result string.
+// It is safe for concurrent use: each evaluation has its own globals, so a
+// slow DNS helper in one does not hold up the others.
+func (c *PACConfig) FindProxyForURL(ctx context.Context, rawURL, host string) (string, error) {
+	in := &interp{
+		ctx:      ctx,
+		funcs:    c.funcs,
+		builtins: newBuiltins(c.Resolver),
+		globals:  newScope(nil),
+	}
+
+	// top-level statements initialize globals
+	for _, s := range c.top {
+		if _, _, err := s.exec(in, in.globals); err != nil {
+			return "", err
+		}
+	}
+
+	v, err := in.call("FindProxyForURL", []any{rawURL, host})
+	if err != nil {
+		return "", err
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %v", ErrInvalidResult, v)
+	}
+	return s, nil
+}
+
+// FindProxy returns the ordered proxy list to use for the "host:port" address.
+func (c *PACConfig) FindProxy(ctx context.Context, address string) ([]Proxy, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.FindProxyForURL(ctx, targetURL(host, port), host)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseResult(result)
+}
+
+// ParseResult parses a FindProxyForURL result such as "SOCKS5 10.0.0.1:1080; DIRECT".
+func ParseResult(result string) ([]Proxy, error) {
+	var proxies []Proxy
+
+	for entry := range strings.SplitSeq(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		typ := strings.ToUpper(fields[0])
+		switch {
+		case typ == TypeDirect && len(fields) == 1:
+			proxies = append(proxies, Proxy{Type: TypeDirect})
+
+		case (typ == TypeProxy || typ == TypeSOCKS4 || typ == TypeSOCKS5) && len(fields) == 2:
+			if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+				return nil, fmt.Errorf("%w: %q: %v", ErrInvalidResult, entry, err)
+			}
+			proxies = append(proxies, Proxy{Type: typ, Addr: fields[1]})
+
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidResult, entry)
+		}
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidResult, result)
+	}
+	return proxies, nil
+}
+
+// Dialer returns a DialFunc that evaluates FindProxyForURL for each target
+// address and connects through the selected proxies, trying each entry of
+// the result in order until one succeeds.
+func Dialer(cfg *PACConfig) socksnet.DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		proxies, err := cfg.FindProxy(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+
+		lastErr := ErrNoProxyResolved
+		for _, p := range proxies {
+			conn, err := cfg.proxyDialer(p).DialContext(ctx, network, address)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		return nil, lastErr
+	}
+}
+
+// proxyDialer returns the dialer used for a single PAC result entry.
+func (c *PACConfig) proxyDialer(p Proxy) socksnet.Dialer {
+	base := c.Dialer
+	if base == nil {
+		base = socksnet.DefaultDialer
+	}
+
+	switch p.Type {
+	case TypeSOCKS5:
+		return socks5.NewDialer(p.Addr, nil, base)
+	case TypeSOCKS4:
+		return socks4.NewDialer(p.Addr, "", base)
+	case TypeProxy:
+		return &httpConnectDialer{proxyAddr: p.Addr, dialer: base}
+	default:
+		return base
+	}
+}
+
+// targetURL builds the URL passed to FindProxyForURL for a raw TCP target.
+func targetURL(host, port string) string {
+	scheme := "http"
+	if port == "443" {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/"
+}
+
+// fetch loads the PAC script from rawURL.
+func fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	var rc io.ReadCloser
+
+	switch u.Scheme {
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := (&http.Client{Timeout: fetchTimeout}).Do(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("pac: fetching %s: %s", rawURL, resp.Status)
+		}
+		rc = resp.Body
+
+	case "file", "":
+		path := u.Path
+		if u.Scheme == "" {
+			path = rawURL
+		}
+		if rc, err = os.Open(path); err != nil {
+			return "", err
+		}
+
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedURL, u.Scheme)
+	}
+	defer rc.Close()
+
+	b, err := io.ReadAll(io.LimitReader(rc, maxScriptSize))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy using CONNECT.
+type httpConnectDialer struct {
+	proxyAddr string
+	dialer    socksnet.Dialer
+}
+
+// DialContext implements [socksnet.Dialer].
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Read the status line and headers byte by byte so that no tunneled data is buffered.
+	br := bufio.NewReaderSize(&byteReader{conn}, 16)
+	status, err := br.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if line == "\r\n" || line == "\n" {
+			break
+		}
+	}
+
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		conn.Close()
+		return nil, fmt.Errorf("pac: malformed proxy response %q", status)
+	}
+	if code, err := strconv.Atoi(fields[1]); err != nil || code/100 != 2 {
+		conn.Close()
+		return nil, fmt.Errorf("pac: proxy CONNECT failed: %s", strings.TrimSpace(status))
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// byteReader reads at most one byte per call.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
@@ -0,0 +1,304 @@
+package pac_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+	"github.com/33TU/socks/pac"
+	"github.com/33TU/socks/socks5"
+)
+
+const testPAC = `
+// route internal hosts through the SOCKS5 proxy
+var proxy = "SOCKS5 10.0.0.1:1080";
+
+function isInternal(host) {
+	return shExpMatch(host, "*.internal") || dnsDomainIs(host, ".corp");
+}
+
+function FindProxyForURL(url, host) {
+	if (isInternal(host)) {
+		return proxy;
+	}
+	if (isPlainHostName(host) && host != "localhost") {
+		return "PROXY 127.0.0.1:3128; DIRECT";
+	}
+	return "DIRECT";
+}
+`
+
+func startEcho(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen echo: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(c)
+		}
+	}()
+
+	return ln
+}
+
+// startSOCKS5 starts a SOCKS5 server which sends every CONNECT to target and counts them.
+func startSOCKS5(t *testing.T, target string, count *atomic.Int32) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen socks5: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	handler := &socks5.BaseServerHandler{
+		AllowConnect: true,
+		Dialer: socksnet.DialFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			count.Add(1)
+			return (&net.Dialer{}).DialContext(ctx, network, target)
+		}),
+	}
+
+	go socks5.Serve(ctx, ln, handler)
+	return ln
+}
+
+func servePAC(t *testing.T, script string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+		io.WriteString(w, script)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/proxy.pac"
+}
+
+func TestParsePAC_FindProxy(t *testing.T) {
+	cfg, err := pac.ParsePAC(servePAC(t, testPAC))
+	if err != nil {
+		t.Fatalf("ParsePAC: %v", err)
+	}
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"db.internal:5432", "SOCKS5 10.0.0.1:1080"},
+		{"git.corp:443", "SOCKS5 10.0.0.1:1080"},
+		{"example.com:80", "DIRECT"},
+		{"intranet:80", "PROXY 127.0.0.1:3128"},
+		{"localhost:80", "DIRECT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			proxies, err := cfg.FindProxy(context.Background(), tt.address)
+			if err != nil {
+				t.Fatalf("FindProxy: %v", err)
+			}
+			if got := proxies[0].String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialer_RoutesByPAC(t *testing.T) {
+	echoLn := startEcho(t)
+	_, echoPort, _ := net.SplitHostPort(echoLn.Addr().String())
+
+	var proxied atomic.Int32
+	socksLn := startSOCKS5(t, echoLn.Addr().String(), &proxied)
+
+	script := `function FindProxyForURL(url, host) {
+		if (shExpMatch(host, "*.internal")) {
+			return "SOCKS5 ` + socksLn.Addr().String() + `";
+		}
+		return "DIRECT";
+	}`
+
+	cfg, err := pac.ParsePAC(servePAC(t, script))
+	if err != nil {
+		t.Fatalf("ParsePAC: %v", err)
+	}
+	dial := pac.Dialer(cfg)
+
+	roundTrip := func(address string) {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		conn, err := dial(ctx, "tcp", address)
+		if err != nil {
+			t.Fatalf("dial %s: %v", address, err)
+		}
+		defer conn.Close()
+
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(buf) != "ping" {
+			t.Fatalf("expected echo, got %q", buf)
+		}
+	}
+
+	// matching host goes through the SOCKS5 proxy
+	roundTrip(net.JoinHostPort("db.internal", echoPort))
+	if n := proxied.Load(); n != 1 {
+		t.Fatalf("expected 1 proxied dial, got %d", n)
+	}
+
+	// everything else is dialed directly
+	roundTrip(echoLn.Addr().String())
+	if n := proxied.Load(); n != 1 {
+		t.Fatalf("expected direct dial to bypass proxy, got %d proxied dials", n)
+	}
+}
+
+func TestParsePACScript_Errors(t *testing.T) {
+	if _, err := pac.ParsePACScript(`function other() { return "DIRECT"; }`); !errors.Is(err, pac.ErrNoFindProxy) {
+		t.Errorf("expected ErrNoFindProxy, got %v", err)
+	}
+	if _, err := pac.ParsePACScript(`function FindProxyForURL(url, host) { return "DIRECT" `); !errors.Is(err, pac.ErrSyntax) {
+		t.Errorf("expected ErrSyntax, got %v", err)
+	}
+
+	cfg, err := pac.ParsePACScript(`function FindProxyForURL(url, host) { return "BOGUS"; }`)
+	if err != nil {
+		t.Fatalf("ParsePACScript: %v", err)
+	}
+	if _, err := cfg.FindProxy(context.Background(), "example.com:80"); !errors.Is(err, pac.ErrInvalidResult) {
+		t.Errorf("expected ErrInvalidResult, got %v", err)
+	}
+}
+
+func TestParsePACScript_Builtins(t *testing.T) {
+	script := `function FindProxyForURL(url, host) {
+		if (isInNet(host, "10.0.0.0", "255.0.0.0")) return "SOCKS5 10.0.0.1:1080";
+		if (localHostOrDomainIs(host, "www.example.com")) return "SOCKS4 10.0.0.2:1080";
+		if (dnsDomainLevels(host) > 2) return "PROXY 10.0.0.3:8080";
+		return "DIRECT";
+	}`
+
+	cfg, err := pac.ParsePACScript(script)
+	if err != nil {
+		t.Fatalf("ParsePACScript: %v", err)
+	}
+
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"10.1.2.3:80", "SOCKS5 10.0.0.1:1080"},
+		{"www:80", "SOCKS4 10.0.0.2:1080"},
+		{"www.example.com:80", "SOCKS4 10.0.0.2:1080"},
+		{"a.b.example.com:80", "PROXY 10.0.0.3:8080"},
+		{"example.com:80", "DIRECT"},
+	}
+
+	for _, tt := range tests {
+		proxies, err := cfg.FindProxy(context.Background(), tt.address)
+		if err != nil {
+			t.Fatalf("FindProxy(%s): %v", tt.address, err)
+		}
+		if got := proxies[0].String(); got != tt.want {
+			t.Errorf("FindProxy(%s) = %q, want %q", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestParsePACContext_HungServer(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := pac.ParsePACContext(ctx, srv.URL+"/proxy.pac"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ParsePACContext = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("ParsePACContext returned after %v", elapsed)
+	}
+}
+
+func TestFindProxyForURL_Concurrent(t *testing.T) {
+	// lookups of slow.example block until released
+	lookup := make(chan struct{}, 1)
+	release := make(chan struct{})
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			select {
+			case lookup <- struct{}{}:
+			default:
+			}
+			<-release
+			return nil, errors.New("no DNS in tests")
+		},
+	}
+
+	cfg, err := pac.ParsePACScript(`function FindProxyForURL(url, host) {
+		if (host == "slow.example" && isResolvable(host)) {
+			return "SOCKS5 10.0.0.1:1080";
+		}
+		return "DIRECT";
+	}`)
+	if err != nil {
+		t.Fatalf("ParsePACScript: %v", err)
+	}
+	cfg.Resolver = resolver
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := cfg.FindProxy(context.Background(), "slow.example:80")
+		slow <- err
+	}()
+	<-lookup
+
+	// another evaluation is not held up by the pending lookup
+	done := make(chan error, 1)
+	go func() {
+		_, err := cfg.FindProxy(context.Background(), "fast.example:80")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("FindProxy(fast.example) = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("FindProxy waited for another evaluation's DNS lookup")
+	}
+
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("FindProxy(slow.example) = %v", err)
+	}
+}
@@ -0,0 +1,809 @@
+package pac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The evaluator below understands the small JavaScript subset used by
+// practically all PAC files: function declarations, var declarations and
+// assignments, if/else, return, string/number/boolean literals, function
+// calls and the usual comparison, logical and "+" operators.
+
+// Script evaluation errors.
+var (
+	ErrSyntax         = errors.New("pac: syntax error")
+	ErrUndefined      = errors.New("pac: undefined identifier")
+	ErrNotCallable    = errors.New("pac: undefined function")
+	ErrNoFindProxy    = errors.New("pac: FindProxyForURL is not defined")
+	ErrRecursionLimit = errors.New("pac: maximum call depth exceeded")
+)
+
+var errUnexpectedToken = fmt.Errorf("%w: unexpected token", ErrSyntax)
+
+// maxCallDepth bounds recursion in user-defined PAC functions.
+const maxCallDepth = 64
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits src into tokens, skipping whitespace and comments.
+func lex(src string) ([]token, error) {
+	var toks []token
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment at %d", ErrSyntax, i)
+			}
+			i += end + 4
+
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(src[j])
+					}
+					continue
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrSyntax, i)
+			}
+			toks = append(toks, token{tokString, sb.String(), i})
+			i = j + 1
+
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+
+		case c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' ||
+				src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' ||
+				src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+
+		default:
+			op := ""
+			for _, p := range []string{"===", "!==", "==", "!=", "<=", ">=", "&&", "||"} {
+				if strings.HasPrefix(src[i:], p) {
+					op = p
+					break
+				}
+			}
+			if op == "" {
+				if !strings.ContainsRune("(){};,=!+-<>", rune(c)) {
+					return nil, fmt.Errorf("%w: unexpected character %q at %d", ErrSyntax, c, i)
+				}
+				op = string(c)
+			}
+			toks = append(toks, token{tokPunct, op, i})
+			i += len(op)
+		}
+	}
+
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+// AST
+
+type expr interface {
+	eval(in *interp, sc *scope) (any, error)
+}
+
+type stmt interface {
+	exec(in *interp, sc *scope) (ret bool, v any, err error)
+}
+
+type (
+	litExpr   struct{ v any }
+	identExpr struct{ name string }
+	callExpr  struct {
+		name string
+		args []expr
+	}
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		l, r expr
+	}
+)
+
+type (
+	blockStmt  struct{ body []stmt }
+	exprStmt   struct{ x expr }
+	returnStmt struct{ x expr }
+	assignStmt struct {
+		name    string
+		x       expr
+		declare bool
+	}
+	ifStmt struct {
+		cond            expr
+		then, otherwise stmt
+	}
+)
+
+type function struct {
+	name   string
+	params []string
+	body   *blockStmt
+}
+
+// parser
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokPunct || t.kind == tokIdent) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("%w %q at %d, expected %q", errUnexpectedToken, t.text, t.pos, text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("%w %q at %d, expected identifier", errUnexpectedToken, t.text, t.pos)
+	}
+	return t.text, nil
+}
+
+// parseProgram parses the top-level function declarations and statements.
+func parseProgram(src string) (map[string]*function, []stmt, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &parser{toks: toks}
+	funcs := make(map[string]*function)
+	var top []stmt
+
+	for p.peek().kind != tokEOF {
+		if p.accept("function") {
+			fn, err := p.parseFunction()
+			if err != nil {
+				return nil, nil, err
+			}
+			funcs[fn.name] = fn
+			continue
+		}
+
+		s, err := p.parseStmt()
+		if err != nil {
+			return nil, nil, err
+		}
+		top = append(top, s)
+	}
+
+	return funcs, top, nil
+}
+
+func (p *parser) parseFunction() (*function, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var params []string
+	for !p.accept(")") {
+		if len(params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+	}
+
+	body, err := p.parseBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return &function{name: name, params: params, body: body}, nil
+}
+
+func (p *parser) parseBlock() (*blockStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var body []stmt
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			return nil, fmt.Errorf("%w: unterminated block", ErrSyntax)
+		}
+		s, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+
+	return &blockStmt{body: body}, nil
+}
+
+func (p *parser) parseStmt() (stmt, error) {
+	switch {
+	case p.is("{"):
+		return p.parseBlock()
+
+	case p.accept(";"):
+		return &blockStmt{}, nil
+
+	case p.accept("if"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		then, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		var els stmt
+		if p.accept("else") {
+			if els, err = p.parseStmt(); err != nil {
+				return nil, err
+			}
+		}
+		return &ifStmt{cond: cond, then: then, otherwise: els}, nil
+
+	case p.accept("return"):
+		var x expr
+		if !p.is(";") && !p.is("}") {
+			var err error
+			if x, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		p.accept(";")
+		return &returnStmt{x: x}, nil
+
+	case p.accept("var"):
+		var decls []stmt
+		for {
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			var x expr = &litExpr{}
+			if p.accept("=") {
+				if x, err = p.parseExpr(); err != nil {
+					return nil, err
+				}
+			}
+			decls = append(decls, &assignStmt{name: name, x: x, declare: true})
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.accept(";")
+		return &blockStmt{body: decls}, nil
+	}
+
+	// assignment
+	if p.peek().kind == tokIdent && p.toks[p.pos+1].kind == tokPunct && p.toks[p.pos+1].text == "=" {
+		name := p.next().text
+		p.next()
+		x, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		p.accept(";")
+		return &assignStmt{name: name, x: x}, nil
+	}
+
+	x, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	return &exprStmt{x: x}, nil
+}
+
+// binary operator precedence levels, lowest first.
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+
+	l, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		matched := false
+		if t.kind == tokPunct {
+			for _, op := range precedence[level] {
+				if t.text == op {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return l, nil
+		}
+
+		p.next()
+		r, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryExpr{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	if p.is("!") || p.is("-") {
+		op := p.next().text
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokString:
+		return &litExpr{v: t.text}, nil
+
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number %q at %d", ErrSyntax, t.text, t.pos)
+		}
+		return &litExpr{v: f}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &litExpr{v: true}, nil
+		case "false":
+			return &litExpr{v: false}, nil
+		case "null", "undefined":
+			return &litExpr{}, nil
+		}
+
+		if !p.accept("(") {
+			return &identExpr{name: t.text}, nil
+		}
+
+		call := &callExpr{name: t.text}
+		for !p.accept(")") {
+			if len(call.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+		}
+		return call, nil
+
+	case tokPunct:
+		if t.text == "(" {
+			x, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w %q at %d", errUnexpectedToken, t.text, t.pos)
+}
+
+// interpreter
+
+// builtin is a PAC helper function implemented in Go.
+type builtin func(ctx context.Context, args []any) (any, error)
+
+type interp struct {
+	ctx      context.Context
+	funcs    map[string]*function
+	builtins map[string]builtin
+	globals  *scope
+	depth    int
+}
+
+type scope struct {
+	vars   map[string]any
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: make(map[string]any), parent: parent}
+}
+
+func (s *scope) lookup(name string) (any, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *scope) set(name string, v any) {
+	for sc := s; sc != nil; sc = sc.parent {
+		if _, ok := sc.vars[name]; ok {
+			sc.vars[name] = v
+			return
+		}
+	}
+	s.vars[name] = v
+}
+
+func (in *interp) call(name string, args []any) (any, error) {
+	if fn, ok := in.funcs[name]; ok {
+		if in.depth >= maxCallDepth {
+			return nil, ErrRecursionLimit
+		}
+		in.depth++
+		defer func() { in.depth-- }()
+
+		sc := newScope(in.globals)
+		for i, param := range fn.params {
+			var v any
+			if i < len(args) {
+				v = args[i]
+			}
+			sc.vars[param] = v
+		}
+
+		ret, v, err := fn.body.exec(in, sc)
+		if err != nil || !ret {
+			return nil, err
+		}
+		return v, nil
+	}
+
+	if b, ok := in.builtins[name]; ok {
+		return b(in.ctx, args)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrNotCallable, name)
+}
+
+func (e *litExpr) eval(in *interp, sc *scope) (any, error) { return e.v, nil }
+
+func (e *identExpr) eval(in *interp, sc *scope) (any, error) {
+	if v, ok := sc.lookup(e.name); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUndefined, e.name)
+}
+
+func (e *callExpr) eval(in *interp, sc *scope) (any, error) {
+	args := make([]any, len(e.args))
+	for i, a := range e.args {
+		v, err := a.eval(in, sc)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return in.call(e.name, args)
+}
+
+func (e *unaryExpr) eval(in *interp, sc *scope) (any, error) {
+	v, err := e.x.eval(in, sc)
+	if err != nil {
+		return nil, err
+	}
+	if e.op == "!" {
+		return !truthy(v), nil
+	}
+	return -toNumber(v), nil
+}
+
+func (e *binaryExpr) eval(in *interp, sc *scope) (any, error) {
+	l, err := e.l.eval(in, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// short-circuit operators
+	switch e.op {
+	case "||":
+		if truthy(l) {
+			return l, nil
+		}
+		return e.r.eval(in, sc)
+	case "&&":
+		if !truthy(l) {
+			return l, nil
+		}
+		return e.r.eval(in, sc)
+	}
+
+	r, err := e.r.eval(in, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "==":
+		return looseEqual(l, r), nil
+	case "!=":
+		return !looseEqual(l, r), nil
+	case "===":
+		return l == r, nil
+	case "!==":
+		return l != r, nil
+	case "+":
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok || rok {
+			if !lok {
+				ls = toString(l)
+			}
+			if !rok {
+				rs = toString(r)
+			}
+			return ls + rs, nil
+		}
+		return toNumber(l) + toNumber(r), nil
+	case "-":
+		return toNumber(l) - toNumber(r), nil
+	}
+
+	// relational
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch e.op {
+			case "<":
+				return ls < rs, nil
+			case ">":
+				return ls > rs, nil
+			case "<=":
+				return ls <= rs, nil
+			default:
+				return ls >= rs, nil
+			}
+		}
+	}
+
+	lf, rf := toNumber(l), toNumber(r)
+	switch e.op {
+	case "<":
+		return lf < rf, nil
+	case ">":
+		return lf > rf, nil
+	case "<=":
+		return lf <= rf, nil
+	default:
+		return lf >= rf, nil
+	}
+}
+
+func (s *blockStmt) exec(in *interp, sc *scope) (bool, any, error) {
+	for _, st := range s.body {
+		ret, v, err := st.exec(in, sc)
+		if err != nil || ret {
+			return ret, v, err
+		}
+	}
+	return false, nil, nil
+}
+
+func (s *exprStmt) exec(in *interp, sc *scope) (bool, any, error) {
+	_, err := s.x.eval(in, sc)
+	return false, nil, err
+}
+
+func (s *returnStmt) exec(in *interp, sc *scope) (bool, any, error) {
+	if s.x == nil {
+		return true, nil, nil
+	}
+	v, err := s.x.eval(in, sc)
+	return true, v, err
+}
+
+func (s *assignStmt) exec(in *interp, sc *scope) (bool, any, error) {
+	v, err := s.x.eval(in, sc)
+	if err != nil {
+		return false, nil, err
+	}
+	if s.declare {
+		sc.vars[s.name] = v
+	} else {
+		sc.set(s.name, v)
+	}
+	return false, nil, nil
+}
+
+func (s *ifStmt) exec(in *interp, sc *scope) (bool, any, error) {
+	cond, err := s.cond.eval(in, sc)
+	if err != nil {
+		return false, nil, err
+	}
+	if truthy(cond) {
+		return s.then.exec(in, sc)
+	}
+	if s.otherwise != nil {
+		return s.otherwise.exec(in, sc)
+	}
+	return false, nil, nil
+}
+
+// value helpers following JavaScript semantics where it matters for PAC files.
+
+func truthy(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case string:
+		return x != ""
+	case float64:
+		return x != 0 && x == x
+	}
+	return true
+}
+
+func toNumber(v any) float64 {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	case float64:
+		return x
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0
+		}
+		return f
+	}
+	return 0
+}
+
+func toString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case string:
+		return x
+	}
+	return fmt.Sprint(v)
+}
+
+func looseEqual(l, r any) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	switch lv := l.(type) {
+	case string:
+		if rv, ok := r.(string); ok {
+			return lv == rv
+		}
+	case bool:
+		if rv, ok := r.(bool); ok {
+			return lv == rv
+		}
+	}
+	return toNumber(l) == toNumber(r)
+}
@@ -3,18 +3,53 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"time"
 
+	socksnet "github.com/33TU/socks/net"
 	"github.com/33TU/socks/socks4"
 	"github.com/33TU/socks/socks5"
 )
 
+// ErrNotSOCKS is passed to OnError and returned by ServeConn when the first byte
+// from a client is not a SOCKS version served by the handler.
+type ErrNotSOCKS = socksnet.ErrNotSOCKS
+
 // ServerHandler multiplexes incoming connections to the appropriate SOCKS4 or SOCKS5 handlers based on protocol detection.
+//
+// OnAccept, OnError and OnPanic are shared by both protocols, so policy such as
+// an address filter is set once rather than on each protocol handler.
 type ServerHandler struct {
 	Socks4 socks4.ServerHandler
 	Socks5 socks5.ServerHandler
 
 	UnknownHandler func(conn net.Conn, peekedByte byte)
+
+	AcceptMaxBackoff time.Duration // Maximum delay between retries of temporary Accept errors (0=1s)
+
+	// OnAccept is called for each accepted connection before its protocol is
+	// detected; an error closes the connection. Optional.
+	OnAccept func(ctx context.Context, conn net.Conn) error
+
+	// OnError is called for Accept errors and for connections closed before
+	// reaching a protocol handler, e.g. with an *ErrNotSOCKS. Errors are
+	// *socksnet.PhaseError. Errors after that go to the protocol handler. Optional.
+	OnError func(ctx context.Context, conn net.Conn, err error)
+
+	// OnPanic is called when OnAccept or UnknownHandler panics; panics in the
+	// protocol handlers go to their own OnPanic. Optional; if nil, the panic is
+	// not recovered.
+	OnPanic func(ctx context.Context, conn net.Conn, r any)
+}
+
+// onError calls OnError, if set, with err tagged with phase and returns the tagged error.
+func (h *ServerHandler) onError(ctx context.Context, conn net.Conn, phase socksnet.Phase, err error) error {
+	err = socksnet.WithPhase(phase, err)
+	if h.OnError != nil {
+		h.OnError(ctx, conn, err)
+	}
+	return err
 }
 
 // Serve accepts incoming connections and dispatches based on protocol.
@@ -24,6 +59,8 @@ func Serve(ctx context.Context, listener net.Listener, handler *ServerHandler) e
 		listener.Close()
 	}()
 
+	backoff := socksnet.AcceptBackoff{Max: handler.AcceptMaxBackoff}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -31,9 +68,19 @@ func Serve(ctx context.Context, listener net.Listener, handler *ServerHandler) e
 		default:
 			conn, err := listener.Accept()
 			if err != nil {
-				continue
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				// Retry temporary errors (e.g. EMFILE) with backoff, give up on permanent ones
+				handler.onError(ctx, nil, socksnet.PhaseAccept, err)
+				if backoff.Wait(ctx, err) || ctx.Err() != nil {
+					continue
+				}
+				return err
 			}
 
+			backoff.Reset()
 			go ServeConn(ctx, handler, conn)
 		}
 	}
@@ -49,23 +96,39 @@ func ListenAndServe(ctx context.Context, network, address string, handler *Serve
 }
 
 // ServeConn handles a single client connection, including protocol detection and dispatching to the appropriate handler.
-// If no handler is found the connection is closed.
-func ServeConn(ctx context.Context, handler *ServerHandler, conn net.Conn) error {
+// The first byte is read to pick the handler and replayed to it. If no handler is found the connection is closed
+// with an *ErrNotSOCKS.
+func ServeConn(ctx context.Context, handler *ServerHandler, conn net.Conn) (err error) {
 	defer conn.Close()
 
 	if handler == nil {
 		return fmt.Errorf("nil handler provided")
 	}
 
-	bc, err := newPeekConn(conn)
-	if err != nil {
-		return fmt.Errorf("unable to determine protocol: %w", err)
+	if handler.OnPanic != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				handler.OnPanic(ctx, conn, r)
+			}
+		}()
 	}
 
-	switch bc.initialByte {
+	if handler.OnAccept != nil {
+		if err = handler.OnAccept(ctx, conn); err != nil {
+			return handler.onError(ctx, conn, socksnet.PhaseAccept, err)
+		}
+	}
+
+	var first [1]byte
+	if _, err = io.ReadFull(conn, first[:]); err != nil {
+		return handler.onError(ctx, conn, socksnet.PhaseHandshake, fmt.Errorf("unable to determine protocol: %w", err))
+	}
+	pc := socksnet.NewPrefixConn(conn, first[:])
+
+	switch first[0] {
 	case socks4.SocksVersion:
 		if handler.Socks4 != nil {
-			if err = socks4.ServeConn(ctx, handler.Socks4, bc); err != nil {
+			if err = socks4.ServeConn(ctx, handler.Socks4, pc); err != nil {
 				return fmt.Errorf("socks4 handler error: %w", err)
 			}
 			return nil
@@ -73,7 +136,7 @@ func ServeConn(ctx context.Context, handler *ServerHandler, conn net.Conn) error
 
 	case socks5.SocksVersion:
 		if handler.Socks5 != nil {
-			if err = socks5.ServeConn(ctx, handler.Socks5, bc); err != nil {
+			if err = socks5.ServeConn(ctx, handler.Socks5, pc); err != nil {
 				return fmt.Errorf("socks5 handler error: %w", err)
 			}
 			return nil
@@ -81,8 +144,16 @@ func ServeConn(ctx context.Context, handler *ServerHandler, conn net.Conn) error
 	}
 
 	if handler.UnknownHandler != nil {
-		handler.UnknownHandler(bc, bc.initialByte)
+		handler.UnknownHandler(pc, first[0])
 	}
 
-	return fmt.Errorf("unsupported protocol version: %d", bc.initialByte)
+	// Name the other version if only one is served, e.g. "socks4-on-socks5-port"
+	var version byte
+	switch {
+	case handler.Socks4 == nil && handler.Socks5 != nil:
+		version = socks5.SocksVersion
+	case handler.Socks5 == nil && handler.Socks4 != nil:
+		version = socks4.SocksVersion
+	}
+	return handler.onError(ctx, conn, socksnet.PhaseHandshake, &ErrNotSOCKS{Detected: socksnet.DetectProtocol(first[0], version)})
 }
@@ -3,8 +3,11 @@ package proxy_test
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -112,3 +115,131 @@ func TestProxyMux_SOCKS4_and_SOCKS5(t *testing.T) {
 		})
 	}
 }
+
+func TestProxyMux_Concurrent(t *testing.T) {
+	echoLn := startEcho(t)
+	defer echoLn.Close()
+
+	var accepted sync.Map // remote address -> struct{}
+	proxyLn := startProxy(t, &proxy.ServerHandler{
+		Socks4: socks4.DefaultServerHandler,
+		Socks5: socks5.DefaultServerHandler,
+		OnAccept: func(ctx context.Context, conn net.Conn) error {
+			accepted.Store(conn.RemoteAddr().String(), struct{}{})
+			return nil
+		},
+	})
+	defer proxyLn.Close()
+
+	proxyAddr := proxyLn.Addr().String()
+	dialers := []func() (net.Conn, error){
+		func() (net.Conn, error) {
+			return socks4.NewDialer(proxyAddr, "test", nil).DialContext(context.Background(), "tcp", echoLn.Addr().String())
+		},
+		func() (net.Conn, error) {
+			return socks5.NewDialer(proxyAddr, nil, nil).DialContext(context.Background(), "tcp", echoLn.Addr().String())
+		},
+	}
+
+	const perDialer = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, perDialer*len(dialers))
+	for i := range perDialer * len(dialers) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := dialers[i%len(dialers)]()
+			if err != nil {
+				errs <- fmt.Errorf("dial %d: %w", i, err)
+				return
+			}
+			defer conn.Close()
+
+			payload := fmt.Appendf(nil, "hello from client %d", i)
+			if _, err := conn.Write(payload); err != nil {
+				errs <- fmt.Errorf("write %d: %w", i, err)
+				return
+			}
+			resp := make([]byte, len(payload))
+			if _, err := io.ReadFull(conn, resp); err != nil || !bytes.Equal(resp, payload) {
+				errs <- fmt.Errorf("client %d read (%q, %v), want %q", i, resp, err, payload)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	n := 0
+	accepted.Range(func(_, _ any) bool { n++; return true })
+	if n != perDialer*len(dialers) {
+		t.Errorf("OnAccept saw %d connections, want %d", n, perDialer*len(dialers))
+	}
+}
+
+func TestProxyMux_NotSOCKS(t *testing.T) {
+	errc := make(chan error, 1)
+	proxyLn := startProxy(t, &proxy.ServerHandler{
+		Socks5: socks5.DefaultServerHandler,
+		OnError: func(ctx context.Context, conn net.Conn, err error) {
+			errc <- err
+		},
+	})
+	defer proxyLn.Close()
+
+	for _, tt := range []struct {
+		data string
+		want string
+	}{
+		{"GET / HTTP/1.1\r\n\r\n", "http"},
+		{"\x04\x01\x00\x50", "socks4-on-socks5-port"},
+	} {
+		conn, err := net.Dial("tcp", proxyLn.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		conn.Write([]byte(tt.data))
+
+		// the connection is closed without a reply (reset if data was left unread)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var ne net.Error
+		if n, err := conn.Read(make([]byte, 1)); n != 0 || err == nil || errors.As(err, &ne) && ne.Timeout() {
+			t.Errorf("%q: read (%d, %v), want the connection closed", tt.data, n, err)
+		}
+		conn.Close()
+
+		var notSOCKS *proxy.ErrNotSOCKS
+		if err := <-errc; !errors.As(err, &notSOCKS) || notSOCKS.Detected != tt.want {
+			t.Errorf("%q: OnError got %v, want ErrNotSOCKS detecting %s", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestProxyMux_OnAcceptReject(t *testing.T) {
+	errReject := errors.New("rejected")
+	errc := make(chan error, 1)
+	proxyLn := startProxy(t, &proxy.ServerHandler{
+		Socks4: socks4.DefaultServerHandler,
+		Socks5: socks5.DefaultServerHandler,
+		OnAccept: func(ctx context.Context, conn net.Conn) error {
+			return errReject
+		},
+		OnError: func(ctx context.Context, conn net.Conn, err error) {
+			errc <- err
+		},
+	})
+	defer proxyLn.Close()
+
+	d := socks5.NewDialer(proxyLn.Addr().String(), nil, nil)
+	if conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1"); err == nil {
+		conn.Close()
+		t.Fatal("dial succeeded through a rejecting OnAccept")
+	}
+	if err := <-errc; !errors.Is(err, errReject) {
+		t.Fatalf("OnError got %v, want the OnAccept error", err)
+	}
+}
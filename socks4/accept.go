@@ -0,0 +1,59 @@
+package socks4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// ErrSourceNotAllowed is returned by the AcceptOnlyFromCIDRs filter for
+// connections from addresses outside the allowed networks.
+var ErrSourceNotAllowed = errors.New("connection source address not allowed")
+
+// AcceptOnlyFromCIDRs returns an accept filter, for BaseServerHandler.AcceptFilter,
+// that admits only connections from within the given IPv4 or IPv6 networks,
+// such as "127.0.0.0/8" or "::1/128". IPv4-mapped IPv6 source addresses are
+// matched as IPv4. A rejected connection is closed without a reply.
+//
+// The networks are parsed once, here; an invalid one is an error.
+func AcceptOnlyFromCIDRs(cidrs ...string) (func(ctx context.Context, conn net.Conn) error, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return func(ctx context.Context, conn net.Conn) error {
+		if ip, ok := remoteIP(conn); ok {
+			for _, prefix := range prefixes {
+				if prefix.Contains(ip) {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: %v", ErrSourceNotAllowed, conn.RemoteAddr())
+	}, nil
+}
+
+// remoteIP returns the IP address conn's peer connected from, unmapped from
+// IPv6 if it is an IPv4 address.
+func remoteIP(conn net.Conn) (netip.Addr, bool) {
+	switch addr := conn.RemoteAddr().(type) {
+	case nil:
+		return netip.Addr{}, false
+	case *net.TCPAddr:
+		if addr == nil {
+			return netip.Addr{}, false
+		}
+		ip, ok := netip.AddrFromSlice(addr.IP)
+		return ip.Unmap(), ok
+	default:
+		ap, err := netip.ParseAddrPort(addr.String())
+		return ap.Addr().Unmap(), err == nil
+	}
+}
@@ -0,0 +1,94 @@
+package socks4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// remoteAddrConn reports addr as its peer's address.
+type remoteAddrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *remoteAddrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestAcceptOnlyFromCIDRs(t *testing.T) {
+	filter, err := AcceptOnlyFromCIDRs("127.0.0.0/8")
+	if err != nil {
+		t.Fatalf("AcceptOnlyFromCIDRs failed: %v", err)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		addr  string
+		allow bool
+	}{
+		{"127.0.0.1:1080", true},
+		{"[::ffff:127.0.0.1]:1080", true}, // IPv4-mapped
+		{"10.0.0.1:1080", false},
+		{"[::1]:1080", false},
+	}
+	for _, tt := range tests {
+		conn := &remoteAddrConn{addr: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(tt.addr))}
+		if err := filter(ctx, conn); (err == nil) != tt.allow {
+			t.Errorf("filter(%s) = %v, want allowed=%v", tt.addr, err, tt.allow)
+		} else if err != nil && !errors.Is(err, ErrSourceNotAllowed) {
+			t.Errorf("filter(%s) = %v, want ErrSourceNotAllowed", tt.addr, err)
+		}
+	}
+
+	if _, err := AcceptOnlyFromCIDRs("127.0.0.0/8", "10.0.0.0"); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
+
+func TestBaseServerHandler_AcceptFilter(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	filter, err := AcceptOnlyFromCIDRs("127.0.0.0/8")
+	if err != nil {
+		t.Fatalf("AcceptOnlyFromCIDRs failed: %v", err)
+	}
+	handler := &BaseServerHandler{
+		RequestTimeout: 2 * time.Second,
+		AllowConnect:   true,
+		AcceptFilter:   filter,
+	}
+
+	// a client on 127.0.0.1 is served
+	socksLn := startSOCKS4Server(t, handler)
+	defer socksLn.Close()
+
+	conn, err := NewDialer(socksLn.Addr().String(), "", nil).DialContext(context.Background(), "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial from 127.0.0.1 failed: %v", err)
+	}
+	conn.Close()
+
+	// a client on 10.0.0.1 is closed without a reply
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConn(context.Background(), handler, &remoteAddrConn{
+			Conn: server,
+			addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1080},
+		})
+	}()
+
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if b, err := io.ReadAll(client); err != nil || len(b) != 0 {
+		t.Fatalf("rejected client read (%x, %v), want EOF and no reply", b, err)
+	}
+	if err := <-done; !errors.Is(err, ErrSourceNotAllowed) {
+		t.Fatalf("ServeConn = %v, want ErrSourceNotAllowed", err)
+	}
+}
@@ -0,0 +1,253 @@
+package socks4_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/33TU/socks/socks4"
+)
+
+func Test_Request_MarshalBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		req  socks4.Request
+	}{
+		{"SOCKS4", socks4.Request{Version: 4, Command: socks4.CmdConnect, Port: 80, IP: ip4(10, 0, 0, 1), UserID: "user"}},
+		{"SOCKS4a", socks4.Request{Version: 4, Command: socks4.CmdConnect, Port: 443, IP: ip4(0, 0, 0, 1), UserID: "user", Domain: "example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := tt.req.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+			b, err := tt.req.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary failed: %v", err)
+			}
+			if !bytes.Equal(b, buf.Bytes()) {
+				t.Fatalf("MarshalBinary = %x, WriteTo = %x", b, buf.Bytes())
+			}
+
+			var got socks4.Request
+			if err := got.UnmarshalBinary(b); err != nil {
+				t.Fatalf("UnmarshalBinary failed: %v", err)
+			}
+			if got != tt.req {
+				t.Fatalf("UnmarshalBinary = %+v, want %+v", got, tt.req)
+			}
+
+			// ReadFrom buffers past the terminators, so trailing data must be detected by length
+			if err := got.UnmarshalBinary(append(b, 'x')); !errors.Is(err, socks4.ErrTrailingData) {
+				t.Errorf("trailing byte: got %v, want ErrTrailingData", err)
+			}
+			if err := got.UnmarshalBinary(b[:len(b)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+func Test_Reply_MarshalBinary(t *testing.T) {
+	r := socks4.NewGranted(1080, net.IPv4(127, 0, 0, 1))
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf)
+	b, err := r.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Fatalf("MarshalBinary = %x, WriteTo = %x", b, buf.Bytes())
+	}
+
+	var got socks4.Reply
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if got != *r {
+		t.Fatalf("UnmarshalBinary = %+v, want %+v", got, *r)
+	}
+
+	if err := got.UnmarshalBinary(append(b, 0x00)); !errors.Is(err, socks4.ErrTrailingData) {
+		t.Errorf("trailing byte: got %v, want ErrTrailingData", err)
+	}
+	if err := got.UnmarshalBinary(b[:7]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func Test_Request_AppendTo(t *testing.T) {
+	req := socks4.Request{Version: 4, Command: socks4.CmdConnect, Port: 443, IP: ip4(0, 0, 0, 1), UserID: "user", Domain: "example.com"}
+
+	want, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	prefix := []byte("prefix")
+	b, err := req.AppendTo(prefix)
+	if err != nil {
+		t.Fatalf("AppendTo failed: %v", err)
+	}
+	if !bytes.HasPrefix(b, prefix) || !bytes.Equal(b[len(prefix):], want) {
+		t.Fatalf("AppendTo = %x, want prefix followed by %x", b, want)
+	}
+
+	bad := req
+	bad.Version = 5
+	if b, err := bad.AppendTo(prefix); !errors.Is(err, socks4.ErrInvalidVersion) || !bytes.Equal(b, prefix) {
+		t.Fatalf("invalid request: AppendTo = (%q, %v), want dst unchanged and ErrInvalidVersion", b, err)
+	}
+
+	buf := make([]byte, 0, 64)
+	if allocs := testing.AllocsPerRun(100, func() { req.AppendTo(buf) }); allocs != 0 {
+		t.Errorf("AppendTo allocated %v times, want 0", allocs)
+	}
+}
+
+func Test_Request_UnmarshalFrom(t *testing.T) {
+	req := socks4.Request{Version: 4, Command: socks4.CmdConnect, Port: 443, IP: ip4(0, 0, 0, 1), UserID: "user", Domain: "a.io"}
+	b, _ := req.MarshalBinary()
+
+	// bytes after the request are left for the caller
+	data := append(bytes.Clone(b), "leftover"...)
+	var got socks4.Request
+	n, err := got.UnmarshalFrom(data)
+	if err != nil || n != len(b) {
+		t.Fatalf("UnmarshalFrom = (%d, %v), want (%d, nil)", n, err, len(b))
+	}
+	if got != req {
+		t.Fatalf("UnmarshalFrom = %+v, want %+v", got, req)
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() { got.UnmarshalFrom(data) }); allocs != 0 {
+		t.Errorf("UnmarshalFrom of the same request allocated %v times, want 0", allocs)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		opts    []socks4.ReadOption
+		wantErr error
+	}{
+		{"empty", nil, nil, io.EOF},
+		{"short header", b[:5], nil, io.ErrUnexpectedEOF},
+		{"bad version", append([]byte{5}, b[1:]...), nil, socks4.ErrInvalidVersion},
+		{"unterminated USERID", b[:10], nil, io.ErrUnexpectedEOF},
+		{"unterminated DOMAIN", b[:len(b)-1], nil, io.ErrUnexpectedEOF},
+		{"long USERID", append(append(bytes.Clone(b[:8]), "abcdefghi"...), 0, 'x', 0), nil, socks4.ErrUserIDTooLong},
+		{"long DOMAIN", append(bytes.Clone(b[:8]), 0, 'a', 'b', 'c', 'd', 'e', 'f', 'g', 'h', 'i', 0), nil, socks4.ErrDomainTooLong},
+		{"USERID charset", append(append(bytes.Clone(b[:8]), "us\x80r"...), 0, 'x', 0), []socks4.ReadOption{socks4.WithCharSetValidation(socks4.UserIDCharSetASCII)}, socks4.ErrInvalidUserID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r socks4.Request
+			n, err := r.UnmarshalFromWithLimits(tt.data, 8, 8, tt.opts...)
+			if !errors.Is(err, tt.wantErr) || n != 0 {
+				t.Fatalf("UnmarshalFromWithLimits = (%d, %v), want (0, %v)", n, err, tt.wantErr)
+			}
+
+			// errors match ReadFromWithLimits
+			_, readErr := new(socks4.Request).ReadFromWithLimits(bytes.NewReader(tt.data), 8, 8, tt.opts...)
+			if err.Error() != readErr.Error() {
+				t.Errorf("UnmarshalFromWithLimits error %q, ReadFromWithLimits error %q", err, readErr)
+			}
+		})
+	}
+}
+
+func Test_Reply_AppendTo_UnmarshalFrom(t *testing.T) {
+	r := socks4.NewGranted(1080, net.IPv4(127, 0, 0, 1))
+
+	b, err := r.AppendTo([]byte("prefix"))
+	if err != nil {
+		t.Fatalf("AppendTo failed: %v", err)
+	}
+	if want, _ := r.MarshalBinary(); !bytes.Equal(b[6:], want) {
+		t.Fatalf("AppendTo = %x, want prefix followed by %x", b, want)
+	}
+
+	var got socks4.Reply
+	if n, err := got.UnmarshalFrom(append(b[6:], 0xFF)); err != nil || n != 8 || got != *r {
+		t.Fatalf("UnmarshalFrom = (%d, %v, %+v), want (8, nil, %+v)", n, err, got, *r)
+	}
+	if _, err := got.UnmarshalFrom(b[6:13]); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func FuzzRequestUnmarshalFrom(f *testing.F) {
+	for _, req := range []socks4.Request{
+		{Version: 4, Command: socks4.CmdConnect, Port: 80, IP: ip4(10, 0, 0, 1), UserID: "user"},
+		{Version: 4, Command: socks4.CmdBind, Port: 443, IP: ip4(0, 0, 0, 1), UserID: "", Domain: "example.com"},
+	} {
+		b, _ := req.MarshalBinary()
+		f.Add(b)
+		f.Add(append(b, "leftover"...))
+	}
+	f.Add([]byte{4, 1, 0, 80, 0, 0, 0, 1, 'u', 0})
+
+	const maxLen = 32
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var r socks4.Request
+		n, err := r.UnmarshalFromWithLimits(data, maxLen, maxLen)
+
+		// decoding matches ReadFromWithLimits
+		var rr socks4.Request
+		rn, rerr := rr.ReadFromWithLimits(bytes.NewReader(data), maxLen, maxLen)
+		if (err == nil) != (rerr == nil) {
+			t.Fatalf("UnmarshalFrom error %v, ReadFrom error %v", err, rerr)
+		}
+		if err != nil {
+			return
+		}
+		if int64(n) != rn || r != rr {
+			t.Fatalf("UnmarshalFrom = (%d, %+v), ReadFrom = (%d, %+v)", n, r, rn, rr)
+		}
+		if n > len(data) || len(r.UserID) > maxLen || len(r.Domain) > maxLen {
+			t.Fatalf("UnmarshalFrom consumed %d of %d bytes, UserID %d and Domain %d bytes", n, len(data), len(r.UserID), len(r.Domain))
+		}
+
+		// requests that pass Validate round-trip
+		b, err := r.MarshalBinary()
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(b, data[:n]) {
+			t.Fatalf("MarshalBinary = %x, want %x", b, data[:n])
+		}
+		var got socks4.Request
+		if err := got.UnmarshalBinary(b); err != nil || got != r {
+			t.Fatalf("UnmarshalBinary = (%+v, %v), want %+v", got, err, r)
+		}
+	})
+}
+
+func FuzzReplyUnmarshalFrom(f *testing.F) {
+	b, _ := socks4.NewGranted(1080, net.IPv4(127, 0, 0, 1)).MarshalBinary()
+	f.Add(b)
+	f.Add(b[:4])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var r socks4.Reply
+		n, err := r.UnmarshalFrom(data)
+		if err != nil {
+			return
+		}
+		if n != 8 {
+			t.Fatalf("UnmarshalFrom consumed %d bytes, want 8", n)
+		}
+
+		b, err := r.MarshalBinary()
+		if err != nil || !bytes.Equal(b, data[:n]) {
+			t.Fatalf("MarshalBinary = (%x, %v), want %x", b, err, data[:n])
+		}
+	})
+}
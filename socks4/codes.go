@@ -0,0 +1,54 @@
+package socks4
+
+import "fmt"
+
+// ReplyCode is a SOCKS4 reply code (CD). A ReplyCode other than RepGranted can be
+// returned as an error; the Dialer reports rejected requests this way.
+type ReplyCode byte
+
+// String returns a short description of the reply code, e.g. "rejected".
+func (c ReplyCode) String() string {
+	switch c {
+	case RepGranted:
+		return "granted"
+	case RepRejected:
+		return "rejected"
+	case RepIdentFailed:
+		return "identd failed"
+	case RepUserIDMismatch:
+		return "userid mismatch"
+	default:
+		return fmt.Sprintf("unknown(0x%02x)", byte(c))
+	}
+}
+
+// Error implements error with a human-readable description of the code.
+func (c ReplyCode) Error() string {
+	switch c {
+	case RepGranted:
+		return "socks4: request granted"
+	case RepRejected:
+		return "socks4: request rejected"
+	case RepIdentFailed:
+		return "socks4: failed to connect to identd"
+	case RepUserIDMismatch:
+		return "socks4: user ID does not match identd"
+	default:
+		return fmt.Sprintf("socks4: unknown error (code 0x%02x)", byte(c))
+	}
+}
+
+// Command is a SOCKS4 request command (CD).
+type Command byte
+
+// String returns the name of the command, e.g. "CONNECT".
+func (c Command) String() string {
+	switch c {
+	case CmdConnect:
+		return "CONNECT"
+	case CmdBind:
+		return "BIND"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02x)", byte(c))
+	}
+}
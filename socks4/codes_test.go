@@ -0,0 +1,50 @@
+package socks4_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/33TU/socks/socks4"
+)
+
+func Test_ReplyCode(t *testing.T) {
+	tests := []struct {
+		code socks4.ReplyCode
+		name string
+		err  string
+	}{
+		{socks4.RepGranted, "granted", "socks4: request granted"},
+		{socks4.RepRejected, "rejected", "socks4: request rejected"},
+		{socks4.RepIdentFailed, "identd failed", "socks4: failed to connect to identd"},
+		{socks4.RepUserIDMismatch, "userid mismatch", "socks4: user ID does not match identd"},
+		{0x10, "unknown(0x10)", "socks4: unknown error (code 0x10)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.name {
+			t.Errorf("String() = %q, want %q", got, tt.name)
+		}
+		if got := tt.code.Error(); got != tt.err {
+			t.Errorf("Error() = %q, want %q", got, tt.err)
+		}
+	}
+
+	err := fmt.Errorf("dial: %w", socks4.ReplyCode(socks4.RepRejected))
+	if !errors.Is(err, socks4.ReplyCode(socks4.RepRejected)) {
+		t.Errorf("errors.Is failed for wrapped ReplyCode")
+	}
+}
+
+func Test_Command_String(t *testing.T) {
+	req := socks4.Request{Command: socks4.CmdBind}
+	if got := req.CommandType().String(); got != "BIND" {
+		t.Errorf("CommandType().String() = %q", got)
+	}
+	if got := socks4.Command(socks4.CmdConnect).String(); got != "CONNECT" {
+		t.Errorf("Command.String() = %q", got)
+	}
+	if got := socks4.Command(0x09).String(); got != "UNKNOWN(0x09)" {
+		t.Errorf("Command.String() = %q", got)
+	}
+}
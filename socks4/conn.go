@@ -0,0 +1,45 @@
+package socks4
+
+import (
+	"net"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// Conn is a connection established through a SOCKS4/4a proxy by
+// Dialer.DialContext or DialConnContext. It relays through the embedded
+// net.Conn and records how the tunnel was set up.
+type Conn struct {
+	net.Conn
+
+	userID string
+	bound  *net.TCPAddr
+	target string
+}
+
+// UserID returns the user ID sent with the request (empty if none).
+func (c *Conn) UserID() string {
+	return c.userID
+}
+
+// BoundAddr returns the DSTIP and DSTPORT of the proxy's reply. Many proxies
+// reply with 0.0.0.0:0 to CONNECT.
+func (c *Conn) BoundAddr() net.Addr {
+	return c.bound
+}
+
+// Target returns the address requested from the proxy. With a Dialer.Resolver
+// this is the resolved address the proxy granted.
+func (c *Conn) Target() string {
+	return c.target
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// CloseWrite closes the write side of the connection if supported.
+func (c *Conn) CloseWrite() error {
+	return socksnet.CloseWrite(c.Conn)
+}
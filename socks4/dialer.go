@@ -2,8 +2,11 @@ package socks4
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -11,11 +14,34 @@ import (
 	socksnet "github.com/33TU/socks/net"
 )
 
+// ErrDomainRequiresSOCKS4a is returned when a host name is dialed with
+// DisableSOCKS4a set and no Resolver to resolve it locally.
+var ErrDomainRequiresSOCKS4a = errors.New("host name requires SOCKS4a")
+
 // Dialer implements a SOCKS4/4a proxy dialer.
 type Dialer struct {
-	ProxyAddr string          // e.g. "127.0.0.1:1080"
-	UserID    string          // optional SOCKS4 user ID
-	Dialer    socksnet.Dialer // optional underlying dialer (nil=DefaultDialer)
+	ProxyAddr      string          // e.g. "127.0.0.1:1080"
+	UserID         string          // optional SOCKS4 user ID
+	Dialer         socksnet.Dialer // optional underlying dialer (nil=DefaultDialer)
+	TLSConfig      *tls.Config     // optional TLS config for DialTLSContext (nil=default)
+	IDNA           bool            // convert Unicode host names to punycode before sending
+	DisableSOCKS4a bool            // send only plain SOCKS4 requests, for servers without 4a support
+
+	// AllowDomainUnderscore sends SOCKS4a host names with '_' in their labels
+	// instead of rejecting them with ErrInvalidDomain (see ValidateDomainName).
+	AllowDomainUnderscore bool
+
+	// Resolver, if set, resolves host names locally so that plain SOCKS4 requests
+	// are sent instead of SOCKS4a. DialContext tries each IPv4 address in order
+	// until the proxy grants one. Without a Resolver, host names are sent with
+	// SOCKS4a, or rejected with ErrDomainRequiresSOCKS4a if DisableSOCKS4a is set.
+	Resolver socksnet.Resolver
+
+	// LimitUp and LimitDown limit the rates of data written to and read from
+	// connections returned by DialContext (nil=unlimited). A limiter shared by
+	// several Dialers or connections limits their combined rate.
+	LimitUp   socksnet.RateLimiter
+	LimitDown socksnet.RateLimiter
 }
 
 // NewDialer creates a new SOCKS4 dialer instance.
@@ -30,19 +56,80 @@ func NewDialer(proxyAddr, userID string, dialer socksnet.Dialer) *Dialer {
 	}
 }
 
+// Dial connects to target through the SOCKS4/4a proxy at proxyAddr using a
+// one-off Dialer. Use a Dialer directly to reuse settings across connections.
+func Dial(ctx context.Context, proxyAddr, network, target, userID string) (net.Conn, error) {
+	return NewDialer(proxyAddr, userID, nil).DialContext(ctx, network, target)
+}
+
 // ProxyAddress returns the configured SOCKS4 proxy address.
 func (d *Dialer) ProxyAddress() string {
 	return d.ProxyAddr
 }
 
-// DialContext establishes a connection via SOCKS4/4a proxy (CONNECT command).
+// DialContext establishes a connection via SOCKS4/4a proxy (CONNECT command)
+// and returns it as a *Conn, or as a *socksnet.RateLimitedConn wrapping one if
+// LimitUp or LimitDown is set.
+// With a Resolver, each address of a host name is tried over a new proxy
+// connection until the proxy grants one.
 func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := splitHostPort(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Resolver == nil || net.ParseIP(host) != nil {
+		return d.dialOnce(ctx, network, address)
+	}
+
+	ips, err := d.lookupIPv4(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := d.dialOnce(ctx, network, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+		if err == nil {
+			return conn, nil
+		}
+
+		// Only a rejection by the proxy is worth trying the next address for
+		var code ReplyCode
+		if !errors.As(err, &code) || ctx.Err() != nil {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// DialContextIP is DialContext for a target given as an IPv4 address, e.g. one
+// resolved in advance or pinned to a specific backend. A plain SOCKS4 request
+// is sent, so neither the client nor the proxy resolves a name. It fails with
+// ErrInvalidIP if ip is not an IPv4 address.
+func (d *Dialer) DialContextIP(ctx context.Context, network string, ip net.IP, port uint16) (net.Conn, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, ErrInvalidIP
+	}
+	return d.dialOnce(ctx, network, net.JoinHostPort(ip4.String(), strconv.Itoa(int(port))))
+}
+
+// dialOnce connects to the proxy and sends a single CONNECT request for address.
+func (d *Dialer) dialOnce(ctx context.Context, network, address string) (net.Conn, error) {
 	conn, err := d.dialProxy(ctx, network)
 	if err != nil {
 		return nil, err
 	}
 
-	return d.DialConnContext(ctx, conn, network, address)
+	if conn, err = d.DialConnContext(ctx, conn, network, address); err != nil {
+		return nil, err
+	}
+	if d.LimitUp != nil || d.LimitDown != nil {
+		conn = socksnet.NewRateLimitedConn(conn, d.LimitDown, d.LimitUp)
+	}
+	return conn, nil
 }
 
 // Dial establishes a connection via SOCKS4/4a proxy using background context.
@@ -50,7 +137,18 @@ func (d *Dialer) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
 
+// DialTLSContext establishes a connection via SOCKS4/4a proxy and performs a TLS handshake with the target.
+func (d *Dialer) DialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return socksnet.DialTLSContext(ctx, d, d.TLSConfig, network, address)
+}
+
+// NewHTTPTransport returns an http.Transport that connects through the SOCKS4/4a proxy.
+func (d *Dialer) NewHTTPTransport() *http.Transport {
+	return socksnet.NewHTTPTransport(d.DialContext, d.DialTLSContext)
+}
+
 // DialConnContext upgrades an existing connection via SOCKS4/4a proxy (CONNECT command).
+// The returned connection is a *Conn.
 func (d *Dialer) DialConnContext(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
 	host, port, err := splitHostPort(ctx, address)
 	if err != nil {
@@ -61,7 +159,7 @@ func (d *Dialer) DialConnContext(ctx context.Context, conn net.Conn, network, ad
 	cleanup := bindConnToContext(ctx, conn)
 	defer cleanup()
 
-	reply, err := d.doRequest(conn, CmdConnect, host, port)
+	reply, err := d.doRequest(ctx, conn, CmdConnect, host, port)
 	if err != nil {
 		conn.Close()
 		return nil, err
@@ -72,7 +170,8 @@ func (d *Dialer) DialConnContext(ctx context.Context, conn net.Conn, network, ad
 		return nil, replyToError(reply.Code)
 	}
 
-	return conn, nil
+	bound := &net.TCPAddr{IP: reply.GetIP(), Port: int(reply.Port)}
+	return &Conn{Conn: conn, userID: d.UserID, bound: bound, target: address}, nil
 }
 
 // DialConn upgrades an existing connection using background context.
@@ -101,7 +200,7 @@ func (d *Dialer) BindContext(
 	cleanup := bindConnToContext(ctx, conn)
 	defer cleanup()
 
-	reply, err := d.doRequest(conn, CmdBind, host, port)
+	reply, err := d.doRequest(ctx, conn, CmdBind, host, port)
 	if err != nil {
 		conn.Close()
 		return nil, nil, nil, err
@@ -177,21 +276,39 @@ func bindConnToContext(ctx context.Context, conn net.Conn) (cleanup func()) {
 
 // doRequest sends a SOCKS4 request and reads the reply.
 func (d *Dialer) doRequest(
+	ctx context.Context,
 	conn net.Conn,
 	cmd byte,
 	host string,
 	port uint16,
 ) (*Reply, error) {
 	// Build SOCKS4 request
-	var req Request
-	req.Init(SocksVersion, cmd, port, net.ParseIP(host), d.UserID, "")
-	if net.ParseIP(host) == nil {
-		// SOCKS4a fallback
-		copy(req.IP[:], []byte{0, 0, 0, 1})
-		req.Domain = host
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		return nil, ErrInvalidIP
+	}
+	if ip == nil && (d.Resolver != nil || d.DisableSOCKS4a) {
+		ips, err := d.lookupIPv4(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		ip = ips[0]
+	}
+
+	// SOCKS4a fallback for hosts not resolved above
+	target := host
+	if ip != nil {
+		target = ip.String()
+	} else if d.IDNA {
+		target = internal.DomainToASCII(host)
+	}
+
+	req := Request{Version: SocksVersion, Command: cmd, UserID: d.UserID}
+	if err := req.setTarget(target, port, d.AllowDomainUnderscore); err != nil {
+		return nil, err
 	}
 
-	if _, err := req.WriteTo(conn); err != nil {
+	if _, err := req.writeTo(conn, d.AllowDomainUnderscore); err != nil {
 		return nil, err
 	}
 
@@ -206,6 +323,30 @@ func (d *Dialer) doRequest(
 	return &reply, nil
 }
 
+// lookupIPv4 resolves host with the Dialer's Resolver for plain SOCKS4 requests.
+// It returns at least one address or an error.
+func (d *Dialer) lookupIPv4(ctx context.Context, host string) ([]net.IP, error) {
+	if d.Resolver == nil {
+		return nil, ErrDomainRequiresSOCKS4a
+	}
+
+	addrs, err := d.Resolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, ip := range addrs {
+		if ip4 := ip.To4(); ip4 != nil {
+			ips = append(ips, ip4)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no IPv4 address found for host: %s", host)
+	}
+	return ips, nil
+}
+
 // splitHostPort parses address into host and port with context for DNS resolution.
 func splitHostPort(ctx context.Context, addr string) (string, uint16, error) {
 	host, portStr, err := net.SplitHostPort(addr)
@@ -238,14 +379,5 @@ func parsePort(ctx context.Context, p string) (uint16, error) {
 
 // replyToError converts a SOCKS4 reply code to an error.
 func replyToError(code byte) error {
-	switch code {
-	case RepRejected:
-		return fmt.Errorf("socks4: request rejected")
-	case RepIdentFailed:
-		return fmt.Errorf("socks4: failed to connect to identd")
-	case RepUserIDMismatch:
-		return fmt.Errorf("socks4: user ID does not match identd")
-	default:
-		return fmt.Errorf("socks4: unknown error (code 0x%02x)", code)
-	}
+	return ReplyCode(code)
 }
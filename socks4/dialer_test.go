@@ -2,12 +2,17 @@ package socks4_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	socksnet "github.com/33TU/socks/net"
 	"github.com/33TU/socks/socks4"
 )
 
@@ -75,6 +80,297 @@ func TestDialer_Connect_Success(t *testing.T) {
 	}
 }
 
+func TestDialer_Conn(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+
+		var resp socks4.Reply
+		resp.Init(0, socks4.RepGranted, 4321, net.IPv4(10, 0, 0, 1))
+		resp.WriteTo(c)
+	})
+	defer stop()
+
+	d := &socks4.Dialer{ProxyAddr: proxyAddr, UserID: "tester"}
+	conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1234")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	sc, ok := conn.(*socks4.Conn)
+	if !ok {
+		t.Fatalf("expected *socks4.Conn, got %T", conn)
+	}
+	if sc.UserID() != "tester" {
+		t.Errorf("UserID = %q, want tester", sc.UserID())
+	}
+	if sc.Target() != "127.0.0.1:1234" {
+		t.Errorf("Target = %q, want 127.0.0.1:1234", sc.Target())
+	}
+	if got := sc.BoundAddr().String(); got != "10.0.0.1:4321" {
+		t.Errorf("BoundAddr = %s, want 10.0.0.1:4321", got)
+	}
+	if _, ok := sc.NetConn().(*net.TCPConn); !ok {
+		t.Errorf("NetConn = %T, want *net.TCPConn", sc.NetConn())
+	}
+}
+
+func TestDialer_IDNA(t *testing.T) {
+	domains := make(chan string, 1)
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		domains <- req.Domain
+
+		var resp socks4.Reply
+		resp.Init(0, socks4.RepGranted, req.Port, req.IPv4())
+		resp.WriteTo(c)
+	})
+	defer stop()
+
+	// without IDNA the name is rejected before anything is sent
+	d := &socks4.Dialer{ProxyAddr: proxyAddr}
+	if _, err := d.DialContext(context.Background(), "tcp", "bücher.example:80"); !errors.Is(err, socks4.ErrInvalidDomain) {
+		t.Fatalf("expected ErrInvalidDomain, got %v", err)
+	}
+
+	d.IDNA = true
+	conn, err := d.DialContext(context.Background(), "tcp", "bücher.example:80")
+	if err != nil {
+		t.Fatalf("DialContext with IDNA failed: %v", err)
+	}
+	conn.Close()
+
+	if got := <-domains; got != "xn--bcher-kva.example" {
+		t.Fatalf("proxy received domain %q, want %q", got, "xn--bcher-kva.example")
+	}
+	select {
+	case got := <-domains:
+		t.Fatalf("unexpected request for %q", got)
+	default:
+	}
+}
+
+func TestDialer_DisableSOCKS4a(t *testing.T) {
+	requests := make(chan socks4.Request, 1)
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		requests <- req
+
+		var resp socks4.Reply
+		resp.Init(0, socks4.RepGranted, req.Port, req.IPv4())
+		resp.WriteTo(c)
+	})
+	defer stop()
+
+	// without a resolver the host name is rejected before anything is sent
+	d := &socks4.Dialer{ProxyAddr: proxyAddr, DisableSOCKS4a: true}
+	if _, err := d.DialContext(context.Background(), "tcp", "localhost:80"); !errors.Is(err, socks4.ErrDomainRequiresSOCKS4a) {
+		t.Fatalf("expected ErrDomainRequiresSOCKS4a, got %v", err)
+	}
+
+	// with a resolver the name is resolved locally and sent as plain SOCKS4
+	d.Resolver = net.DefaultResolver
+	conn, err := d.DialContext(context.Background(), "tcp", "localhost:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	conn.Close()
+
+	req := <-requests
+	if !req.IsSOCKS4() || req.Domain != "" || !req.IPv4().Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("proxy received %v, want a SOCKS4 request for 127.0.0.1", &req)
+	}
+	select {
+	case req := <-requests:
+		t.Fatalf("unexpected request %v", &req)
+	default:
+	}
+}
+
+func TestDialer_Resolver_TriesAddressesInOrder(t *testing.T) {
+	requests := make(chan socks4.Request, 4)
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		requests <- req
+
+		// the first address is refused by the proxy
+		if req.IPv4().Equal(net.IPv4(10, 0, 0, 1)) {
+			socks4.NewRejected(req.Port, req.IPv4()).WriteTo(c)
+			return
+		}
+		socks4.NewGranted(req.Port, req.IPv4()).WriteTo(c)
+	})
+	defer stop()
+
+	resolver := socksnet.LookupIPFunc(func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if network != "ip4" || host != "multi.example" {
+			return nil, fmt.Errorf("unexpected lookup %s %s", network, host)
+		}
+		return []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}, nil
+	})
+
+	d := &socks4.Dialer{ProxyAddr: proxyAddr, Resolver: resolver}
+	conn, err := d.DialContext(context.Background(), "tcp", "multi.example:8080")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	conn.Close()
+
+	for _, want := range []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)} {
+		req := <-requests
+		if !req.IsSOCKS4() || !req.IPv4().Equal(want) || req.Port != 8080 {
+			t.Fatalf("proxy received %v, want a SOCKS4 request for %v", &req, want)
+		}
+	}
+
+	// the lookup observes cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DialContext(ctx, "tcp", "multi.example:8080"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	select {
+	case req := <-requests:
+		t.Fatalf("unexpected request %v", &req)
+	default:
+	}
+}
+
+func TestDialer_DialContextIP(t *testing.T) {
+	requests := make(chan socks4.Request, 1)
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		requests <- req
+		socks4.NewGranted(req.Port, req.IPv4()).WriteTo(c)
+	})
+	defer stop()
+
+	// the resolver must not be consulted for an IP target
+	resolver := socksnet.LookupIPFunc(func(ctx context.Context, network, host string) ([]net.IP, error) {
+		t.Errorf("unexpected lookup of %s", host)
+		return nil, errors.New("unexpected lookup")
+	})
+
+	d := &socks4.Dialer{ProxyAddr: proxyAddr, Resolver: resolver}
+	conn, err := d.DialContextIP(context.Background(), "tcp", net.IPv4(192, 0, 2, 7), 8443)
+	if err != nil {
+		t.Fatalf("DialContextIP failed: %v", err)
+	}
+	conn.Close()
+
+	req := <-requests
+	if !req.IsSOCKS4() || req.Domain != "" || !req.IPv4().Equal(net.IPv4(192, 0, 2, 7)) || req.Port != 8443 {
+		t.Fatalf("proxy received %v, want a SOCKS4 request for 192.0.2.7:8443", &req)
+	}
+
+	if _, err := d.DialContextIP(context.Background(), "tcp", net.ParseIP("2001:db8::1"), 80); !errors.Is(err, socks4.ErrInvalidIP) {
+		t.Fatalf("DialContextIP with IPv6 = %v, want ErrInvalidIP", err)
+	}
+}
+
+func TestDialer_AllowDomainUnderscore(t *testing.T) {
+	domains := make(chan string, 1)
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		domains <- req.Domain
+
+		var resp socks4.Reply
+		resp.Init(0, socks4.RepGranted, req.Port, req.IPv4())
+		resp.WriteTo(c)
+	})
+	defer stop()
+
+	d := &socks4.Dialer{ProxyAddr: proxyAddr}
+	if _, err := d.DialContext(context.Background(), "tcp", "my_host.local:80"); !errors.Is(err, socks4.ErrInvalidDomain) {
+		t.Fatalf("expected ErrInvalidDomain, got %v", err)
+	}
+
+	d.AllowDomainUnderscore = true
+	conn, err := d.DialContext(context.Background(), "tcp", "my_host.local:80")
+	if err != nil {
+		t.Fatalf("DialContext with AllowDomainUnderscore failed: %v", err)
+	}
+	conn.Close()
+
+	if got := <-domains; got != "my_host.local" {
+		t.Fatalf("proxy received domain %q, want %q", got, "my_host.local")
+	}
+}
+
+func TestDial(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var req socks4.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			t.Errorf("server: read request: %v", err)
+			return
+		}
+		if req.UserID != "tester" || req.Domain != "example.com" || req.Port != 80 {
+			t.Errorf("server: unexpected request: %v", &req)
+			return
+		}
+		socks4.NewGranted(req.Port, net.IPv4(127, 0, 0, 1)).WriteTo(c)
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		c.Write([]byte("pong"))
+	})
+	defer stop()
+
+	conn, err := socks4.Dial(context.Background(), proxyAddr, "tcp", "example.com:80", "tester")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("expected pong, got %q", buf)
+	}
+}
+
 func TestDialer_Connect_Rejected(t *testing.T) {
 	proxyAddr, stop := startMockSOCKS4Server(t, func(c net.Conn) {
 		defer c.Close()
@@ -224,3 +520,41 @@ func TestDialer_Connect_WithDeadline(t *testing.T) {
 		t.Logf("got error (acceptable): %v", err) // Log but don't fail - different error types are OK
 	}
 }
+
+func TestDialer_NewHTTPTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	httpSrv := httptest.NewServer(handler)
+	defer httpSrv.Close()
+	httpsSrv := httptest.NewTLSServer(handler)
+	defer httpsSrv.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go socks4.Serve(ctx, ln, &socks4.BaseServerHandler{AllowConnect: true})
+
+	d := socks4.NewDialer(ln.Addr().String(), "tester", nil)
+	d.TLSConfig = httpsSrv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	tr := d.NewHTTPTransport()
+	defer tr.CloseIdleConnections()
+
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	for _, url := range []string{httpSrv.URL, httpsSrv.URL} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Fatalf("GET %s: status %d, body %q", url, resp.StatusCode, body)
+		}
+	}
+}
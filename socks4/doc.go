@@ -0,0 +1,10 @@
+// Package socks4 implements the SOCKS4 and SOCKS4a protocols: message codecs, a Dialer and a server.
+//
+// The ReadFrom methods of protocol messages return io.EOF only if the reader ends
+// before the first byte of the message. A message cut off after that fails with
+// io.ErrUnexpectedEOF.
+//
+// MarshalBinary returns the encoding written by WriteTo, and UnmarshalBinary
+// decodes data as ReadFrom does. data must hold exactly one message; trailing
+// bytes fail with ErrTrailingData.
+package socks4
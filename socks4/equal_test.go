@@ -0,0 +1,40 @@
+package socks4_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/33TU/socks/socks4"
+)
+
+func Test_Equal_Clone(t *testing.T) {
+	req := &socks4.Request{}
+	req.Init(socks4.SocksVersion, socks4.CmdConnect, 443, net.IPv4(0, 0, 0, 1), "alice", "example.org")
+
+	c := req.Clone()
+	if c == req || !c.Equal(req) {
+		t.Fatal("Request clone is not an equal copy")
+	}
+	c.UserID = "bob"
+	if c.Equal(req) || req.UserID != "alice" {
+		t.Error("Request clone is not independent")
+	}
+
+	// Init accepts both IP forms
+	a, b := &socks4.Request{}, &socks4.Request{}
+	a.Init(socks4.SocksVersion, socks4.CmdBind, 80, net.IPv4(10, 0, 0, 1), "", "")
+	b.Init(socks4.SocksVersion, socks4.CmdBind, 80, net.IPv4(10, 0, 0, 1).To4(), "", "")
+	if !a.Equal(b) {
+		t.Error("4- and 16-byte forms of the same address differ")
+	}
+
+	reply := socks4.NewGranted(1080, net.IPv4(10, 0, 0, 1))
+	if rc := reply.Clone(); !rc.Equal(reply) || rc.Equal(socks4.NewRejected(1080, net.IPv4(10, 0, 0, 1))) {
+		t.Error("unexpected Reply comparison")
+	}
+
+	var nilReq *socks4.Request
+	if !nilReq.Equal(nil) || nilReq.Equal(req) || req.Equal(nil) || nilReq.Clone() != nil {
+		t.Error("unexpected result for nil Request")
+	}
+}
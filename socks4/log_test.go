@@ -0,0 +1,72 @@
+package socks4_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/33TU/socks/socks4"
+)
+
+func Test_Request_LogValue(t *testing.T) {
+	req := &socks4.Request{Version: 4, Command: socks4.CmdConnect, Port: 80, IP: ip4(0, 0, 0, 1), UserID: "alice", Domain: "example.com"}
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("msg", "req", req)
+	line := buf.String()
+
+	if strings.Contains(line, "alice") {
+		t.Fatalf("log line leaks user ID: %s", line)
+	}
+	for _, want := range []string{"req.cmd=CONNECT", "req.host=example.com", "req.port=80", "req.user=sha256:"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log line %q missing %q", line, want)
+		}
+	}
+
+	buf.Reset()
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("msg", "req", socks4.Unredacted(req))
+	if line := buf.String(); !strings.Contains(line, "req.user=alice") {
+		t.Fatalf("unredacted log line has no plain user ID: %s", line)
+	}
+}
+
+func Test_Request_RedactedString(t *testing.T) {
+	for _, req := range []*socks4.Request{
+		{Version: 4, Command: socks4.CmdConnect, Port: 80, IP: ip4(10, 0, 0, 1), UserID: "alice"},
+		{Version: 4, Command: socks4.CmdConnect, Port: 80, IP: ip4(0, 0, 0, 1), UserID: "alice", Domain: "example.com"},
+	} {
+		s := req.RedactedString()
+		if strings.Contains(s, "alice") {
+			t.Errorf("RedactedString leaks user ID: %s", s)
+		}
+		if !strings.Contains(s, "sha256:") || !strings.Contains(s, "Port=80") {
+			t.Errorf("unexpected RedactedString %s", s)
+		}
+		if !strings.Contains(req.String(), `UserID="alice"`) {
+			t.Errorf("String no longer shows the user ID: %s", req.String())
+		}
+	}
+}
+
+func Test_MarshalJSON(t *testing.T) {
+	req := &socks4.Request{Version: 4, Command: socks4.CmdBind, Port: 80, IP: ip4(0, 0, 0, 1), UserID: "alice", Domain: "example.com"}
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `{"cmd":"BIND","host":"example.com","port":80,"user":"alice"}`; string(b) != want {
+		t.Fatalf("Marshal = %s, want %s", b, want)
+	}
+
+	b, err = json.Marshal(socks4.NewGranted(1080, net.IPv4(10, 0, 0, 1)))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `{"reply":"granted","host":"10.0.0.1","port":1080}`; string(b) != want {
+		t.Fatalf("Marshal = %s, want %s", b, want)
+	}
+}
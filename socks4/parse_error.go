@@ -0,0 +1,51 @@
+package socks4
+
+import (
+	"github.com/33TU/socks/internal"
+	socksnet "github.com/33TU/socks/net"
+)
+
+// ParseError reports which field of a message failed to parse and how many
+// bytes had been read (see socksnet.ParseError). ReadFrom methods return it
+// for malformed or truncated input; errors.Is still matches the sentinels.
+type ParseError = socksnet.ParseError
+
+// Message names reported in ParseError.
+const (
+	msgRequest = "socks4 request"
+	msgReply   = "socks4 reply"
+)
+
+// sentinelFields names the field each validation error is about.
+var sentinelFields = map[error]string{
+	ErrInvalidVersion:      "VN",
+	ErrInvalidReplyVersion: "VN",
+	ErrInvalidCommand:      "CD",
+	ErrInvalidReplyCode:    "CD",
+	ErrInvalidIP:           "DSTIP",
+	ErrInvalidDomain:       "DOMAIN",
+	ErrUserIDTooLong:       "USERID",
+	ErrDomainTooLong:       "DOMAIN",
+}
+
+// parseError wraps err from reading message in a *ParseError. Validation errors
+// are attributed to their own field; other errors, such as a truncated read,
+// to field. offset is the number of bytes of the message read so far.
+func parseError(message, field string, offset int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	if f, ok := sentinelFields[err]; ok {
+		field = f
+	}
+	return socksnet.NewParseError(message, field, offset, err)
+}
+
+// truncated is parseError for a field that could not be read in full. Once part
+// of the message has been read, io.EOF is reported as io.ErrUnexpectedEOF.
+func truncated(message, field string, offset int64, err error) error {
+	if offset > 0 {
+		err = internal.UnexpectedEOF(err)
+	}
+	return parseError(message, field, offset, err)
+}
@@ -2,16 +2,26 @@ package socks4
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 )
 
 // SOCKS4 reply error codes and helpers.
 var (
-	ErrInvalidResponseVersion = errors.New("invalid SOCKS4 reply version (must be 0x00)")
-	ErrInvalidResponseCode    = errors.New("invalid SOCKS4 reply code")
+	ErrInvalidReplyVersion = errors.New("invalid SOCKS4 reply version (must be 0x00)")
+	ErrInvalidReplyCode    = errors.New("invalid SOCKS4 reply code")
+)
+
+// Former names of the reply errors, kept so existing errors.Is checks match.
+var (
+	// Deprecated: Use ErrInvalidReplyVersion.
+	ErrInvalidResponseVersion = ErrInvalidReplyVersion
+	// Deprecated: Use ErrInvalidReplyCode.
+	ErrInvalidResponseCode = ErrInvalidReplyCode
 )
 
 // Reply represents a SOCKS4 or SOCKS4a CONNECT/BIND server reply.
@@ -22,6 +32,21 @@ type Reply struct {
 	IP      [4]byte // DSTIP; server-assigned or echoed address
 }
 
+// NewGranted returns a request-granted reply with the given port and IPv4 address.
+func NewGranted(port uint16, ip net.IP) *Reply {
+	r := &Reply{}
+	r.Init(0, RepGranted, port, ip)
+	return r
+}
+
+// NewRejected returns a request-rejected reply with the given port and IPv4 address.
+// Set Code to report a more specific failure.
+func NewRejected(port uint16, ip net.IP) *Reply {
+	r := &Reply{}
+	r.Init(0, RepRejected, port, ip)
+	return r
+}
+
 // Init initializes a SOCKS4 Reply.
 func (r *Reply) Init(version, code byte, port uint16, ip net.IP) {
 	r.Version = version
@@ -33,13 +58,13 @@ func (r *Reply) Init(version, code byte, port uint16, ip net.IP) {
 // Validate checks the correctness of the SOCKS4 reply fields.
 func (r *Reply) Validate() error {
 	if r.Version != 0x00 {
-		return ErrInvalidResponseVersion
+		return ErrInvalidReplyVersion
 	}
 	switch r.Code {
 	case RepGranted, RepRejected, RepIdentFailed, RepUserIDMismatch:
 		return nil
 	default:
-		return ErrInvalidResponseCode
+		return ErrInvalidReplyCode
 	}
 }
 
@@ -48,6 +73,12 @@ func (r *Reply) IsGranted() bool {
 	return r.Code == RepGranted
 }
 
+// IsRejected reports whether the request was rejected or failed (RepRejected).
+// Rejections due to identd report RepIdentFailed or RepUserIDMismatch instead.
+func (r *Reply) IsRejected() bool {
+	return r.Code == RepRejected
+}
+
 // GetIP returns the IPv4 address as net.IP.
 func (r *Reply) GetIP() net.IP {
 	return net.IP(r.IP[:]).To4()
@@ -59,41 +90,111 @@ func (r *Reply) ReadFrom(src io.Reader) (int64, error) {
 	var hdr [8]byte
 	n, err := io.ReadFull(src, hdr[:])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgReply, "header", int64(n), err)
 	}
+	r.setHeader(hdr[:])
+	return int64(n), parseError(msgReply, "", int64(n), r.Validate())
+}
+
+// setHeader sets the fields from the 8-byte reply hdr.
+func (r *Reply) setHeader(hdr []byte) {
 	r.Version = hdr[0]
 	r.Code = hdr[1]
 	r.Port = binary.BigEndian.Uint16(hdr[2:4])
 	copy(r.IP[:], hdr[4:8])
-	return int64(n), r.Validate()
+}
+
+// UnmarshalFrom decodes a reply from the start of b as ReadFrom does and
+// returns the number of bytes consumed (8); any bytes after the reply are left
+// for the caller.
+func (r *Reply) UnmarshalFrom(b []byte) (int, error) {
+	if len(b) < 8 {
+		return 0, truncated(msgReply, "header", int64(len(b)), io.EOF)
+	}
+	r.setHeader(b[:8])
+	if err := r.Validate(); err != nil {
+		return 0, parseError(msgReply, "", 8, err)
+	}
+	return 8, nil
+}
+
+// AppendTo appends the wire encoding produced by WriteTo to dst.
+// The reply is validated first; dst is returned unchanged if it is malformed.
+func (r *Reply) AppendTo(dst []byte) ([]byte, error) {
+	if err := r.Validate(); err != nil {
+		return dst, err
+	}
+
+	dst = append(dst, r.Version, r.Code, byte(r.Port>>8), byte(r.Port))
+	return append(dst, r.IP[:]...), nil
 }
 
 // WriteTo writes a SOCKS4 Reply to an io.Writer.
+// The reply is validated first; nothing is written if it is malformed.
 // Implements io.WriterTo.
 func (r *Reply) WriteTo(dst io.Writer) (int64, error) {
 	var hdr [8]byte
-	hdr[0] = r.Version
-	hdr[1] = r.Code
-	binary.BigEndian.PutUint16(hdr[2:4], r.Port)
-	copy(hdr[4:8], r.IP[:])
-	n, err := dst.Write(hdr[:])
+	buf, err := r.AppendTo(hdr[:0])
+	if err != nil {
+		return 0, err
+	}
+	n, err := dst.Write(buf)
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for Reply.
+func (r *Reply) MarshalBinary() ([]byte, error) {
+	return r.AppendTo(make([]byte, 0, 8))
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for Reply.
+func (r *Reply) UnmarshalBinary(data []byte) error {
+	n, err := r.UnmarshalFrom(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// Equal reports whether Reply r and other have the same code, port and IP.
+func (r *Reply) Equal(other *Reply) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return *r == *other
+}
+
+// Clone returns a copy of r.
+func (r *Reply) Clone() *Reply {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
 // String returns a string representation of the SOCKS4 Reply.
 func (r *Reply) String() string {
-	var desc string
-	switch r.Code {
-	case RepGranted:
-		desc = "granted"
-	case RepRejected:
-		desc = "rejected"
-	case RepIdentFailed:
-		desc = "identd failed"
-	case RepUserIDMismatch:
-		desc = "userid mismatch"
-	default:
-		desc = fmt.Sprintf("unknown(0x%02x)", r.Code)
-	}
-	return fmt.Sprintf("SOCKS4 Reply{Version:%d Code:%s Port:%d IP:%s}", r.Version, desc, r.Port, net.IP(r.IP[:]).String())
+	return fmt.Sprintf("SOCKS4 Reply{Version:%d Code:%s Port:%d IP:%s}", r.Version, ReplyCode(r.Code), r.Port, net.IP(r.IP[:]).String())
+}
+
+// LogValue implements slog.LogValuer.
+func (r *Reply) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("code", ReplyCode(r.Code).String()),
+		slog.String("host", r.GetIP().String()),
+		slog.Int("port", int(r.Port)),
+	)
+}
+
+// MarshalJSON implements json.Marshaler with stable field names for audit logs.
+func (r *Reply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Reply string `json:"reply"`
+		Host  string `json:"host"`
+		Port  uint16 `json:"port"`
+	}{ReplyCode(r.Code).String(), r.GetIP().String(), r.Port})
 }
@@ -2,13 +2,14 @@ package socks4_test
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"testing"
 
 	"github.com/33TU/socks/socks4"
 )
 
-func Test_Response_Init_Validate(t *testing.T) {
+func Test_Reply_Init_Validate(t *testing.T) {
 	tests := []struct {
 		name    string
 		resp    socks4.Reply
@@ -53,7 +54,7 @@ func Test_Response_Init_Validate(t *testing.T) {
 	}
 }
 
-func Test_Response_IsGranted(t *testing.T) {
+func Test_Reply_IsGranted(t *testing.T) {
 	var r socks4.Reply
 	r.Init(0x00, socks4.RepGranted, 1080, net.IPv4(127, 0, 0, 1))
 	if !r.IsGranted() {
@@ -66,7 +67,19 @@ func Test_Response_IsGranted(t *testing.T) {
 	}
 }
 
-func Test_Response_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
+func Test_Reply_IsRejected(t *testing.T) {
+	for _, code := range []byte{socks4.RepGranted, socks4.RepRejected, socks4.RepIdentFailed, socks4.RepUserIDMismatch, 0x10} {
+		r := socks4.Reply{Code: code}
+		if got, want := r.IsGranted(), code == socks4.RepGranted; got != want {
+			t.Errorf("code %#x: IsGranted() = %v, want %v", code, got, want)
+		}
+		if got, want := r.IsRejected(), code == socks4.RepRejected; got != want {
+			t.Errorf("code %#x: IsRejected() = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func Test_Reply_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
 	want := socks4.Reply{}
 	want.Init(0x00, socks4.RepGranted, 4321, net.IPv4(192, 168, 1, 10))
 
@@ -88,12 +101,12 @@ func Test_Response_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
 		t.Errorf("expected 8 bytes read, got %d", nr)
 	}
 
-	if got.Code != want.Code || got.Port != want.Port || !got.GetIP().Equal(want.GetIP()) {
+	if !got.Equal(&want) {
 		t.Errorf("round-trip mismatch: got %+v, want %+v", got, want)
 	}
 }
 
-func Test_Response_ReadFrom_InvalidVersion(t *testing.T) {
+func Test_Reply_ReadFrom_InvalidVersion(t *testing.T) {
 	b := []byte{
 		0x04,       // invalid version (should be 0x00)
 		0x5A,       // granted
@@ -103,12 +116,15 @@ func Test_Response_ReadFrom_InvalidVersion(t *testing.T) {
 
 	var r socks4.Reply
 	_, err := r.ReadFrom(bytes.NewReader(b))
-	if err == nil {
-		t.Fatal("expected error for invalid version")
+	if !errors.Is(err, socks4.ErrInvalidReplyVersion) {
+		t.Fatalf("expected ErrInvalidReplyVersion, got %v", err)
+	}
+	if !errors.Is(err, socks4.ErrInvalidResponseVersion) {
+		t.Fatalf("expected deprecated ErrInvalidResponseVersion to match, got %v", err)
 	}
 }
 
-func Test_Response_ReadFrom_InvalidCode(t *testing.T) {
+func Test_Reply_ReadFrom_InvalidCode(t *testing.T) {
 	b := []byte{
 		0x00,
 		0x99,       // invalid code
@@ -118,7 +134,61 @@ func Test_Response_ReadFrom_InvalidCode(t *testing.T) {
 
 	var r socks4.Reply
 	_, err := r.ReadFrom(bytes.NewReader(b))
-	if err == nil {
-		t.Fatal("expected error for invalid code")
+	if !errors.Is(err, socks4.ErrInvalidReplyCode) {
+		t.Fatalf("expected ErrInvalidReplyCode, got %v", err)
+	}
+	if !errors.Is(err, socks4.ErrInvalidResponseCode) {
+		t.Fatalf("expected deprecated ErrInvalidResponseCode to match, got %v", err)
+	}
+}
+
+func Test_Reply_WriteTo_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    socks4.Reply
+		wantErr error
+	}{
+		{"SOCKS5 success code", socks4.Reply{Version: 0x00, Code: 0x00}, socks4.ErrInvalidReplyCode},
+		{"nonzero version", socks4.Reply{Version: 0x04, Code: socks4.RepGranted}, socks4.ErrInvalidReplyVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := tt.resp.WriteTo(&buf); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("WriteTo() error = %v, want %v", err, tt.wantErr)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("WriteTo() wrote %d bytes for a malformed reply", buf.Len())
+			}
+		})
+	}
+}
+
+func Test_NewGranted_NewRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply *socks4.Reply
+		want  []byte
+	}{
+		{"granted", socks4.NewGranted(1080, net.IPv4(10, 0, 0, 1)), []byte{0x00, 0x5A, 0x04, 0x38, 10, 0, 0, 1}},
+		{"rejected", socks4.NewRejected(0, net.IPv4zero), []byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0}},
+		{"rejected nil ip", socks4.NewRejected(0, nil), []byte{0x00, 0x5B, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.reply.Validate(); err != nil {
+				t.Fatalf("Validate() error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tt.reply.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo() error: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("got % x, want % x", buf.Bytes(), tt.want)
+			}
+		})
 	}
 }
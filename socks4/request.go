@@ -1,11 +1,18 @@
 package socks4
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/netip"
+	"strings"
+	"time"
 
 	"github.com/33TU/socks/internal"
 )
@@ -15,8 +22,64 @@ var (
 	ErrInvalidCommand = errors.New("invalid command (must be 1=CONNECT or 2=BIND)")
 	ErrInvalidIP      = errors.New("invalid IP (must be IPv4)")
 	ErrInvalidDomain  = errors.New("invalid SOCKS4a domain usage")
+	ErrInvalidUserID  = errors.New("invalid user ID")
+	ErrUserIDTooLong  = errors.New("user ID exceeds length limit")
+	ErrDomainTooLong  = errors.New("domain exceeds length limit")
+
+	// ErrTrailingData is returned by UnmarshalBinary when data continues past the end of the message.
+	ErrTrailingData = internal.ErrTrailingData
 )
 
+// ValidateDomainName checks that name is a DNS host name as RFC 1035 defines it:
+// at most 253 bytes plus an optional trailing dot, made of labels of 1-63 letters,
+// digits and hyphens. allowUnderscore also accepts '_' in labels: host names
+// exclude it, but some names in real use contain it. Unicode names must be
+// converted to their ASCII form first, e.g. with Dialer.IDNA.
+func ValidateDomainName(name string, allowUnderscore bool) error {
+	if !internal.ValidDomainName(name, allowUnderscore) {
+		return ErrInvalidDomain
+	}
+	return nil
+}
+
+// User ID character sets for ValidateUserID and WithCharSetValidation. The
+// SOCKS4 spec does not restrict USERID, but some servers accept only ASCII or
+// printable ASCII. Sets combine with |, each restricting further.
+const (
+	UserIDCharSetASCII     = 1 << iota // Bytes 0x01-0x7F
+	UserIDCharSetPrintable             // Printable ASCII, 0x20-0x7E
+)
+
+// ReadOption configures ReadFromWithLimits and ReadUserIDAndDomain.
+type ReadOption func(*readOptions)
+
+// readOptions holds the ReadOptions of one read.
+type readOptions struct {
+	charSet int // USERID character set (0=any)
+}
+
+// applyReadOptions returns the readOptions set by opts. Without options it
+// does not allocate.
+func applyReadOptions(opts []ReadOption) readOptions {
+	if len(opts) == 0 {
+		return readOptions{}
+	}
+
+	var o readOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithCharSetValidation rejects a USERID containing a byte outside charSet
+// with ErrInvalidUserID once it has been read (0=no check).
+func WithCharSetValidation(charSet int) ReadOption {
+	return func(o *readOptions) {
+		o.charSet = charSet
+	}
+}
+
 // Request represents a SOCKS4 or SOCKS4a CONNECT/BIND request.
 type Request struct {
 	Version byte    // VN; SOCKS protocol version (should always be 4)
@@ -27,31 +90,80 @@ type Request struct {
 	Domain  string  // DOMAIN; null-terminated domain name (SOCKS4a only)
 }
 
-// IsSOCKS4a returns true if the request is a SOCKS4a request.
+// IsSOCKS4a returns true if the request is a SOCKS4a request: DSTIP is
+// 0.0.0.x with x nonzero. The protocol allows any such x; clients commonly send
+// 0.0.0.1, and some 0.0.0.255.
 func (r *Request) IsSOCKS4a() bool {
-	ip := net.IP(r.IP[:])
-	return ip != nil &&
-		ip[0] == 0 &&
-		ip[1] == 0 &&
-		ip[2] == 0 &&
-		ip[3] != 0
+	return r.IP[0] == 0 &&
+		r.IP[1] == 0 &&
+		r.IP[2] == 0 &&
+		r.IP[3] != 0
 }
 
-// IsSOCKS4 returns true if the request is a SOCKS4 request.
+// IsSOCKS4 returns true if the request is a SOCKS4 request, i.e. not SOCKS4a.
+// This includes DSTIP 0.0.0.0, which ValidateHeader accepts only for BIND.
 func (r *Request) IsSOCKS4() bool {
-	ip := net.IP(r.IP[:])
-	return ip != nil &&
-		!(ip[0] == 0 &&
-			ip[1] == 0 &&
-			ip[2] == 0 &&
-			ip[3] != 0)
+	return !r.IsSOCKS4a()
+}
+
+// MarkSOCKS4a sets DSTIP to 0.0.0.1, the SOCKS4a marker Dialer sends, so that
+// DOMAIN is sent and read as the destination.
+func (r *Request) MarkSOCKS4a() {
+	r.IP = [4]byte{0, 0, 0, 1}
+}
+
+// CommandType returns the request command as a Command.
+func (r *Request) CommandType() Command {
+	return Command(r.Command)
 }
 
-// IPv4 returns the destination IPv4 address.
+// IPv4 returns the destination IPv4 address, or nil for a SOCKS4a request,
+// whose DSTIP 0.0.0.x only marks that DOMAIN follows.
 func (r *Request) IPv4() net.IP {
+	if r.IsSOCKS4a() {
+		return nil
+	}
 	return net.IP(r.IP[:]).To4()
 }
 
+// AddrPort returns the destination IPv4 address and port. ok is false for a
+// SOCKS4a request, whose destination is DOMAIN.
+func (r *Request) AddrPort() (ap netip.AddrPort, ok bool) {
+	if r.IsSOCKS4a() {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(netip.AddrFrom4(r.IP), r.Port), true
+}
+
+// SetTarget sets the destination to host and port. An IPv4 address is sent as
+// DSTIP with no DOMAIN; any other host must pass ValidateDomainName and is sent
+// as a SOCKS4a DOMAIN with DSTIP 0.0.0.1. IPv6 addresses fail with ErrInvalidIP.
+func (r *Request) SetTarget(host string, port uint16) error {
+	return r.setTarget(host, port, false)
+}
+
+// setTarget is SetTarget, accepting '_' in host name labels if allowUnderscore is set.
+func (r *Request) setTarget(host string, port uint16, allowUnderscore bool) error {
+	if ip := net.ParseIP(host); ip != nil {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			return ErrInvalidIP
+		}
+		r.IP = [4]byte(ip4)
+		r.Domain = ""
+		r.Port = port
+		return nil
+	}
+
+	if err := ValidateDomainName(host, allowUnderscore); err != nil {
+		return err
+	}
+	r.MarkSOCKS4a()
+	r.Domain = host
+	r.Port = port
+	return nil
+}
+
 // Host returns the destination host.
 func (r *Request) Host() string {
 	if r.IsSOCKS4a() {
@@ -66,6 +178,7 @@ func (r *Request) Addr() string {
 }
 
 // Init initializes a SOCKS4 or SOCKS4a CONNECT/BIND request.
+// DSTIP is left as 0.0.0.0 if ip has no IPv4 form.
 func (r *Request) Init(
 	version byte,
 	command byte,
@@ -77,11 +190,17 @@ func (r *Request) Init(
 	r.Version = version
 	r.Command = command
 	r.Port = port
+	r.IP = [4]byte{}
 	copy(r.IP[:], ip.To4())
 	r.UserID = userID
 	r.Domain = domain
 }
 
+// Reset clears the request for reuse.
+func (r *Request) Reset() {
+	*r = Request{}
+}
+
 // ValidateHeader validates a SOCKS4 or SOCKS4a CONNECT/BIND request header (first 8 bytes).
 func (r *Request) ValidateHeader() error {
 	if r.Version != SocksVersion {
@@ -104,26 +223,74 @@ func (r *Request) ValidateHeader() error {
 	return nil
 }
 
-// Validate validates a SOCKS4 or SOCKS4a CONNECT/BIND request (SOCKS4a only).
+// ValidateDomain validates the domain of a SOCKS4 or SOCKS4a CONNECT/BIND request:
+// only SOCKS4a requests carry one, and it must pass ValidateDomainName.
 func (r *Request) ValidateDomain() error {
-	if r.IsSOCKS4a() {
-		if len(r.Domain) == 0 {
-			return ErrInvalidDomain
-		}
-	} else {
+	return r.validateDomain(false)
+}
+
+// validateDomain is ValidateDomain, accepting '_' in labels if allowUnderscore is set.
+func (r *Request) validateDomain(allowUnderscore bool) error {
+	if !r.IsSOCKS4a() {
 		if len(r.Domain) > 0 {
 			return ErrInvalidDomain
 		}
+		return nil
+	}
+
+	// NUL terminates the field, so an embedded one would corrupt the framing
+	if len(r.Domain) == 0 || strings.IndexByte(r.Domain, 0) >= 0 {
+		return ErrInvalidDomain
+	}
+	return ValidateDomainName(r.Domain, allowUnderscore)
+}
+
+// ValidateComplete validates a request once USERID and DOMAIN have been read:
+// the header must pass ValidateHeader and a SOCKS4a request must carry a
+// DOMAIN, or it fails with ErrInvalidDomain. Unlike Validate it does not check
+// the domain name syntax. ReadFrom and UnmarshalFrom call it.
+func (r *Request) ValidateComplete() error {
+	if err := r.ValidateHeader(); err != nil {
+		return err
+	}
+	if r.IsSOCKS4a() && len(r.Domain) == 0 {
+		return ErrInvalidDomain
+	}
+	return nil
+}
+
+// ValidateUserID checks that UserID contains no NUL, which would end the field
+// early on the wire, and, if charSet is not 0, only bytes of charSet (see
+// UserIDCharSetASCII). The error wraps ErrInvalidUserID and names the offending byte.
+func (r *Request) ValidateUserID(charSet int) error {
+	for i := 0; i < len(r.UserID); i++ {
+		b := r.UserID[i]
+		switch {
+		case b == 0x00:
+			return fmt.Errorf("%w: NUL at index %d", ErrInvalidUserID, i)
+		case charSet&UserIDCharSetASCII != 0 && b > 0x7F:
+			return fmt.Errorf("%w: non-ASCII byte 0x%02x at index %d", ErrInvalidUserID, b, i)
+		case charSet&UserIDCharSetPrintable != 0 && (b < 0x20 || b > 0x7E):
+			return fmt.Errorf("%w: non-printable byte 0x%02x at index %d", ErrInvalidUserID, b, i)
+		}
 	}
 	return nil
 }
 
 // Validate validates a SOCKS4 or SOCKS4a CONNECT/BIND request.
 func (r *Request) Validate() error {
+	return r.validate(false)
+}
+
+// validate is Validate, checking the domain with validateDomain(allowUnderscore).
+func (r *Request) validate(allowUnderscore bool) error {
 	if err := r.ValidateHeader(); err != nil {
 		return err
 	}
-	return r.ValidateDomain()
+	if err := r.ValidateUserID(0); err != nil {
+		return err
+	}
+	return r.validateDomain(allowUnderscore)
 }
 
 // ReadHeaderFrom reads a 8-byte SOCKS4 or SOCKS4a CONNECT/BIND request from a Reader.
@@ -132,20 +299,35 @@ func (r *Request) ReadHeaderFrom(src io.Reader) (int64, error) {
 
 	n, err := io.ReadFull(src, hdr[:])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgRequest, "header", int64(n), err)
 	}
 
+	r.setHeader(hdr[:])
+	return int64(n), parseError(msgRequest, "", int64(n), r.ValidateHeader())
+}
+
+// setHeader sets the header fields from the 8-byte header hdr.
+func (r *Request) setHeader(hdr []byte) {
 	r.Version = hdr[0]
 	r.Command = hdr[1]
 	r.Port = binary.BigEndian.Uint16(hdr[2:4])
 	copy(r.IP[:], hdr[4:8])
-	return int64(n), r.ValidateHeader()
 }
 
 // ReadUserIDAndDomain reads a 8-byte SOCKS4 or SOCKS4a CONNECT/BIND request from a Reader.
-// Note that the limits do not include the null-terminator.
+// Note that the limits do not include the null-terminator. A field with no
+// terminator within its limit fails with ErrUserIDTooLong or ErrDomainTooLong;
+// input that ends before the terminator fails with io.ErrUnexpectedEOF.
 // Beware if there is data beyond request it can be dropped.
-func (r *Request) ReadUserIDAndDomain(src io.Reader, maxUserIDLen, maxDomainLen int64) (int64, error) {
+func (r *Request) ReadUserIDAndDomain(src io.Reader, maxUserIDLen, maxDomainLen int64, opts ...ReadOption) (int64, error) {
+	return r.readUserIDAndDomain(src, maxUserIDLen, maxDomainLen, 0, opts)
+}
+
+// readUserIDAndDomain is ReadUserIDAndDomain for fields that start offset bytes
+// into the message, which ParseError offsets include.
+func (r *Request) readUserIDAndDomain(src io.Reader, maxUserIDLen, maxDomainLen, offset int64, opts []ReadOption) (int64, error) {
+	o := applyReadOptions(opts)
+
 	var lr internal.LimitedReader
 	rdr := internal.GetReader(&lr)
 	defer internal.PutReader(rdr)
@@ -158,17 +340,33 @@ func (r *Request) ReadUserIDAndDomain(src io.Reader, maxUserIDLen, maxDomainLen
 	userID, err := rdr.ReadString(0x00)
 	total += int64(len(userID))
 	if err != nil {
-		return total, err
+		if err == io.EOF && lr.Exceeded() {
+			err = ErrUserIDTooLong
+		}
+		return total, truncated(msgRequest, "USERID", offset+total, err)
 	}
 	r.UserID = userID[:len(userID)-1]
 
+	if o.charSet != 0 {
+		if err := r.ValidateUserID(o.charSet); err != nil {
+			return total, parseError(msgRequest, "USERID", offset+total, err)
+		}
+	}
+
 	// read DOMAIN
 	if r.IsSOCKS4a() {
-		lr.Init(src, maxDomainLen+1)
+		// bytes read ahead with USERID count towards the DOMAIN limit
+		lr.ResetN(max(maxDomainLen+1-int64(rdr.Buffered()), 0))
 		domain, err := rdr.ReadString(0x00)
 		total += int64(len(domain))
+		if err == nil && int64(len(domain)-1) > maxDomainLen {
+			err = ErrDomainTooLong
+		}
 		if err != nil {
-			return total, err
+			if err == io.EOF && lr.Exceeded() {
+				err = ErrDomainTooLong
+			}
+			return total, truncated(msgRequest, "DOMAIN", offset+total, err)
 		}
 		r.Domain = domain[:len(domain)-1]
 	}
@@ -177,15 +375,20 @@ func (r *Request) ReadUserIDAndDomain(src io.Reader, maxUserIDLen, maxDomainLen
 }
 
 // ReadFromWithLimits reads a 8-byte SOCKS4 or SOCKS4a CONNECT/BIND request from a Reader.
-// Note that the limits do not include the null-terminator.
-func (r *Request) ReadFromWithLimits(src io.Reader, maxUserIDLen, maxDomainLen int64) (int64, error) {
+// Note that the limits do not include the null-terminator; fields exceeding
+// them fail with ErrUserIDTooLong or ErrDomainTooLong. The request read must
+// pass ValidateComplete, so a SOCKS4a request needs a DOMAIN.
+func (r *Request) ReadFromWithLimits(src io.Reader, maxUserIDLen, maxDomainLen int64, opts ...ReadOption) (int64, error) {
 	n1, err := r.ReadHeaderFrom(src)
 	if err != nil {
 		return n1, err
 	}
 
-	n2, err := r.ReadUserIDAndDomain(src, maxUserIDLen, maxDomainLen)
-	return n1 + n2, err
+	n2, err := r.readUserIDAndDomain(src, maxUserIDLen, maxDomainLen, n1, opts)
+	if err != nil {
+		return n1 + n2, err
+	}
+	return n1 + n2, parseError(msgRequest, "", n1+n2, r.ValidateComplete())
 }
 
 // ReadFrom reads a SOCKS4 or SOCKS4a CONNECT/BIND request from a Reader.
@@ -194,61 +397,285 @@ func (r *Request) ReadFrom(src io.Reader) (int64, error) {
 	return r.ReadFromWithLimits(src, DefaultMaxUserIDLen, DefaultMaxDomainLen)
 }
 
-// WriteTo writes a SOCKS4 or SOCKS4a CONNECT/BIND request to a Writer.
-// Implements the io.WriterTo interface.
-func (r *Request) WriteTo(dst io.Writer) (int64, error) {
-	var bufArr [512]byte // safe upper bound
-	buf := bufArr[:0]
+// UnmarshalFrom decodes a request from the start of b as ReadFrom does and
+// returns the number of bytes consumed; any bytes after the request are left
+// for the caller.
+func (r *Request) UnmarshalFrom(b []byte) (int, error) {
+	return r.UnmarshalFromWithLimits(b, DefaultMaxUserIDLen, DefaultMaxDomainLen)
+}
+
+// UnmarshalFromWithLimits is UnmarshalFrom with the limits and options of
+// ReadFromWithLimits. UserID and Domain are kept if they already hold the
+// decoded values, so decoding the same request repeatedly does not allocate.
+func (r *Request) UnmarshalFromWithLimits(b []byte, maxUserIDLen, maxDomainLen int64, opts ...ReadOption) (int, error) {
+	o := applyReadOptions(opts)
+
+	if len(b) < 8 {
+		return 0, truncated(msgRequest, "header", int64(len(b)), io.EOF)
+	}
+	r.setHeader(b[:8])
+	if err := r.ValidateHeader(); err != nil {
+		return 0, parseError(msgRequest, "", 8, err)
+	}
+	i := 8
+
+	// USERID (cstring)
+	field, n, err := cutCString(b[i:], maxUserIDLen, ErrUserIDTooLong)
+	if err != nil {
+		return 0, truncated(msgRequest, "USERID", int64(i+n), err)
+	}
+	setString(&r.UserID, field)
+	i += n
+
+	if o.charSet != 0 {
+		if err := r.ValidateUserID(o.charSet); err != nil {
+			return 0, parseError(msgRequest, "USERID", int64(i), err)
+		}
+	}
+
+	// DOMAIN (SOCKS4a only)
+	if r.IsSOCKS4a() {
+		field, n, err := cutCString(b[i:], maxDomainLen, ErrDomainTooLong)
+		if err != nil {
+			return 0, truncated(msgRequest, "DOMAIN", int64(i+n), err)
+		}
+		setString(&r.Domain, field)
+		i += n
+	}
+
+	if err := r.ValidateComplete(); err != nil {
+		return 0, parseError(msgRequest, "", int64(i), err)
+	}
+	return i, nil
+}
+
+// cutCString returns the NUL-terminated field at the start of b, which may be
+// at most maxLen bytes long, and the bytes consumed including the terminator.
+// A field with no terminator within maxLen+1 bytes fails with errTooLong, one
+// cut short by the end of b with io.EOF; n is then the number of bytes scanned.
+func cutCString(b []byte, maxLen int64, errTooLong error) (field []byte, n int, err error) {
+	limit := b
+	if int64(len(b)) > maxLen+1 {
+		limit = b[:max(maxLen+1, 0)]
+	}
+
+	i := bytes.IndexByte(limit, 0x00)
+	switch {
+	case i >= 0:
+		return b[:i], i + 1, nil
+	case int64(len(limit)) >= maxLen+1:
+		return nil, len(limit), errTooLong
+	default:
+		return nil, len(limit), io.EOF
+	}
+}
+
+// setString sets *s to b, keeping the current string if it already holds b.
+func setString(s *string, b []byte) {
+	if string(b) != *s {
+		*s = string(b)
+	}
+}
+
+// AppendTo appends the wire encoding produced by WriteTo to dst.
+// The request is validated first; dst is returned unchanged if it is malformed.
+func (r *Request) AppendTo(dst []byte) ([]byte, error) {
+	return r.appendTo(dst, false)
+}
+
+// appendTo is AppendTo, validating the request with validate(allowUnderscore).
+func (r *Request) appendTo(dst []byte, allowUnderscore bool) ([]byte, error) {
+	if err := r.validate(allowUnderscore); err != nil {
+		return dst, err
+	}
 
 	// Header (8 bytes)
-	buf = append(buf,
+	dst = append(dst,
 		r.Version,
 		r.Command,
 		byte(r.Port>>8),
 		byte(r.Port),
 	)
-	buf = append(buf, r.IP[:]...)
+	dst = append(dst, r.IP[:]...)
 
 	// USERID (cstring)
-	if len(r.UserID) > 0 {
-		buf = append(buf, r.UserID...)
-	}
-	buf = append(buf, 0)
+	dst = append(dst, r.UserID...)
+	dst = append(dst, 0)
 
 	// DOMAIN (SOCKS4a only)
 	if r.IsSOCKS4a() {
-		if len(r.Domain) > 0 {
-			buf = append(buf, r.Domain...)
-		}
-		buf = append(buf, 0)
+		dst = append(dst, r.Domain...)
+		dst = append(dst, 0)
+	}
+	return dst, nil
+}
+
+// ReadFromWithContext is ReadFrom returning context.Cause(ctx) once ctx is done.
+// If src has a SetReadDeadline method, as a net.Conn does, the pending read is
+// interrupted with a past deadline and has ended when the call returns; the
+// deadline is then cleared. Other readers cannot be interrupted: their read
+// continues in the background and its result is discarded. Either way r is
+// left unchanged if ctx ends first.
+func (r *Request) ReadFromWithContext(ctx context.Context, src io.Reader) (int64, error) {
+	return r.readWithContext(ctx, src, (*Request).ReadFrom)
+}
+
+// ReadHeaderFromWithContext is ReadHeaderFrom with cancellation as in
+// ReadFromWithContext.
+func (r *Request) ReadHeaderFromWithContext(ctx context.Context, src io.Reader) (int64, error) {
+	return r.readWithContext(ctx, src, (*Request).ReadHeaderFrom)
+}
+
+// ReadUserIDAndDomainWithContext is ReadUserIDAndDomain with cancellation as
+// in ReadFromWithContext.
+func (r *Request) ReadUserIDAndDomainWithContext(ctx context.Context, src io.Reader, maxUserIDLen, maxDomainLen int64, opts ...ReadOption) (int64, error) {
+	return r.readWithContext(ctx, src, func(r *Request, src io.Reader) (int64, error) {
+		return r.ReadUserIDAndDomain(src, maxUserIDLen, maxDomainLen, opts...)
+	})
+}
+
+// readWithContext runs read on a copy of r in a goroutine until it finishes or
+// ctx is done (see ReadFromWithContext).
+func (r *Request) readWithContext(ctx context.Context, src io.Reader, read func(*Request, io.Reader) (int64, error)) (int64, error) {
+	if ctx.Err() != nil {
+		return 0, context.Cause(ctx)
+	}
+	if ctx.Done() == nil {
+		return read(r, src)
+	}
+
+	type result struct {
+		n   int64
+		err error
+	}
+
+	req := *r
+	done := make(chan result, 1)
+	go func() {
+		n, err := read(&req, src)
+		done <- result{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		*r = req
+		return res.n, res.err
+	case <-ctx.Done():
 	}
 
+	if d, ok := src.(interface{ SetReadDeadline(time.Time) error }); ok {
+		d.SetReadDeadline(time.Unix(1, 0))
+		res := <-done
+		d.SetReadDeadline(time.Time{})
+		return res.n, context.Cause(ctx)
+	}
+	return 0, context.Cause(ctx)
+}
+
+// WriteTo writes a SOCKS4 or SOCKS4a CONNECT/BIND request to a Writer.
+// The request is validated first; nothing is written if it is malformed.
+// Implements the io.WriterTo interface.
+func (r *Request) WriteTo(dst io.Writer) (int64, error) {
+	return r.writeTo(dst, false)
+}
+
+// writeTo is WriteTo, validating the request with validate(allowUnderscore).
+func (r *Request) writeTo(dst io.Writer, allowUnderscore bool) (int64, error) {
+	bw := internal.GetWriter(dst)
+	defer internal.PutWriter(bw)
+
+	buf, err := r.appendTo(bw.AvailableBuffer(), allowUnderscore)
+	if err != nil {
+		return 0, err
+	}
+	bw.Write(buf)
+
 	// Single write
-	n, err := dst.Write(buf)
-	return int64(n), err
+	return internal.FlushWriter(bw)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for Request.
+func (r *Request) MarshalBinary() ([]byte, error) {
+	return r.AppendTo(make([]byte, 0, 8+len(r.UserID)+1+len(r.Domain)+1))
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for Request.
+func (r *Request) UnmarshalBinary(data []byte) error {
+	n, err := r.UnmarshalFrom(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// Equal reports whether Request r and other have the same command, target and user ID.
+func (r *Request) Equal(other *Request) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return *r == *other
+}
+
+// Clone returns a copy of r.
+func (r *Request) Clone() *Request {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
 }
 
 // String returns a string representation of the SOCKS4(a) Request.
+// It includes UserID verbatim; use RedactedString, or log the Request with
+// slog (see LogValue), where the user ID must not appear.
 func (r *Request) String() string {
-	var cmd string
-	switch r.Command {
-	case CmdConnect:
-		cmd = "CONNECT"
-	case CmdBind:
-		cmd = "BIND"
-	default:
-		cmd = fmt.Sprintf("UNKNOWN(0x%02x)", r.Command)
-	}
+	return r.format(r.UserID)
+}
+
+// RedactedString is String with UserID replaced by a short hash.
+func (r *Request) RedactedString() string {
+	return r.format(internal.RedactIdentifier(r.UserID))
+}
 
+// format returns the String form of r showing userID as the user ID.
+func (r *Request) format(userID string) string {
+	cmd := r.CommandType()
 	if r.IsSOCKS4a() {
 		return fmt.Sprintf(
 			"SOCKS4a Request{Cmd=%s, Host=%s, Port=%d, UserID=%q, Version=%d}",
-			cmd, r.Domain, r.Port, r.UserID, r.Version,
+			cmd, r.Domain, r.Port, userID, r.Version,
 		)
 	}
 
 	return fmt.Sprintf(
 		"SOCKS4 Request{Cmd=%s, IP=%s, Port=%d, UserID=%q, Version=%d}",
-		cmd, r.IPv4(), r.Port, r.UserID, r.Version,
+		cmd, r.IPv4(), r.Port, userID, r.Version,
+	)
+}
+
+// LogValue implements slog.LogValuer. The user ID is hashed; see Unredacted.
+func (r *Request) LogValue() slog.Value {
+	return r.logValue(false)
+}
+
+func (r *Request) logValue(verbatim bool) slog.Value {
+	return slog.GroupValue(
+		slog.String("cmd", r.CommandType().String()),
+		slog.String("host", r.Host()),
+		slog.Int("port", int(r.Port)),
+		slog.String("user", logIdentifier(r.UserID, verbatim)),
 	)
 }
+
+// MarshalJSON implements json.Marshaler with stable field names for audit logs.
+func (r *Request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Cmd  string `json:"cmd"`
+		Host string `json:"host"`
+		Port uint16 `json:"port"`
+		User string `json:"user"`
+	}{r.CommandType().String(), r.Host(), r.Port, r.UserID})
+}
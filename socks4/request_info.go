@@ -0,0 +1,24 @@
+package socks4
+
+import (
+	socksnet "github.com/33TU/socks/net"
+)
+
+// RequestInfo is a version-agnostic view of a request (see socksnet.RequestInfo).
+type RequestInfo = socksnet.RequestInfo
+
+// NewRequestInfo returns a RequestInfo for req. Its username is the user ID.
+func NewRequestInfo(req *Request) RequestInfo {
+	return &requestInfo{req: req}
+}
+
+// requestInfo adapts a Request to RequestInfo.
+type requestInfo struct {
+	req *Request
+}
+
+func (r *requestInfo) Version() int     { return SocksVersion }
+func (r *requestInfo) Command() string  { return r.req.CommandType().String() }
+func (r *requestInfo) Host() string     { return r.req.Host() }
+func (r *requestInfo) Port() uint16     { return r.req.Port }
+func (r *requestInfo) Username() string { return r.req.UserID }
@@ -2,10 +2,15 @@ package socks4_test
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/33TU/socks/socks4"
 )
@@ -46,7 +51,7 @@ func Test_Request_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
 	if n1 != n2 {
 		t.Errorf("expected %d bytes read, got %d", n1, n2)
 	}
-	if parsed.UserID != orig.UserID || parsed.Port != orig.Port || parsed.IP != orig.IP {
+	if !parsed.Equal(&orig) {
 		t.Errorf("mismatch:\n got  %+v\n want %+v", parsed, orig)
 	}
 }
@@ -68,11 +73,8 @@ func Test_Request_WriteTo_ReadFrom_SOCKS4a(t *testing.T) {
 	if !parsed.IsSOCKS4a() {
 		t.Fatalf("expected SOCKS4a request")
 	}
-	if parsed.Domain != "example.org" {
-		t.Errorf("expected domain %q, got %q", "example.org", parsed.Domain)
-	}
-	if parsed.UserID != "alice" {
-		t.Errorf("expected userid %q, got %q", "alice", parsed.UserID)
+	if !parsed.Equal(&orig) {
+		t.Errorf("mismatch:\n got  %+v\n want %+v", parsed, orig)
 	}
 }
 
@@ -88,6 +90,61 @@ func Test_Request_GetHost(t *testing.T) {
 	}
 }
 
+func Test_Request_SetTarget(t *testing.T) {
+	tests := []struct {
+		host     string
+		wantErr  error
+		wantAddr string
+		wantIP   net.IP // nil for SOCKS4a
+	}{
+		{"192.0.2.1", nil, "192.0.2.1:80", net.IPv4(192, 0, 2, 1)},
+		{"::ffff:192.0.2.1", nil, "192.0.2.1:80", net.IPv4(192, 0, 2, 1)},
+		{"example.com", nil, "example.com:80", nil},
+		{"2001:db8::1", socks4.ErrInvalidIP, "", nil},
+		{"", socks4.ErrInvalidDomain, "", nil},
+		{"bad host", socks4.ErrInvalidDomain, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			r := socks4.Request{Version: socks4.SocksVersion, Command: socks4.CmdConnect}
+			err := r.SetTarget(tt.host, 80)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetTarget() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if err := r.Validate(); err != nil {
+				t.Fatalf("Validate() = %v", err)
+			}
+			if got := r.Addr(); got != tt.wantAddr {
+				t.Errorf("Addr() = %s, want %s", got, tt.wantAddr)
+			}
+
+			ap, ok := r.AddrPort()
+			if tt.wantIP == nil {
+				if !r.IsSOCKS4a() || r.IPv4() != nil || ok {
+					t.Errorf("expected SOCKS4a request with no IP, got %v (AddrPort %v, %v)", &r, ap, ok)
+				}
+				return
+			}
+			if r.IsSOCKS4a() || !r.IPv4().Equal(tt.wantIP) || !ok || ap.String() != tt.wantAddr {
+				t.Errorf("expected SOCKS4 request to %s, got %v (AddrPort %v, %v)", tt.wantAddr, &r, ap, ok)
+			}
+		})
+	}
+
+	// switching from a domain to an IP clears DOMAIN
+	var r socks4.Request
+	r.SetTarget("example.com", 80)
+	r.SetTarget("192.0.2.1", 443)
+	if r.Domain != "" || r.Addr() != "192.0.2.1:443" {
+		t.Errorf("expected plain SOCKS4 target, got %v", &r)
+	}
+}
+
 func Test_Request_ValidateDomain(t *testing.T) {
 	r := socks4.Request{IP: ip4(0, 0, 0, 1), Domain: ""}
 	if err := r.ValidateDomain(); !errors.Is(err, socks4.ErrInvalidDomain) {
@@ -103,6 +160,38 @@ func Test_Request_ValidateDomain(t *testing.T) {
 	if err := r.ValidateDomain(); !errors.Is(err, socks4.ErrInvalidDomain) {
 		t.Errorf("expected ErrInvalidDomain, got %v", err)
 	}
+
+	for _, domain := range []string{"exa\x00mple.com", "ex ample.com", "bücher.example", "-bad.com", "my_host.local"} {
+		r = socks4.Request{IP: ip4(0, 0, 0, 1), Domain: domain}
+		if err := r.ValidateDomain(); !errors.Is(err, socks4.ErrInvalidDomain) {
+			t.Errorf("expected ErrInvalidDomain for %q, got %v", domain, err)
+		}
+	}
+}
+
+func Test_ValidateDomainName_Underscore(t *testing.T) {
+	if err := socks4.ValidateDomainName("my_host.local", false); !errors.Is(err, socks4.ErrInvalidDomain) {
+		t.Errorf("expected ErrInvalidDomain without allowUnderscore, got %v", err)
+	}
+	if err := socks4.ValidateDomainName("my_host.local", true); err != nil {
+		t.Errorf("expected underscore to be allowed, got %v", err)
+	}
+	if err := socks4.ValidateDomainName("my host.local", true); !errors.Is(err, socks4.ErrInvalidDomain) {
+		t.Errorf("expected ErrInvalidDomain, got %v", err)
+	}
+}
+
+// An embedded NUL would end the DOMAIN field early and desynchronize the stream.
+func Test_Request_WriteTo_DomainWithNUL(t *testing.T) {
+	r := socks4.Request{Version: socks4.SocksVersion, Command: socks4.CmdConnect, Port: 80, IP: ip4(0, 0, 0, 1), Domain: "evil.com\x00extra"}
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); !errors.Is(err, socks4.ErrInvalidDomain) {
+		t.Fatalf("expected ErrInvalidDomain, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("WriteTo wrote %d bytes on error", buf.Len())
+	}
 }
 
 func Test_Request_ReadHeaderFrom_Invalid(t *testing.T) {
@@ -134,8 +223,176 @@ func Test_Request_ReadUserIDAndDomain_Truncated(t *testing.T) {
 	data := []byte{4, 1, 0x1F, 0x90, 127, 0, 0, 1, 'u'} // no null terminator
 	r := socks4.Request{}
 	_, err := r.ReadFrom(bytes.NewReader(data))
-	if err == nil {
-		t.Errorf("expected EOF for truncated userid")
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for truncated userid, got %v", err)
+	}
+
+	var pe *socks4.ParseError
+	if !errors.As(err, &pe) || pe.Field != "USERID" || pe.Offset != int64(len(data)) {
+		t.Errorf("expected ParseError for USERID at offset %d, got %#v", len(data), err)
+	}
+}
+
+func Test_Request_ReadFromWithLimits_TooLong(t *testing.T) {
+	const limit = 4
+	hdr := []byte{4, 1, 0x1F, 0x90, 127, 0, 0, 1}
+	hdr4a := []byte{4, 1, 0x1F, 0x90, 0, 0, 0, 1}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		want  error
+		field string
+	}{
+		{"userid at limit", append(slices.Clone(hdr), "user\x00"...), nil, ""},
+		{"userid over limit", append(slices.Clone(hdr), "users\x00"...), socks4.ErrUserIDTooLong, "USERID"},
+		{"userid over limit at EOF", append(slices.Clone(hdr), "users"...), socks4.ErrUserIDTooLong, "USERID"},
+		{"userid truncated", append(slices.Clone(hdr), "use"...), io.ErrUnexpectedEOF, "USERID"},
+		{"domain at limit", append(slices.Clone(hdr4a), "u\x00a.io\x00"...), nil, ""},
+		{"domain over limit", append(slices.Clone(hdr4a), "u\x00ab.io\x00"...), socks4.ErrDomainTooLong, "DOMAIN"},
+		{"domain truncated", append(slices.Clone(hdr4a), "u\x00a.i"...), io.ErrUnexpectedEOF, "DOMAIN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r socks4.Request
+			_, err := r.ReadFromWithLimits(bytes.NewReader(tt.data), limit, limit)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("expected success, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			var pe *socks4.ParseError
+			if !errors.As(err, &pe) || pe.Field != tt.field {
+				t.Errorf("expected ParseError for %s, got %v", tt.field, err)
+			}
+		})
+	}
+
+	// the whole domain is read ahead with a larger USERID limit
+	var r socks4.Request
+	data := append(slices.Clone(hdr4a), "u\x00ab.io\x00"...)
+	if _, err := r.ReadFromWithLimits(bytes.NewReader(data), 64, limit); !errors.Is(err, socks4.ErrDomainTooLong) {
+		t.Fatalf("read-ahead domain: got %v, want ErrDomainTooLong", err)
+	}
+}
+
+func Test_Request_ReadFromWithLimits_CharSet(t *testing.T) {
+	request := func(userID string) []byte {
+		return append(append([]byte{4, 1, 0x1F, 0x90, 127, 0, 0, 1}, userID...), 0)
+	}
+
+	tests := []struct {
+		name    string
+		userID  string
+		charSet int
+		want    string // error text (empty=accepted)
+	}{
+		{"any", "u\x07\xc3r", 0, ""},
+		{"printable ok", "user-1 name", socks4.UserIDCharSetPrintable, ""},
+		{"non-printable", "u\x07ser", socks4.UserIDCharSetPrintable, "non-printable byte 0x07 at index 1"},
+		{"non-printable DEL", "user\x7f", socks4.UserIDCharSetPrintable, "non-printable byte 0x7f at index 4"},
+		{"ascii ok", "u\x07ser", socks4.UserIDCharSetASCII, ""},
+		{"non-ascii", "us\xc3\xa9r", socks4.UserIDCharSetASCII, "non-ASCII byte 0xc3 at index 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := request(tt.userID)
+
+			var r socks4.Request
+			_, err := r.ReadFromWithLimits(bytes.NewReader(data), socks4.DefaultMaxUserIDLen, socks4.DefaultMaxDomainLen, socks4.WithCharSetValidation(tt.charSet))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, socks4.ErrInvalidUserID) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected ErrInvalidUserID with %q, got %v", tt.want, err)
+			}
+			var pe *socks4.ParseError
+			if !errors.As(err, &pe) || pe.Field != "USERID" || pe.Offset != int64(len(data)) {
+				t.Errorf("expected ParseError for USERID at offset %d, got %#v", len(data), err)
+			}
+		})
+	}
+}
+
+func Test_Request_ValidateUserID_NUL(t *testing.T) {
+	// A NUL before the terminator ends USERID early on the wire, so it is
+	// rejected whatever the character set.
+	r := socks4.Request{Version: socks4.SocksVersion, Command: socks4.CmdConnect, Port: 80, IP: [4]byte{127, 0, 0, 1}, UserID: "us\x00er"}
+
+	for _, charSet := range []int{0, socks4.UserIDCharSetASCII, socks4.UserIDCharSetPrintable} {
+		err := r.ValidateUserID(charSet)
+		if !errors.Is(err, socks4.ErrInvalidUserID) || !strings.Contains(err.Error(), "NUL at index 2") {
+			t.Errorf("charSet %d: expected NUL error, got %v", charSet, err)
+		}
+	}
+
+	if _, err := r.WriteTo(io.Discard); !errors.Is(err, socks4.ErrInvalidUserID) {
+		t.Errorf("WriteTo: expected ErrInvalidUserID, got %v", err)
+	}
+
+	// read back, the bytes after the NUL are not part of the request
+	data := []byte{4, 1, 0, 80, 127, 0, 0, 1, 'u', 's', 0, 'e', 'r', 0}
+	if err := r.UnmarshalBinary(data); !errors.Is(err, socks4.ErrTrailingData) {
+		t.Errorf("UnmarshalBinary: expected ErrTrailingData, got %v", err)
+	}
+}
+
+func Test_Request_ReadFrom_ParseError(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		want   error
+		field  string
+		offset int64
+	}{
+		{"version", []byte{5, 1, 0x1F, 0x90, 127, 0, 0, 1, 0}, socks4.ErrInvalidVersion, "VN", 8},
+		{"command", []byte{4, 9, 0x1F, 0x90, 127, 0, 0, 1, 0}, socks4.ErrInvalidCommand, "CD", 8},
+		{"truncated header", []byte{4, 1, 0x1F}, io.ErrUnexpectedEOF, "header", 3},
+		{"truncated domain", []byte{4, 1, 0x1F, 0x90, 0, 0, 0, 1, 'u', 0, 'e', 'x'}, io.ErrUnexpectedEOF, "DOMAIN", 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r socks4.Request
+			_, err := r.ReadFrom(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			var pe *socks4.ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected ParseError, got %T", err)
+			}
+			if pe.Field != tt.field || pe.Offset != tt.offset {
+				t.Errorf("got field %q at offset %d, want %q at %d", pe.Field, pe.Offset, tt.field, tt.offset)
+			}
+		})
+	}
+}
+
+func Test_Request_ReadFrom_EOF(t *testing.T) {
+	var r socks4.Request
+
+	// nothing sent: clean EOF
+	if _, err := r.ReadFrom(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty input: got %v, want io.EOF", err)
+	}
+
+	// connection closed after the 8-byte header: truncated request
+	hdr := []byte{4, 1, 0x1F, 0x90, 127, 0, 0, 1}
+	if _, err := r.ReadFrom(bytes.NewReader(hdr)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("header only: got %v, want io.ErrUnexpectedEOF", err)
 	}
 }
 
@@ -146,3 +403,167 @@ func Test_Request_ValidateHeader_InvalidIP(t *testing.T) {
 		t.Errorf("expected ErrInvalidIP for IPv6")
 	}
 }
+
+func BenchmarkRequestWriteTo(b *testing.B) {
+	var r socks4.Request
+	r.Init(socks4.SocksVersion, socks4.CmdConnect, 443, net.IPv4(0, 0, 0, 1), "user", "www.example.com")
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := r.WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_Request_WriteTo_NonIPv4(t *testing.T) {
+	var r socks4.Request
+	r.Init(socks4.SocksVersion, socks4.CmdConnect, 80, net.IPv4(10, 0, 0, 1), "", "")
+	r.Init(socks4.SocksVersion, socks4.CmdConnect, 80, net.ParseIP("2001:db8::1"), "", "")
+
+	// the previous address must not leak through
+	if r.IP != ip4(0, 0, 0, 0) {
+		t.Fatalf("IP = %v, want 0.0.0.0", r.IP)
+	}
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); !errors.Is(err, socks4.ErrInvalidIP) {
+		t.Fatalf("WriteTo() error = %v, want ErrInvalidIP", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("WriteTo() wrote %d bytes for a malformed request", buf.Len())
+	}
+}
+
+func Test_Request_Reset(t *testing.T) {
+	r := socks4.Request{}
+	r.Init(socks4.SocksVersion, socks4.CmdConnect, 443, net.IPv4(0, 0, 0, 1), "alice", "example.org")
+
+	r.Reset()
+	if !r.Equal(&socks4.Request{}) {
+		t.Errorf("expected zero request, got %+v", r)
+	}
+}
+
+// readTrackingConn records whether a Read is in progress.
+type readTrackingConn struct {
+	net.Conn
+	reading atomic.Bool
+}
+
+func (c *readTrackingConn) Read(p []byte) (int, error) {
+	c.reading.Store(true)
+	defer c.reading.Store(false)
+	return c.Conn.Read(p)
+}
+
+func Test_Request_ReadFromWithContext(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	// the client sends the header, then pauses before USERID
+	go client.Write([]byte{socks4.SocksVersion, socks4.CmdConnect, 0, 80, 10, 0, 0, 1})
+
+	src := &readTrackingConn{Conn: server}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	var r socks4.Request
+	if _, err := r.ReadFromWithContext(ctx, src); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ReadFromWithContext error = %v, want context.Canceled", err)
+	}
+	if src.reading.Load() {
+		t.Fatal("read still in progress after ReadFromWithContext returned")
+	}
+	if r != (socks4.Request{}) {
+		t.Errorf("request modified after cancellation: %+v", r)
+	}
+
+	// the deadline is cleared, so the connection is still usable
+	go client.Write([]byte{4, 1, 0, 80, 10, 0, 0, 2, 'u', 0})
+	if _, err := r.ReadFromWithContext(context.Background(), src); err != nil {
+		t.Fatalf("ReadFromWithContext after cancellation: %v", err)
+	}
+	if r.UserID != "u" || r.IP != ip4(10, 0, 0, 2) {
+		t.Errorf("unexpected request %+v", r)
+	}
+}
+
+func Test_Request_ReadWithContext_Parts(t *testing.T) {
+	data := []byte{socks4.SocksVersion, socks4.CmdConnect, 0, 80, 0, 0, 0, 1, 'u', 0, 'a', '.', 'i', 'o', 0}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := bytes.NewReader(data)
+	var r socks4.Request
+	if n, err := r.ReadHeaderFromWithContext(ctx, src); err != nil || n != 8 {
+		t.Fatalf("ReadHeaderFromWithContext = (%d, %v), want (8, nil)", n, err)
+	}
+	if n, err := r.ReadUserIDAndDomainWithContext(ctx, src, 16, 16); err != nil || n != 7 {
+		t.Fatalf("ReadUserIDAndDomainWithContext = (%d, %v), want (7, nil)", n, err)
+	}
+	if r.UserID != "u" || r.Domain != "a.io" {
+		t.Errorf("unexpected request %+v", r)
+	}
+
+	// a context already done does not read
+	cancel()
+	src.Reset(data)
+	if _, err := r.ReadFromWithContext(ctx, src); !errors.Is(err, context.Canceled) || src.Len() != len(data) {
+		t.Errorf("ReadFromWithContext with done context = %v after reading %d bytes", err, len(data)-src.Len())
+	}
+}
+
+func Test_Request_SOCKS4a_MarkerRange(t *testing.T) {
+	for x := 1; x <= 255; x++ {
+		r := socks4.Request{Version: socks4.SocksVersion, Command: socks4.CmdConnect, Port: 80, IP: ip4(0, 0, 0, byte(x)), Domain: "example.com"}
+		if !r.IsSOCKS4a() || r.IsSOCKS4() {
+			t.Fatalf("0.0.0.%d: IsSOCKS4a=%v IsSOCKS4=%v, want SOCKS4a", x, r.IsSOCKS4a(), r.IsSOCKS4())
+		}
+
+		b, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatalf("0.0.0.%d: MarshalBinary failed: %v", x, err)
+		}
+		var got socks4.Request
+		if _, err := got.ReadFrom(bytes.NewReader(b)); err != nil || got != r {
+			t.Fatalf("0.0.0.%d: ReadFrom = (%+v, %v), want %+v", x, got, err, r)
+		}
+	}
+
+	// 0.0.0.0 is SOCKS4: invalid for CONNECT, valid for BIND
+	r := socks4.Request{Version: socks4.SocksVersion, Command: socks4.CmdConnect}
+	if r.IsSOCKS4a() || !r.IsSOCKS4() {
+		t.Errorf("0.0.0.0: IsSOCKS4a=%v IsSOCKS4=%v, want SOCKS4", r.IsSOCKS4a(), r.IsSOCKS4())
+	}
+	if err := r.ValidateHeader(); !errors.Is(err, socks4.ErrInvalidIP) {
+		t.Errorf("CONNECT 0.0.0.0: ValidateHeader = %v, want ErrInvalidIP", err)
+	}
+	r.Command = socks4.CmdBind
+	if err := r.ValidateComplete(); err != nil {
+		t.Errorf("BIND 0.0.0.0: ValidateComplete = %v, want nil", err)
+	}
+
+	r.IP = ip4(10, 0, 0, 1)
+	r.MarkSOCKS4a()
+	if r.IP != ip4(0, 0, 0, 1) || !r.IsSOCKS4a() {
+		t.Errorf("MarkSOCKS4a: IP = %v", r.IP)
+	}
+}
+
+func Test_Request_ReadFrom_SOCKS4aWithoutDomain(t *testing.T) {
+	for _, x := range []byte{1, 255} {
+		data := []byte{socks4.SocksVersion, socks4.CmdConnect, 0, 80, 0, 0, 0, x, 'u', 0, 0}
+
+		var r socks4.Request
+		_, err := r.ReadFrom(bytes.NewReader(data))
+		var pe *socks4.ParseError
+		if !errors.Is(err, socks4.ErrInvalidDomain) || !errors.As(err, &pe) || pe.Field != "DOMAIN" {
+			t.Errorf("0.0.0.%d: ReadFrom error = %v, want ErrInvalidDomain in DOMAIN", x, err)
+		}
+		if _, err := r.UnmarshalFrom(data); !errors.Is(err, socks4.ErrInvalidDomain) {
+			t.Errorf("0.0.0.%d: UnmarshalFrom error = %v, want ErrInvalidDomain", x, err)
+		}
+	}
+}
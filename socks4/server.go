@@ -4,11 +4,32 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/33TU/socks/internal"
+	socksnet "github.com/33TU/socks/net"
 )
 
+// ErrNotSOCKS is passed to OnError and returned by ServeConn when a client is
+// not speaking SOCKS4 at all, e.g. an HTTP or TLS client (see socksnet.SniffNotSOCKS).
+type ErrNotSOCKS = socksnet.ErrNotSOCKS
+
+// PhaseError is passed to OnError and OnClose and returned by ServeConn. It
+// tags an error with the connection phase it occurred in (see socksnet.Phase).
+type PhaseError = socksnet.PhaseError
+
+// AuditRecord describes a proxied connection for the audit hook (see
+// BaseServerHandler.OnAudit).
+type AuditRecord = socksnet.AuditRecord
+
+// PanicEntry describes a panic recovered while serving a connection, for the
+// panic hook (see BaseServerHandler.OnPanicEntry).
+type PanicEntry = socksnet.PanicEntry
+
+// requestPool holds the requests reused by ServeConn.
+var requestPool = sync.Pool{New: func() any { return new(Request) }}
+
 // DefaultServerHandler is a default implementation used when no custom ServerHandler is provided to Serve or ListenAndServe.
 var DefaultServerHandler ServerHandler = &BaseServerHandler{
 	RequestTimeout:     10 * time.Second,
@@ -21,6 +42,10 @@ var DefaultServerHandler ServerHandler = &BaseServerHandler{
 }
 
 // ServerHandler handles SOCKS4 server events.
+//
+// The *Request passed to the handler is pooled by ServeConn and reused for a
+// later connection once it returns. Handlers and the hooks they call must not
+// keep it past ServeConn; use Clone to retain a copy.
 type ServerHandler interface {
 	// OnAccept is called for each accepted connection.
 	OnAccept(ctx context.Context, conn net.Conn) error
@@ -41,7 +66,8 @@ type ServerHandler interface {
 	// OnBind is called for each BIND request.
 	OnBind(ctx context.Context, conn net.Conn, req *Request) error
 
-	// OnError is called for each connection error.
+	// OnError is called for each connection error. Errors are *PhaseError,
+	// telling e.g. a failed dial from a malformed request or a client timeout.
 	OnError(ctx context.Context, conn net.Conn, err error)
 
 	// OnPanic is called when a panic occurs in any handler goroutine.
@@ -59,6 +85,8 @@ func Serve(ctx context.Context, listener net.Listener, handler ServerHandler) er
 		listener.Close()
 	}()
 
+	backoff := socksnet.AcceptBackoff{Max: acceptMaxBackoff(handler)}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -66,15 +94,37 @@ func Serve(ctx context.Context, listener net.Listener, handler ServerHandler) er
 		default:
 			conn, err := listener.Accept()
 			if err != nil {
-				handler.OnError(ctx, nil, err)
-				continue
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				// Retry temporary errors (e.g. EMFILE) with backoff, give up on permanent ones
+				handler.OnError(ctx, nil, socksnet.WithPhase(socksnet.PhaseAccept, err))
+				if backoff.Wait(ctx, err) || ctx.Err() != nil {
+					continue
+				}
+				return err
 			}
 
+			backoff.Reset()
 			go ServeConn(ctx, handler, conn)
 		}
 	}
 }
 
+// acceptBackoffHandler is implemented by handlers that configure the accept backoff.
+type acceptBackoffHandler interface {
+	GetAcceptMaxBackoff() time.Duration
+}
+
+// acceptMaxBackoff returns the handler's maximum accept backoff, or 0 for the default.
+func acceptMaxBackoff(handler ServerHandler) time.Duration {
+	if h, ok := handler.(acceptBackoffHandler); ok {
+		return h.GetAcceptMaxBackoff()
+	}
+	return 0
+}
+
 // ListenAndServe listens on the network address and serves SOCKS4 requests.
 func ListenAndServe(ctx context.Context, network, address string, handler ServerHandler) error {
 	ln, err := net.Listen(network, address)
@@ -91,21 +141,55 @@ func ServeConn(ctx context.Context, handler ServerHandler, conn net.Conn) (err e
 		return fmt.Errorf("nil handler provided")
 	}
 
+	start := time.Now()
+	connID := socksnet.NewConnID()
+	audit := auditHook(handler)
+	onPanic := panicHook(handler)
+
+	// The request is pooled across connections (see ServerHandler)
+	req := requestPool.Get().(*Request)
+
+	var (
+		hasReq  bool
+		auditor *socksnet.AuditConn
+	)
+
 	defer func() {
 		if r := recover(); r != nil {
-			handler.OnPanic(ctx, conn, r)
+			if onPanic != nil {
+				onPanic(ctx, conn, socksnet.NewPanicEntry(r, connID))
+			} else {
+				handler.OnPanic(ctx, conn, r)
+			}
 		}
 
 		handler.OnClose(ctx, conn, err)
 		_ = conn.Close()
+
+		if audit != nil {
+			var r *Request
+			if hasReq {
+				r = req
+			}
+			audit(ctx, newAuditRecord(start, conn, r, auditor, err))
+		}
+
+		req.Reset()
+		requestPool.Put(req)
 	}()
 
-	// OnAccept callback
-	if err = handler.OnAccept(ctx, conn); err != nil {
+	// fail tags err with the phase it occurred in and reports it
+	fail := func(phase socksnet.Phase, cause error) error {
+		err = socksnet.WithPhase(phase, cause)
 		handler.OnError(ctx, conn, err)
 		return err
 	}
 
+	// OnAccept callback
+	if err = handler.OnAccept(ctx, conn); err != nil {
+		return fail(socksnet.PhaseAccept, err)
+	}
+
 	// Use reused reader to reduce allocations
 	reader := internal.GetReader(conn)
 	released := false
@@ -120,38 +204,110 @@ func ServeConn(ctx context.Context, handler ServerHandler, conn net.Conn) (err e
 	}
 	defer release()
 
+	// Tell clients speaking another protocol apart from malformed requests
+	if err = socksnet.SniffNotSOCKS(reader, SocksVersion); err != nil {
+		return fail(socksnet.PhaseRequest, err)
+	}
+
+	// Record replies and relayed bytes for the audit hook
+	if audit != nil {
+		auditor = socksnet.NewAuditConn(conn)
+		conn = auditor
+	}
+
 	// Read SOCKS4 request using pooled reader
-	var req Request
 	if _, err = req.ReadFrom(reader); err != nil {
 		WriteRejectReply(conn, RepRejected)
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseRequest, err)
+	}
+	hasReq = true
+	if auditor != nil && req.Command == CmdBind {
+		auditor.Replies = 2
 	}
 
 	// Validate user ID
 	if err = handler.OnUserID(ctx, conn, req.UserID, len(req.UserID) > 0); err != nil {
 		WriteRejectReply(conn, RepRejected)
 		err = fmt.Errorf("user ID validation failed: %w", err)
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseAuth, err)
 	}
 
 	// Release resources used for io
 	release()
 
 	// Handle the request
-	if err = handler.OnRequest(ctx, conn, &req); err != nil {
-		handler.OnError(ctx, conn, err)
-		return err
+	if err = handler.OnRequest(ctx, conn, req); err != nil {
+		return fail(socksnet.PhaseRequest, err)
 	}
 
 	return nil
 }
 
+// auditHookHandler is implemented by handlers that receive an AuditRecord for
+// each connection.
+type auditHookHandler interface {
+	GetAuditHook() func(ctx context.Context, rec *AuditRecord)
+}
+
+// auditHook returns the handler's audit hook, or nil if auditing is disabled.
+func auditHook(handler ServerHandler) func(ctx context.Context, rec *AuditRecord) {
+	if h, ok := handler.(auditHookHandler); ok {
+		return h.GetAuditHook()
+	}
+	return nil
+}
+
+// panicHookHandler is implemented by handlers that receive a PanicEntry,
+// including the stack trace, instead of calling OnPanic.
+type panicHookHandler interface {
+	GetPanicHook() func(ctx context.Context, conn net.Conn, e *PanicEntry)
+}
+
+// panicHook returns the handler's panic hook, or nil to call OnPanic.
+func panicHook(handler ServerHandler) func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+	if h, ok := handler.(panicHookHandler); ok {
+		return h.GetPanicHook()
+	}
+	return nil
+}
+
+// newAuditRecord assembles the AuditRecord of a connection accepted at start.
+// req is nil if no request was read, and auditor is nil if the connection ended
+// before the request was read.
+func newAuditRecord(start time.Time, conn net.Conn, req *Request, auditor *socksnet.AuditConn, err error) *AuditRecord {
+	rec := &AuditRecord{
+		Time:     start,
+		ID:       socksnet.NewAuditID(),
+		Version:  SocksVersion,
+		Duration: time.Since(start),
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		rec.Client = addr.String()
+	}
+	if req != nil {
+		rec.Command = req.CommandType().String()
+		rec.Target = req.Addr()
+		rec.User = req.UserID
+	}
+	if auditor != nil {
+		if code, ok := auditor.Reply(); ok {
+			rec.Reply = ReplyCode(code).String()
+		}
+		rec.BytesUp, rec.BytesDown = auditor.Snapshot()
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		if phase, ok := socksnet.ErrorPhase(err); ok {
+			rec.Phase = phase.String()
+		}
+	}
+	return rec
+}
+
 // WriteRejectReply sends a SOCKS4 reply with the given rejection code.
 func WriteRejectReply(conn net.Conn, code byte) {
-	var resp Reply
-	resp.Init(0, code, 0, net.IPv4zero)
+	resp := NewRejected(0, net.IPv4zero)
+	resp.Code = code
 	resp.WriteTo(conn)
 }
 
@@ -184,8 +340,6 @@ func WriteSuccessReply(conn net.Conn, addr net.Addr) error {
 		}
 	}
 
-	var resp Reply
-	resp.Init(0, RepGranted, port, ip)
-	_, err := resp.WriteTo(conn)
+	_, err := NewGranted(port, ip).WriteTo(conn)
 	return err
 }
@@ -9,29 +9,67 @@ import (
 	"net"
 	"time"
 
-	"golang.org/x/sync/errgroup"
-
 	socksnet "github.com/33TU/socks/net"
 )
 
+// BeforeRelayFunc is called once the CONNECT target has been dialed, before the
+// success reply is sent and data is relayed. Returning an error rejects the request
+// and closes both connections.
+type BeforeRelayFunc func(ctx context.Context, clientConn, targetConn net.Conn, req *Request) error
+
+// AfterRelayFunc is called once both directions of a CONNECT relay have finished.
+// bytesUp counts client-to-target bytes and bytesDown target-to-client bytes.
+type AfterRelayFunc func(ctx context.Context, clientConn net.Conn, req *Request, bytesUp, bytesDown int64, relayErr error)
+
 // BaseServerHandler provides a basic implementation of ServerHandler with configurable options.
 type BaseServerHandler struct {
-	Dialer             socksnet.Dialer
+	// Dialer connects to request targets (nil=socksnet.DefaultDialer, which times
+	// out after socksnet.DefaultDialTimeout; set &net.Dialer{} for no timeout).
+	Dialer socksnet.Dialer
+
 	RequestTimeout     time.Duration
 	BindAcceptTimeout  time.Duration
 	BindConnTimeout    time.Duration
 	ConnectConnTimeout time.Duration
-	ConnectBufferSize  int
+	ConnectBufferSize  int // Relay copy buffer per direction (0=32KB, clamped to 4KB-4MB)
 	AllowConnect       bool
 	AllowBind          bool
+	AcceptMaxBackoff   time.Duration // Maximum delay between retries of temporary Accept errors (0=1s)
+	LogIdentifiers     bool          // Log user IDs verbatim instead of as a short hash
+
+	// AcceptFilter is called first by OnAccept; an error closes the connection
+	// without a reply (nil=accept all). See AcceptOnlyFromCIDRs.
+	AcceptFilter func(ctx context.Context, conn net.Conn) error
 
 	// UserIDChecker is a function that validates the user ID from the SOCKS4 request.
 	// It should return an error if the user ID is not allowed, or nil to accept the request.
 	// If nil, all user IDs will be accepted by default.
 	UserIDChecker func(ctx context.Context, userID string) error
+
+	BeforeRelay BeforeRelayFunc // Optional hook before a CONNECT relay starts
+	AfterRelay  AfterRelayFunc  // Optional hook after a CONNECT relay ends
+
+	// Rule is checked before each request is handled; an error rejects the request
+	// (nil=allow all). One RuleFunc can serve both the SOCKS4 and SOCKS5 servers.
+	Rule socksnet.RuleFunc
+
+	// OnAudit is called with an AuditRecord once each connection has closed, including
+	// connections rejected before a request was read (nil=no auditing).
+	OnAudit func(ctx context.Context, rec *AuditRecord)
+
+	// OnPanicEntry is called instead of OnPanic when serving a connection panics,
+	// with the stack trace of the panic (nil=log the panic value only). See
+	// socksnet.DefaultPanicLogger.
+	OnPanicEntry func(ctx context.Context, conn net.Conn, e *PanicEntry)
 }
 
 func (d *BaseServerHandler) OnAccept(ctx context.Context, conn net.Conn) error {
+	if d.AcceptFilter != nil {
+		if err := d.AcceptFilter(ctx, conn); err != nil {
+			return err
+		}
+	}
+
 	slog.InfoContext(ctx, "accepted connection", "from", conn.RemoteAddr())
 
 	if d.RequestTimeout != 0 {
@@ -65,7 +103,7 @@ func (d *BaseServerHandler) OnConnect(ctx context.Context, conn net.Conn, req *R
 	addr := req.Addr()
 	slog.InfoContext(ctx, "CONNECT request", "from", conn.RemoteAddr(), "target", addr)
 
-	if err := BaseOnConnect(ctx, conn, req, d.Dialer, d.ConnectConnTimeout, d.ConnectBufferSize); isUnexpectedNetErr(err) {
+	if err := baseOnConnect(ctx, conn, req, d.Dialer, d.ConnectConnTimeout, d.ConnectBufferSize, d.BeforeRelay, d.AfterRelay); isUnexpectedNetErr(err) {
 		return fmt.Errorf("CONNECT failed to %s: %w", addr, err)
 	}
 
@@ -85,8 +123,23 @@ func (d *BaseServerHandler) OnPanic(ctx context.Context, conn net.Conn, r any) {
 	slog.WarnContext(ctx, "panic occurred", "error", r)
 }
 
+// GetAuditHook returns the hook called with each connection's AuditRecord.
+func (d *BaseServerHandler) GetAuditHook() func(ctx context.Context, rec *AuditRecord) {
+	return d.OnAudit
+}
+
+// GetPanicHook returns the hook called with the PanicEntry of a recovered panic.
+func (d *BaseServerHandler) GetPanicHook() func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+	return d.OnPanicEntry
+}
+
+// GetAcceptMaxBackoff returns the maximum delay between retries of temporary Accept errors.
+func (d *BaseServerHandler) GetAcceptMaxBackoff() time.Duration {
+	return d.AcceptMaxBackoff
+}
+
 func (d *BaseServerHandler) OnUserID(ctx context.Context, conn net.Conn, userID string, hasUserID bool) error {
-	slog.InfoContext(ctx, "validating user ID", "from", conn.RemoteAddr(), "user_id", userID, "has_user_id", hasUserID)
+	slog.InfoContext(ctx, "validating user ID", "from", conn.RemoteAddr(), "user_id", logIdentifier(userID, d.LogIdentifiers), "has_user_id", hasUserID)
 
 	if d.UserIDChecker != nil {
 		return d.UserIDChecker(ctx, userID)
@@ -95,7 +148,16 @@ func (d *BaseServerHandler) OnUserID(ctx context.Context, conn net.Conn, userID
 }
 
 func (d *BaseServerHandler) OnRequest(ctx context.Context, conn net.Conn, req *Request) error {
-	err := BaseOnRequest(ctx, d, conn, req)
+	var err error
+	if d.Rule != nil {
+		if err = d.Rule(ctx, NewRequestInfo(req)); err != nil {
+			WriteRejectReply(conn, RepRejected)
+			err = fmt.Errorf("request rejected by rule: %w", err)
+		}
+	}
+	if err == nil {
+		err = BaseOnRequest(ctx, d, conn, req)
+	}
 	if err != nil {
 		slog.ErrorContext(ctx, "request handling failed", "error", err, "from", conn.RemoteAddr(), "request", req)
 	}
@@ -117,6 +179,20 @@ func BaseOnRequest(ctx context.Context, handler ServerHandler, conn net.Conn, re
 
 // BaseOnConnect provides CONNECT implementation
 func BaseOnConnect(ctx context.Context, conn net.Conn, req *Request, dialer socksnet.Dialer, connTimeout time.Duration, bufferSize int) error {
+	return baseOnConnect(ctx, conn, req, dialer, connTimeout, bufferSize, nil, nil)
+}
+
+// baseOnConnect is BaseOnConnect with the relay hooks, which are optional (nil=none).
+func baseOnConnect(
+	ctx context.Context,
+	conn net.Conn,
+	req *Request,
+	dialer socksnet.Dialer,
+	connTimeout time.Duration,
+	bufferSize int,
+	beforeRelay BeforeRelayFunc,
+	afterRelay AfterRelayFunc,
+) error {
 	if dialer == nil {
 		dialer = socksnet.DefaultDialer
 	}
@@ -124,27 +200,31 @@ func BaseOnConnect(ctx context.Context, conn net.Conn, req *Request, dialer sock
 	remote, err := dialer.DialContext(ctx, "tcp", req.Addr())
 	if err != nil {
 		WriteRejectReply(conn, RepRejected)
-		return fmt.Errorf("failed to connect to target: %w", err)
+		return socksnet.WithPhase(socksnet.PhaseDial, fmt.Errorf("failed to connect to target: %w", err))
 	}
 	defer remote.Close()
 
+	if beforeRelay != nil {
+		if err := beforeRelay(ctx, conn, remote, req); err != nil {
+			WriteRejectReply(conn, RepRejected)
+			return fmt.Errorf("relay rejected: %w", err)
+		}
+	}
+
 	// Send success reply
 	if err := WriteSuccessReply(conn, remote.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to write connect response: %w", err)
 	}
 
-	// Start bidirectional copying with coordinated error handling
-	g, ctx := errgroup.WithContext(ctx)
-
-	g.Go(func() error {
-		return socksnet.CopyConn(remote, conn, connTimeout, bufferSize)
+	// Relay in both directions until both sides are done
+	stats, err := socksnet.Relay(context.WithoutCancel(ctx), conn, remote, &socksnet.RelayOptions{
+		BufferSize:  bufferSize,
+		IdleTimeout: connTimeout,
 	})
-
-	g.Go(func() error {
-		return socksnet.CopyConn(conn, remote, connTimeout, bufferSize)
-	})
-
-	return g.Wait()
+	if afterRelay != nil {
+		afterRelay(ctx, conn, req, stats.BytesUp, stats.BytesDown, err)
+	}
+	return socksnet.WithPhase(socksnet.PhaseRelay, err)
 }
 
 // BaseOnBind provides BIND implementation
@@ -195,18 +275,12 @@ func BaseOnBind(ctx context.Context, conn net.Conn, req *Request, acceptTimeout,
 		return fmt.Errorf("failed to write connection response: %w", err)
 	}
 
-	// Start bidirectional copying with coordinated error handling
-	g, ctx := errgroup.WithContext(ctx)
-
-	g.Go(func() error {
-		return socksnet.CopyConn(incomingConn, conn, connTimeout, bufferSize)
-	})
-
-	g.Go(func() error {
-		return socksnet.CopyConn(conn, incomingConn, connTimeout, bufferSize)
+	// Relay in both directions until both sides are done
+	_, err = socksnet.Relay(context.WithoutCancel(ctx), conn, incomingConn, &socksnet.RelayOptions{
+		BufferSize:  bufferSize,
+		IdleTimeout: connTimeout,
 	})
-
-	return g.Wait()
+	return socksnet.WithPhase(socksnet.PhaseRelay, err)
 }
 
 // isUnexpectedNetErr checks if an error is a network error that is not EOF or ErrClosed
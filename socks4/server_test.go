@@ -4,12 +4,16 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"sync"
 	"testing"
 	"time"
+
+	socksnet "github.com/33TU/socks/net"
 )
 
 // genRandom creates n random bytes.
@@ -65,6 +69,54 @@ func startSOCKS4Server(t *testing.T, handler ServerHandler) net.Listener {
 	return ln
 }
 
+// sniffErrorHandler records the errors passed to OnError.
+type sniffErrorHandler struct {
+	*BaseServerHandler
+	errs chan error
+}
+
+func (h *sniffErrorHandler) OnError(ctx context.Context, conn net.Conn, err error) {
+	h.errs <- err
+}
+
+func TestServeConn_NotSOCKS(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		detected string
+	}{
+		{"http GET", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "http"},
+		{"tls ClientHello", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", "tls"},
+		{"socks5 handshake", "\x05\x01\x00", "socks5-on-socks4-port"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			handler := &sniffErrorHandler{BaseServerHandler: &BaseServerHandler{}, errs: make(chan error, 1)}
+			go client.Write([]byte(tt.input))
+
+			err := ServeConn(context.Background(), handler, server)
+
+			var notSOCKS *ErrNotSOCKS
+			if !errors.As(err, &notSOCKS) || notSOCKS.Detected != tt.detected {
+				t.Fatalf("ServeConn error = %v, want ErrNotSOCKS{%q}", err, tt.detected)
+			}
+			if got := <-handler.errs; !errors.As(got, &notSOCKS) {
+				t.Fatalf("OnError got %v, want *ErrNotSOCKS", got)
+			}
+
+			// no SOCKS4 reply is written back
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			if n, err := client.Read(make([]byte, 16)); err != io.EOF {
+				t.Fatalf("expected EOF, got %d bytes, %v", n, err)
+			}
+		})
+	}
+}
+
 func TestBaseServerHandler_OnConnect_Success(t *testing.T) {
 	// Start echo server
 	echoLn := echoServer(t)
@@ -169,6 +221,178 @@ func TestBaseServerHandler_OnConnect_TargetUnreachable(t *testing.T) {
 	t.Log("Target unreachable test passed")
 }
 
+func TestBaseServerHandler_RelayHooks(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	type relayResult struct {
+		up, down int64
+		err      error
+	}
+	results := make(chan relayResult, 1)
+	errDenied := fmt.Errorf("denied")
+
+	handler := &BaseServerHandler{
+		RequestTimeout: 2 * time.Second,
+		AllowConnect:   true,
+		BeforeRelay: func(ctx context.Context, clientConn, targetConn net.Conn, req *Request) error {
+			if req.UserID == "blocked" {
+				return errDenied
+			}
+			return nil
+		},
+		AfterRelay: func(ctx context.Context, clientConn net.Conn, req *Request, bytesUp, bytesDown int64, relayErr error) {
+			results <- relayResult{bytesUp, bytesDown, relayErr}
+		},
+	}
+
+	socksLn := startSOCKS4Server(t, handler)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// BeforeRelay rejects the request
+	blocked := NewDialer(socksLn.Addr().String(), "blocked", nil)
+	if conn, err := blocked.DialContext(ctx, "tcp", echoLn.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("Expected BeforeRelay to reject the request")
+	}
+
+	// AfterRelay reports the relayed byte counts
+	dialer := NewDialer(socksLn.Addr().String(), "testuser", nil)
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect through SOCKS4 proxy: %v", err)
+	}
+
+	payload := genRandom(1000)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case r := <-results:
+		if r.up != 1000 || r.down != 1000 {
+			t.Fatalf("AfterRelay got up=%d down=%d, want 1000 each", r.up, r.down)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("AfterRelay was not called")
+	}
+
+	// the rejected request never reached the relay
+	select {
+	case r := <-results:
+		t.Fatalf("unexpected AfterRelay call: %+v", r)
+	default:
+	}
+}
+
+func TestBaseServerHandler_Audit(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	records := make(chan *AuditRecord, 1)
+	handler := &BaseServerHandler{
+		RequestTimeout: 2 * time.Second,
+		AllowConnect:   true,
+		OnAudit: func(ctx context.Context, rec *AuditRecord) {
+			records <- rec
+		},
+	}
+
+	socksLn := startSOCKS4Server(t, handler)
+	defer socksLn.Close()
+
+	nextRecord := func() *AuditRecord {
+		select {
+		case rec := <-records:
+			return rec
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnAudit was not called")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	dialer := NewDialer(socksLn.Addr().String(), "alice", nil)
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect through SOCKS4 proxy: %v", err)
+	}
+
+	payload := genRandom(1000)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	conn.Close()
+
+	rec := nextRecord()
+	if rec.Version != 4 || rec.ID == "" || rec.Client == "" || rec.Duration <= 0 {
+		t.Fatalf("incomplete record: %+v", rec)
+	}
+	if rec.Command != "CONNECT" || rec.Target != echoLn.Addr().String() || rec.User != "alice" || rec.Reply != "granted" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if rec.BytesUp != 1000 || rec.BytesDown != 1000 {
+		t.Fatalf("record has up=%d down=%d, want 1000 each", rec.BytesUp, rec.BytesDown)
+	}
+
+	// BIND is not allowed, and the rejection is recorded
+	bindDialer := NewDialer(socksLn.Addr().String(), "bob", nil)
+	if _, _, _, err := bindDialer.BindContext(ctx, "tcp", "127.0.0.1:0"); err == nil {
+		t.Fatal("expected BIND to be rejected")
+	}
+	rec = nextRecord()
+	if rec.Command != "BIND" || rec.User != "bob" || rec.Reply != "rejected" || rec.BytesDown != 0 {
+		t.Fatalf("unexpected record for rejected BIND: %+v", rec)
+	}
+}
+
+func TestBaseServerHandler_OnPanicEntry(t *testing.T) {
+	entries := make(chan *PanicEntry, 1)
+	socksLn := startSOCKS4Server(t, &BaseServerHandler{
+		RequestTimeout: 2 * time.Second,
+		AllowConnect:   true,
+		Rule: func(ctx context.Context, req socksnet.RequestInfo) error {
+			panic("boom")
+		},
+		OnPanicEntry: func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+			entries <- e
+		},
+	})
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	dialer := NewDialer(socksLn.Addr().String(), "", nil)
+	if _, err := dialer.DialContext(ctx, "tcp", "127.0.0.1:9"); err == nil {
+		t.Fatal("expected CONNECT to fail after the rule panicked")
+	}
+
+	select {
+	case e := <-entries:
+		if e.PanicValue != "boom" || e.ConnID == 0 {
+			t.Fatalf("unexpected entry: value=%v conn=%d", e.PanicValue, e.ConnID)
+		}
+		if !bytes.Contains(e.Stack, []byte("TestBaseServerHandler_OnPanicEntry")) {
+			t.Fatalf("stack does not contain the panicking function:\n%s", e.Stack)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPanicEntry was not called")
+	}
+}
+
 func TestBaseServerHandler_OnBind_Success(t *testing.T) {
 	// Start SOCKS4 server with BIND enabled
 	handler := &BaseServerHandler{
@@ -468,3 +692,130 @@ func TestBaseServerHandler_UserIDValidation(t *testing.T) {
 		})
 	}
 }
+
+// tempError is a temporary net.Error such as EMFILE.
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// flakyListener fails Accept with err fails times before delegating to the wrapped listener.
+type flakyListener struct {
+	net.Listener
+	err   error
+	fails int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestServe_AcceptBackoff(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	flaky := &flakyListener{Listener: ln, err: tempError{}, fails: 4}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := &BaseServerHandler{AllowConnect: true, AcceptMaxBackoff: 20 * time.Millisecond}
+	start := time.Now()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- Serve(ctx, flaky, handler) }()
+
+	// Serve keeps running through temporary errors and serves the next connection
+	d := NewDialer(ln.Addr().String(), "", nil)
+	conn, err := d.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	conn.Close()
+
+	// 5ms + 10ms + 20ms + 20ms (capped)
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Fatalf("expected backoff between accept retries, served after %v", elapsed)
+	}
+
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Fatalf("expected nil from Serve after cancel, got %v", err)
+	}
+}
+
+func TestServe_AcceptPermanentError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	permanent := fmt.Errorf("listener broken")
+	flaky := &flakyListener{Listener: ln, err: permanent, fails: 1}
+
+	done := make(chan error, 1)
+	go func() { done <- Serve(context.Background(), flaky, &BaseServerHandler{}) }()
+
+	select {
+	case err := <-done:
+		if err != permanent {
+			t.Fatalf("expected permanent error from Serve, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return on permanent accept error")
+	}
+}
+
+// replayConn replays a client's bytes to the server and discards its replies.
+type replayConn struct {
+	net.Conn // unused methods panic
+	r        bytes.Reader
+	addr     net.TCPAddr
+}
+
+func (c *replayConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *replayConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) RemoteAddr() net.Addr               { return &c.addr }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// parseOnlyHandler stops after the request has been read.
+type parseOnlyHandler struct {
+	BaseServerHandler
+}
+
+func (h *parseOnlyHandler) OnRequest(ctx context.Context, conn net.Conn, req *Request) error {
+	return nil
+}
+
+// BenchmarkServeConn measures a full SOCKS4a request parse.
+func BenchmarkServeConn(b *testing.B) {
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	var client bytes.Buffer
+	(&Request{Version: SocksVersion, Command: CmdConnect, Port: 443, IP: [4]byte{0, 0, 0, 1}, UserID: "user", Domain: "example.com"}).WriteTo(&client)
+
+	handler := &parseOnlyHandler{}
+	conn := &replayConn{}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		conn.r.Reset(client.Bytes())
+		if err := ServeConn(ctx, handler, conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package socks5
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogTimeFormat is the timestamp format of the Common Log Format.
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry is one connection in the style of a web server access log,
+// for log pipelines that expect one. It is built from the connection's
+// AuditRecord; see AccessLog for writing entries as connections close.
+type AccessLogEntry struct {
+	Time     time.Time     `json:"time"`             // When the connection was accepted
+	Client   string        `json:"client"`           // Client address
+	User     string        `json:"user,omitempty"`   // Authenticated user
+	Command  string        `json:"cmd,omitempty"`    // Request command, e.g. "CONNECT" (empty if no request was read)
+	Target   string        `json:"target,omitempty"` // Requested host:port
+	Result   string        `json:"result,omitempty"` // Reply code sent to the client, e.g. "SUCCESS" (empty if none)
+	BytesRx  int64         `json:"bytes_rx"`         // Bytes received from the client after the reply
+	BytesTx  int64         `json:"bytes_tx"`         // Bytes sent to the client after the reply
+	Duration time.Duration `json:"duration_ns"`      // Connection lifetime
+}
+
+// NewAccessLogEntry returns the access log entry of the connection described by rec.
+func NewAccessLogEntry(rec *AuditRecord) *AccessLogEntry {
+	return &AccessLogEntry{
+		Time:     rec.Time,
+		Client:   rec.Client,
+		User:     rec.User,
+		Command:  rec.Command,
+		Target:   rec.Target,
+		Result:   rec.Reply,
+		BytesRx:  rec.BytesUp,
+		BytesTx:  rec.BytesDown,
+		Duration: rec.Duration,
+	}
+}
+
+// String formats e as a line modelled on the Common Log Format:
+//
+//	client - user [time] "command target" result bytes_rx bytes_tx duration_ms
+//
+// e.g.
+//
+//	127.0.0.1:50312 - alice [17/Oct/2026:10:04:05 +0000] "CONNECT example.com:443" SUCCESS 517 4821 1250
+//
+// Fields are separated by single spaces. Empty fields are "-", and a user or
+// client containing spaces, quotes or control characters is quoted with
+// strconv.Quote, so the line splits back into its fields unambiguously.
+func (e *AccessLogEntry) String() string {
+	request := "-"
+	if e.Command != "" {
+		request = e.Command + " " + e.Target
+	}
+
+	return fmt.Sprintf("%s - %s [%s] %s %s %d %d %d",
+		accessLogField(e.Client),
+		accessLogField(e.User),
+		e.Time.Format(accessLogTimeFormat),
+		strconv.Quote(request),
+		accessLogField(e.Result),
+		e.BytesRx,
+		e.BytesTx,
+		e.Duration.Milliseconds(),
+	)
+}
+
+// accessLogField returns s as a single token of an access log line.
+func accessLogField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	if strings.ContainsFunc(s, func(r rune) bool { return r <= ' ' || r == '"' || r >= 0x7f }) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// AccessLog writes AccessLogEntries to w, one per line, as Common-style lines
+// (see AccessLogEntry.String) or JSON. It is safe for concurrent use.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format func(e *AccessLogEntry) ([]byte, error)
+}
+
+// NewAccessLog returns an AccessLog writing Common-style lines to w.
+func NewAccessLog(w io.Writer) *AccessLog {
+	return &AccessLog{w: w, format: func(e *AccessLogEntry) ([]byte, error) {
+		return []byte(e.String()), nil
+	}}
+}
+
+// NewJSONAccessLog returns an AccessLog writing entries to w as JSON lines.
+func NewJSONAccessLog(w io.Writer) *AccessLog {
+	return &AccessLog{w: w, format: func(e *AccessLogEntry) ([]byte, error) {
+		return json.Marshal(e)
+	}}
+}
+
+// Write appends e as a single line.
+func (l *AccessLog) Write(e *AccessLogEntry) error {
+	line, err := l.format(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(line)
+	return err
+}
+
+// Attach makes h write an entry to l after each connection through its OnAudit
+// hook, including connections rejected before a request was read. A hook
+// already set on h is kept and called after the entry is written.
+func (l *AccessLog) Attach(h *BaseServerHandler) {
+	onAudit := h.OnAudit
+
+	h.OnAudit = func(ctx context.Context, rec *AuditRecord) {
+		l.Write(NewAccessLogEntry(rec))
+		if onAudit != nil {
+			onAudit(ctx, rec)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package socks5_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func TestAccessLog_Connect(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	var lines, jsonLines syncBuffer
+	audited := make(chan struct{}, 1)
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			return nil
+		},
+		// runs after both entries are written
+		OnAudit: func(ctx context.Context, rec *socks5.AuditRecord) { audited <- struct{}{} },
+	}
+	socks5.NewJSONAccessLog(&jsonLines).Attach(handler)
+	socks5.NewAccessLog(&lines).Attach(handler)
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dialer := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "alice", Password: "s3cret"}, nil)
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	payload := genRandom(1000)
+	conn.Write(payload)
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case <-audited:
+	case <-ctx.Done():
+		t.Fatal("connection was not audited")
+	}
+
+	line := lines.String()
+	re := regexp.MustCompile(`^(\S+) - (\S+) \[([^\]]+)\] "(\S+) (\S+)" (\S+) (\d+) (\d+) (\d+)\n$`)
+	m := re.FindStringSubmatch(line)
+	if m == nil {
+		t.Fatalf("malformed access log line: %q", line)
+	}
+	if _, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[3]); err != nil {
+		t.Errorf("malformed time %q: %v", m[3], err)
+	}
+	want := []string{"alice", "CONNECT", echoLn.Addr().String(), "SUCCESS", "1000", "1000"}
+	if got := []string{m[2], m[4], m[5], m[6], m[7], m[8]}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("line fields = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(m[1], "127.0.0.1:") {
+		t.Errorf("client = %q, want the loopback address", m[1])
+	}
+
+	var e socks5.AccessLogEntry
+	if err := json.Unmarshal([]byte(jsonLines.String()), &e); err != nil {
+		t.Fatalf("malformed JSON access log line %q: %v", jsonLines.String(), err)
+	}
+	if e.User != "alice" || e.Command != "CONNECT" || e.Result != "SUCCESS" || e.BytesRx != 1000 || e.BytesTx != 1000 || e.Duration <= 0 {
+		t.Errorf("unexpected JSON entry: %+v", e)
+	}
+}
+
+func TestAccessLogEntry_String(t *testing.T) {
+	e := &socks5.AccessLogEntry{
+		Time:     time.Date(2026, time.October, 17, 10, 4, 5, 0, time.UTC),
+		Client:   "192.0.2.1:50312",
+		User:     `bob "b" smith`,
+		Duration: 1500 * time.Millisecond,
+	}
+
+	// no request: empty fields are "-", unsafe ones quoted
+	want := `192.0.2.1:50312 - "bob \"b\" smith" [17/Oct/2026:10:04:05 +0000] "-" - 0 0 1500`
+	if got := e.String(); got != want {
+		t.Errorf("String() = %s\nwant       %s", got, want)
+	}
+}
@@ -0,0 +1,362 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"github.com/33TU/socks/internal"
+)
+
+// ValidateDomainName checks that name is a DNS host name as RFC 1035 defines it:
+// at most 253 bytes plus an optional trailing dot, made of labels of 1-63 letters,
+// digits and hyphens. allowUnderscore also accepts '_' in labels: host names
+// exclude it, but some names in real use contain it. Unicode names must be
+// converted to their ASCII form first, e.g. with Dialer.IDNA.
+func ValidateDomainName(name string, allowUnderscore bool) error {
+	if !internal.ValidDomainName(name, allowUnderscore) {
+		return ErrInvalidDomain
+	}
+	return nil
+}
+
+// Addr represents a SOCKS5 address (ATYP, ADDR and PORT) as carried by
+// requests, replies and UDP packets.
+type Addr struct {
+	AddrType byte   // ATYP; address type (IPv4, DOMAIN, IPv6)
+	IP       net.IP // ADDR; IP address (if ATYP=IPv4 or IPv6)
+	Domain   string // ADDR; domain name (if ATYP=DOMAIN)
+	Port     uint16 // PORT; port number (big-endian)
+}
+
+// Init initializes a SOCKS5 address.
+func (a *Addr) Init(addrType byte, ip net.IP, domain string, port uint16) {
+	a.AddrType = addrType
+	a.IP = ip
+	a.Domain = domain
+	a.Port = port
+}
+
+// GetHost returns the hostname or IP string.
+func (a *Addr) GetHost() string {
+	if a.AddrType == AddrTypeDomain {
+		return a.Domain
+	}
+	return a.IP.String()
+}
+
+// AddrPort returns the IP address and port, or the zero AddrPort if ATYP is not IPv4 or IPv6.
+// IPv4 addresses are returned in their 4-byte form. No allocation is made.
+func (a *Addr) AddrPort() netip.AddrPort {
+	ip, ok := netip.AddrFromSlice(a.IP)
+	if !ok {
+		return netip.AddrPort{}
+	}
+
+	switch a.AddrType {
+	case AddrTypeIPv4:
+		if ip = ip.Unmap(); !ip.Is4() {
+			return netip.AddrPort{}
+		}
+	case AddrTypeIPv6:
+	default:
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(ip, a.Port)
+}
+
+// SetAddrPort sets the address from ap, choosing ATYP automatically.
+// IPv4-mapped IPv6 addresses are stored as AddrTypeIPv4. An invalid ap sets 0.0.0.0.
+func (a *Addr) SetAddrPort(ap netip.AddrPort) {
+	a.AddrType, a.IP = addrFromNetip(ap.Addr())
+	a.Domain = ""
+	a.Port = ap.Port()
+}
+
+// DomainPort returns the domain and port if ATYP is DOMAIN.
+func (a *Addr) DomainPort() (domain string, port uint16, ok bool) {
+	if a.AddrType != AddrTypeDomain {
+		return "", 0, false
+	}
+	return a.Domain, a.Port, true
+}
+
+// addrFromNetip converts ip to an address type and net.IP.
+func addrFromNetip(ip netip.Addr) (byte, net.IP) {
+	if !ip.IsValid() {
+		return AddrTypeIPv4, net.IPv4zero.To4()
+	}
+
+	ip = ip.Unmap()
+	if ip.Is4() {
+		b := ip.As4()
+		return AddrTypeIPv4, net.IP(b[:])
+	}
+
+	b := ip.As16()
+	return AddrTypeIPv6, net.IP(b[:])
+}
+
+// Equal reports whether Addr a and other name the same host and port.
+// IPs are compared with net.IP.Equal, so the 4- and 16-byte forms of an IPv4
+// address are equal.
+func (a *Addr) Equal(other *Addr) bool {
+	if a == nil || other == nil {
+		return a == other
+	}
+	return a.AddrType == other.AddrType &&
+		a.IP.Equal(other.IP) &&
+		a.Domain == other.Domain &&
+		a.Port == other.Port
+}
+
+// Clone returns a deep copy of a.
+func (a *Addr) Clone() *Addr {
+	if a == nil {
+		return nil
+	}
+	c := *a
+	c.IP = slices.Clone(a.IP)
+	return &c
+}
+
+// String returns the "host:port" form of the address.
+func (a *Addr) String() string {
+	return net.JoinHostPort(a.GetHost(), strconv.Itoa(int(a.Port)))
+}
+
+// Network returns "socks", so that Addr implements net.Addr.
+func (a *Addr) Network() string {
+	return "socks"
+}
+
+// ValidateType checks that ATYP is a known address type.
+func (a *Addr) ValidateType() error {
+	switch a.AddrType {
+	case AddrTypeIPv4, AddrTypeDomain, AddrTypeIPv6:
+		return nil
+	default:
+		return ErrInvalidAddr
+	}
+}
+
+// Validate checks that the address is encodable: IPv4 addresses must have a
+// 4-byte form (so 16-byte IPv4-mapped slices are accepted), IPv6 addresses
+// must be 16 bytes and domains must pass ValidateDomainName.
+func (a *Addr) Validate() error {
+	return a.validate(false)
+}
+
+// validate is Validate, accepting '_' in domain labels if allowUnderscore is set.
+func (a *Addr) validate(allowUnderscore bool) error {
+	switch a.AddrType {
+	case AddrTypeDomain:
+		if err := ValidateDomainName(a.Domain, allowUnderscore); err != nil {
+			return err
+		}
+	case AddrTypeIPv4:
+		if a.IP.To4() == nil {
+			return ErrInvalidAddr
+		}
+	case AddrTypeIPv6:
+		if len(a.IP) != net.IPv6len {
+			return ErrInvalidAddr
+		}
+	default:
+		return ErrInvalidAddr
+	}
+	return nil
+}
+
+// Size returns the encoded length of the address including ATYP and PORT.
+func (a *Addr) Size() int {
+	switch a.AddrType {
+	case AddrTypeIPv4:
+		return 1 + 4 + 2
+	case AddrTypeIPv6:
+		return 1 + 16 + 2
+	case AddrTypeDomain:
+		return 1 + 1 + len(a.Domain) + 2
+	default:
+		return 1 + 2
+	}
+}
+
+// AppendTo appends the wire encoding of the address (ATYP, ADDR, PORT) to dst.
+func (a *Addr) AppendTo(dst []byte) ([]byte, error) {
+	if err := a.Validate(); err != nil {
+		return dst, err
+	}
+
+	return a.appendTo(dst), nil
+}
+
+// appendTo appends ATYP, ADDR and PORT to dst. The address must be valid.
+func (a *Addr) appendTo(dst []byte) []byte {
+	return a.appendBody(append(dst, a.AddrType))
+}
+
+// appendBody appends ADDR and PORT to dst. The address must be valid.
+func (a *Addr) appendBody(dst []byte) []byte {
+	switch a.AddrType {
+	case AddrTypeIPv4:
+		dst = append(dst, a.IP.To4()...)
+	case AddrTypeIPv6:
+		dst = append(dst, a.IP.To16()...)
+	case AddrTypeDomain:
+		dst = append(dst, byte(len(a.Domain)))
+		dst = append(dst, a.Domain...)
+	}
+	return binary.BigEndian.AppendUint16(dst, a.Port)
+}
+
+// WriteTo writes the address (ATYP, ADDR, PORT) to a Writer.
+// Implements io.WriterTo.
+func (a *Addr) WriteTo(dst io.Writer) (int64, error) {
+	var bufArr [1 + 1 + 255 + 2]byte
+
+	buf, err := a.AppendTo(bufArr[:0])
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := dst.Write(buf)
+	return int64(n), err
+}
+
+// ReadFrom reads an address (ATYP, ADDR, PORT) from a Reader.
+// Implements io.ReaderFrom.
+func (a *Addr) ReadFrom(src io.Reader) (int64, error) {
+	atyp, err := internal.ReadN(src, 1)
+	if err != nil {
+		return int64(len(atyp)), parseError(msgAddr, "ATYP", int64(len(atyp)), err)
+	}
+
+	a.AddrType = atyp[0]
+	if err := a.ValidateType(); err != nil {
+		return 1, parseError(msgAddr, "ATYP", 1, err)
+	}
+
+	n, err := a.readBody(src)
+	total := 1 + n
+	return total, parseError(msgAddr, "ADDR", total, err)
+}
+
+// readBody reads ADDR and PORT for the already-set ATYP.
+// It always follows part of a message, so EOF is reported as io.ErrUnexpectedEOF.
+func (a *Addr) readBody(src io.Reader) (int64, error) {
+	switch a.AddrType {
+	case AddrTypeIPv4, AddrTypeIPv6:
+		ipLen := 4
+		if a.AddrType == AddrTypeIPv6 {
+			ipLen = 16
+		}
+
+		b, err := internal.ReadN(src, ipLen+2)
+		if err != nil {
+			return int64(len(b)), internal.UnexpectedEOF(err)
+		}
+
+		a.IP = net.IP(internal.ReuseBytes(a.IP, ipLen))
+		copy(a.IP, b[:ipLen])
+		a.Domain = ""
+		a.Port = binary.BigEndian.Uint16(b[ipLen:])
+		return int64(len(b)), nil
+
+	case AddrTypeDomain:
+		b, err := internal.ReadN(src, 1)
+		if err != nil {
+			return int64(len(b)), internal.UnexpectedEOF(err)
+		}
+		dlen := int(b[0])
+		if dlen == 0 {
+			return 1, ErrInvalidDomain
+		}
+
+		b, err = internal.ReadN(src, dlen+2)
+		if err != nil {
+			return 1 + int64(len(b)), internal.UnexpectedEOF(err)
+		}
+
+		a.IP = nil
+		a.setDomain(b[:dlen])
+		a.Port = binary.BigEndian.Uint16(b[dlen:])
+		return 1 + int64(len(b)), nil
+
+	default:
+		return 0, ErrInvalidAddr
+	}
+}
+
+// setDomain sets Domain to b, keeping the current string if it already holds
+// b so that repeated reads of the same name do not allocate.
+func (a *Addr) setDomain(b []byte) {
+	if string(b) != a.Domain {
+		a.Domain = string(b)
+	}
+}
+
+// UnmarshalFrom parses an address (ATYP, ADDR, PORT) from b and returns the number of bytes consumed.
+// IP is a sub-slice of b.
+func (a *Addr) UnmarshalFrom(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	a.AddrType = b[0]
+	if err := a.ValidateType(); err != nil {
+		return 0, err
+	}
+
+	n, err := a.unmarshalBody(b[1:])
+	if err != nil {
+		return 0, err
+	}
+	return 1 + n, nil
+}
+
+// unmarshalBody parses ADDR and PORT for the already-set ATYP.
+func (a *Addr) unmarshalBody(b []byte) (int, error) {
+	i := 0
+
+	switch a.AddrType {
+	case AddrTypeIPv4, AddrTypeIPv6:
+		ipLen := 4
+		if a.AddrType == AddrTypeIPv6 {
+			ipLen = 16
+		}
+		if len(b) < ipLen {
+			return 0, io.ErrUnexpectedEOF
+		}
+		a.IP = net.IP(b[:ipLen])
+		a.Domain = ""
+		i += ipLen
+
+	case AddrTypeDomain:
+		if len(b) < 1 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		dlen := int(b[0])
+		if dlen == 0 {
+			return 0, ErrInvalidDomain
+		}
+		if len(b) < 1+dlen {
+			return 0, io.ErrUnexpectedEOF
+		}
+		a.IP = nil
+		a.setDomain(b[1 : 1+dlen])
+		i += 1 + dlen
+
+	default:
+		return 0, ErrInvalidAddr
+	}
+
+	if len(b) < i+2 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	a.Port = binary.BigEndian.Uint16(b[i:])
+	return i + 2, nil
+}
@@ -0,0 +1,260 @@
+package socks5_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func Test_Addr_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		addr socks5.Addr
+		wire []byte
+	}{
+		{
+			name: "IPv4",
+			addr: socks5.Addr{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(192, 168, 1, 1), Port: 8080},
+			wire: []byte{0x01, 192, 168, 1, 1, 0x1F, 0x90},
+		},
+		{
+			name: "IPv6",
+			addr: socks5.Addr{AddrType: socks5.AddrTypeIPv6, IP: net.ParseIP("2001:db8::1"), Port: 443},
+			wire: append(append([]byte{0x04}, net.ParseIP("2001:db8::1")...), 0x01, 0xBB),
+		},
+		{
+			name: "domain",
+			addr: socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 80},
+			wire: append(append([]byte{0x03, 11}, "example.com"...), 0x00, 0x50),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// AppendTo
+			got, err := tt.addr.AppendTo(nil)
+			if err != nil {
+				t.Fatalf("AppendTo() error: %v", err)
+			}
+			if !bytes.Equal(got, tt.wire) {
+				t.Fatalf("AppendTo() = %x, want %x", got, tt.wire)
+			}
+			if tt.addr.Size() != len(tt.wire) {
+				t.Errorf("Size() = %d, want %d", tt.addr.Size(), len(tt.wire))
+			}
+
+			// WriteTo
+			var buf bytes.Buffer
+			n, err := tt.addr.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("WriteTo() error: %v", err)
+			}
+			if int(n) != len(tt.wire) || !bytes.Equal(buf.Bytes(), tt.wire) {
+				t.Fatalf("WriteTo() wrote %x, want %x", buf.Bytes(), tt.wire)
+			}
+
+			// ReadFrom
+			var rd socks5.Addr
+			n, err = rd.ReadFrom(bytes.NewReader(tt.wire))
+			if err != nil {
+				t.Fatalf("ReadFrom() error: %v", err)
+			}
+			if int(n) != len(tt.wire) {
+				t.Errorf("ReadFrom() read %d bytes, want %d", n, len(tt.wire))
+			}
+			if rd.String() != tt.addr.String() {
+				t.Errorf("ReadFrom() = %s, want %s", rd.String(), tt.addr.String())
+			}
+
+			// UnmarshalFrom
+			var um socks5.Addr
+			m, err := um.UnmarshalFrom(tt.wire)
+			if err != nil {
+				t.Fatalf("UnmarshalFrom() error: %v", err)
+			}
+			if m != len(tt.wire) {
+				t.Errorf("UnmarshalFrom() consumed %d bytes, want %d", m, len(tt.wire))
+			}
+			if um.String() != tt.addr.String() {
+				t.Errorf("UnmarshalFrom() = %s, want %s", um.String(), tt.addr.String())
+			}
+		})
+	}
+}
+
+func Test_Addr_Encode_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    socks5.Addr
+		wantErr error
+	}{
+		{"empty domain", socks5.Addr{AddrType: socks5.AddrTypeDomain, Port: 80}, socks5.ErrInvalidDomain},
+		{"domain too long", socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: strings.Repeat("a", 256), Port: 80}, socks5.ErrInvalidDomain},
+		{"domain label too long", socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: strings.Repeat("a", 64) + ".com", Port: 80}, socks5.ErrInvalidDomain},
+		{"domain with NUL", socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: "exa\x00mple.com", Port: 80}, socks5.ErrInvalidDomain},
+		{"domain with space", socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: "ex ample.com", Port: 80}, socks5.ErrInvalidDomain},
+		{"unicode domain", socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: "bücher.example", Port: 80}, socks5.ErrInvalidDomain},
+		{"domain with underscore", socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: "my_host.local", Port: 80}, socks5.ErrInvalidDomain},
+		{"IPv4 type with IPv6 address", socks5.Addr{AddrType: socks5.AddrTypeIPv4, IP: net.ParseIP("2001:db8::1"), Port: 80}, socks5.ErrInvalidAddr},
+		{"IPv4 type with nil IP", socks5.Addr{AddrType: socks5.AddrTypeIPv4, Port: 80}, socks5.ErrInvalidAddr},
+		{"IPv6 type with nil IP", socks5.Addr{AddrType: socks5.AddrTypeIPv6, Port: 80}, socks5.ErrInvalidAddr},
+		{"unknown type", socks5.Addr{AddrType: 0x99, IP: net.IPv4(127, 0, 0, 1), Port: 80}, socks5.ErrInvalidAddr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.addr.AppendTo(nil); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AppendTo() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var buf bytes.Buffer
+			if _, err := tt.addr.WriteTo(&buf); !errors.Is(err, tt.wantErr) {
+				t.Errorf("WriteTo() error = %v, want %v", err, tt.wantErr)
+			}
+			if buf.Len() != 0 {
+				t.Errorf("WriteTo() wrote %d bytes on error", buf.Len())
+			}
+		})
+	}
+}
+
+func Test_ValidateDomainName_Underscore(t *testing.T) {
+	if err := socks5.ValidateDomainName("_dmarc.example.com", false); !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Errorf("expected ErrInvalidDomain without allowUnderscore, got %v", err)
+	}
+	if err := socks5.ValidateDomainName("_dmarc.example.com", true); err != nil {
+		t.Errorf("expected underscore to be allowed, got %v", err)
+	}
+	if err := socks5.ValidateDomainName("ex ample.com", true); !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Errorf("expected ErrInvalidDomain, got %v", err)
+	}
+}
+
+func Test_Addr_Decode_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		wire    []byte
+		wantErr error
+	}{
+		{"zero-length domain", []byte{0x03, 0x00, 0x00, 0x50}, socks5.ErrInvalidDomain},
+		{"unknown type", []byte{0x99, 0x00, 0x50}, socks5.ErrInvalidAddr},
+		{"truncated IPv4", []byte{0x01, 127, 0, 0}, io.ErrUnexpectedEOF},
+		{"truncated IPv6", []byte{0x04, 0x20, 0x01}, io.ErrUnexpectedEOF},
+		{"truncated domain", []byte{0x03, 0x05, 'a', 'b'}, io.ErrUnexpectedEOF},
+		{"truncated port", []byte{0x01, 127, 0, 0, 1, 0x00}, io.ErrUnexpectedEOF},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var a socks5.Addr
+			if _, err := a.UnmarshalFrom(tt.wire); !errors.Is(err, tt.wantErr) {
+				t.Errorf("UnmarshalFrom() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var r socks5.Addr
+			if _, err := r.ReadFrom(bytes.NewReader(tt.wire)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadFrom() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_Request_WriteTo_IPv4TypeWithIPv6Address(t *testing.T) {
+	var req socks5.Request
+	req.Init(socks5.SocksVersion, socks5.CmdConnect, 0, socks5.AddrTypeIPv4, net.ParseIP("2001:db8::1"), "", 80)
+
+	var buf bytes.Buffer
+	if _, err := req.WriteTo(&buf); !errors.Is(err, socks5.ErrInvalidAddr) {
+		t.Fatalf("expected ErrInvalidAddr, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing written, got %x", buf.Bytes())
+	}
+}
+
+func Test_SetAddrPort_NormalizesIPv4Mapped(t *testing.T) {
+	ap := netip.MustParseAddrPort("[::ffff:192.0.2.1]:1080")
+
+	var req socks5.Request
+	req.Init(socks5.SocksVersion, socks5.CmdConnect, 0, socks5.AddrTypeDomain, nil, "example.com", 80)
+	req.SetAddrPort(ap)
+
+	if req.AddrType != socks5.AddrTypeIPv4 {
+		t.Fatalf("AddrType = %d, want AddrTypeIPv4", req.AddrType)
+	}
+	if len(req.IP) != 4 || !req.IP.Equal(net.IPv4(192, 0, 2, 1)) || req.Port != 1080 {
+		t.Fatalf("fields = %v:%d, want 4-byte 192.0.2.1:1080", req.IP, req.Port)
+	}
+	if req.Domain != "" {
+		t.Fatalf("Domain not cleared: %q", req.Domain)
+	}
+	if got := req.AddrPort(); got != netip.MustParseAddrPort("192.0.2.1:1080") {
+		t.Fatalf("AddrPort() = %v, want 192.0.2.1:1080", got)
+	}
+
+	// wire form uses the IPv4 encoding
+	var buf bytes.Buffer
+	if _, err := req.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	if want := []byte{5, 1, 0, 1, 192, 0, 2, 1, 0x04, 0x38}; !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("WriteTo() = %x, want %x", buf.Bytes(), want)
+	}
+
+	// IPv6 stays IPv6
+	var reply socks5.Reply
+	reply.SetAddrPort(netip.MustParseAddrPort("[2001:db8::1]:443"))
+	if reply.AddrType != socks5.AddrTypeIPv6 || reply.AddrPort() != netip.MustParseAddrPort("[2001:db8::1]:443") {
+		t.Fatalf("unexpected IPv6 result: type=%d addr=%v", reply.AddrType, reply.AddrPort())
+	}
+}
+
+func Test_AddrPort_Views(t *testing.T) {
+	// net.IPv4 returns a 16-byte slice; AddrPort reports the 4-byte form
+	var pkt socks5.UDPPacket
+	pkt.Init([2]byte{}, 0, socks5.AddrTypeIPv4, net.IPv4(10, 0, 0, 1), "", 53, []byte("x"))
+	if got := pkt.AddrPort(); got != netip.MustParseAddrPort("10.0.0.1:53") || !got.Addr().Is4() {
+		t.Fatalf("AddrPort() = %v, want 10.0.0.1:53", got)
+	}
+	if _, _, ok := pkt.DomainPort(); ok {
+		t.Fatal("DomainPort() ok for IPv4 packet")
+	}
+
+	// domain addresses have no AddrPort view
+	pkt.Init([2]byte{}, 0, socks5.AddrTypeDomain, nil, "example.com", 53, []byte("x"))
+	if pkt.AddrPort().IsValid() {
+		t.Fatalf("AddrPort() = %v for domain packet", pkt.AddrPort())
+	}
+	if d, p, ok := pkt.DomainPort(); !ok || d != "example.com" || p != 53 {
+		t.Fatalf("DomainPort() = %q, %d, %v", d, p, ok)
+	}
+
+	// IPv4 type carrying an IPv6 address is inconsistent
+	pkt.Init([2]byte{}, 0, socks5.AddrTypeIPv4, net.ParseIP("2001:db8::1"), "", 53, []byte("x"))
+	if pkt.AddrPort().IsValid() {
+		t.Fatalf("AddrPort() = %v for IPv4 type with IPv6 address", pkt.AddrPort())
+	}
+}
+
+func Test_UDPPacket_AddrPort_NoAlloc(t *testing.T) {
+	raw := []byte{0, 0, 0, socks5.AddrTypeIPv4, 127, 0, 0, 1, 0x1F, 0x90, 'h', 'i'}
+
+	var pkt socks5.UDPPacket
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := pkt.UnmarshalFrom(raw); err != nil {
+			t.Fatal(err)
+		}
+		if pkt.AddrPort().Port() != 8080 {
+			t.Fatal("unexpected port")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("UnmarshalFrom+AddrPort allocated %v times, want 0", allocs)
+	}
+}
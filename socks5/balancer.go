@@ -0,0 +1,170 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Latency balancer defaults.
+const (
+	DefaultLatencyAlpha = 0.2 // Weight of the newest sample in the moving average
+
+	// failureLatency is the sample recorded for a dial that failed without a
+	// reply from the proxy, so an unreachable proxy sorts behind working ones.
+	failureLatency = 30 * time.Second
+)
+
+// LatencyBalancer errors.
+var (
+	// ErrNoDialers is returned by LatencyBalancer.DialContext when it has no Dialers.
+	ErrNoDialers = errors.New("latency balancer has no dialers")
+
+	// ErrNoProbeTarget is returned by LatencyBalancer.Run when ProbeInterval or
+	// ProbeTarget is not set.
+	ErrNoProbeTarget = errors.New("latency balancer probing needs ProbeInterval and ProbeTarget")
+)
+
+// LatencyBalancer dials through whichever of its Dialers has the lowest
+// exponentially weighted moving average (EWMA) of dial latency, the time
+// DialContext takes to return a connection through that proxy. Proxies not
+// yet measured are tried first.
+//
+// A proxy is only measured when it is used, so one that was slow once keeps
+// losing to the others. Run probes every proxy periodically so that it can
+// recover. A LatencyBalancer is safe for concurrent use.
+type LatencyBalancer struct {
+	Dialers []*Dialer
+
+	Alpha         float64       // Weight of the newest sample, in (0, 1] (0=DefaultLatencyAlpha)
+	ProbeInterval time.Duration // Time between probes of each proxy by Run
+	ProbeTarget   string        // Address probes CONNECT to, e.g. a nearby fast host
+
+	mu   sync.Mutex
+	ewma []time.Duration // per dialer (0=not measured)
+}
+
+// NewLatencyBalancer returns a LatencyBalancer over dialers.
+func NewLatencyBalancer(dialers ...*Dialer) *LatencyBalancer {
+	return &LatencyBalancer{Dialers: dialers}
+}
+
+// DialContext connects to address through the proxy with the lowest average
+// latency and records how long the dial took.
+func (b *LatencyBalancer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	i := b.pick()
+	if i < 0 {
+		return nil, ErrNoDialers
+	}
+	return b.dial(ctx, i, network, address)
+}
+
+// Latencies returns the current average latency of each Dialer, in order
+// (0=not measured).
+func (b *LatencyBalancer) Latencies() []time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	lat := make([]time.Duration, len(b.Dialers))
+	copy(lat, b.ewma)
+	return lat
+}
+
+// Run probes every proxy with a CONNECT to ProbeTarget once per ProbeInterval
+// until ctx ends, and returns ctx's error. Probe connections are closed as
+// soon as they are made.
+func (b *LatencyBalancer) Run(ctx context.Context) error {
+	if b.ProbeInterval <= 0 || b.ProbeTarget == "" {
+		return ErrNoProbeTarget
+	}
+
+	t := time.NewTicker(b.ProbeInterval)
+	defer t.Stop()
+
+	for {
+		b.probe(ctx)
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// probe dials ProbeTarget through every proxy concurrently.
+func (b *LatencyBalancer) probe(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, b.ProbeInterval)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range b.Dialers {
+		wg.Go(func() {
+			if conn, err := b.dial(ctx, i, "tcp", b.ProbeTarget); err == nil {
+				conn.Close()
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// dial connects through Dialers[i] and records the latency sample.
+func (b *LatencyBalancer) dial(ctx context.Context, i int, network, address string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := b.Dialers[i].DialContext(ctx, network, address)
+	sample := time.Since(start)
+
+	// A reply code means the proxy answered; anything else counts as a failure.
+	var code ReplyCode
+	if err != nil && !errors.As(err, &code) {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, err // abandoned by the caller, not the proxy's fault
+		}
+		sample = failureLatency
+	}
+
+	b.observe(i, sample)
+	return conn, err
+}
+
+// pick returns the index of the Dialer with the lowest average latency, or -1
+// if there are none.
+func (b *LatencyBalancer) pick() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	best := -1
+	for i := range b.Dialers {
+		if i >= len(b.ewma) || b.ewma[i] == 0 {
+			return i
+		}
+		if best < 0 || b.ewma[i] < b.ewma[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// observe folds sample into the average latency of Dialers[i].
+func (b *LatencyBalancer) observe(i int, sample time.Duration) {
+	sample = max(sample, 1) // keep 0 for "not measured"
+
+	alpha := b.Alpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultLatencyAlpha
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.ewma) < len(b.Dialers) {
+		b.ewma = append(b.ewma, make([]time.Duration, len(b.Dialers)-len(b.ewma))...)
+	}
+	if prev := b.ewma[i]; prev == 0 {
+		b.ewma[i] = sample
+	} else {
+		b.ewma[i] = prev + time.Duration(alpha*float64(sample-prev))
+	}
+}
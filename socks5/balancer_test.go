@@ -0,0 +1,140 @@
+package socks5_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// delayedProxy accepts any CONNECT after delaying the method selection by
+// delay, counting the connections it serves.
+func delayedProxy(t *testing.T, delay time.Duration, served *atomic.Int32) (string, func()) {
+	return startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+		served.Add(1)
+
+		var hsReq socks5.HandshakeRequest
+		if _, err := hsReq.ReadFrom(c); err != nil {
+			return
+		}
+		time.Sleep(delay)
+		hsReply := socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}
+		if _, err := hsReply.WriteTo(c); err != nil {
+			return
+		}
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}).WriteTo(c)
+		io.Copy(io.Discard, c)
+	})
+}
+
+func TestLatencyBalancer_PrefersFasterProxy(t *testing.T) {
+	var fastServed, slowServed atomic.Int32
+	slowAddr, stopSlow := delayedProxy(t, 100*time.Millisecond, &slowServed)
+	defer stopSlow()
+	fastAddr, stopFast := delayedProxy(t, 10*time.Millisecond, &fastServed)
+	defer stopFast()
+
+	b := socks5.NewLatencyBalancer(
+		socks5.NewDialer(slowAddr, nil, nil),
+		socks5.NewDialer(fastAddr, nil, nil),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for range 100 {
+		conn, err := b.DialContext(ctx, "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("DialContext failed: %v", err)
+		}
+		conn.Close()
+	}
+
+	if fast := fastServed.Load(); fast <= 80 {
+		t.Fatalf("fast proxy served %d of 100 dials (slow %d), want more than 80", fast, slowServed.Load())
+	}
+	if lat := b.Latencies(); lat[1] >= lat[0] {
+		t.Fatalf("Latencies = %v, want the fast proxy lower", lat)
+	}
+}
+
+func TestLatencyBalancer_Run(t *testing.T) {
+	var fastServed, slowServed atomic.Int32
+	slowAddr, stopSlow := delayedProxy(t, 50*time.Millisecond, &slowServed)
+	defer stopSlow()
+	fastAddr, stopFast := delayedProxy(t, 0, &fastServed)
+	defer stopFast()
+
+	b := socks5.NewLatencyBalancer(
+		socks5.NewDialer(slowAddr, nil, nil),
+		socks5.NewDialer(fastAddr, nil, nil),
+	)
+	if err := b.Run(context.Background()); !errors.Is(err, socks5.ErrNoProbeTarget) {
+		t.Fatalf("Run without ProbeTarget = %v, want ErrNoProbeTarget", err)
+	}
+
+	b.ProbeInterval = 200 * time.Millisecond
+	b.ProbeTarget = "example.com:80"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	if err := b.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run = %v, want context.DeadlineExceeded", err)
+	}
+
+	// both proxies were probed without any DialContext call
+	if fastServed.Load() < 2 || slowServed.Load() < 2 {
+		t.Fatalf("proxies probed fast=%d slow=%d times, want at least 2 each", fastServed.Load(), slowServed.Load())
+	}
+	if lat := b.Latencies(); lat[0] == 0 || lat[1] == 0 || lat[1] >= lat[0] {
+		t.Fatalf("Latencies = %v, want both measured and the fast proxy lower", lat)
+	}
+}
+
+func TestLatencyBalancer_UnreachableProxy(t *testing.T) {
+	var served atomic.Int32
+	proxyAddr, stop := delayedProxy(t, 0, &served)
+	defer stop()
+
+	// nothing listens on the first proxy's address
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	deadAddr := ln.Addr().String()
+	ln.Close()
+
+	b := socks5.NewLatencyBalancer(
+		socks5.NewDialer(deadAddr, nil, nil),
+		socks5.NewDialer(proxyAddr, nil, nil),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := b.DialContext(ctx, "tcp", "example.com:80"); err == nil {
+		t.Fatal("expected the dial through the unreachable proxy to fail")
+	}
+	for range 5 {
+		conn, err := b.DialContext(ctx, "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("DialContext failed after the unreachable proxy was measured: %v", err)
+		}
+		conn.Close()
+	}
+	if served.Load() != 5 {
+		t.Fatalf("working proxy served %d dials, want 5", served.Load())
+	}
+}
@@ -0,0 +1,171 @@
+package socks5_test
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// binaryMessage is a message with both the streaming and the binary codec.
+type binaryMessage interface {
+	io.ReaderFrom
+	io.WriterTo
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+func binaryMessages() []struct {
+	name string
+	msg  binaryMessage
+	zero func() binaryMessage
+} {
+	return []struct {
+		name string
+		msg  binaryMessage
+		zero func() binaryMessage
+	}{
+		{"Request IPv4", &socks5.Request{Version: 5, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1).To4(), Port: 80},
+			func() binaryMessage { return &socks5.Request{} }},
+		{"Request domain", &socks5.Request{Version: 5, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 443},
+			func() binaryMessage { return &socks5.Request{} }},
+		{"Request IPv6", &socks5.Request{Version: 5, Command: socks5.CmdBind, AddrType: socks5.AddrTypeIPv6, IP: net.ParseIP("2001:db8::1"), Port: 8080},
+			func() binaryMessage { return &socks5.Request{} }},
+		{"Reply", socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}),
+			func() binaryMessage { return &socks5.Reply{} }},
+		{"HandshakeRequest", &socks5.HandshakeRequest{Version: 5, NMethods: 2, Methods: []byte{socks5.MethodNoAuth, socks5.MethodUserPass}},
+			func() binaryMessage { return &socks5.HandshakeRequest{} }},
+		{"HandshakeReply", &socks5.HandshakeReply{Version: 5, Method: socks5.MethodUserPass},
+			func() binaryMessage { return &socks5.HandshakeReply{} }},
+		{"UserPassRequest", &socks5.UserPassRequest{Version: 1, Username: "user", Password: "pass"},
+			func() binaryMessage { return &socks5.UserPassRequest{} }},
+		{"UserPassReply", &socks5.UserPassReply{Version: 1, Status: 0},
+			func() binaryMessage { return &socks5.UserPassReply{} }},
+		{"GSSAPIRequest", &socks5.GSSAPIRequest{Version: 1, MsgType: socks5.GSSAPITypeInit, Token: []byte("token")},
+			func() binaryMessage { return &socks5.GSSAPIRequest{} }},
+		{"GSSAPIReply", &socks5.GSSAPIReply{Version: 1, MsgType: socks5.GSSAPITypeReply, Token: []byte("token")},
+			func() binaryMessage { return &socks5.GSSAPIReply{} }},
+		{"GSSAPIEncapsulation", &socks5.GSSAPIEncapsulation{Version: 1, MsgType: socks5.GSSAPITypeEncapsulated, Token: []byte("wrapped")},
+			func() binaryMessage { return &socks5.GSSAPIEncapsulation{} }},
+	}
+}
+
+func Test_MarshalBinary_MatchesWriteTo(t *testing.T) {
+	for _, tt := range binaryMessages() {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if _, err := tt.msg.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+
+			b, err := tt.msg.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary failed: %v", err)
+			}
+			if !bytes.Equal(b, buf.Bytes()) {
+				t.Fatalf("MarshalBinary = %x, WriteTo = %x", b, buf.Bytes())
+			}
+
+			// decoding with either API yields the same message
+			viaReader := tt.zero()
+			if _, err := viaReader.ReadFrom(bytes.NewReader(b)); err != nil {
+				t.Fatalf("ReadFrom failed: %v", err)
+			}
+			viaBinary := tt.zero()
+			if err := viaBinary.UnmarshalBinary(b); err != nil {
+				t.Fatalf("UnmarshalBinary failed: %v", err)
+			}
+			b1, _ := viaReader.MarshalBinary()
+			b2, _ := viaBinary.MarshalBinary()
+			if !bytes.Equal(b1, b) || !bytes.Equal(b2, b) {
+				t.Fatalf("decoded messages differ: ReadFrom %x, UnmarshalBinary %x, want %x", b1, b2, b)
+			}
+		})
+	}
+}
+
+func Test_UnmarshalBinary_TrailingAndTruncated(t *testing.T) {
+	for _, tt := range binaryMessages() {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.msg.MarshalBinary()
+			if err != nil {
+				t.Fatalf("MarshalBinary failed: %v", err)
+			}
+
+			if err := tt.zero().UnmarshalBinary(append(b, 0x00)); !errors.Is(err, socks5.ErrTrailingData) {
+				t.Errorf("trailing byte: got %v, want ErrTrailingData", err)
+			}
+			if err := tt.zero().UnmarshalBinary(b[:len(b)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+			}
+			if err := tt.zero().UnmarshalBinary(nil); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("empty: got %v, want io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+}
+
+// Random input is accepted by UnmarshalBinary exactly when ReadFrom accepts it
+// and consumes all of it.
+func Test_UnmarshalBinary_AgreesWithReadFrom(t *testing.T) {
+	for _, tt := range binaryMessages() {
+		t.Run(tt.name, func(t *testing.T) {
+			valid, _ := tt.msg.MarshalBinary()
+
+			for i := range 500 {
+				// mutate a valid encoding so a useful share of inputs still parse
+				in := bytes.Clone(valid)
+				for _, j := range genRandom(i % 3) {
+					in[int(j)%len(in)] = j
+				}
+				if i%5 == 0 {
+					in = append(in, genRandom(i%4)...)
+				}
+
+				r := bytes.NewReader(in)
+				_, rerr := tt.zero().ReadFrom(r)
+				want := rerr == nil && r.Len() == 0
+
+				got := tt.zero().UnmarshalBinary(in) == nil
+				if got != want {
+					t.Fatalf("input %x: UnmarshalBinary ok=%v, ReadFrom ok=%v (err=%v, unread=%d)", in, got, want, rerr, r.Len())
+				}
+			}
+		})
+	}
+}
+
+func Test_UDPPacket_MarshalBinary(t *testing.T) {
+	p := socks5.UDPPacket{AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 53, Data: []byte("payload")}
+
+	b, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	var buf bytes.Buffer
+	p.WriteTo(&buf)
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Fatalf("MarshalBinary = %x, WriteTo = %x", b, buf.Bytes())
+	}
+
+	// trailing bytes are payload, and the input is not retained
+	var got socks5.UDPPacket
+	if err := got.UnmarshalBinary(append(b, '!')); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if string(got.Data) != "payload!" || got.Domain != "example.com" || got.Port != 53 {
+		t.Fatalf("unexpected packet: %v", &got)
+	}
+	clear(b)
+	if string(got.Data) != "payload!" {
+		t.Fatal("UnmarshalBinary retained the input buffer")
+	}
+
+	if err := got.UnmarshalBinary(nil); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("empty: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
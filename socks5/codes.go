@@ -0,0 +1,97 @@
+package socks5
+
+import "fmt"
+
+// ReplyCode is a SOCKS5 reply code (REP). A non-success ReplyCode can be
+// returned as an error; the Dialer reports rejected requests this way.
+type ReplyCode byte
+
+// String returns the name of the reply code, e.g. "HOST_UNREACHABLE".
+func (c ReplyCode) String() string {
+	switch c {
+	case RepSuccess:
+		return "SUCCESS"
+	case RepGeneralFailure:
+		return "GENERAL_FAILURE"
+	case RepConnectionNotAllowed:
+		return "CONNECTION_NOT_ALLOWED"
+	case RepNetworkUnreachable:
+		return "NETWORK_UNREACHABLE"
+	case RepHostUnreachable:
+		return "HOST_UNREACHABLE"
+	case RepConnectionRefused:
+		return "CONNECTION_REFUSED"
+	case RepTTLExpired:
+		return "TTL_EXPIRED"
+	case RepCommandNotSupported:
+		return "COMMAND_NOT_SUPPORTED"
+	case RepAddrTypeNotSupported:
+		return "ADDR_TYPE_NOT_SUPPORTED"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02X)", byte(c))
+	}
+}
+
+// Error implements error with a human-readable description of the code.
+func (c ReplyCode) Error() string {
+	switch c {
+	case RepSuccess:
+		return "socks5: succeeded"
+	case RepGeneralFailure:
+		return "socks5: general failure"
+	case RepConnectionNotAllowed:
+		return "socks5: connection not allowed"
+	case RepNetworkUnreachable:
+		return "socks5: network unreachable"
+	case RepHostUnreachable:
+		return "socks5: host unreachable"
+	case RepConnectionRefused:
+		return "socks5: connection refused"
+	case RepTTLExpired:
+		return "socks5: ttl expired"
+	case RepCommandNotSupported:
+		return "socks5: command not supported"
+	case RepAddrTypeNotSupported:
+		return "socks5: address type not supported"
+	default:
+		return fmt.Sprintf("socks5: unknown error (%d)", byte(c))
+	}
+}
+
+// Command is a SOCKS5 request command (CMD).
+type Command byte
+
+// String returns the name of the command, e.g. "UDP_ASSOCIATE".
+func (c Command) String() string {
+	switch c {
+	case CmdConnect:
+		return "CONNECT"
+	case CmdBind:
+		return "BIND"
+	case CmdUDPAssociate:
+		return "UDP_ASSOCIATE"
+	case CmdResolve:
+		return "RESOLVE"
+	case CmdResolvePTR:
+		return "RESOLVE_PTR"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02X)", byte(c))
+	}
+}
+
+// AddrType is a SOCKS5 address type (ATYP).
+type AddrType byte
+
+// String returns the name of the address type, e.g. "IPv4".
+func (t AddrType) String() string {
+	switch t {
+	case AddrTypeIPv4:
+		return "IPv4"
+	case AddrTypeDomain:
+		return "DOMAIN"
+	case AddrTypeIPv6:
+		return "IPv6"
+	default:
+		return fmt.Sprintf("0x%02X", byte(t))
+	}
+}
@@ -0,0 +1,56 @@
+package socks5_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func Test_ReplyCode(t *testing.T) {
+	tests := []struct {
+		code socks5.ReplyCode
+		name string
+		err  string
+	}{
+		{socks5.RepSuccess, "SUCCESS", "socks5: succeeded"},
+		{socks5.RepHostUnreachable, "HOST_UNREACHABLE", "socks5: host unreachable"},
+		{socks5.RepAddrTypeNotSupported, "ADDR_TYPE_NOT_SUPPORTED", "socks5: address type not supported"},
+		{0x42, "UNKNOWN(0x42)", "socks5: unknown error (66)"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.name {
+			t.Errorf("String() = %q, want %q", got, tt.name)
+		}
+		if got := tt.code.Error(); got != tt.err {
+			t.Errorf("Error() = %q, want %q", got, tt.err)
+		}
+	}
+
+	// codes can be wrapped and matched like sentinel errors
+	err := fmt.Errorf("dial: %w", socks5.ReplyCode(socks5.RepTTLExpired))
+	if !errors.Is(err, socks5.ReplyCode(socks5.RepTTLExpired)) {
+		t.Errorf("errors.Is failed for wrapped ReplyCode")
+	}
+}
+
+func Test_Command_AddrType_String(t *testing.T) {
+	if got := socks5.Command(socks5.CmdUDPAssociate).String(); got != "UDP_ASSOCIATE" {
+		t.Errorf("Command.String() = %q", got)
+	}
+	if got := socks5.Command(0x09).String(); got != "UNKNOWN(0x09)" {
+		t.Errorf("Command.String() = %q", got)
+	}
+	req := socks5.Request{Command: socks5.CmdResolve}
+	if got := req.CommandType().String(); got != "RESOLVE" {
+		t.Errorf("CommandType().String() = %q", got)
+	}
+	if got := socks5.AddrType(socks5.AddrTypeIPv6).String(); got != "IPv6" {
+		t.Errorf("AddrType.String() = %q", got)
+	}
+	if got := socks5.AddrType(0x02).String(); got != "0x02" {
+		t.Errorf("AddrType.String() = %q", got)
+	}
+}
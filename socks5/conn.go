@@ -0,0 +1,54 @@
+package socks5
+
+import (
+	"net"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// Conn is a connection established through a SOCKS5 proxy by Dialer.DialContext
+// or DialConnContext. It relays through the embedded net.Conn and records how
+// the tunnel was set up.
+type Conn struct {
+	net.Conn
+
+	method byte
+	bound  net.Addr
+	target string
+}
+
+// NegotiatedMethod returns the authentication method selected by the proxy,
+// e.g. MethodUserPass.
+func (c *Conn) NegotiatedMethod() byte {
+	return c.method
+}
+
+// BoundAddr returns the BND.ADDR of the proxy's reply: a *net.TCPAddr, or an
+// *Addr if the proxy replied with a domain name.
+func (c *Conn) BoundAddr() net.Addr {
+	return c.bound
+}
+
+// Target returns the address passed to DialContext.
+func (c *Conn) Target() string {
+	return c.target
+}
+
+// NetConn returns the underlying connection, which is a *GSSAPIWrappedConn if
+// GSSAPI per-message protection is in effect.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// CloseWrite closes the write side of the connection if supported.
+func (c *Conn) CloseWrite() error {
+	return socksnet.CloseWrite(c.Conn)
+}
+
+// replyBoundAddr returns the BND.ADDR of r as a net.Addr.
+func replyBoundAddr(r *Reply) net.Addr {
+	if r.AddrType == AddrTypeDomain {
+		return r.addr().Clone()
+	}
+	return replyToTCPAddr(r)
+}
@@ -0,0 +1,141 @@
+package socks5
+
+import (
+	"net"
+	"sync"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// ConnState is the lifecycle state of a connection served by ServeConn.
+//
+// A connection starts in ConnStateNew and moves forward through
+// ConnStateHandshaking, ConnStateAuthenticating (only if the selected method
+// authenticates), ConnStateProcessingRequest and ConnStateRelaying (only if the
+// request succeeds and relays data), skipping the states it never reaches. It
+// ends in ConnStateClosed, which can follow any other state.
+type ConnState int
+
+// Connection states reported to OnStateChange.
+const (
+	ConnStateNew               ConnState = iota // accepted, OnAccept not yet passed
+	ConnStateHandshaking                        // method negotiation
+	ConnStateAuthenticating                     // username/password or GSSAPI authentication
+	ConnStateProcessingRequest                  // reading and handling the request
+	ConnStateRelaying                           // success reply sent, relaying data
+	ConnStateClosed                             // connection closed; final state
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case ConnStateNew:
+		return "new"
+	case ConnStateHandshaking:
+		return "handshaking"
+	case ConnStateAuthenticating:
+		return "authenticating"
+	case ConnStateProcessingRequest:
+		return "processing-request"
+	case ConnStateRelaying:
+		return "relaying"
+	case ConnStateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// StateChangeFunc is called by ServeConn on each state transition of conn.
+// conn is the accepted connection, the same for all transitions.
+type StateChangeFunc func(conn net.Conn, from, to ConnState)
+
+// stateChangeHandler is implemented by handlers that observe connection state transitions.
+type stateChangeHandler interface {
+	GetStateChangeHook() StateChangeFunc
+}
+
+// stateChangeHook returns the handler's state change hook, or nil if it has none.
+func stateChangeHook(handler ServerHandler) StateChangeFunc {
+	if h, ok := handler.(stateChangeHandler); ok {
+		return h.GetStateChangeHook()
+	}
+	return nil
+}
+
+// connStates tracks the state of one connection. Replies may be written from
+// handler goroutines, so transitions are serialized.
+type connStates struct {
+	conn net.Conn
+	hook StateChangeFunc
+
+	mu    sync.Mutex
+	state ConnState
+}
+
+// newConnStates returns the state tracker of conn, or nil if hook is nil.
+func newConnStates(conn net.Conn, hook StateChangeFunc) *connStates {
+	if hook == nil {
+		return nil
+	}
+	return &connStates{conn: conn, hook: hook}
+}
+
+// set moves the connection to state to and reports the transition. Moving to
+// the current state, or on from ConnStateClosed, is a no-op, as is any call on
+// a nil tracker.
+func (s *connStates) set(to ConnState) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.state == to || s.state == ConnStateClosed {
+		return
+	}
+	from := s.state
+	s.state = to
+	s.hook(s.conn, from, to)
+}
+
+// stateConn wraps a client connection to move it to ConnStateRelaying once the
+// last reply of the request is written with RepSuccess.
+type stateConn struct {
+	net.Conn
+	states  *connStates
+	replies int // Replies written before relaying starts (0=the command does not relay)
+
+	writes int
+}
+
+// Write passes p through, watching the replies for the start of the relay.
+// Replies are written one per Write and not concurrently, so writes needs no locking.
+func (c *stateConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if c.writes < c.replies {
+		c.writes++
+		if c.writes == c.replies && err == nil && n >= 2 && p[1] == RepSuccess {
+			c.states.set(ConnStateRelaying)
+		}
+	}
+	return n, err
+}
+
+// CloseWrite closes the write side of the connection if supported.
+func (c *stateConn) CloseWrite() error {
+	return socksnet.CloseWrite(c.Conn)
+}
+
+// relayReplies returns the number of replies cmd sends before relaying data,
+// or 0 if it does not relay.
+func relayReplies(cmd byte) int {
+	switch cmd {
+	case CmdConnect, CmdUDPAssociate:
+		return 1
+	case CmdBind:
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,168 @@
+package socks5_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// stateRecorder records state transitions and errors of served connections in order.
+type stateRecorder struct {
+	*socks5.BaseServerHandler
+
+	mu     sync.Mutex
+	events []string
+	closed chan struct{}
+}
+
+func newStateRecorder(methods ...byte) *stateRecorder {
+	r := &stateRecorder{closed: make(chan struct{}, 1)}
+	r.BaseServerHandler = &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		SupportedMethods: methods,
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			return nil
+		},
+		OnStateChange: func(conn net.Conn, from, to socks5.ConnState) {
+			r.record(fmt.Sprintf("%s->%s", from, to))
+			if to == socks5.ConnStateClosed {
+				r.closed <- struct{}{}
+			}
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	return r
+}
+
+func (r *stateRecorder) OnError(ctx context.Context, conn net.Conn, err error) {
+	r.record("error")
+}
+
+func (r *stateRecorder) record(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+// wait returns the events of a connection once it has closed.
+func (r *stateRecorder) wait(t *testing.T) []string {
+	t.Helper()
+	select {
+	case <-r.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection did not reach ConnStateClosed")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+func TestConnState_Echo(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	tests := []struct {
+		name   string
+		method byte
+		auth   *socks5.Auth
+		want   []string
+	}{
+		{"no auth", socks5.MethodNoAuth, nil, []string{
+			"new->handshaking",
+			"handshaking->processing-request",
+			"processing-request->relaying",
+			"relaying->closed",
+		}},
+		{"user/pass", socks5.MethodUserPass, &socks5.Auth{Username: "u", Password: "p"}, []string{
+			"new->handshaking",
+			"handshaking->authenticating",
+			"authenticating->processing-request",
+			"processing-request->relaying",
+			"relaying->closed",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := newStateRecorder(tt.method)
+			socksLn := startSOCKS5Server(t, rec)
+			defer socksLn.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conn, err := socks5.NewDialer(socksLn.Addr().String(), tt.auth, nil).DialContext(ctx, "tcp", echoLn.Addr().String())
+			if err != nil {
+				t.Fatalf("DialContext failed: %v", err)
+			}
+			pingEcho(t, conn)
+			conn.Close()
+
+			if got := rec.wait(t); !slices.Equal(got, tt.want) {
+				t.Fatalf("transitions %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnState_Failure(t *testing.T) {
+	// a port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	unreachable := ln.Addr().String()
+	ln.Close()
+
+	rec := newStateRecorder(socks5.MethodNoAuth)
+	socksLn := startSOCKS5Server(t, rec)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).DialContext(ctx, "tcp", unreachable); err == nil {
+		t.Fatalf("expected DialContext to fail")
+	}
+
+	// no relaying after a failed CONNECT, and OnError before the close
+	want := []string{
+		"new->handshaking",
+		"handshaking->processing-request",
+		"error",
+		"processing-request->closed",
+	}
+	if got := rec.wait(t); !slices.Equal(got, want) {
+		t.Fatalf("events %q, want %q", got, want)
+	}
+}
+
+func TestConnState_ClosedDuringAuth(t *testing.T) {
+	rec := newStateRecorder(socks5.MethodUserPass)
+	socksLn := startSOCKS5Server(t, rec)
+	defer socksLn.Close()
+
+	// a client that stops during authentication
+	conn, err := net.Dial("tcp", socksLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.Write([]byte{socks5.SocksVersion, 1, socks5.MethodUserPass})
+	io.ReadFull(conn, make([]byte, 2))
+	conn.Close()
+
+	got := rec.wait(t)
+	want := []string{"new->handshaking", "handshaking->authenticating", "error", "authenticating->closed"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("events %q, want %q", got, want)
+	}
+}
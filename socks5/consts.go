@@ -5,7 +5,8 @@ const (
 	SocksVersion = 5
 )
 
-// Command codes (CMD) for client requests.
+// Command codes (CMD) for client requests. The constants are untyped so they
+// work with the byte fields of messages; convert to Command for names.
 const (
 	CmdConnect      = 1
 	CmdBind         = 2
@@ -14,14 +15,14 @@ const (
 	CmdResolvePTR   = 0xF1
 )
 
-// Address types (ATYP) used in requests and responses.
+// Address types (ATYP) used in requests and responses (see AddrType).
 const (
 	AddrTypeIPv4   = 1
 	AddrTypeDomain = 3
 	AddrTypeIPv6   = 4
 )
 
-// Reply codes (REP) for server responses.
+// Reply codes (REP) for server responses (see ReplyCode).
 const (
 	RepSuccess              = 0
 	RepGeneralFailure       = 1
@@ -49,9 +50,19 @@ const (
 
 // GSS-API message types (MTYP)
 const (
-	GSSAPITypeInit  = 0x01
-	GSSAPITypeReply = 0x02
-	GSSAPITypeAbort = 0xFF
+	GSSAPITypeInit         = 0x01
+	GSSAPITypeReply        = 0x02 // also the protection-level sub-negotiation (RFC 1961 §4)
+	GSSAPITypeEncapsulated = 0x03 // per-message protected data (RFC 1961 §5)
+	GSSAPITypeAbort        = 0xFF
+)
+
+// GSS-API per-message protection levels, exchanged in the protection-level
+// sub-negotiation after authentication (RFC 1961 §4).
+const (
+	GSSAPIProtNone            = 0x00 // no sub-negotiation; traffic is not encapsulated
+	GSSAPIProtIntegrity       = 0x01 // required per-message integrity
+	GSSAPIProtConfidentiality = 0x02 // required per-message integrity and confidentiality
+	GSSAPIProtSelective       = 0x03 // selective per-message protection
 )
 
 // GSS-API protocol version. (VER)
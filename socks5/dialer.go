@@ -2,10 +2,15 @@ package socks5
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/33TU/socks/internal"
@@ -32,6 +37,15 @@ type Auth struct {
 // GSSAPIAuth holds GSSAPI authentication context.
 type GSSAPIAuth struct {
 	Context GSSAPIContext
+
+	// Mechanism, if set, protects everything after authentication (the request,
+	// the reply and relayed data) with per-message wrapping (see GSSAPIWrappedConn).
+	// The protection level is agreed first with NegotiateGSSAPIProtection.
+	Mechanism GSSAPIMechanism
+
+	// ProtectionLevel is the level requested in the sub-negotiation
+	// (GSSAPIProtNone=GSSAPIProtConfidentiality).
+	ProtectionLevel byte
 }
 
 // Dialer implements a SOCKS5 proxy dialer.
@@ -40,8 +54,105 @@ type Dialer struct {
 	Auth       *Auth
 	GSSAPIAuth *GSSAPIAuth
 	Dialer     socksnet.Dialer
+	TLSConfig  *tls.Config // optional TLS config for DialTLSContext (nil=default)
+
+	// UDPFragmentation makes ListenPacket fragment oversized UDP payloads and
+	// reassemble fragmented datagrams (see UDPConn.EnableFragmentation).
+	UDPFragmentation bool
+
+	// Retry is how many times DialContext retries a CONNECT that the proxy
+	// answered with one of the RetryOn reply codes, each time over a new proxy
+	// connection. The delay starts at RetryBackoff and doubles up to MaxRetryBackoff.
+	Retry        int
+	RetryBackoff time.Duration // Delay before the first retry (0=DefaultRetryBackoff)
+	RetryOn      []byte        // Reply codes worth retrying, e.g. RepConnectionRefused (nil=none)
+
+	// IDNA converts Unicode host names to their ASCII (punycode) form before
+	// they are sent. Without it such names are rejected with ErrInvalidDomain.
+	IDNA bool
+
+	// AllowDomainUnderscore sends host names with '_' in their labels instead
+	// of rejecting them with ErrInvalidDomain (see ValidateDomainName).
+	AllowDomainUnderscore bool
+
+	// StrictReplyAddr fails a CONNECT to an IP address with a
+	// *ReplyAddrMismatchError if the proxy's reply carries a BND.ADDR of
+	// another type, e.g. IPv6 for an IPv4 target. Such replies usually come
+	// from a broken proxy; by default BND.ADDR is not checked.
+	StrictReplyAddr bool
+
+	// MaxConcurrentDials limits how many DialContext calls may be connecting to
+	// the proxy and negotiating at once (0=unlimited). Further calls wait for a
+	// slot until their context ends and then fail with ErrDialerBusy. A slot is
+	// freed once the proxy has replied, not when the connection is closed.
+	MaxConcurrentDials int
+
+	// Pipeline makes DialContext send the greeting and the CONNECT request in a
+	// single write and then read both replies, saving a round trip to proxies
+	// known to accept NoAuth. Only NoAuth is offered, so it applies only when
+	// Auth and GSSAPIAuth are nil.
+	//
+	// Sending the request before the method is selected is not part of RFC 1928.
+	// A proxy that reads the greeting with exact-length reads tolerates it; one
+	// that discards unexpected input, or selects another method, will fail the
+	// dial or misread the request. Enable it only for proxies tested with it.
+	Pipeline bool
+
+	// StepDeadlines splits the deadline of DialContext and
+	// DialConnContext (see RequestTimeout) between the negotiation (method selection and
+	// authentication) and the CONNECT request instead of letting the first
+	// use all of it, so a proxy stalling during negotiation fails the dial
+	// after half the remaining time. The whole deadline still applies to the
+	// TCP dial, and deadlines are cleared once the proxy has replied.
+	StepDeadlines bool
+
+	// RequestTimeout bounds the exchange with the proxy after the TCP connection
+	// is made: method selection, authentication and the request and its reply
+	// (0=context deadline only; the earlier of the two applies). A proxy that accepts the connection but never
+	// answers then fails the dial with a timeout error. The deadline is cleared
+	// before the connection is returned; the TCP dial itself is not covered.
+	RequestTimeout time.Duration
+
+	// LimitUp and LimitDown limit the rates of data written to and read from
+	// connections returned by DialContext (nil=unlimited). Such connections
+	// are then *socksnet.RateLimitedConn. A limiter shared by several Dialers
+	// or connections limits their combined rate.
+	LimitUp   socksnet.RateLimiter
+	LimitDown socksnet.RateLimiter
+
+	semOnce sync.Once
+	sem     chan struct{} // dial slots (nil=unlimited)
 }
 
+// Dialer errors.
+var (
+	// ErrDialerBusy is returned by DialContext when the context ends while
+	// waiting for one of the Dialer's MaxConcurrentDials slots.
+	ErrDialerBusy = errors.New("dialer busy: too many concurrent dials")
+
+	// ErrNoAuthHandler is returned when the proxy selects an authentication
+	// method the Dialer cannot perform: username/password without Auth, or
+	// GSSAPI without a GSSAPIAuth.Context.
+	ErrNoAuthHandler = errors.New("no handler for the selected authentication method")
+)
+
+// ReplyAddrMismatchError is returned by a Dialer with StrictReplyAddr when the
+// address type of a CONNECT reply does not match the request's.
+type ReplyAddrMismatchError struct {
+	Requested AddrType // ATYP of the request
+	Replied   AddrType // ATYP of the reply
+}
+
+func (e *ReplyAddrMismatchError) Error() string {
+	return fmt.Sprintf("proxy replied with address type %s to a %s request", e.Replied, e.Requested)
+}
+
+// Dialer retry backoff bounds.
+const (
+	DefaultRetryBackoff = 100 * time.Millisecond
+	MaxRetryBackoff     = 30 * time.Second
+)
+
 // NewDialer creates a new SOCKS5 dialer instance.
 func NewDialer(proxyAddr string, auth *Auth, dialer socksnet.Dialer) *Dialer {
 	if dialer == nil {
@@ -54,6 +165,13 @@ func NewDialer(proxyAddr string, auth *Auth, dialer socksnet.Dialer) *Dialer {
 	}
 }
 
+// Dial connects to target through the SOCKS5 proxy at proxyAddr using a
+// one-off Dialer, authenticating with auth if the proxy asks for it (nil=no auth).
+// Use a Dialer directly to reuse settings across connections.
+func Dial(ctx context.Context, proxyAddr, network, target string, auth *Auth) (net.Conn, error) {
+	return NewDialer(proxyAddr, auth, nil).DialContext(ctx, network, target)
+}
+
 // ProxyAddress returns the configured SOCKS5 proxy address.
 func (d *Dialer) ProxyAddress() string {
 	return d.ProxyAddr
@@ -72,14 +190,94 @@ func NewDialerWithGSSAPI(proxyAddr string, auth *Auth, gssapiAuth *GSSAPIAuth, d
 	}
 }
 
-// DialContext establishes a connection via SOCKS5 proxy (CONNECT command).
+// DialContext establishes a connection via SOCKS5 proxy (CONNECT command) and
+// returns it as a *Conn. Requests refused with a RetryOn reply code are retried
+// up to Retry times.
 func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	backoff := d.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		conn, err := d.dialOnce(ctx, network, address)
+		if err == nil || attempt >= d.Retry || !d.retryable(err) {
+			return conn, err
+		}
+
+		// Give up early rather than sleep past the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return nil, err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		}
+		backoff = min(backoff*2, MaxRetryBackoff)
+	}
+}
+
+// DialContextIP is DialContext for a target given as an IP address, e.g. one
+// resolved in advance or pinned to a specific backend. The request carries the
+// address with ATYP IPv4 or IPv6, so neither the client nor the proxy resolves
+// a name. It fails with ErrInvalidAddr if ip is not a valid IP address.
+func (d *Dialer) DialContextIP(ctx context.Context, network string, ip net.IP, port uint16) (net.Conn, error) {
+	if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+		return nil, ErrInvalidAddr
+	}
+	return d.DialContext(ctx, network, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+}
+
+// dialOnce dials the proxy and issues a single CONNECT request.
+func (d *Dialer) dialOnce(ctx context.Context, network, address string) (net.Conn, error) {
+	release, err := d.acquireDial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	conn, err := d.dialProxy(ctx, network)
 	if err != nil {
 		return nil, err
 	}
 
-	return d.DialConnContext(ctx, conn, network, address)
+	if conn, err = d.DialConnContext(ctx, conn, network, address); err != nil {
+		return nil, err
+	}
+	if d.LimitUp != nil || d.LimitDown != nil {
+		conn = socksnet.NewRateLimitedConn(conn, d.LimitDown, d.LimitUp)
+	}
+	return conn, nil
+}
+
+// acquireDial waits for a free MaxConcurrentDials slot and returns the func
+// that frees it.
+func (d *Dialer) acquireDial(ctx context.Context) (release func(), err error) {
+	d.semOnce.Do(func() {
+		if d.MaxConcurrentDials > 0 {
+			d.sem = make(chan struct{}, d.MaxConcurrentDials)
+		}
+	})
+	if d.sem == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case d.sem <- struct{}{}:
+		return func() { <-d.sem }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %w", ErrDialerBusy, ctx.Err())
+	}
+}
+
+// retryable reports whether err is a reply code listed in RetryOn.
+func (d *Dialer) retryable(err error) bool {
+	var code ReplyCode
+	return errors.As(err, &code) && slices.Contains(d.RetryOn, byte(code))
 }
 
 // Dial establishes a connection via SOCKS5 proxy using background context.
@@ -87,7 +285,18 @@ func (d *Dialer) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
 
+// DialTLSContext establishes a connection via SOCKS5 proxy and performs a TLS handshake with the target.
+func (d *Dialer) DialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return socksnet.DialTLSContext(ctx, d, d.TLSConfig, network, address)
+}
+
+// NewHTTPTransport returns an http.Transport that connects through the SOCKS5 proxy.
+func (d *Dialer) NewHTTPTransport() *http.Transport {
+	return socksnet.NewHTTPTransport(d.DialContext, d.DialTLSContext)
+}
+
 // DialConnContext upgrades an existing connection via SOCKS5 proxy (CONNECT command).
+// The returned connection is a *Conn.
 func (d *Dialer) DialConnContext(ctx context.Context, conn net.Conn, network, address string) (net.Conn, error) {
 	host, port, err := splitHostPort(ctx, address)
 	if err != nil {
@@ -98,12 +307,29 @@ func (d *Dialer) DialConnContext(ctx context.Context, conn net.Conn, network, ad
 	cleanup := bindConnToContext(ctx, conn)
 	defer cleanup()
 
+	deadline := d.requestDeadline(ctx)
+	if d.RequestTimeout > 0 {
+		conn.SetDeadline(deadline)
+	}
+
+	if d.Pipeline && d.Auth == nil && d.GSSAPIAuth == nil {
+		return d.pipelineConnect(conn, host, port, address)
+	}
+
+	if d.StepDeadlines {
+		conn.SetDeadline(proportionalDeadline(deadline, 2))
+	}
+
 	// SOCKS5 negotiation (auth, method selection, etc.)
-	if err := d.handshake(conn); err != nil {
-		conn.Close()
+	conn, method, err := d.handshakeOrClose(conn)
+	if err != nil {
 		return nil, err
 	}
 
+	if d.StepDeadlines {
+		conn.SetDeadline(proportionalDeadline(deadline, 1))
+	}
+
 	// CONNECT request
 	reply, err := d.doRequest(conn, CmdConnect, host, port)
 	if err != nil {
@@ -116,7 +342,56 @@ func (d *Dialer) DialConnContext(ctx context.Context, conn net.Conn, network, ad
 		return nil, replyToError(reply.Reply)
 	}
 
-	return conn, nil
+	return &Conn{Conn: conn, method: method, bound: replyBoundAddr(reply), target: address}, nil
+}
+
+// pipelineConnect sends the greeting, offering only NoAuth, and the CONNECT
+// request in one write, then reads the method selection and the reply.
+func (d *Dialer) pipelineConnect(conn net.Conn, host string, port uint16, address string) (net.Conn, error) {
+	var hs HandshakeRequest
+	hs.Init(SocksVersion, MethodNoAuth)
+	req := d.newRequest(CmdConnect, host, port)
+
+	bw := internal.GetWriter(conn)
+	defer internal.PutWriter(bw)
+
+	if _, err := hs.WriteTo(bw); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := req.writeTo(bw, d.AllowDomainUnderscore); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := internal.FlushWriter(bw); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// Both replies are read unbuffered, so data relayed right after the
+	// reply stays in conn.
+	var hsReply HandshakeReply
+	if _, err := hsReply.ReadFrom(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if hsReply.Method != MethodNoAuth {
+		conn.Close()
+		return nil, errors.New("socks5: no acceptable authentication method")
+	}
+
+	reply, err := d.readReply(conn, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if reply.Reply != RepSuccess {
+		conn.Close()
+		return nil, replyToError(reply.Reply)
+	}
+
+	return &Conn{Conn: conn, method: MethodNoAuth, bound: replyBoundAddr(reply), target: address}, nil
 }
 
 // DialConn upgrades an existing connection using background context.
@@ -143,8 +418,11 @@ func (d *Dialer) BindContext(
 	cleanup := bindConnToContext(ctx, conn)
 	defer cleanup()
 
-	if err := d.handshake(conn); err != nil {
-		conn.Close()
+	if d.RequestTimeout > 0 {
+		conn.SetDeadline(d.requestDeadline(ctx))
+	}
+
+	if conn, _, err = d.handshakeOrClose(conn); err != nil {
 		return nil, nil, nil, err
 	}
 
@@ -159,18 +437,21 @@ func (d *Dialer) BindContext(
 		return nil, nil, nil, replyToError(reply.Reply)
 	}
 
-	addr := replyToTCPAddr(reply)
+	// BND.ADDR may be a domain name
+	addr, err := reply.ResolvedAddr(ctx, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("socks5: resolve BIND address %s: %w", reply.Addr(), err)
+	}
 
 	ready := make(chan error, 1)
 
 	go func() {
 		defer close(ready)
 
-		reader := internal.GetReader(conn)
-		defer internal.PutReader(reader)
-
+		// unbuffered, so data from the incoming connection stays in conn
 		var second Reply
-		_, err := second.ReadFrom(reader)
+		_, err := second.ReadFrom(conn)
 		if err != nil {
 			ready <- err
 			return
@@ -207,8 +488,11 @@ func (d *Dialer) UDPAssociateContext(
 	cleanup := bindConnToContext(ctx, conn)
 	defer cleanup()
 
-	if err := d.handshake(conn); err != nil {
-		conn.Close()
+	if d.RequestTimeout > 0 {
+		conn.SetDeadline(d.requestDeadline(ctx))
+	}
+
+	if conn, _, err = d.handshakeOrClose(conn); err != nil {
 		return nil, nil, err
 	}
 
@@ -231,13 +515,38 @@ func (d *Dialer) UDPAssociateContext(
 		return nil, nil, replyToError(reply.Reply)
 	}
 
-	udpAddr := replyToUDPAddr(reply)
+	// BND.ADDR may be a domain name
+	addr, err := reply.ResolvedAddr(ctx, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("socks5: resolve UDP relay address %s: %w", reply.Addr(), err)
+	}
 
-	return conn, udpAddr, nil
+	return conn, &net.UDPAddr{IP: addr.IP, Port: addr.Port}, nil
 }
 
 // ListenPacket establishes a UDP association and returns a PacketConn for sending/receiving UDP packets via the SOCKS5 proxy.
 func (d *Dialer) ListenPacket(ctx context.Context, network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	conn, err := d.listenUDP(ctx, network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// OpenUDPAssociation establishes a UDP association and returns it as a
+// UDPAssociation, which also ends the association if the proxy closes the
+// control connection.
+func (d *Dialer) OpenUDPAssociation(ctx context.Context, network string, laddr *net.UDPAddr) (*UDPAssociation, error) {
+	conn, err := d.listenUDP(ctx, network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return newUDPAssociation(conn), nil
+}
+
+// listenUDP performs UDP ASSOCIATE and opens a UDP socket to the relay.
+func (d *Dialer) listenUDP(ctx context.Context, network string, laddr *net.UDPAddr) (*UDPConn, error) {
 	tcpConn, relayAddr, err := d.UDPAssociateContext(ctx, network, laddr)
 	if err != nil {
 		return nil, err
@@ -249,7 +558,12 @@ func (d *Dialer) ListenPacket(ctx context.Context, network string, laddr *net.UD
 		return nil, err
 	}
 
-	return NewUDPConn(tcpConn, udpConn, relayAddr), nil
+	conn := NewUDPConn(tcpConn, udpConn, relayAddr)
+	if d.UDPFragmentation {
+		conn.EnableFragmentation(0)
+	}
+
+	return conn, nil
 }
 
 // UDPAssociate establishes a UDP association using background context.
@@ -258,7 +572,13 @@ func (d *Dialer) UDPAssociate(network string, clientAddr *net.UDPAddr) (net.Conn
 }
 
 // ResolveContext resolves a hostname via SOCKS5 proxy (Tor-style extension).
+// IP literals are returned as-is without contacting the proxy: RESOLVE must
+// carry a domain (see ErrInvalidResolveTarget), and an IP needs no lookup.
 func (d *Dialer) ResolveContext(ctx context.Context, network, host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
 	conn, err := d.dialProxy(ctx, network)
 	if err != nil {
 		return nil, err
@@ -269,7 +589,11 @@ func (d *Dialer) ResolveContext(ctx context.Context, network, host string) (net.
 	cleanup := bindConnToContext(ctx, conn)
 	defer cleanup()
 
-	if err := d.handshake(conn); err != nil {
+	if d.RequestTimeout > 0 {
+		conn.SetDeadline(d.requestDeadline(ctx))
+	}
+
+	if conn, _, err = d.handshake(conn); err != nil {
 		return nil, err
 	}
 
@@ -294,12 +618,16 @@ func (d *Dialer) dialProxy(ctx context.Context, network string) (net.Conn, error
 	return dialer.DialContext(ctx, network, d.ProxyAddr)
 }
 
-// handshake performs SOCKS5 method negotiation.
-func (d *Dialer) handshake(conn net.Conn) error {
+// handshake performs SOCKS5 method negotiation and returns the connection to use
+// for the request, which is wrapped if GSSAPI per-message protection is in effect,
+// and the method the proxy selected.
+func (d *Dialer) handshake(conn net.Conn) (net.Conn, byte, error) {
+	// With credentials, UserPass is offered ahead of NoAuth so that proxies
+	// honouring the client's order authenticate; open proxies may still pick
+	// NoAuth, which then skips authentication.
 	methods := []byte{MethodNoAuth}
-
 	if d.Auth != nil {
-		methods = append(methods, MethodUserPass)
+		methods = []byte{MethodUserPass, MethodNoAuth}
 	}
 
 	if d.GSSAPIAuth != nil {
@@ -310,7 +638,7 @@ func (d *Dialer) handshake(conn net.Conn) error {
 	req.Init(SocksVersion, methods...)
 
 	if _, err := req.WriteTo(conn); err != nil {
-		return err
+		return nil, 0, err
 	}
 
 	reader := internal.GetReader(conn)
@@ -318,28 +646,54 @@ func (d *Dialer) handshake(conn net.Conn) error {
 
 	var reply HandshakeReply
 	if _, err := reply.ReadFrom(reader); err != nil {
-		return err
+		return nil, 0, err
 	}
 
 	switch reply.Method {
 	case MethodNoAuth:
-		return nil
+		return conn, reply.Method, nil
 
 	case MethodUserPass:
 		if d.Auth == nil {
-			return errors.New("socks5: server requires authentication")
+			return nil, 0, fmt.Errorf("socks5: server requires authentication: %w", ErrNoAuthHandler)
 		}
-		return d.authUserPass(conn)
+		return conn, reply.Method, d.authUserPass(conn)
 
 	case MethodGSSAPI:
-		if d.GSSAPIAuth == nil {
-			return errors.New("socks5: server requires GSSAPI authentication")
+		// Offering GSSAPI without a context must not start a token exchange
+		if d.GSSAPIAuth == nil || d.GSSAPIAuth.Context == nil {
+			return nil, 0, fmt.Errorf("socks5: server requires GSSAPI authentication: %w", ErrNoAuthHandler)
+		}
+		if err := d.authGSSAPI(conn); err != nil {
+			return nil, 0, err
+		}
+		mech := d.GSSAPIAuth.Mechanism
+		if mech == nil {
+			return conn, reply.Method, nil
+		}
+
+		level := d.GSSAPIAuth.ProtectionLevel
+		if level == GSSAPIProtNone {
+			level = GSSAPIProtConfidentiality
 		}
-		return d.authGSSAPI(conn)
+		if _, err := NegotiateGSSAPIProtection(conn, mech, level); err != nil {
+			return nil, 0, fmt.Errorf("socks5: GSSAPI protection negotiation failed: %w", err)
+		}
+		return NewGSSAPIWrappedConn(conn, mech), reply.Method, nil
 
 	default:
-		return errors.New("socks5: no acceptable authentication method")
+		return nil, 0, errors.New("socks5: no acceptable authentication method")
+	}
+}
+
+// handshakeOrClose is handshake, closing conn if it fails.
+func (d *Dialer) handshakeOrClose(conn net.Conn) (net.Conn, byte, error) {
+	c, method, err := d.handshake(conn)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
 	}
+	return c, method, nil
 }
 
 // authUserPass performs SOCKS5 username/password authentication.
@@ -457,6 +811,27 @@ func bindConnToContext(ctx context.Context, conn net.Conn) (cleanup func()) {
 	}
 }
 
+// requestDeadline returns the deadline of the exchange with the proxy: ctx's
+// deadline or RequestTimeout from now, whichever is earlier (zero=none).
+func (d *Dialer) requestDeadline(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	if d.RequestTimeout > 0 {
+		if t := time.Now().Add(d.RequestTimeout); deadline.IsZero() || t.Before(deadline) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+// proportionalDeadline returns the deadline for the next of steps equal steps
+// sharing the time left until deadline, or the zero time if deadline is zero.
+func proportionalDeadline(deadline time.Time, steps int) time.Time {
+	if deadline.IsZero() || steps <= 1 {
+		return deadline
+	}
+	return time.Now().Add(time.Until(deadline) / time.Duration(steps))
+}
+
 // doRequest sends a SOCKS5 request and reads the reply.
 func (d *Dialer) doRequest(
 	conn net.Conn,
@@ -464,9 +839,21 @@ func (d *Dialer) doRequest(
 	host string,
 	port uint16,
 ) (*Reply, error) {
+	req := d.newRequest(cmd, host, port)
+	if _, err := req.writeTo(conn, d.AllowDomainUnderscore); err != nil {
+		return nil, err
+	}
+
+	// Read unbuffered: whatever follows the reply, e.g. the second BIND reply
+	// or data from the target, must stay in conn.
+	return d.readReply(conn, req)
+}
+
+// newRequest returns the request for cmd to host:port.
+func (d *Dialer) newRequest(cmd byte, host string, port uint16) *Request {
 	ip := net.ParseIP(host)
 
-	req := Request{
+	req := &Request{
 		Version: SocksVersion,
 		Command: cmd,
 		Port:    port,
@@ -474,6 +861,9 @@ func (d *Dialer) doRequest(
 
 	switch {
 	case ip == nil:
+		if d.IDNA {
+			host = internal.DomainToASCII(host)
+		}
 		req.AddrType = AddrTypeDomain
 		req.Domain = host
 
@@ -485,17 +875,19 @@ func (d *Dialer) doRequest(
 		req.AddrType = AddrTypeIPv6
 		req.IP = ip.To16()
 	}
+	return req
+}
 
-	if _, err := req.WriteTo(conn); err != nil {
+// readReply reads the reply to req from src.
+func (d *Dialer) readReply(src io.Reader, req *Request) (*Reply, error) {
+	var reply Reply
+	if _, err := reply.ReadFrom(src); err != nil {
 		return nil, err
 	}
 
-	reader := internal.GetReader(conn)
-	defer internal.PutReader(reader)
-
-	var reply Reply
-	if _, err := reply.ReadFrom(reader); err != nil {
-		return nil, err
+	if d.StrictReplyAddr && req.Command == CmdConnect && req.AddrType != AddrTypeDomain &&
+		reply.Reply == RepSuccess && reply.AddrType != req.AddrType {
+		return nil, &ReplyAddrMismatchError{Requested: AddrType(req.AddrType), Replied: AddrType(reply.AddrType)}
 	}
 
 	return &reply, nil
@@ -539,34 +931,7 @@ func replyToTCPAddr(r *Reply) *net.TCPAddr {
 	}
 }
 
-// replyToUDPAddr converts a SOCKS5 reply to a UDP address.
-func replyToUDPAddr(r *Reply) *net.UDPAddr {
-	return &net.UDPAddr{
-		IP:   r.IP,
-		Port: int(r.Port),
-	}
-}
-
 // replyToError converts a SOCKS5 reply code to an error.
 func replyToError(rep byte) error {
-	switch rep {
-	case RepGeneralFailure:
-		return errors.New("socks5: general failure")
-	case RepConnectionNotAllowed:
-		return errors.New("socks5: connection not allowed")
-	case RepNetworkUnreachable:
-		return errors.New("socks5: network unreachable")
-	case RepHostUnreachable:
-		return errors.New("socks5: host unreachable")
-	case RepConnectionRefused:
-		return errors.New("socks5: connection refused")
-	case RepTTLExpired:
-		return errors.New("socks5: ttl expired")
-	case RepCommandNotSupported:
-		return errors.New("socks5: command not supported")
-	case RepAddrTypeNotSupported:
-		return errors.New("socks5: address type not supported")
-	default:
-		return fmt.Errorf("socks5: unknown error (%d)", rep)
-	}
+	return ReplyCode(rep)
 }
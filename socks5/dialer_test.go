@@ -1,14 +1,22 @@
 package socks5_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	socksnet "github.com/33TU/socks/net"
 	"github.com/33TU/socks/socks5"
 )
 
@@ -135,6 +143,362 @@ func TestDialer_Connect_Rejected(t *testing.T) {
 	if err == nil || !strings.Contains(err.Error(), "connection refused") {
 		t.Fatalf("expected rejection error, got %v", err)
 	}
+
+	var code socks5.ReplyCode
+	if !errors.As(err, &code) || code != socks5.RepConnectionRefused {
+		t.Fatalf("expected ReplyCode %v in error chain, got %v", socks5.ReplyCode(socks5.RepConnectionRefused), err)
+	}
+}
+
+func TestDialer_Connect_Retry(t *testing.T) {
+	var attempts atomic.Int32
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+
+		// refuse the first two attempts
+		if attempts.Add(1) <= 2 {
+			socks5.NewErrorReply(socks5.RepConnectionRefused).WriteTo(c)
+			return
+		}
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}).WriteTo(c)
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		c.Write([]byte("pong"))
+	})
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.Retry = 3
+	d.RetryBackoff = 10 * time.Millisecond
+	d.RetryOn = []byte{socks5.RepConnectionRefused}
+
+	conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1234")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+		t.Fatalf("expected pong, got %q (%v)", buf, err)
+	}
+}
+
+func TestDialer_Connect_RetryNotListed(t *testing.T) {
+	var attempts atomic.Int32
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		req.ReadFrom(c)
+		attempts.Add(1)
+		socks5.NewErrorReply(socks5.RepConnectionNotAllowed).WriteTo(c)
+	})
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.Retry = 3
+	d.RetryBackoff = 10 * time.Millisecond
+	d.RetryOn = []byte{socks5.RepConnectionRefused}
+
+	_, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1234")
+	var code socks5.ReplyCode
+	if !errors.As(err, &code) || code != socks5.RepConnectionNotAllowed {
+		t.Fatalf("expected RepConnectionNotAllowed, got %v", err)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestDialer_Connect_RetryDeadline(t *testing.T) {
+	var attempts atomic.Int32
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		req.ReadFrom(c)
+		attempts.Add(1)
+		socks5.NewErrorReply(socks5.RepConnectionRefused).WriteTo(c)
+	})
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.Retry = 5
+	d.RetryBackoff = time.Second
+	d.RetryOn = []byte{socks5.RepConnectionRefused}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// the backoff outlasts the deadline, so the refusal is returned at once
+	start := time.Now()
+	_, err := d.DialContext(ctx, "tcp", "127.0.0.1:1234")
+	var code socks5.ReplyCode
+	if !errors.As(err, &code) || code != socks5.RepConnectionRefused {
+		t.Fatalf("expected RepConnectionRefused, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("DialContext waited %v despite the deadline", elapsed)
+	}
+	if n := attempts.Load(); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d", n)
+	}
+}
+
+func TestDialer_Connect_IDNA(t *testing.T) {
+	domains := make(chan string, 1)
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		domains <- req.Domain
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}).WriteTo(c)
+	})
+	defer stop()
+
+	// without IDNA the name is rejected before the request is sent
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	if _, err := d.DialContext(context.Background(), "tcp", "例え.テスト:443"); !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Fatalf("expected ErrInvalidDomain, got %v", err)
+	}
+
+	d.IDNA = true
+	conn, err := d.DialContext(context.Background(), "tcp", "例え.テスト:443")
+	if err != nil {
+		t.Fatalf("DialContext with IDNA failed: %v", err)
+	}
+	conn.Close()
+
+	if got := <-domains; got != "xn--r8jz45g.xn--zckzah" {
+		t.Fatalf("proxy received domain %q, want %q", got, "xn--r8jz45g.xn--zckzah")
+	}
+	select {
+	case got := <-domains:
+		t.Fatalf("unexpected request for %q", got)
+	default:
+	}
+}
+
+func TestDialer_Connect_StrictReplyAddr(t *testing.T) {
+	// the proxy always binds an IPv6 address, whatever the target
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234}).WriteTo(c)
+	})
+	defer stop()
+
+	// lenient by default
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	conn.Close()
+
+	d.StrictReplyAddr = true
+	_, err = d.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+	var mismatch *socks5.ReplyAddrMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected ReplyAddrMismatchError, got %v", err)
+	}
+	if mismatch.Requested != socks5.AddrTypeIPv4 || mismatch.Replied != socks5.AddrTypeIPv6 {
+		t.Fatalf("unexpected mismatch %+v", mismatch)
+	}
+
+	// matching families and domain targets pass
+	for _, target := range []string{"[::1]:80", "example.com:80"} {
+		conn, err := d.DialContext(context.Background(), "tcp", target)
+		if err != nil {
+			t.Fatalf("DialContext to %s failed: %v", target, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestDialer_Conn(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			return nil
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	defer socksLn.Close()
+
+	d := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "u", Password: "p"}, nil)
+	conn, err := d.DialContext(context.Background(), "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+	pingEcho(t, conn)
+
+	sc, ok := conn.(*socks5.Conn)
+	if !ok {
+		t.Fatalf("expected *socks5.Conn, got %T", conn)
+	}
+	if sc.NegotiatedMethod() != socks5.MethodUserPass {
+		t.Errorf("NegotiatedMethod = %d, want MethodUserPass", sc.NegotiatedMethod())
+	}
+	if sc.Target() != echoLn.Addr().String() {
+		t.Errorf("Target = %q, want %q", sc.Target(), echoLn.Addr())
+	}
+	if bound, ok := sc.BoundAddr().(*net.TCPAddr); !ok || bound.Port == 0 {
+		t.Errorf("BoundAddr = %v, want the proxy's outgoing TCP address", sc.BoundAddr())
+	}
+}
+
+func TestDialer_Conn_DomainBoundAddr(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		req.ReadFrom(c)
+		(&socks5.Reply{Version: socks5.SocksVersion, Reply: socks5.RepSuccess, AddrType: socks5.AddrTypeDomain, Domain: "proxy.example", Port: 4321}).WriteTo(c)
+	})
+	defer stop()
+
+	conn, err := socks5.NewDialer(proxyAddr, nil, nil).DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	sc := conn.(*socks5.Conn)
+	if sc.NegotiatedMethod() != socks5.MethodNoAuth {
+		t.Errorf("NegotiatedMethod = %d, want MethodNoAuth", sc.NegotiatedMethod())
+	}
+	if got := sc.BoundAddr().String(); got != "proxy.example:4321" {
+		t.Errorf("BoundAddr = %s, want proxy.example:4321", got)
+	}
+}
+
+func TestDialer_MaxConcurrentDials(t *testing.T) {
+	var inHandshake, peak atomic.Int32
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		n := inHandshake.Add(1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			inHandshake.Add(-1)
+			return
+		}
+
+		// stay in the handshake long enough for the other dials to pile up
+		time.Sleep(20 * time.Millisecond)
+		inHandshake.Add(-1)
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}).WriteTo(c)
+	})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.MaxConcurrentDials = 2
+
+	errs := make(chan error, 10)
+	for range 10 {
+		go func() {
+			conn, err := d.DialContext(ctx, "tcp", "127.0.0.1:80")
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+	for range 10 {
+		if err := <-errs; err != nil {
+			t.Fatalf("DialContext failed: %v", err)
+		}
+	}
+
+	if p := peak.Load(); p > 2 {
+		t.Fatalf("%d dials were in the handshake at once, want at most 2", p)
+	}
+}
+
+func TestDialer_MaxConcurrentDials_Busy(t *testing.T) {
+	block := make(chan struct{})
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+		<-block
+	})
+	defer stop()
+	defer close(block)
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.MaxConcurrentDials = 1
+
+	// the first dial holds the only slot until its context ends
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	go d.DialContext(ctx1, "tcp", "127.0.0.1:80")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel2()
+	if _, err := d.DialContext(ctx2, "tcp", "127.0.0.1:80"); !errors.Is(err, socks5.ErrDialerBusy) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrDialerBusy, got %v", err)
+	}
 }
 
 func TestDialer_Connect_WithAuth(t *testing.T) {
@@ -216,6 +580,63 @@ func TestDialer_Connect_WithAuth(t *testing.T) {
 	}
 }
 
+func TestDial(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodUserPass}).WriteTo(c)
+
+		var authReq socks5.UserPassRequest
+		if _, err := authReq.ReadFrom(c); err != nil {
+			t.Errorf("server: read auth request: %v", err)
+			return
+		}
+		if authReq.Username != "testuser" || authReq.Password != "testpass" {
+			t.Errorf("server: invalid credentials")
+			return
+		}
+		(&socks5.UserPassReply{Version: 1, Status: 0}).WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			t.Errorf("server: read request: %v", err)
+			return
+		}
+		if req.Domain != "example.com" || req.Port != 80 {
+			t.Errorf("server: unexpected target %s", req.Addr())
+			return
+		}
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}).WriteTo(c)
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			return
+		}
+		c.Write([]byte("pong"))
+	})
+	defer stop()
+
+	auth := &socks5.Auth{Username: "testuser", Password: "testpass"}
+	conn, err := socks5.Dial(context.Background(), proxyAddr, "tcp", "example.com:80", auth)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("expected pong, got %q", buf)
+	}
+}
+
 func TestDialer_Bind_Success(t *testing.T) {
 	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
 		defer c.Close()
@@ -735,22 +1156,108 @@ func TestDialer_Connect_WithGSSAPI_NoContext(t *testing.T) {
 	}
 }
 
-func TestDialer_Connect_WithDeadline(t *testing.T) {
+func TestDialer_Connect_WithGSSAPI_NoHandler(t *testing.T) {
+	offered := make(chan []byte, 1)
 	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
 		defer c.Close()
 
-		// Handshake
 		var hsReq socks5.HandshakeRequest
 		hsReq.ReadFrom(c)
-		hsReply := &socks5.HandshakeReply{
-			Version: socks5.SocksVersion,
-			Method:  socks5.MethodNoAuth,
-		}
-		hsReply.WriteTo(c)
-
-		// Read request
-		var req socks5.Request
-		req.ReadFrom(c)
+		offered <- hsReq.Methods
+
+		// select GSSAPI and wait for a token that must never come
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodGSSAPI}).WriteTo(c)
+		io.Copy(io.Discard, c)
+	})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// GSSAPI is offered but no mechanism is configured
+	d := socks5.NewDialerWithGSSAPI(proxyAddr, &socks5.Auth{Username: "u", Password: "p"}, &socks5.GSSAPIAuth{}, nil)
+	_, err := d.DialContext(ctx, "tcp", "127.0.0.1:1234")
+	if !errors.Is(err, socks5.ErrNoAuthHandler) {
+		t.Fatalf("expected ErrNoAuthHandler, got %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("dial did not fail promptly")
+	}
+
+	want := []byte{socks5.MethodUserPass, socks5.MethodNoAuth, socks5.MethodGSSAPI}
+	if got := <-offered; !bytes.Equal(got, want) {
+		t.Fatalf("offered methods %v, want %v", got, want)
+	}
+}
+
+func TestDialer_Connect_OptionalAuth(t *testing.T) {
+	for _, method := range []byte{socks5.MethodNoAuth, socks5.MethodUserPass} {
+		t.Run(fmt.Sprintf("method %#02x", method), func(t *testing.T) {
+			offered := make(chan []byte, 1)
+			authed := make(chan bool, 1)
+			proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+				defer c.Close()
+
+				var hsReq socks5.HandshakeRequest
+				if _, err := hsReq.ReadFrom(c); err != nil {
+					return
+				}
+				offered <- hsReq.Methods
+				(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: method}).WriteTo(c)
+
+				if method == socks5.MethodUserPass {
+					var authReq socks5.UserPassRequest
+					if _, err := authReq.ReadFrom(c); err != nil {
+						return
+					}
+					authed <- authReq.Username == "testuser" && authReq.Password == "testpass"
+					(&socks5.UserPassReply{Version: socks5.AuthVersionUserPass, Status: socks5.UserPassStatusSuccess}).WriteTo(c)
+				}
+
+				// with NoAuth the request must follow the method selection directly
+				var req socks5.Request
+				if _, err := req.ReadFrom(c); err != nil {
+					t.Errorf("server: read request: %v", err)
+					return
+				}
+				socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}).WriteTo(c)
+			})
+			defer stop()
+
+			d := socks5.NewDialer(proxyAddr, &socks5.Auth{Username: "testuser", Password: "testpass"}, nil)
+			conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1234")
+			if err != nil {
+				t.Fatalf("DialContext failed: %v", err)
+			}
+			conn.Close()
+
+			want := []byte{socks5.MethodUserPass, socks5.MethodNoAuth}
+			if got := <-offered; !bytes.Equal(got, want) {
+				t.Fatalf("offered methods %v, want %v", got, want)
+			}
+			if method == socks5.MethodUserPass && !<-authed {
+				t.Fatal("server received wrong credentials")
+			}
+		})
+	}
+}
+
+func TestDialer_Connect_WithDeadline(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		// Handshake
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		hsReply := &socks5.HandshakeReply{
+			Version: socks5.SocksVersion,
+			Method:  socks5.MethodNoAuth,
+		}
+		hsReply.WriteTo(c)
+
+		// Read request
+		var req socks5.Request
+		req.ReadFrom(c)
 
 		// Send success reply
 		resp := &socks5.Reply{
@@ -805,3 +1312,462 @@ func TestDialer_Connect_WithDeadline(t *testing.T) {
 		t.Logf("got error (acceptable): %v", err) // Log but don't fail - different error types are OK
 	}
 }
+
+func TestDialer_NewHTTPTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	httpSrv := httptest.NewServer(handler)
+	defer httpSrv.Close()
+	httpsSrv := httptest.NewTLSServer(handler)
+	defer httpsSrv.Close()
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowConnect: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	defer socksLn.Close()
+
+	d := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+	d.TLSConfig = httpsSrv.Client().Transport.(*http.Transport).TLSClientConfig
+
+	tr := d.NewHTTPTransport()
+	defer tr.CloseIdleConnections()
+	if tr.MaxIdleConns != 100 || tr.IdleConnTimeout != 90*time.Second ||
+		tr.TLSHandshakeTimeout != 10*time.Second || tr.ExpectContinueTimeout != time.Second {
+		t.Fatalf("unexpected transport settings: %+v", tr)
+	}
+
+	client := &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	for _, url := range []string{httpSrv.URL, httpsSrv.URL} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Fatalf("GET %s: status %d, body %q", url, resp.StatusCode, body)
+		}
+	}
+}
+
+// writeCountingConn counts the Write calls made on a net.Conn.
+type writeCountingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *writeCountingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func TestDialer_Pipeline(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	defer socksLn.Close()
+
+	raw, err := net.Dial("tcp", socksLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial proxy: %v", err)
+	}
+	cc := &writeCountingConn{Conn: raw}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+	d.Pipeline = true
+	conn, err := d.DialConnContext(ctx, cc, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("pipelined dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if n := cc.writes.Load(); n != 1 {
+		t.Errorf("greeting and request took %d writes, want 1", n)
+	}
+	if m := conn.(*socks5.Conn).NegotiatedMethod(); m != socks5.MethodNoAuth {
+		t.Errorf("NegotiatedMethod = %d, want MethodNoAuth", m)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = (%q, %v), want ping", buf, err)
+	}
+}
+
+func TestDialer_DataAfterReply(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		var req socks5.Request
+		if _, err := hsReq.ReadFrom(c); err != nil {
+			return
+		}
+		hsReply := socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}
+		hsReply.WriteTo(c)
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+
+		// the reply and the first relayed bytes in one segment
+		var out bytes.Buffer
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}).WriteTo(&out)
+		out.WriteString("hello")
+		c.Write(out.Bytes())
+		io.Copy(io.Discard, c)
+	})
+	defer stop()
+
+	for _, pipeline := range []bool{false, true} {
+		d := socks5.NewDialer(proxyAddr, nil, nil)
+		d.Pipeline = pipeline
+		conn, err := d.DialContext(context.Background(), "tcp", "example.com:80")
+		if err != nil {
+			t.Fatalf("Pipeline=%v: dial failed: %v", pipeline, err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("Pipeline=%v: read after reply = (%q, %v), want hello", pipeline, buf, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestDialer_Pipeline_MethodRejected(t *testing.T) {
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		if _, err := hsReq.ReadFrom(c); err != nil {
+			return
+		}
+		hsReply := socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAcceptable}
+		hsReply.WriteTo(c)
+	})
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.Pipeline = true
+	if _, err := d.DialContext(context.Background(), "tcp", "example.com:80"); err == nil {
+		t.Fatal("expected error when the proxy rejects NoAuth")
+	}
+}
+
+// slowProxy serves one CONNECT, waiting delay before each reply.
+func slowProxy(t *testing.T, delay time.Duration) (string, func()) {
+	return startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		if _, err := hsReq.ReadFrom(c); err != nil {
+			return
+		}
+		time.Sleep(delay)
+		hsReply := socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}
+		if _, err := hsReply.WriteTo(c); err != nil {
+			return
+		}
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		time.Sleep(delay)
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}).WriteTo(c)
+		io.Copy(io.Discard, c)
+	})
+}
+
+func TestDialer_StepDeadlines(t *testing.T) {
+	// the proxy would take 3s; negotiation gets half of the 1s deadline
+	proxyAddr, stop := slowProxy(t, 1500*time.Millisecond)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.StepDeadlines = true
+
+	start := time.Now()
+	if conn, err := d.DialContext(ctx, "tcp", "example.com:80"); err == nil {
+		conn.Close()
+		t.Fatal("expected dial to time out")
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 900*time.Millisecond {
+		t.Fatalf("dial failed after %v, want about 500ms", elapsed)
+	}
+}
+
+func TestDialer_StepDeadlines_WithinBudget(t *testing.T) {
+	proxyAddr, stop := slowProxy(t, 200*time.Millisecond)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.StepDeadlines = true
+
+	conn, err := d.DialContext(ctx, "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	// deadlines are cleared once the proxy has replied
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read after dial = %v, want only the new deadline to expire", err)
+	}
+}
+
+func TestDialer_DialContextIP(t *testing.T) {
+	requests := make(chan socks5.Request, 1)
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		if _, err := hsReq.ReadFrom(c); err != nil {
+			return
+		}
+		hsReply := socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}
+		hsReply.WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		requests <- req
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1080}).WriteTo(c)
+	})
+	defer stop()
+
+	tests := []struct {
+		ip       net.IP
+		addrType socks5.AddrType
+	}{
+		{net.IPv4(192, 0, 2, 7), socks5.AddrTypeIPv4},
+		{net.ParseIP("2001:db8::1"), socks5.AddrTypeIPv6},
+	}
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	for _, tt := range tests {
+		conn, err := d.DialContextIP(context.Background(), "tcp", tt.ip, 8443)
+		if err != nil {
+			t.Fatalf("DialContextIP(%v) failed: %v", tt.ip, err)
+		}
+		conn.Close()
+
+		req := <-requests
+		if socks5.AddrType(req.AddrType) != tt.addrType || req.Domain != "" || !req.IP.Equal(tt.ip) || req.Port != 8443 {
+			t.Fatalf("proxy received %v, want ATYP %v for %v:8443", &req, tt.addrType, tt.ip)
+		}
+	}
+
+	if _, err := d.DialContextIP(context.Background(), "tcp", nil, 80); !errors.Is(err, socks5.ErrInvalidAddr) {
+		t.Fatalf("DialContextIP with nil IP = %v, want ErrInvalidAddr", err)
+	}
+}
+
+func TestDialer_RequestTimeout(t *testing.T) {
+	// the proxy accepts the connection but never answers the greeting
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+		io.Copy(io.Discard, c)
+	})
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.RequestTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	conn, err := d.DialContext(context.Background(), "tcp", "example.com:80")
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected dial to time out")
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("DialContext error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("dial failed after %v, want about 200ms", elapsed)
+	}
+}
+
+func TestDialer_RequestTimeout_WithinBudget(t *testing.T) {
+	proxyAddr, stop := slowProxy(t, 50*time.Millisecond)
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	d.RequestTimeout = time.Second
+
+	conn, err := d.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	// the timeout no longer applies once the proxy has replied
+	time.Sleep(1100 * time.Millisecond)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("read after dial = %v, want only the new deadline to expire", err)
+	}
+}
+
+// domainReplyProxy serves one request, replying with BND.ADDR domain; a BIND
+// gets a second reply.
+func domainReplyProxy(t *testing.T, domain string) (string, func()) {
+	return startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		hsReply := socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}
+		hsReply.WriteTo(c)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(c); err != nil {
+			return
+		}
+		socks5.NewDomainSuccessReply(domain, 5555).WriteTo(c)
+		if req.Command == socks5.CmdBind {
+			socks5.NewDomainSuccessReply(domain, 5555).WriteTo(c)
+		}
+		io.Copy(io.Discard, c)
+	})
+}
+
+func TestDialer_Bind_DomainReply(t *testing.T) {
+	proxyAddr, stop := domainReplyProxy(t, "localhost")
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	conn, bindAddr, readyCh, err := d.BindContext(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("BindContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	if !bindAddr.IP.IsLoopback() || bindAddr.Port != 5555 {
+		t.Errorf("bind address = %v, want localhost resolved with port 5555", bindAddr)
+	}
+	if err := <-readyCh; err != nil {
+		t.Fatalf("bind ready failed: %v", err)
+	}
+}
+
+func TestDialer_Bind_DomainReply_Unresolvable(t *testing.T) {
+	proxyAddr, stop := domainReplyProxy(t, "proxy.invalid")
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	if conn, _, _, err := d.BindContext(context.Background(), "tcp", "127.0.0.1:0"); err == nil {
+		conn.Close()
+		t.Fatal("expected error for unresolvable BIND address")
+	}
+}
+
+func TestDialer_Connect_DomainReply(t *testing.T) {
+	// CONNECT does not resolve BND.ADDR, so even an unresolvable name works
+	proxyAddr, stop := domainReplyProxy(t, "proxy.invalid")
+	defer stop()
+
+	conn, err := socks5.NewDialer(proxyAddr, nil, nil).DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	if bound, ok := conn.(*socks5.Conn).BoundAddr().(*socks5.Addr); !ok || bound.Domain != "proxy.invalid" || bound.Port != 5555 {
+		t.Errorf("BoundAddr = %v, want proxy.invalid:5555", conn.(*socks5.Conn).BoundAddr())
+	}
+}
+
+// countingLimiter allows everything, counting the bytes it was asked for.
+type countingLimiter struct {
+	n atomic.Int64
+}
+
+func (l *countingLimiter) WaitN(ctx context.Context, n int) error {
+	l.n.Add(int64(n))
+	return nil
+}
+
+func TestDialer_RateLimit(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+	})
+	defer socksLn.Close()
+
+	var up, down countingLimiter
+	d := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+	d.LimitUp, d.LimitDown = &up, &down
+
+	conn, err := d.DialContext(context.Background(), "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*socksnet.RateLimitedConn); !ok {
+		t.Fatalf("DialContext returned %T, want *socksnet.RateLimitedConn", conn)
+	}
+
+	payload := genRandom(64 * 1024)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("read back (%v), want the echoed payload", err)
+	}
+
+	// the handshake is not limited, only the relayed data
+	if up.n.Load() != int64(len(payload)) || down.n.Load() != int64(len(payload)) {
+		t.Fatalf("limiters allowed up=%d down=%d, want %d each", up.n.Load(), down.n.Load(), len(payload))
+	}
+}
+
+func TestDialer_Resolve_IPLiteral(t *testing.T) {
+	var dialed atomic.Int32
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		dialed.Add(1)
+		c.Close()
+	})
+	defer stop()
+
+	d := socks5.NewDialer(proxyAddr, nil, nil)
+	for _, host := range []string{"192.0.2.7", "2001:db8::1"} {
+		ip, err := d.ResolveContext(context.Background(), "tcp", host)
+		if err != nil || !ip.Equal(net.ParseIP(host)) {
+			t.Fatalf("ResolveContext(%s) = (%v, %v), want the literal", host, ip, err)
+		}
+	}
+	if n := dialed.Load(); n != 0 {
+		t.Fatalf("proxy was dialed %d times for IP literals", n)
+	}
+}
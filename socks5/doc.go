@@ -0,0 +1,13 @@
+// Package socks5 implements the SOCKS5 protocol (RFC 1928), with username/password
+// (RFC 1929) and GSSAPI (RFC 1961) authentication: message codecs, a Dialer and a server.
+//
+// The ReadFrom methods of protocol messages return io.EOF only if the reader ends
+// before the first byte of the message. A message cut off after that fails with
+// io.ErrUnexpectedEOF, so a clean close can be told apart from a truncated message.
+// All messages implement Message, so ReadMessage and WriteMessage can read or
+// write any of them with a deadline.
+//
+// MarshalBinary returns the encoding written by WriteTo, and UnmarshalBinary
+// decodes data as ReadFrom does. data must hold exactly one message; trailing
+// bytes fail with ErrTrailingData. A UDPPacket's trailing bytes are its payload.
+package socks5
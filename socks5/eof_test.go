@@ -0,0 +1,97 @@
+package socks5_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func Test_ReadFrom_EOFContract(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     io.ReaderFrom
+		field   string // field reported as truncated
+		partial []byte // a valid message prefix
+	}{
+		{"Reply after header", &socks5.Reply{}, "BND.ADDR", []byte{socks5.SocksVersion, socks5.RepSuccess, 0x00, socks5.AddrTypeIPv4}},
+		{"Reply after domain length", &socks5.Reply{}, "BND.ADDR", []byte{socks5.SocksVersion, socks5.RepSuccess, 0x00, socks5.AddrTypeDomain, 0x04}},
+		{"Request after header", &socks5.Request{}, "DST.ADDR", []byte{socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeIPv6}},
+		{"Addr after type", &socks5.Addr{}, "ADDR", []byte{socks5.AddrTypeIPv4}},
+		{"HandshakeRequest after header", &socks5.HandshakeRequest{}, "METHODS", []byte{socks5.SocksVersion, 0x01}},
+		{"HandshakeReply partial", &socks5.HandshakeReply{}, "header", []byte{socks5.SocksVersion}},
+		{"UserPassRequest after username", &socks5.UserPassRequest{}, "PLEN", []byte{0x01, 0x01, 'u'}},
+		{"UserPassRequest after password length", &socks5.UserPassRequest{}, "PASSWD", []byte{0x01, 0x01, 'u', 0x01}},
+		{"UserPassReply partial", &socks5.UserPassReply{}, "header", []byte{0x01}},
+		{"GSSAPIRequest after type", &socks5.GSSAPIRequest{}, "LEN", []byte{socks5.GSSAPIVersion, socks5.GSSAPITypeInit}},
+		{"GSSAPIReply after length", &socks5.GSSAPIReply{}, "TOKEN", []byte{socks5.GSSAPIVersion, socks5.GSSAPITypeReply, 0x00, 0x02}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing sent: clean EOF
+			if _, err := tt.msg.ReadFrom(bytes.NewReader(nil)); err != io.EOF {
+				t.Errorf("empty input: got %v, want io.EOF", err)
+			}
+
+			// closed mid-message: truncated
+			_, err := tt.msg.ReadFrom(bytes.NewReader(tt.partial))
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("partial input: got %v, want io.ErrUnexpectedEOF", err)
+			}
+
+			var pe *socks5.ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("partial input: expected ParseError, got %T", err)
+			}
+			if pe.Field != tt.field || pe.Offset != int64(len(tt.partial)) {
+				t.Errorf("partial input: got field %q at offset %d, want %q at %d", pe.Field, pe.Offset, tt.field, len(tt.partial))
+			}
+		})
+	}
+}
+
+func Test_UDPPacket_ReadFrom_EOF(t *testing.T) {
+	var p socks5.UDPPacket
+	if _, err := p.ReadFrom(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("empty input: got %v, want io.EOF", err)
+	}
+	if _, err := p.ReadFrom(bytes.NewReader([]byte{0, 0, 0, socks5.AddrTypeIPv4, 127})); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("partial input: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func Test_ReadFrom_ParseError_Sentinel(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    io.ReaderFrom
+		data   []byte
+		want   error
+		field  string
+		offset int64
+	}{
+		{"Request version", &socks5.Request{}, []byte{0x04, socks5.CmdConnect, 0x00, socks5.AddrTypeIPv4}, socks5.ErrInvalidVersion, "VER", 4},
+		{"Request address type", &socks5.Request{}, []byte{socks5.SocksVersion, socks5.CmdConnect, 0x00, 0x09}, socks5.ErrInvalidAddr, "ATYP", 4},
+		{"Request empty domain", &socks5.Request{}, []byte{socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeDomain, 0x00}, socks5.ErrInvalidDomain, "ADDR", 5},
+		{"HandshakeRequest no methods", &socks5.HandshakeRequest{}, []byte{socks5.SocksVersion, 0x00}, socks5.ErrNoMethodsProvided, "NMETHODS", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.msg.ReadFrom(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			var pe *socks5.ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected ParseError, got %T", err)
+			}
+			if pe.Field != tt.field || pe.Offset != tt.offset {
+				t.Errorf("got field %q at offset %d, want %q at %d", pe.Field, pe.Offset, tt.field, tt.offset)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package socks5_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func Test_Equal_IPForms(t *testing.T) {
+	ip16 := net.IPv4(10, 0, 0, 1)
+	ip4 := ip16.To4()
+
+	if a, b := (&socks5.Request{AddrType: socks5.AddrTypeIPv4, IP: ip16, Port: 80}), (&socks5.Request{AddrType: socks5.AddrTypeIPv4, IP: ip4, Port: 80}); !a.Equal(b) {
+		t.Error("Request: 4- and 16-byte forms of the same IPv4 address differ")
+	}
+	if a, b := (&socks5.Reply{IP: ip16}), (&socks5.Reply{IP: ip4}); !a.Equal(b) {
+		t.Error("Reply: 4- and 16-byte forms of the same IPv4 address differ")
+	}
+	if a, b := (&socks5.Addr{IP: ip16}), (&socks5.Addr{IP: ip4}); !a.Equal(b) {
+		t.Error("Addr: 4- and 16-byte forms of the same IPv4 address differ")
+	}
+	if a, b := (&socks5.UDPPacket{IP: ip16}), (&socks5.UDPPacket{IP: ip4}); !a.Equal(b) {
+		t.Error("UDPPacket: 4- and 16-byte forms of the same IPv4 address differ")
+	}
+
+	if a, b := (&socks5.Request{IP: ip4}), (&socks5.Request{IP: net.ParseIP("::ffff:10.0.0.2")}); a.Equal(b) {
+		t.Error("Request: different addresses compare equal")
+	}
+	if a, b := (&socks5.Request{IP: ip4}), (&socks5.Request{}); a.Equal(b) {
+		t.Error("Request: address compares equal to nil IP")
+	}
+}
+
+func Test_Equal_NilAndEmpty(t *testing.T) {
+	ip := net.IPv4(10, 0, 0, 1).To4()
+
+	tests := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"nil and empty IP", (&socks5.Request{}).Equal(&socks5.Request{IP: net.IP{}}), true},
+		{"nil and empty Methods", (&socks5.HandshakeRequest{}).Equal(&socks5.HandshakeRequest{Methods: []byte{}}), true},
+		{"nil and empty Token", (&socks5.GSSAPIReply{}).Equal(&socks5.GSSAPIReply{Token: []byte{}}), true},
+		{"nil and empty Data", (&socks5.UDPPacket{}).Equal(&socks5.UDPPacket{Data: []byte{}}), true},
+		{"domain and IP target",
+			(&socks5.Request{AddrType: socks5.AddrTypeDomain, Domain: ip.String(), Port: 80}).Equal(
+				&socks5.Request{AddrType: socks5.AddrTypeIPv4, IP: ip, Port: 80}),
+			false},
+	}
+
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: Equal = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// nil pointers equal only each other
+	var nilReq *socks5.Request
+	if !nilReq.Equal(nil) || nilReq.Equal(&socks5.Request{}) || (&socks5.Request{}).Equal(nil) {
+		t.Error("unexpected result comparing nil requests")
+	}
+}
+
+func Test_Clone(t *testing.T) {
+	req := &socks5.Request{Version: 5, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1).To4(), Port: 80}
+	reqClone := req.Clone()
+	reqClone.IP[3] = 2
+	if !req.IP.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Error("Request clone shares IP")
+	}
+
+	pkt := &socks5.UDPPacket{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1).To4(), Port: 53, Data: []byte("query")}
+	pktClone := pkt.Clone()
+	if !pktClone.Equal(pkt) {
+		t.Fatalf("clone %v differs from %v", pktClone, pkt)
+	}
+	pktClone.Data[0] = 'Q'
+	pktClone.IP[0] = 11
+	if string(pkt.Data) != "query" || pkt.IP[0] != 10 {
+		t.Error("UDPPacket clone shares buffers")
+	}
+
+	hs := &socks5.HandshakeRequest{Version: 5, NMethods: 1, Methods: []byte{socks5.MethodNoAuth}}
+	hsClone := hs.Clone()
+	hsClone.Methods[0] = socks5.MethodGSSAPI
+	if hs.Methods[0] != socks5.MethodNoAuth {
+		t.Error("HandshakeRequest clone shares Methods")
+	}
+
+	tok := &socks5.GSSAPIRequest{Version: 1, MsgType: socks5.GSSAPITypeInit, Token: []byte("t")}
+	tokClone := tok.Clone()
+	tokClone.Token[0] = 'x'
+	if string(tok.Token) != "t" {
+		t.Error("GSSAPIRequest clone shares Token")
+	}
+
+	// nil and empty slices keep their form
+	if c := (&socks5.GSSAPIReply{}).Clone(); c.Token != nil {
+		t.Error("clone of nil Token is not nil")
+	}
+	if c := (&socks5.GSSAPIReply{Token: []byte{}}).Clone(); c.Token == nil {
+		t.Error("clone of empty Token is nil")
+	}
+
+	var nilReply *socks5.Reply
+	if nilReply.Clone() != nil {
+		t.Error("clone of nil Reply is not nil")
+	}
+	hr := &socks5.HandshakeReply{Version: 5, Method: socks5.MethodUserPass}
+	if c := hr.Clone(); c == hr || !c.Equal(hr) {
+		t.Error("HandshakeReply clone is not an equal copy")
+	}
+}
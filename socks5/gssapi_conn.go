@@ -0,0 +1,174 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// Errors for GSSAPI per-message protection.
+var (
+	ErrUnexpectedGSSAPIMessage = errors.New("unexpected GSSAPI message type")
+	ErrInvalidGSSAPIProtection = errors.New("invalid GSSAPI protection level")
+)
+
+// maxGSSAPIWrapChunk is the largest plaintext wrapped into a single token,
+// leaving room for the mechanism's own overhead within the 65535-byte limit.
+const maxGSSAPIWrapChunk = 32 * 1024
+
+// GSSAPIMechanism provides GSSAPI per-message protection (gss_wrap/gss_unwrap)
+// for an established security context.
+type GSSAPIMechanism interface {
+	// Wrap protects a plaintext message and returns the token to send.
+	Wrap(msg []byte) ([]byte, error)
+	// Unwrap verifies and decodes a received token and returns the plaintext.
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// GSSAPIWrappedConn is a net.Conn that encapsulates all traffic in GSSAPI
+// tokens once per-message protection has been negotiated (RFC 1961 §5).
+// Each Write is sent as one or more encapsulated messages
+// (VER, MTYP=GSSAPITypeEncapsulated, LEN, TOKEN); Read returns unwrapped data.
+type GSSAPIWrappedConn struct {
+	net.Conn
+	mech GSSAPIMechanism
+	opts GSSAPIValidateOptions // Limits of the tokens read
+
+	rmu     sync.Mutex
+	pending []byte // unwrapped data not yet returned by Read
+
+	wmu sync.Mutex
+}
+
+// NewGSSAPIWrappedConn returns conn protected with mech.
+func NewGSSAPIWrappedConn(conn net.Conn, mech GSSAPIMechanism) *GSSAPIWrappedConn {
+	return &GSSAPIWrappedConn{Conn: conn, mech: mech}
+}
+
+// Read reads and unwraps encapsulated messages from the underlying connection.
+func (c *GSSAPIWrappedConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	for len(c.pending) == 0 {
+		var msg GSSAPIEncapsulation
+		if _, err := msg.ReadFromWithOptions(c.Conn, c.opts); err != nil {
+			return 0, err
+		}
+		if msg.MsgType != GSSAPITypeEncapsulated {
+			return 0, ErrUnexpectedGSSAPIMessage
+		}
+
+		data, err := c.mech.Unwrap(msg.Token)
+		if err != nil {
+			return 0, err
+		}
+		c.pending = data
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Write wraps p and writes it to the underlying connection.
+func (c *GSSAPIWrappedConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:min(written+maxGSSAPIWrapChunk, len(p))]
+
+		token, err := c.mech.Wrap(chunk)
+		if err != nil {
+			return written, err
+		}
+
+		var msg GSSAPIEncapsulation
+		msg.Init(GSSAPIVersion, GSSAPITypeEncapsulated, token)
+		if _, err := msg.WriteTo(c.Conn); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// CloseWrite closes the write side of the underlying connection if supported.
+func (c *GSSAPIWrappedConn) CloseWrite() error {
+	return socksnet.CloseWrite(c.Conn)
+}
+
+// NegotiateGSSAPIProtection performs the client side of the protection-level
+// sub-negotiation (RFC 1961 §4): it sends the requested level wrapped with mech
+// and returns the level chosen by the server.
+func NegotiateGSSAPIProtection(conn io.ReadWriter, mech GSSAPIMechanism, level byte) (byte, error) {
+	if err := writeGSSAPIProtection(conn, mech, level); err != nil {
+		return GSSAPIProtNone, err
+	}
+	return readGSSAPIProtection(conn, mech, GSSAPIValidateOptions{})
+}
+
+// AcceptGSSAPIProtection performs the server side of the protection-level
+// sub-negotiation. The server grants level, or the client's requested level if
+// level is GSSAPIProtNone, and returns the granted level.
+func AcceptGSSAPIProtection(conn io.ReadWriter, mech GSSAPIMechanism, level byte) (byte, error) {
+	return acceptGSSAPIProtectionLevel(conn, mech, level, GSSAPIValidateOptions{})
+}
+
+// acceptGSSAPIProtectionLevel is AcceptGSSAPIProtection reading the client's
+// message with the token limits of opts.
+func acceptGSSAPIProtectionLevel(conn io.ReadWriter, mech GSSAPIMechanism, level byte, opts GSSAPIValidateOptions) (byte, error) {
+	requested, err := readGSSAPIProtection(conn, mech, opts)
+	if err != nil {
+		return GSSAPIProtNone, err
+	}
+	if level == GSSAPIProtNone {
+		level = requested
+	}
+	if err := writeGSSAPIProtection(conn, mech, level); err != nil {
+		return GSSAPIProtNone, err
+	}
+	return level, nil
+}
+
+// writeGSSAPIProtection sends a wrapped protection-level message.
+func writeGSSAPIProtection(w io.Writer, mech GSSAPIMechanism, level byte) error {
+	if level < GSSAPIProtIntegrity || level > GSSAPIProtSelective {
+		return ErrInvalidGSSAPIProtection
+	}
+
+	token, err := mech.Wrap([]byte{level})
+	if err != nil {
+		return err
+	}
+
+	var msg GSSAPIEncapsulation
+	msg.Init(GSSAPIVersion, GSSAPITypeReply, token)
+	_, err = msg.WriteTo(w)
+	return err
+}
+
+// readGSSAPIProtection reads and unwraps a protection-level message.
+func readGSSAPIProtection(r io.Reader, mech GSSAPIMechanism, opts GSSAPIValidateOptions) (byte, error) {
+	var msg GSSAPIEncapsulation
+	if _, err := msg.ReadFromWithOptions(r, opts); err != nil {
+		return GSSAPIProtNone, err
+	}
+	if msg.MsgType != GSSAPITypeReply {
+		return GSSAPIProtNone, ErrUnexpectedGSSAPIMessage
+	}
+
+	data, err := mech.Unwrap(msg.Token)
+	if err != nil {
+		return GSSAPIProtNone, err
+	}
+	if len(data) != 1 || data[0] < GSSAPIProtIntegrity || data[0] > GSSAPIProtSelective {
+		return GSSAPIProtNone, ErrInvalidGSSAPIProtection
+	}
+	return data[0], nil
+}
@@ -0,0 +1,263 @@
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// xorMechanism is a fake GSSAPIMechanism that XORs every byte with a key.
+type xorMechanism byte
+
+func (m xorMechanism) Wrap(msg []byte) ([]byte, error) {
+	out := make([]byte, len(msg))
+	for i, b := range msg {
+		out[i] = b ^ byte(m)
+	}
+	return out, nil
+}
+
+func (m xorMechanism) Unwrap(token []byte) ([]byte, error) {
+	return m.Wrap(token)
+}
+
+func Test_GSSAPIWrappedConn_RequestReply(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	const mech = xorMechanism(0x5a)
+	wc := socks5.NewGSSAPIWrappedConn(client, mech)
+
+	req := socks5.Request{
+		Version:  socks5.SocksVersion,
+		Command:  socks5.CmdConnect,
+		AddrType: socks5.AddrTypeDomain,
+		Domain:   "example.com",
+		Port:     443,
+	}
+	var plain bytes.Buffer
+	req.WriteTo(&plain)
+
+	go req.WriteTo(wc)
+
+	// the request travels as a single encapsulated token
+	var msg socks5.GSSAPIRequest
+	if _, err := msg.ReadFrom(server); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if msg.MsgType != socks5.GSSAPITypeEncapsulated {
+		t.Fatalf("MsgType = %#x, want %#x", msg.MsgType, socks5.GSSAPITypeEncapsulated)
+	}
+	if bytes.Equal(msg.Token, plain.Bytes()) {
+		t.Fatal("request was sent unwrapped")
+	}
+	if got, _ := mech.Unwrap(msg.Token); !bytes.Equal(got, plain.Bytes()) {
+		t.Fatalf("unwrapped token = %x, want %x", got, plain.Bytes())
+	}
+
+	// the reply is unwrapped transparently
+	sc := socks5.NewGSSAPIWrappedConn(server, mech)
+	go socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8080}).WriteTo(sc)
+
+	var reply socks5.Reply
+	if _, err := reply.ReadFrom(wc); err != nil {
+		t.Fatalf("Reply.ReadFrom failed: %v", err)
+	}
+	if reply.Reply != socks5.RepSuccess || !reply.IP.Equal(net.IPv4(10, 0, 0, 1)) || reply.Port != 8080 {
+		t.Fatalf("unexpected reply: %v", &reply)
+	}
+}
+
+func Test_GSSAPIWrappedConn_UnexpectedMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	wc := socks5.NewGSSAPIWrappedConn(client, xorMechanism(0x5a))
+
+	go (&socks5.GSSAPIReply{Version: socks5.GSSAPIVersion, MsgType: socks5.GSSAPITypeReply, Token: []byte("x")}).WriteTo(server)
+
+	if _, err := wc.Read(make([]byte, 16)); !errors.Is(err, socks5.ErrUnexpectedGSSAPIMessage) {
+		t.Fatalf("expected ErrUnexpectedGSSAPIMessage, got %v", err)
+	}
+}
+
+func Test_GSSAPIProtection_Negotiate(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	const mech = xorMechanism(0x3c)
+
+	done := make(chan byte, 1)
+	go func() {
+		level, err := socks5.AcceptGSSAPIProtection(server, mech, socks5.GSSAPIProtIntegrity)
+		if err != nil {
+			t.Errorf("AcceptGSSAPIProtection failed: %v", err)
+		}
+		done <- level
+	}()
+
+	// the server's choice wins over the requested level
+	level, err := socks5.NegotiateGSSAPIProtection(client, mech, socks5.GSSAPIProtConfidentiality)
+	if err != nil {
+		t.Fatalf("NegotiateGSSAPIProtection failed: %v", err)
+	}
+	if level != socks5.GSSAPIProtIntegrity || <-done != socks5.GSSAPIProtIntegrity {
+		t.Fatalf("agreed level = %d, want %d", level, socks5.GSSAPIProtIntegrity)
+	}
+}
+
+func Test_GSSAPIProtection_InvalidLevel(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	if _, err := socks5.NegotiateGSSAPIProtection(client, xorMechanism(0x3c), 0x07); !errors.Is(err, socks5.ErrInvalidGSSAPIProtection) {
+		t.Fatalf("expected ErrInvalidGSSAPIProtection, got %v", err)
+	}
+
+	// a well-framed message carrying an unknown level is rejected on receipt
+	go (&socks5.GSSAPIEncapsulation{Version: socks5.GSSAPIVersion, MsgType: socks5.GSSAPITypeReply, Token: []byte{0x00}}).WriteTo(client)
+	if _, err := socks5.AcceptGSSAPIProtection(server, xorMechanism(0), socks5.GSSAPIProtNone); !errors.Is(err, socks5.ErrInvalidGSSAPIProtection) {
+		t.Fatalf("expected ErrInvalidGSSAPIProtection, got %v", err)
+	}
+}
+
+func TestDialer_Connect_WithGSSAPI_Protected(t *testing.T) {
+	const mech = xorMechanism(0xa5)
+
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodGSSAPI}).WriteTo(c)
+
+		var gssReq socks5.GSSAPIRequest
+		if _, err := gssReq.ReadFrom(c); err != nil {
+			t.Errorf("server: read GSSAPI request: %v", err)
+			return
+		}
+		(&socks5.GSSAPIReply{
+			Version: socks5.GSSAPIVersion,
+			MsgType: socks5.GSSAPITypeReply,
+			Token:   []byte("server-success-token"),
+		}).WriteTo(c)
+
+		level, err := socks5.AcceptGSSAPIProtection(c, mech, socks5.GSSAPIProtNone)
+		if err != nil || level != socks5.GSSAPIProtConfidentiality {
+			t.Errorf("server: protection negotiation: level=%d, err=%v", level, err)
+			return
+		}
+
+		// everything after the protection-level agreement is encapsulated
+		wc := socks5.NewGSSAPIWrappedConn(c, mech)
+
+		var req socks5.Request
+		if _, err := req.ReadFrom(wc); err != nil {
+			t.Errorf("server: read wrapped request: %v", err)
+			return
+		}
+		if req.Port != 1234 {
+			t.Errorf("server: unexpected request: %v", &req)
+			return
+		}
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}).WriteTo(wc)
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(wc, buf); err != nil {
+			return
+		}
+		wc.Write([]byte("pong"))
+	})
+	defer stop()
+
+	gssapiAuth := &socks5.GSSAPIAuth{
+		Context:   &dialerMockGSSAPIContext_Success{},
+		Mechanism: mech,
+	}
+	d := socks5.NewDialerWithGSSAPI(proxyAddr, nil, gssapiAuth, nil)
+	conn, err := d.DialContext(context.Background(), "tcp", "127.0.0.1:1234")
+	if err != nil {
+		t.Fatalf("DialContext with protected GSSAPI failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("expected pong, got %q", buf)
+	}
+}
+
+func TestBaseServerHandler_GSSAPI_Protected(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	const mech = xorMechanism(0x77)
+
+	// the relay must run over the protected connection
+	var sawPlain atomic.Bool
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:     2 * time.Second,
+		ConnectConnTimeout: 2 * time.Second,
+		AllowConnect:       true,
+		SupportedMethods:   []byte{socks5.MethodGSSAPI},
+		GSSAPIMechanism: func(ctx context.Context, conn net.Conn) socks5.GSSAPIMechanism {
+			return mech
+		},
+		BeforeRelay: func(ctx context.Context, clientConn, targetConn net.Conn, req *socks5.Request) error {
+			if _, ok := clientConn.(*socks5.GSSAPIWrappedConn); !ok {
+				sawPlain.Store(true)
+			}
+			return nil
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	gssapiAuth := &socks5.GSSAPIAuth{
+		Context:   &serverMockGSSAPIContext_Success{},
+		Mechanism: mech,
+	}
+	dialer := socks5.NewDialerWithGSSAPI(socksLn.Addr().String(), nil, gssapiAuth, nil)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	if _, ok := conn.(*socks5.Conn).NetConn().(*socks5.GSSAPIWrappedConn); !ok {
+		t.Fatalf("expected a protected connection, got %T", conn.(*socks5.Conn).NetConn())
+	}
+
+	payload := genRandom(40 * 1024) // spans several tokens
+	go conn.Write(payload)
+
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(payload, buf) {
+		t.Fatal("echo mismatch")
+	}
+	if sawPlain.Load() {
+		t.Fatal("server relayed an unprotected client connection")
+	}
+}
@@ -0,0 +1,156 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/33TU/socks/internal"
+)
+
+// GSSAPIEncapsulation represents a GSSAPI-protected message sent after
+// authentication (RFC 1961 §4-5): the protection-level sub-negotiation
+// (MTYP=GSSAPITypeReply) and encapsulated data (MTYP=GSSAPITypeEncapsulated).
+// The token is the output of gss_wrap; see GSSAPIMechanism.
+type GSSAPIEncapsulation struct {
+	Version byte   // VER (should always be 0x01)
+	MsgType byte   // MTYP (0x02 = protection level, 0x03 = encapsulated data)
+	Token   []byte // TOKEN (wrapped payload)
+}
+
+// Init initializes a GSSAPI encapsulated message.
+func (m *GSSAPIEncapsulation) Init(version, msgType byte, token []byte) {
+	m.Version = version
+	m.MsgType = msgType
+	m.Token = token
+}
+
+// Validate checks for protocol correctness.
+func (m *GSSAPIEncapsulation) Validate() error {
+	if m.Version != GSSAPIVersion {
+		return ErrInvalidGSSAPIVersion
+	}
+	if m.MsgType != GSSAPITypeReply && m.MsgType != GSSAPITypeEncapsulated {
+		return ErrUnexpectedGSSAPIMessage
+	}
+	if len(m.Token) > 65535 {
+		return ErrGSSAPITokenTooLong
+	}
+	return nil
+}
+
+// ReadFrom reads a GSSAPI encapsulated message from a reader.
+// Other message types are rejected before their length is read.
+func (m *GSSAPIEncapsulation) ReadFrom(src io.Reader) (int64, error) {
+	return m.ReadFromWithOptions(src, GSSAPIValidateOptions{})
+}
+
+// ReadFromWithOptions is ReadFrom rejecting tokens longer than the maximum of
+// opts before they are read. The minimum does not apply.
+func (m *GSSAPIEncapsulation) ReadFromWithOptions(src io.Reader, opts GSSAPIValidateOptions) (int64, error) {
+	var hdr [4]byte
+	n, err := io.ReadFull(src, hdr[:2])
+	if err != nil {
+		return int64(n), parseError(msgGSSAPIEncapsulation, "header", int64(n), err)
+	}
+
+	m.Version = hdr[0]
+	m.MsgType = hdr[1]
+	m.Token = nil
+	if err := m.Validate(); err != nil {
+		return int64(n), parseError(msgGSSAPIEncapsulation, "", int64(n), err)
+	}
+
+	// Read length
+	n2, err := io.ReadFull(src, hdr[2:4])
+	n += n2
+	if err != nil {
+		return int64(n), parseError(msgGSSAPIEncapsulation, "LEN", int64(n), internal.UnexpectedEOF(err))
+	}
+
+	length := binary.BigEndian.Uint16(hdr[2:4])
+	if length == 0 {
+		return int64(n), nil
+	}
+	if int(length) > opts.maxLen() {
+		return int64(n), parseError(msgGSSAPIEncapsulation, "LEN", int64(n), ErrGSSAPITokenTooLong)
+	}
+
+	token := make([]byte, length)
+	n3, err := io.ReadFull(src, token)
+	total := int64(n + n3)
+	if err != nil {
+		return total, parseError(msgGSSAPIEncapsulation, "TOKEN", total, internal.UnexpectedEOF(err))
+	}
+
+	m.Token = token
+	return total, nil
+}
+
+// WriteTo writes the GSSAPI encapsulated message to a writer.
+func (m *GSSAPIEncapsulation) WriteTo(dst io.Writer) (int64, error) {
+	if err := m.Validate(); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 0, 4+len(m.Token))
+	buf = append(buf,
+		m.Version,
+		m.MsgType,
+		byte(len(m.Token)>>8),
+		byte(len(m.Token)),
+	)
+	buf = append(buf, m.Token...)
+
+	n, err := dst.Write(buf)
+	return int64(n), err
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for GSSAPIEncapsulation.
+func (m *GSSAPIEncapsulation) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(m)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for GSSAPIEncapsulation.
+func (m *GSSAPIEncapsulation) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(m, data)
+}
+
+// Equal reports whether GSSAPIEncapsulation m and other wrap the same token.
+// A nil and an empty Token are equal.
+func (m *GSSAPIEncapsulation) Equal(other *GSSAPIEncapsulation) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+	return m.Version == other.Version &&
+		m.MsgType == other.MsgType &&
+		bytes.Equal(m.Token, other.Token)
+}
+
+// Clone returns a deep copy of m.
+func (m *GSSAPIEncapsulation) Clone() *GSSAPIEncapsulation {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	c.Token = bytes.Clone(m.Token)
+	return &c
+}
+
+// String returns a human-readable representation.
+func (m *GSSAPIEncapsulation) String() string {
+	return fmt.Sprintf(
+		"GSSAPIEncapsulation{Version=%d, MsgType=0x%02x, TokenLen=%d}",
+		m.Version, m.MsgType, len(m.Token),
+	)
+}
+
+// LogValue implements slog.LogValuer. Only the token length is included.
+func (m *GSSAPIEncapsulation) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("mtyp", fmt.Sprintf("0x%02x", m.MsgType)),
+		slog.Int("token_len", len(m.Token)),
+	)
+}
@@ -0,0 +1,79 @@
+package socks5_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func Test_GSSAPIEncapsulation_Init_And_Validate(t *testing.T) {
+	var m socks5.GSSAPIEncapsulation
+	m.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeEncapsulated, []byte{0xca, 0xfe})
+
+	if err := m.Validate(); err != nil {
+		t.Fatalf("expected valid message, got %v", err)
+	}
+
+	// Protection-level messages share the framing
+	m.MsgType = socks5.GSSAPITypeReply
+	if err := m.Validate(); err != nil {
+		t.Errorf("expected protection-level message to be valid, got %v", err)
+	}
+
+	m.MsgType = socks5.GSSAPITypeInit
+	if err := m.Validate(); !errors.Is(err, socks5.ErrUnexpectedGSSAPIMessage) {
+		t.Errorf("expected ErrUnexpectedGSSAPIMessage, got %v", err)
+	}
+
+	m.MsgType = socks5.GSSAPITypeEncapsulated
+	m.Version = 0x02
+	if err := m.Validate(); !errors.Is(err, socks5.ErrInvalidGSSAPIVersion) {
+		t.Errorf("expected ErrInvalidGSSAPIVersion, got %v", err)
+	}
+
+	m.Version = socks5.GSSAPIVersion
+	m.Token = make([]byte, 70000)
+	if err := m.Validate(); !errors.Is(err, socks5.ErrGSSAPITokenTooLong) {
+		t.Errorf("expected ErrGSSAPITokenTooLong, got %v", err)
+	}
+}
+
+func Test_GSSAPIEncapsulation_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
+	orig := &socks5.GSSAPIEncapsulation{}
+	orig.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeEncapsulated, []byte{0xde, 0xad, 0xbe, 0xef})
+
+	var buf bytes.Buffer
+	n1, err := orig.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	var parsed socks5.GSSAPIEncapsulation
+	n2, err := parsed.ReadFrom(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+
+	if n1 != n2 || n1 != 8 {
+		t.Errorf("byte count mismatch: wrote %d, read %d", n1, n2)
+	}
+	if parsed.MsgType != orig.MsgType || !bytes.Equal(parsed.Token, orig.Token) {
+		t.Errorf("mismatch: got %v, want %v", &parsed, orig)
+	}
+}
+
+func Test_GSSAPIEncapsulation_ReadFrom_UnexpectedType(t *testing.T) {
+	// an abort has no length, so the header alone must be enough to reject it
+	r := bytes.NewReader([]byte{socks5.GSSAPIVersion, socks5.GSSAPITypeAbort})
+
+	var m socks5.GSSAPIEncapsulation
+	n, err := m.ReadFrom(r)
+	if !errors.Is(err, socks5.ErrUnexpectedGSSAPIMessage) {
+		t.Fatalf("expected ErrUnexpectedGSSAPIMessage, got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 bytes read, got %d", n)
+	}
+}
@@ -1,17 +1,21 @@
 package socks5
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Errors for GSSAPI authentication replies.
 var (
 	ErrInvalidGSSAPIReplyVersion = errors.New("invalid GSSAPI reply version (must be 1)")
 	ErrInvalidGSSAPIMsgType      = errors.New("invalid GSSAPI message type")
-	ErrGSSAPIReplyTooLong        = errors.New("GSSAPI reply token too long (max 65535)")
+	ErrGSSAPIReplyTooLong        = errors.New("GSSAPI reply token too long")
 )
 
 // GSSAPIReply represents a GSSAPI authentication reply message (RFC 1961 §3.7).
@@ -30,6 +34,11 @@ func (r *GSSAPIReply) Init(version, msgType byte, token []byte) {
 
 // Validate checks for protocol correctness.
 func (r *GSSAPIReply) Validate() error {
+	return r.ValidateWithOptions(GSSAPIValidateOptions{})
+}
+
+// ValidateWithOptions is Validate with the token length limits of opts.
+func (r *GSSAPIReply) ValidateWithOptions(opts GSSAPIValidateOptions) error {
 	if r.Version != GSSAPIVersion {
 		return ErrInvalidGSSAPIReplyVersion
 	}
@@ -43,21 +52,23 @@ func (r *GSSAPIReply) Validate() error {
 		return ErrInvalidGSSAPIMsgType
 	}
 
-	if len(r.Token) > 65535 {
-		return ErrGSSAPIReplyTooLong
-	}
-
-	return nil
+	return opts.check(r.Token, ErrGSSAPIReplyTooLong)
 }
 
 // ReadFrom reads a GSSAPI reply from a reader.
 func (r *GSSAPIReply) ReadFrom(src io.Reader) (int64, error) {
+	return r.ReadFromWithOptions(src, GSSAPIValidateOptions{})
+}
+
+// ReadFromWithOptions is ReadFrom validating the token with the limits of opts.
+// A LEN above the maximum fails before the token is read.
+func (r *GSSAPIReply) ReadFromWithOptions(src io.Reader, opts GSSAPIValidateOptions) (int64, error) {
 	var hdr [4]byte
 
 	// Read VER + MTYP
 	n, err := io.ReadFull(src, hdr[:2])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgGSSAPIReply, "header", int64(n), err)
 	}
 
 	r.Version = hdr[0]
@@ -66,14 +77,14 @@ func (r *GSSAPIReply) ReadFrom(src io.Reader) (int64, error) {
 	// Abort message has no token
 	if r.MsgType == GSSAPITypeAbort {
 		r.Token = nil
-		return int64(n), r.Validate()
+		return int64(n), parseError(msgGSSAPIReply, "", int64(n), r.ValidateWithOptions(opts))
 	}
 
 	// Read token length
 	n2, err := io.ReadFull(src, hdr[2:4])
 	n += n2
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgGSSAPIReply, "LEN", int64(n), internal.UnexpectedEOF(err))
 	}
 
 	length := binary.BigEndian.Uint16(hdr[2:4])
@@ -81,18 +92,21 @@ func (r *GSSAPIReply) ReadFrom(src io.Reader) (int64, error) {
 	// Zero-length token is valid (final step)
 	if length == 0 {
 		r.Token = nil
-		return int64(n), r.Validate()
+		return int64(n), parseError(msgGSSAPIReply, "", int64(n), r.ValidateWithOptions(opts))
+	}
+	if int(length) > opts.maxLen() {
+		return int64(n), parseError(msgGSSAPIReply, "LEN", int64(n), ErrGSSAPIReplyTooLong)
 	}
 
 	token := make([]byte, length)
 	n3, err := io.ReadFull(src, token)
 	total := int64(n + n3)
 	if err != nil {
-		return total, err
+		return total, parseError(msgGSSAPIReply, "TOKEN", total, internal.UnexpectedEOF(err))
 	}
 
 	r.Token = token
-	return total, r.Validate()
+	return total, parseError(msgGSSAPIReply, "", total, r.ValidateWithOptions(opts))
 }
 
 // WriteTo writes the GSSAPI reply to a writer.
@@ -130,6 +144,37 @@ func (r *GSSAPIReply) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for GSSAPIReply.
+func (r *GSSAPIReply) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for GSSAPIReply.
+func (r *GSSAPIReply) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(r, data)
+}
+
+// Equal reports whether GSSAPIReply r and other have the same type and token.
+// A nil and an empty Token are equal.
+func (r *GSSAPIReply) Equal(other *GSSAPIReply) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return r.Version == other.Version &&
+		r.MsgType == other.MsgType &&
+		bytes.Equal(r.Token, other.Token)
+}
+
+// Clone returns a deep copy of r.
+func (r *GSSAPIReply) Clone() *GSSAPIReply {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Token = bytes.Clone(r.Token)
+	return &c
+}
+
 // String returns a human-readable representation.
 func (r *GSSAPIReply) String() string {
 	return fmt.Sprintf(
@@ -137,3 +182,11 @@ func (r *GSSAPIReply) String() string {
 		r.Version, r.MsgType, len(r.Token),
 	)
 }
+
+// LogValue implements slog.LogValuer. Only the token length is included.
+func (r *GSSAPIReply) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("mtyp", fmt.Sprintf("0x%02x", r.MsgType)),
+		slog.Int("token_len", len(r.Token)),
+	)
+}
@@ -87,6 +87,27 @@ func Test_GSSAPIReply_ReadFrom_Truncated(t *testing.T) {
 	}
 }
 
+func Test_GSSAPIReply_ReadFrom_MaxTokenLen(t *testing.T) {
+	opts := socks5.GSSAPIValidateOptions{MaxTokenLength: 1024}
+
+	// header announces 4096 bytes; no token bytes follow, so reading them would fail with EOF
+	data := []byte{socks5.GSSAPIVersion, socks5.GSSAPITypeReply, 0x10, 0x00}
+	r := &socks5.GSSAPIReply{}
+	n, err := r.ReadFromWithOptions(bytes.NewReader(data), opts)
+	if !errors.Is(err, socks5.ErrGSSAPIReplyTooLong) {
+		t.Fatalf("expected ErrGSSAPIReplyTooLong, got %v", err)
+	}
+	if n != 4 || r.Token != nil {
+		t.Errorf("token was read past the cap (n=%d, token=%d bytes)", n, len(r.Token))
+	}
+
+	// tokens within the cap are accepted
+	data = append([]byte{socks5.GSSAPIVersion, socks5.GSSAPITypeReply, 0x04, 0x00}, make([]byte, 1024)...)
+	if _, err := r.ReadFromWithOptions(bytes.NewReader(data), opts); err != nil {
+		t.Fatalf("token at cap rejected: %v", err)
+	}
+}
+
 func Test_GSSAPIReply_ReadFrom_Abort(t *testing.T) {
 	data := []byte{socks5.GSSAPIVersion, socks5.GSSAPITypeAbort}
 	r := &socks5.GSSAPIReply{}
@@ -156,3 +177,24 @@ func Test_GSSAPIReply_String(t *testing.T) {
 		t.Errorf("expected non-empty String() output")
 	}
 }
+
+func Test_GSSAPIReply_ValidateWithOptions(t *testing.T) {
+	opts := socks5.GSSAPIValidateOptions{MinTokenLength: 16}
+	r := &socks5.GSSAPIReply{}
+
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeReply, make([]byte, 10))
+	if err := r.ValidateWithOptions(opts); !errors.Is(err, socks5.ErrGSSAPITokenTooShort) {
+		t.Fatalf("10-byte token with MinTokenLength=16: got %v, want ErrGSSAPITokenTooShort", err)
+	}
+
+	// an empty token still ends the exchange
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeReply, nil)
+	if err := r.ValidateWithOptions(opts); err != nil {
+		t.Fatalf("empty token with MinTokenLength=16: %v", err)
+	}
+
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeReply, make([]byte, 9))
+	if err := r.ValidateWithOptions(socks5.GSSAPIValidateOptions{MaxTokenLength: 8}); !errors.Is(err, socks5.ErrGSSAPIReplyTooLong) {
+		t.Fatalf("9-byte token with MaxTokenLength=8: got %v, want ErrGSSAPIReplyTooLong", err)
+	}
+}
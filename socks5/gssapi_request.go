@@ -1,18 +1,58 @@
 package socks5
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Errors for GSSAPI authentication requests.
 var (
 	ErrInvalidGSSAPIVersion = errors.New("invalid GSSAPI version (must be 1)")
-	ErrGSSAPITokenTooLong   = errors.New("GSSAPI token too long (max 65535)")
+	ErrGSSAPITokenTooLong   = errors.New("GSSAPI token too long")
+	ErrGSSAPITokenTooShort  = errors.New("GSSAPI token too short")
 )
 
+// MaxGSSAPITokenLen is the protocol limit on GSSAPI tokens, set by the 16-bit
+// LEN field. GSSAPIValidateOptions.MaxTokenLength lowers it to bound
+// per-connection memory use; longer tokens are then rejected before any buffer
+// is allocated for them.
+const MaxGSSAPITokenLen = 65535
+
+// GSSAPIValidateOptions sets the token length limits checked by the
+// ValidateWithOptions and ReadFromWithOptions methods of GSSAPIRequest and
+// GSSAPIReply, and the maximum read by GSSAPIEncapsulation. The minimum
+// applies only to tokens that are present: an empty token is left to the rules
+// of the message, e.g. the final step of a GSSAPIReply.
+type GSSAPIValidateOptions struct {
+	MinTokenLength int // Fewest bytes in a non-empty token (0=1)
+	MaxTokenLength int // Most bytes in a token (0=MaxGSSAPITokenLen)
+}
+
+// maxLen returns MaxTokenLength, or MaxGSSAPITokenLen if it is unset or larger.
+func (o GSSAPIValidateOptions) maxLen() int {
+	if o.MaxTokenLength <= 0 || o.MaxTokenLength > MaxGSSAPITokenLen {
+		return MaxGSSAPITokenLen
+	}
+	return o.MaxTokenLength
+}
+
+// check returns errTooLong or ErrGSSAPITokenTooShort if token is outside the limits.
+func (o GSSAPIValidateOptions) check(token []byte, errTooLong error) error {
+	if len(token) > o.maxLen() {
+		return errTooLong
+	}
+	if len(token) > 0 && len(token) < o.MinTokenLength {
+		return ErrGSSAPITokenTooShort
+	}
+	return nil
+}
+
 // GSSAPIRequest represents a GSSAPI authentication request (RFC 1961 §3.4).
 type GSSAPIRequest struct {
 	Version byte   // VER (should always be 0x01)
@@ -27,26 +67,40 @@ func (r *GSSAPIRequest) Init(version, msgType byte, token []byte) {
 	r.Token = token
 }
 
+// Reset clears the request for reuse. The capacity of Token is kept and filled
+// by the next ReadFrom.
+func (r *GSSAPIRequest) Reset() {
+	*r = GSSAPIRequest{Token: r.Token[:0]}
+}
+
 // Validate checks for protocol correctness.
 func (r *GSSAPIRequest) Validate() error {
+	return r.ValidateWithOptions(GSSAPIValidateOptions{})
+}
+
+// ValidateWithOptions is Validate with the token length limits of opts.
+func (r *GSSAPIRequest) ValidateWithOptions(opts GSSAPIValidateOptions) error {
 	if r.Version != 0x01 {
 		return ErrInvalidGSSAPIVersion
 	}
 	if r.MsgType == GSSAPITypeAbort {
 		return nil // Abort messages have no token
 	}
-	if len(r.Token) > 65535 {
-		return ErrGSSAPITokenTooLong
-	}
-	return nil
+	return opts.check(r.Token, ErrGSSAPITokenTooLong)
 }
 
 // ReadFrom reads a GSSAPI authentication request from a reader.
 func (r *GSSAPIRequest) ReadFrom(src io.Reader) (int64, error) {
+	return r.ReadFromWithOptions(src, GSSAPIValidateOptions{})
+}
+
+// ReadFromWithOptions is ReadFrom validating the token with the limits of opts.
+// A LEN above the maximum fails before the token is read.
+func (r *GSSAPIRequest) ReadFromWithOptions(src io.Reader, opts GSSAPIValidateOptions) (int64, error) {
 	var hdr [4]byte
 	n, err := io.ReadFull(src, hdr[:2])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgGSSAPIRequest, "header", int64(n), err)
 	}
 
 	r.Version = hdr[0]
@@ -59,24 +113,27 @@ func (r *GSSAPIRequest) ReadFrom(src io.Reader) (int64, error) {
 	n2, err := io.ReadFull(src, hdr[2:4])
 	n += n2
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgGSSAPIRequest, "LEN", int64(n), internal.UnexpectedEOF(err))
 	}
 
 	length := binary.BigEndian.Uint16(hdr[2:4])
 	if length == 0 {
 		r.Token = nil
-		return int64(n), r.Validate()
+		return int64(n), parseError(msgGSSAPIRequest, "", int64(n), r.ValidateWithOptions(opts))
+	}
+	if int(length) > opts.maxLen() {
+		return int64(n), parseError(msgGSSAPIRequest, "LEN", int64(n), ErrGSSAPITokenTooLong)
 	}
 
-	token := make([]byte, length)
+	token := internal.ReuseBytes(r.Token, int(length))
 	n3, err := io.ReadFull(src, token)
 	total := int64(n + n3)
 	if err != nil {
-		return total, err
+		return total, parseError(msgGSSAPIRequest, "TOKEN", total, internal.UnexpectedEOF(err))
 	}
 
 	r.Token = token
-	return total, r.Validate()
+	return total, parseError(msgGSSAPIRequest, "", total, r.ValidateWithOptions(opts))
 }
 
 // WriteTo writes the GSSAPI authentication request to a writer.
@@ -104,6 +161,37 @@ func (r *GSSAPIRequest) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for GSSAPIRequest.
+func (r *GSSAPIRequest) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for GSSAPIRequest.
+func (r *GSSAPIRequest) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(r, data)
+}
+
+// Equal reports whether GSSAPIRequest r and other have the same type and token.
+// A nil and an empty Token are equal.
+func (r *GSSAPIRequest) Equal(other *GSSAPIRequest) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return r.Version == other.Version &&
+		r.MsgType == other.MsgType &&
+		bytes.Equal(r.Token, other.Token)
+}
+
+// Clone returns a deep copy of r.
+func (r *GSSAPIRequest) Clone() *GSSAPIRequest {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Token = bytes.Clone(r.Token)
+	return &c
+}
+
 // String returns a human-readable representation.
 func (r *GSSAPIRequest) String() string {
 	return fmt.Sprintf(
@@ -111,3 +199,11 @@ func (r *GSSAPIRequest) String() string {
 		r.Version, r.MsgType, len(r.Token),
 	)
 }
+
+// LogValue implements slog.LogValuer. Only the token length is included.
+func (r *GSSAPIRequest) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("mtyp", fmt.Sprintf("0x%02x", r.MsgType)),
+		slog.Int("token_len", len(r.Token)),
+	)
+}
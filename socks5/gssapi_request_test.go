@@ -67,6 +67,27 @@ func Test_GSSAPIRequest_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
 	}
 }
 
+func Test_GSSAPIRequest_ReadFrom_MaxTokenLen(t *testing.T) {
+	opts := socks5.GSSAPIValidateOptions{MaxTokenLength: 1024}
+
+	// header announces 4096 bytes; no token bytes follow, so reading them would fail with EOF
+	data := []byte{socks5.GSSAPIVersion, socks5.GSSAPITypeInit, 0x10, 0x00}
+	r := &socks5.GSSAPIRequest{}
+	n, err := r.ReadFromWithOptions(bytes.NewReader(data), opts)
+	if !errors.Is(err, socks5.ErrGSSAPITokenTooLong) {
+		t.Fatalf("expected ErrGSSAPITokenTooLong, got %v", err)
+	}
+	if n != 4 || r.Token != nil {
+		t.Errorf("token was read past the cap (n=%d, token=%d bytes)", n, len(r.Token))
+	}
+
+	// tokens within the cap are accepted
+	data = append([]byte{socks5.GSSAPIVersion, socks5.GSSAPITypeInit, 0x04, 0x00}, make([]byte, 1024)...)
+	if _, err := r.ReadFromWithOptions(bytes.NewReader(data), opts); err != nil {
+		t.Fatalf("token at cap rejected: %v", err)
+	}
+}
+
 func Test_GSSAPIRequest_ReadFrom_Abort(t *testing.T) {
 	data := []byte{socks5.GSSAPIVersion, socks5.GSSAPITypeAbort}
 	r := &socks5.GSSAPIRequest{}
@@ -122,3 +143,35 @@ func Test_GSSAPIRequest_String(t *testing.T) {
 		t.Errorf("expected non-empty String() output")
 	}
 }
+
+func Test_GSSAPIRequest_ValidateWithOptions(t *testing.T) {
+	r := &socks5.GSSAPIRequest{}
+
+	// a single byte token is valid by default
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeInit, []byte{0x01})
+	if err := r.ValidateWithOptions(socks5.GSSAPIValidateOptions{}); err != nil {
+		t.Fatalf("1-byte token with default options: %v", err)
+	}
+
+	opts := socks5.GSSAPIValidateOptions{MinTokenLength: 16}
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeInit, make([]byte, 10))
+	if err := r.ValidateWithOptions(opts); !errors.Is(err, socks5.ErrGSSAPITokenTooShort) {
+		t.Fatalf("10-byte token with MinTokenLength=16: got %v, want ErrGSSAPITokenTooShort", err)
+	}
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeInit, make([]byte, 16))
+	if err := r.ValidateWithOptions(opts); err != nil {
+		t.Fatalf("16-byte token with MinTokenLength=16: %v", err)
+	}
+
+	opts = socks5.GSSAPIValidateOptions{MaxTokenLength: 8}
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeInit, make([]byte, 9))
+	if err := r.ValidateWithOptions(opts); !errors.Is(err, socks5.ErrGSSAPITokenTooLong) {
+		t.Fatalf("9-byte token with MaxTokenLength=8: got %v, want ErrGSSAPITokenTooLong", err)
+	}
+
+	// abort messages carry no token and are not checked
+	r.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeAbort, nil)
+	if err := r.ValidateWithOptions(socks5.GSSAPIValidateOptions{MinTokenLength: 16}); err != nil {
+		t.Fatalf("abort with MinTokenLength=16: %v", err)
+	}
+}
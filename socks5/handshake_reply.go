@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Errors for SOCKS5 handshake replies.
@@ -38,13 +40,13 @@ func (h *HandshakeReply) ReadFrom(src io.Reader) (int64, error) {
 
 	n, err := io.ReadFull(src, buf[:])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgHandshakeReply, "header", int64(n), err)
 	}
 
 	h.Version = buf[0]
 	h.Method = buf[1]
 
-	return int64(n), h.Validate()
+	return int64(n), parseError(msgHandshakeReply, "", int64(n), h.Validate())
 }
 
 // WriteTo writes the handshake reply to an io.Writer.
@@ -55,6 +57,33 @@ func (h *HandshakeReply) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for HandshakeReply.
+func (h *HandshakeReply) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(h)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for HandshakeReply.
+func (h *HandshakeReply) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(h, data)
+}
+
+// Equal reports whether HandshakeReply h and other have the same version and method.
+func (h *HandshakeReply) Equal(other *HandshakeReply) bool {
+	if h == nil || other == nil {
+		return h == other
+	}
+	return *h == *other
+}
+
+// Clone returns a copy of h.
+func (h *HandshakeReply) Clone() *HandshakeReply {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	return &c
+}
+
 // String returns a human-readable representation of the handshake reply.
 func (h *HandshakeReply) String() string {
 	var method string
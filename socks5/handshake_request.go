@@ -1,18 +1,28 @@
 package socks5
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"slices"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Errors for SOCKS5 handshake requests.
 var (
 	ErrInvalidHandshakeVersion = errors.New("invalid SOCKS version (must be 5)")
-	ErrTooManyMethods          = errors.New("too many authentication methods")
+	ErrTooManyMethods          = errors.New("too many authentication methods (max 255)")
 	ErrNoMethodsProvided       = errors.New("no authentication methods provided")
+	ErrMethodCountMismatch     = errors.New("NMETHODS does not match the number of methods")
+	ErrInvalidMethod           = errors.New("invalid authentication method (0xFF cannot be offered)")
 )
 
+// MaxMethods is the most methods a handshake request can offer, as NMETHODS is one byte.
+const MaxMethods = 255
+
 // HandshakeRequest represents the initial SOCKS5 client handshake (method negotiation).
 type HandshakeRequest struct {
 	Version  byte   // VER (should always be 0x05)
@@ -21,26 +31,70 @@ type HandshakeRequest struct {
 }
 
 // Init initializes a handshake request with the given methods.
+// More than MaxMethods methods fail validation.
 func (h *HandshakeRequest) Init(version byte, methods ...byte) {
 	h.Version = version
 	h.NMethods = byte(len(methods))
 	h.Methods = append([]byte(nil), methods...) // copy
 }
 
+// Reset clears the request for reuse. The capacity of Methods is kept and
+// filled by the next ReadFrom.
+func (h *HandshakeRequest) Reset() {
+	*h = HandshakeRequest{Methods: h.Methods[:0]}
+}
+
 // Validate ensures the handshake request is structurally valid.
 func (h *HandshakeRequest) Validate() error {
 	if h.Version != SocksVersion {
 		return ErrInvalidHandshakeVersion
 	}
+	if len(h.Methods) > MaxMethods {
+		return ErrTooManyMethods
+	}
 	if h.NMethods == 0 {
 		return ErrNoMethodsProvided
 	}
 	if len(h.Methods) != int(h.NMethods) {
-		return ErrTooManyMethods
+		return ErrMethodCountMismatch
+	}
+	if h.HasMethod(MethodNoAcceptable) {
+		return ErrInvalidMethod
 	}
 	return nil
 }
 
+// HasMethod reports whether the client offers method m.
+func (h *HandshakeRequest) HasMethod(m byte) bool {
+	return slices.Contains(h.Methods, m)
+}
+
+// ChooseMethod returns the first of preferred that the client offers.
+// It returns MethodNoAcceptable and false if none are offered.
+func (h *HandshakeRequest) ChooseMethod(preferred ...byte) (byte, bool) {
+	for _, m := range preferred {
+		if h.HasMethod(m) {
+			return m, true
+		}
+	}
+	return MethodNoAcceptable, false
+}
+
+// Normalize removes duplicate methods, keeping the first occurrence of each,
+// and updates NMethods to match.
+func (h *HandshakeRequest) Normalize() {
+	var seen [256]bool
+	methods := h.Methods[:0]
+	for _, m := range h.Methods {
+		if !seen[m] {
+			seen[m] = true
+			methods = append(methods, m)
+		}
+	}
+	h.Methods = methods
+	h.NMethods = byte(len(methods))
+}
+
 // ReadFrom reads a SOCKS5 handshake request from an io.Reader.
 // Implements io.ReaderFrom.
 func (h *HandshakeRequest) ReadFrom(src io.Reader) (int64, error) {
@@ -48,31 +102,37 @@ func (h *HandshakeRequest) ReadFrom(src io.Reader) (int64, error) {
 
 	n, err := io.ReadFull(src, hdr[:])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgHandshakeRequest, "header", int64(n), err)
 	}
 
 	h.Version = hdr[0]
 	h.NMethods = hdr[1]
 
 	if h.NMethods == 0 {
-		return int64(n), ErrNoMethodsProvided
+		return int64(n), parseError(msgHandshakeRequest, "NMETHODS", int64(n), ErrNoMethodsProvided)
 	}
 
-	methods := make([]byte, h.NMethods)
+	// Exactly NMETHODS method bytes must follow; a short stream is io.ErrUnexpectedEOF
+	methods := internal.ReuseBytes(h.Methods, int(h.NMethods))
 	n2, err := io.ReadFull(src, methods)
 	total := int64(n + n2)
 	if err != nil {
-		return total, err
+		return total, parseError(msgHandshakeRequest, "METHODS", total, internal.UnexpectedEOF(err))
 	}
 
 	h.Methods = methods
-	return total, h.Validate()
+	return total, parseError(msgHandshakeRequest, "", total, h.Validate())
 }
 
 // WriteTo writes the handshake request to an io.Writer.
+// The request is validated first, so NMETHODS always matches the methods written.
 // Implements io.WriterTo.
 func (h *HandshakeRequest) WriteTo(dst io.Writer) (int64, error) {
-	var bufArr [258]byte // 2 + max 255 methods (spec)
+	if err := h.Validate(); err != nil {
+		return 0, err
+	}
+
+	var bufArr [2 + MaxMethods]byte
 	buf := bufArr[:0]
 
 	buf = append(buf, h.Version, h.NMethods)
@@ -82,6 +142,37 @@ func (h *HandshakeRequest) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for HandshakeRequest.
+func (h *HandshakeRequest) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(h)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for HandshakeRequest.
+func (h *HandshakeRequest) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(h, data)
+}
+
+// Equal reports whether HandshakeRequest h and other offer the same methods in the
+// same order. A nil and an empty Methods are equal.
+func (h *HandshakeRequest) Equal(other *HandshakeRequest) bool {
+	if h == nil || other == nil {
+		return h == other
+	}
+	return h.Version == other.Version &&
+		h.NMethods == other.NMethods &&
+		bytes.Equal(h.Methods, other.Methods)
+}
+
+// Clone returns a deep copy of h.
+func (h *HandshakeRequest) Clone() *HandshakeRequest {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.Methods = bytes.Clone(h.Methods)
+	return &c
+}
+
 // String returns a human-readable representation of the handshake request.
 func (h *HandshakeRequest) String() string {
 	return fmt.Sprintf(
@@ -89,3 +180,8 @@ func (h *HandshakeRequest) String() string {
 		h.Version, h.Methods,
 	)
 }
+
+// LogValue implements slog.LogValuer.
+func (h *HandshakeRequest) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("methods", fmt.Sprint(h.Methods)))
+}
@@ -67,6 +67,51 @@ func Test_HandshakeRequest_ReadFrom_Truncated(t *testing.T) {
 	if _, err := r.ReadFrom(bytes.NewReader(data)); err == nil {
 		t.Errorf("expected error for truncated handshake")
 	}
+
+	data = []byte{5, 3, 0x00, 0x02} // NMETHODS=3 but only 2 method bytes present
+	n, err := r.ReadFrom(bytes.NewReader(data))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("expected %d bytes read, got %d", len(data), n)
+	}
+}
+
+func Test_HandshakeRequest_WriteTo_TooManyMethods(t *testing.T) {
+	methods := make([]byte, socks5.MaxMethods+1)
+	for i := range methods {
+		methods[i] = byte(i % socks5.MaxMethods) // never 0xFF
+	}
+
+	var r socks5.HandshakeRequest
+	r.Init(socks5.SocksVersion, methods...)
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); !errors.Is(err, socks5.ErrTooManyMethods) {
+		t.Fatalf("expected ErrTooManyMethods, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("WriteTo wrote %d bytes on error", buf.Len())
+	}
+
+	// the maximum itself is fine
+	r.Init(socks5.SocksVersion, methods[:socks5.MaxMethods]...)
+	if n, err := r.WriteTo(&buf); err != nil || n != 2+socks5.MaxMethods {
+		t.Fatalf("WriteTo with %d methods: (%d, %v)", socks5.MaxMethods, n, err)
+	}
+}
+
+func Test_HandshakeRequest_Validate_CountMismatch(t *testing.T) {
+	r := socks5.HandshakeRequest{Version: socks5.SocksVersion, NMethods: 3, Methods: []byte{socks5.MethodNoAuth}}
+	if err := r.Validate(); !errors.Is(err, socks5.ErrMethodCountMismatch) {
+		t.Fatalf("expected ErrMethodCountMismatch, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); !errors.Is(err, socks5.ErrMethodCountMismatch) {
+		t.Fatalf("WriteTo: expected ErrMethodCountMismatch, got %v", err)
+	}
 }
 
 func Test_HandshakeRequest_WriteTo_ErrorPropagation(t *testing.T) {
@@ -96,3 +141,76 @@ func Test_HandshakeRequest_String(t *testing.T) {
 type writerFunc func([]byte) (int, error)
 
 func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func Test_HandshakeRequest_ChooseMethod(t *testing.T) {
+	var h socks5.HandshakeRequest
+	h.Init(socks5.SocksVersion, socks5.MethodNoAuth, socks5.MethodUserPass, socks5.MethodNoAuth)
+
+	if !h.HasMethod(socks5.MethodUserPass) || h.HasMethod(socks5.MethodGSSAPI) {
+		t.Fatalf("HasMethod mismatch for %v", h.Methods)
+	}
+
+	// the server's preference order decides
+	if m, ok := h.ChooseMethod(socks5.MethodGSSAPI, socks5.MethodUserPass, socks5.MethodNoAuth); !ok || m != socks5.MethodUserPass {
+		t.Errorf("ChooseMethod = (%#x, %v), want UserPass", m, ok)
+	}
+	if m, ok := h.ChooseMethod(socks5.MethodGSSAPI); ok || m != socks5.MethodNoAcceptable {
+		t.Errorf("ChooseMethod = (%#x, %v), want (NoAcceptable, false)", m, ok)
+	}
+}
+
+func Test_HandshakeRequest_Normalize(t *testing.T) {
+	var h socks5.HandshakeRequest
+	h.Init(socks5.SocksVersion, socks5.MethodUserPass, socks5.MethodNoAuth, socks5.MethodUserPass, socks5.MethodNoAuth)
+	h.Normalize()
+
+	if !bytes.Equal(h.Methods, []byte{socks5.MethodUserPass, socks5.MethodNoAuth}) || h.NMethods != 2 {
+		t.Fatalf("Normalize = %v (NMethods=%d), want [2 0]", h.Methods, h.NMethods)
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("Validate after Normalize: %v", err)
+	}
+}
+
+func Test_HandshakeRequest_Validate_NoAcceptableOffered(t *testing.T) {
+	var h socks5.HandshakeRequest
+	h.Init(socks5.SocksVersion, socks5.MethodNoAuth, socks5.MethodNoAcceptable)
+
+	if err := h.Validate(); !errors.Is(err, socks5.ErrInvalidMethod) {
+		t.Fatalf("Validate() error = %v, want ErrInvalidMethod", err)
+	}
+
+	if _, err := h.ReadFrom(bytes.NewReader([]byte{0x05, 0x01, 0xFF})); !errors.Is(err, socks5.ErrInvalidMethod) {
+		t.Fatalf("ReadFrom() error = %v, want ErrInvalidMethod", err)
+	}
+}
+
+func Test_HandshakeRequest_Reset(t *testing.T) {
+	data := []byte{socks5.SocksVersion, 2, socks5.MethodNoAuth, socks5.MethodUserPass}
+
+	var r socks5.HandshakeRequest
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	methods := r.Methods
+
+	r.Reset()
+	if len(r.Methods) != 0 || r.NMethods != 0 || r.Version != 0 {
+		t.Fatalf("expected cleared request, got %+v", r)
+	}
+
+	if err := r.UnmarshalBinary(data[:3]); err == nil {
+		t.Fatal("expected error for truncated request")
+	}
+	r.Reset()
+
+	if err := r.UnmarshalBinary([]byte{socks5.SocksVersion, 1, socks5.MethodGSSAPI}); err != nil {
+		t.Fatalf("UnmarshalBinary after Reset failed: %v", err)
+	}
+	if &r.Methods[0] != &methods[0] {
+		t.Error("expected Methods backing array to be reused after Reset")
+	}
+	if !r.HasMethod(socks5.MethodGSSAPI) || len(r.Methods) != 1 {
+		t.Errorf("unexpected methods %v", r.Methods)
+	}
+}
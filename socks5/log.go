@@ -0,0 +1,36 @@
+package socks5
+
+import (
+	"log/slog"
+
+	"github.com/33TU/socks/internal"
+)
+
+// logIdentifier returns id as it should appear in logs: a short hash, or id
+// itself when verbatim is set.
+func logIdentifier(id string, verbatim bool) string {
+	if verbatim {
+		return id
+	}
+	return internal.RedactIdentifier(id)
+}
+
+// Unredacted wraps v so that slog logs its user identifiers verbatim instead
+// of as a short hash. Passwords and tokens are never logged regardless.
+func Unredacted(v slog.LogValuer) slog.LogValuer {
+	return unredacted{v}
+}
+
+type unredacted struct{ v slog.LogValuer }
+
+// identifierLogValuer is implemented by messages that carry a user identifier.
+type identifierLogValuer interface {
+	logValue(verbatim bool) slog.Value
+}
+
+func (u unredacted) LogValue() slog.Value {
+	if m, ok := u.v.(identifierLogValuer); ok {
+		return m.logValue(true)
+	}
+	return u.v.LogValue()
+}
@@ -0,0 +1,88 @@
+package socks5_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func logLine(v any) string {
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("msg", "v", v)
+	return buf.String()
+}
+
+func Test_UserPassRequest_LogValue(t *testing.T) {
+	req := &socks5.UserPassRequest{Version: 1, Username: "alice", Password: "hunter2"}
+
+	line := logLine(req)
+	if strings.Contains(line, "hunter2") || strings.Contains(line, "alice") {
+		t.Fatalf("log line leaks credentials: %s", line)
+	}
+	if !strings.Contains(line, "v.user=sha256:") {
+		t.Fatalf("log line has no hashed user: %s", line)
+	}
+
+	line = logLine(socks5.Unredacted(req))
+	if strings.Contains(line, "hunter2") {
+		t.Fatalf("log line leaks password: %s", line)
+	}
+	if !strings.Contains(line, "v.user=alice") {
+		t.Fatalf("log line has no plain user: %s", line)
+	}
+}
+
+func Test_Request_LogValue(t *testing.T) {
+	req := &socks5.Request{Version: 5, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1), Port: 443}
+
+	line := logLine(req)
+	for _, want := range []string{"v.cmd=CONNECT", "v.atyp=IPv4", "v.host=10.0.0.1", "v.port=443"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log line %q missing %q", line, want)
+		}
+	}
+}
+
+func Test_GSSAPIRequest_LogValue(t *testing.T) {
+	req := &socks5.GSSAPIRequest{Version: 1, MsgType: socks5.GSSAPITypeInit, Token: []byte("secret-token")}
+
+	line := logLine(req)
+	if strings.Contains(line, "secret-token") {
+		t.Fatalf("log line leaks token: %s", line)
+	}
+	if !strings.Contains(line, "v.token_len=12") {
+		t.Fatalf("log line has no token length: %s", line)
+	}
+}
+
+func Test_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"Request", &socks5.Request{Version: 5, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 443},
+			`{"cmd":"CONNECT","atyp":"DOMAIN","host":"example.com","port":443}`},
+		{"Reply", socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1080}),
+			`{"reply":"SUCCESS","atyp":"IPv4","host":"10.0.0.1","port":1080}`},
+		{"UserPassRequest", &socks5.UserPassRequest{Version: 1, Username: "alice", Password: "hunter2"},
+			`{"user":"alice"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.v)
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			if string(b) != tt.want {
+				t.Fatalf("Marshal = %s, want %s", b, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package socks5
+
+import (
+	"io"
+	"time"
+)
+
+// Message is implemented by pointers to the SOCKS5 message types, e.g.
+// *Request, *Reply, *HandshakeRequest and *UserPassRequest.
+type Message interface {
+	io.ReaderFrom
+	io.WriterTo
+	Validate() error
+}
+
+// messagePtr constrains PT to a pointer to a message type T.
+type messagePtr[T any] interface {
+	*T
+	Message
+}
+
+// ReadMessage reads and validates the next message of type T from src, e.g.
+// ReadMessage[Request](conn, time.Time{}). If deadline is not zero and src has
+// a SetReadDeadline method, as a net.Conn does, the read must finish by
+// deadline; the deadline is cleared afterwards.
+func ReadMessage[T any, PT messagePtr[T]](src io.Reader, deadline time.Time) (*T, error) {
+	if !deadline.IsZero() {
+		if d, ok := src.(interface{ SetReadDeadline(time.Time) error }); ok {
+			if err := d.SetReadDeadline(deadline); err != nil {
+				return nil, err
+			}
+			defer d.SetReadDeadline(time.Time{})
+		}
+	}
+
+	msg := PT(new(T))
+	if _, err := msg.ReadFrom(src); err != nil {
+		return nil, err
+	}
+	if err := msg.Validate(); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage validates msg and writes it to dst. If deadline is not zero and
+// dst has a SetWriteDeadline method, the write must finish by deadline; the
+// deadline is cleared afterwards. Nothing is written if msg is invalid.
+func WriteMessage(dst io.Writer, msg Message, deadline time.Time) error {
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	if !deadline.IsZero() {
+		if d, ok := dst.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			if err := d.SetWriteDeadline(deadline); err != nil {
+				return err
+			}
+			defer d.SetWriteDeadline(time.Time{})
+		}
+	}
+
+	_, err := msg.WriteTo(dst)
+	return err
+}
@@ -0,0 +1,84 @@
+package socks5_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// roundTripMessage writes msg with WriteMessage and reads it back with ReadMessage.
+func roundTripMessage[T any, PT interface {
+	*T
+	socks5.Message
+	Equal(*T) bool
+}](t *testing.T, msg PT) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := socks5.WriteMessage(&buf, msg, time.Time{}); err != nil {
+		t.Fatalf("WriteMessage(%T) failed: %v", msg, err)
+	}
+	got, err := socks5.ReadMessage[T, PT](&buf, time.Time{})
+	if err != nil {
+		t.Fatalf("ReadMessage[%T] failed: %v", msg, err)
+	}
+	if !msg.Equal(got) {
+		t.Fatalf("ReadMessage[%T] = %+v, want %+v", msg, got, msg)
+	}
+}
+
+func TestMessage_RoundTrip(t *testing.T) {
+	var hs socks5.HandshakeRequest
+	hs.Init(socks5.SocksVersion, socks5.MethodNoAuth, socks5.MethodUserPass)
+	roundTripMessage(t, &hs)
+
+	var up socks5.UserPassRequest
+	up.Init(socks5.AuthVersionUserPass, "alice", "secret")
+	roundTripMessage(t, &up)
+
+	roundTripMessage(t, &socks5.Request{Version: socks5.SocksVersion, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 443})
+	roundTripMessage(t, socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1080}))
+
+	var enc socks5.GSSAPIEncapsulation
+	enc.Init(socks5.GSSAPIVersion, socks5.GSSAPITypeEncapsulated, []byte("token"))
+	roundTripMessage(t, &enc)
+}
+
+func TestWriteMessage_Invalid(t *testing.T) {
+	var buf bytes.Buffer
+	if err := socks5.WriteMessage(&buf, &socks5.Request{Version: 4}, time.Time{}); err == nil {
+		t.Fatal("expected error for invalid request")
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("invalid message wrote %d bytes", buf.Len())
+	}
+}
+
+func TestReadMessage_Deadline(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	_, err := socks5.ReadMessage[socks5.HandshakeRequest](server, time.Now().Add(20*time.Millisecond))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadMessage error = %v, want os.ErrDeadlineExceeded", err)
+	}
+
+	// the deadline is cleared afterwards
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Write([]byte{socks5.SocksVersion, 1, socks5.MethodNoAuth})
+	}()
+	hs, err := socks5.ReadMessage[socks5.HandshakeRequest](server, time.Time{})
+	if err != nil {
+		t.Fatalf("ReadMessage after deadline: %v", err)
+	}
+	if len(hs.Methods) != 1 || hs.Methods[0] != socks5.MethodNoAuth {
+		t.Fatalf("unexpected handshake %+v", hs)
+	}
+}
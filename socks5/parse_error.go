@@ -0,0 +1,73 @@
+package socks5
+
+import (
+	socksnet "github.com/33TU/socks/net"
+)
+
+// ParseError reports which field of a message failed to parse and how many
+// bytes had been read (see socksnet.ParseError). ReadFrom methods return it
+// for malformed or truncated input; errors.Is still matches the sentinels.
+type ParseError = socksnet.ParseError
+
+// Message names reported in ParseError.
+const (
+	msgAddr                = "socks5 address"
+	msgRequest             = "socks5 request"
+	msgReply               = "socks5 reply"
+	msgHandshakeRequest    = "socks5 handshake request"
+	msgHandshakeReply      = "socks5 handshake reply"
+	msgUserPassRequest     = "socks5 username/password request"
+	msgUserPassReply       = "socks5 username/password reply"
+	msgGSSAPIRequest       = "socks5 GSSAPI request"
+	msgGSSAPIReply         = "socks5 GSSAPI reply"
+	msgGSSAPIEncapsulation = "socks5 GSSAPI message"
+	msgUDPPacket           = "socks5 UDP packet"
+)
+
+// sentinelFields names the field each validation error is about.
+var sentinelFields = map[error]string{
+	ErrInvalidVersion:               "VER",
+	ErrInvalidHandshakeVersion:      "VER",
+	ErrInvalidHandshakeReplyVersion: "VER",
+	ErrInvalidReplyVersion:          "VER",
+	ErrInvalidUserPassVersion:       "VER",
+	ErrInvalidUserPassReplyVersion:  "VER",
+	ErrInvalidGSSAPIVersion:         "VER",
+	ErrInvalidGSSAPIReplyVersion:    "VER",
+	ErrInvalidCommand:               "CMD",
+	ErrInvalidRSV:                   "RSV",
+	ErrInvalidReplyRSV:              "RSV",
+	ErrInvalidUDPReserved:           "RSV",
+	ErrUnsupportedFrag:              "FRAG",
+	ErrInvalidAddr:                  "ATYP",
+	ErrInvalidReplyAddr:             "ATYP",
+	ErrInvalidUDPAddrType:           "ATYP",
+	ErrInvalidDomain:                "ADDR",
+	ErrInvalidReplyDomain:           "ADDR",
+	ErrInvalidUDPDomain:             "ADDR",
+	ErrInvalidResolveTarget:         "ADDR",
+	ErrNoMethodsProvided:            "NMETHODS",
+	ErrTooManyMethods:               "NMETHODS",
+	ErrMethodCountMismatch:          "NMETHODS",
+	ErrInvalidMethod:                "METHODS",
+	ErrEmptyUserPassUsername:        "ULEN",
+	ErrEmptyUserPassPassword:        "PLEN",
+	ErrInvalidGSSAPIMsgType:         "MTYP",
+	ErrUnexpectedGSSAPIMessage:      "MTYP",
+	ErrGSSAPITokenTooLong:           "LEN",
+	ErrGSSAPIReplyTooLong:           "LEN",
+	ErrGSSAPITokenTooShort:          "LEN",
+}
+
+// parseError wraps err from reading message in a *ParseError. Validation errors
+// are attributed to their own field; other errors, such as a truncated read,
+// to field. offset is the number of bytes of the message read so far.
+func parseError(message, field string, offset int64, err error) error {
+	if err == nil {
+		return nil
+	}
+	if f, ok := sentinelFields[err]; ok {
+		field = f
+	}
+	return socksnet.NewParseError(message, field, offset, err)
+}
@@ -0,0 +1,86 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// ErrQuotaExceeded is returned when a user has used up their traffic quota.
+var ErrQuotaExceeded = errors.New("traffic quota exceeded")
+
+// QuotaStore records per-user traffic usage.
+type QuotaStore interface {
+	// Consumed returns the number of bytes used by user so far.
+	Consumed(user string) (int64, error)
+
+	// Record adds bytes to the usage of user.
+	Record(user string, bytes int64) error
+}
+
+// QuotaMiddleware returns a ConnectMiddleware that limits the total traffic of
+// each user to limit bytes. Requests from users at or over their limit are
+// rejected with RepConnectionNotAllowed. Bytes relayed in both directions are
+// recorded once the relay completes. The user is the authenticated username
+// (see UsernameFromContext), or "" for unauthenticated connections.
+func QuotaMiddleware(store QuotaStore, limit int64) ConnectMiddleware {
+	return func(next ConnectHandler) ConnectHandler {
+		return func(ctx context.Context, conn net.Conn, req *Request) error {
+			user, _ := UsernameFromContext(ctx)
+
+			consumed, err := store.Consumed(user)
+			if err != nil {
+				WriteRejectReply(conn, RepGeneralFailure)
+				return err
+			}
+			if consumed >= limit {
+				WriteRejectReply(conn, RepConnectionNotAllowed)
+				return ErrQuotaExceeded
+			}
+
+			cc := socksnet.NewCountingConn(conn)
+			err = next(ctx, cc, req)
+
+			read, written := cc.Snapshot()
+			if rerr := store.Record(user, read+written); rerr != nil && err == nil {
+				err = rerr
+			}
+			return err
+		}
+	}
+}
+
+// InMemoryQuotaStore is a QuotaStore kept in memory. The zero value is ready to use.
+type InMemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+// Consumed implements QuotaStore.
+func (s *InMemoryQuotaStore) Consumed(user string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage[user], nil
+}
+
+// Record implements QuotaStore.
+func (s *InMemoryQuotaStore) Record(user string, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.usage == nil {
+		s.usage = make(map[string]int64)
+	}
+	s.usage[user] += bytes
+	return nil
+}
+
+// Reset clears the usage of user.
+func (s *InMemoryQuotaStore) Reset(user string) {
+	s.mu.Lock()
+	delete(s.usage, user)
+	s.mu.Unlock()
+}
@@ -0,0 +1,88 @@
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func TestQuotaMiddleware(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	const limit = 1 << 20 // 1 MB
+	store := &socks5.InMemoryQuotaStore{}
+
+	handler := &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		Logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	handler.ConnectHandler = socks5.QuotaMiddleware(store, limit)(handler.DefaultConnect)
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	transfer := func(user string, size int) error {
+		t.Helper()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		d := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: user, Password: "pw"}, nil)
+		conn, err := d.DialContext(ctx, "tcp", echoLn.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		payload := genRandom(size)
+		go conn.Write(payload)
+
+		response := make([]byte, size)
+		if _, err := io.ReadFull(conn, response); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(payload, response) {
+			t.Fatal("echo data mismatch")
+		}
+		return nil
+	}
+
+	// waitRecorded waits for the relay to finish and record its usage.
+	waitRecorded := func(user string, atLeast int64) {
+		t.Helper()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if n, _ := store.Consumed(user); n >= atLeast {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		n, _ := store.Consumed(user)
+		t.Fatalf("usage of %s = %d, want at least %d", user, n, atLeast)
+	}
+
+	// first 600 KB transfer is within the quota; it is counted in both directions
+	if err := transfer("alice", 600*1024); err != nil {
+		t.Fatalf("first transfer: %v", err)
+	}
+	waitRecorded("alice", 2*600*1024)
+
+	// second transfer is rejected because the quota is used up
+	if err := transfer("alice", 600*1024); err == nil {
+		t.Fatal("expected second transfer to be rejected")
+	}
+
+	// other users have their own quota
+	if err := transfer("bob", 1024); err != nil {
+		t.Fatalf("transfer for another user: %v", err)
+	}
+}
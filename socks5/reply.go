@@ -1,11 +1,18 @@
 package socks5
 
 import (
+	"context"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Common validation errors for replies.
@@ -13,7 +20,7 @@ var (
 	ErrInvalidReplyVersion = errors.New("invalid SOCKS version in reply (must be 5)")
 	ErrInvalidReplyRSV     = errors.New("invalid reserved byte in reply (must be 0x00)")
 	ErrInvalidReplyAddr    = errors.New("invalid address or address type in reply")
-	ErrInvalidReplyDomain  = errors.New("invalid domain in reply (empty or too long)")
+	ErrInvalidReplyDomain  = errors.New("invalid domain name in reply")
 )
 
 // Reply represents a SOCKS5 server reply.
@@ -27,6 +34,50 @@ type Reply struct {
 	Port     uint16 // BND.PORT; Bound port
 }
 
+// NewSuccessReply returns a success reply carrying bound as BND.ADDR and BND.PORT.
+// A *Addr holding a domain is reported as ATYP=DOMAIN. Addresses other than
+// *net.TCPAddr, *net.UDPAddr and *Addr are reported as 0.0.0.0:0.
+func NewSuccessReply(bound net.Addr) *Reply {
+	var ap netip.AddrPort
+	switch a := bound.(type) {
+	case *net.TCPAddr:
+		ap = a.AddrPort()
+	case *net.UDPAddr:
+		ap = a.AddrPort()
+	case *Addr:
+		if a.AddrType == AddrTypeDomain {
+			return NewDomainSuccessReply(a.Domain, a.Port)
+		}
+		ap = a.AddrPort()
+	}
+
+	r := &Reply{Version: SocksVersion, Reply: RepSuccess}
+	r.SetAddrPort(ap)
+	return r
+}
+
+// NewDomainSuccessReply returns a success reply carrying domain as BND.ADDR.
+// WriteTo rejects domains that fail ValidateDomainName.
+func NewDomainSuccessReply(domain string, port uint16) *Reply {
+	return &Reply{
+		Version:  SocksVersion,
+		Reply:    RepSuccess,
+		AddrType: AddrTypeDomain,
+		Domain:   domain,
+		Port:     port,
+	}
+}
+
+// NewErrorReply returns a failure reply with the given code and 0.0.0.0:0 as the bound address.
+func NewErrorReply(code byte) *Reply {
+	return &Reply{
+		Version:  SocksVersion,
+		Reply:    code,
+		AddrType: AddrTypeIPv4,
+		IP:       net.IPv4zero.To4(),
+	}
+}
+
 // Init initializes a SOCKS5 reply.
 func (r *Reply) Init(version, rep, reserved, addrType byte, ip net.IP, domain string, port uint16) {
 	r.Version = version
@@ -38,6 +89,62 @@ func (r *Reply) Init(version, rep, reserved, addrType byte, ip net.IP, domain st
 	r.Port = port
 }
 
+// IsSuccess reports whether the request succeeded (RepSuccess).
+func (r *Reply) IsSuccess() bool {
+	return r.Reply == RepSuccess
+}
+
+// IsGeneralFailure reports whether the proxy reported a general failure (RepGeneralFailure).
+func (r *Reply) IsGeneralFailure() bool {
+	return r.Reply == RepGeneralFailure
+}
+
+// IsConnectionNotAllowed reports whether the connection was not allowed by the ruleset (RepConnectionNotAllowed).
+func (r *Reply) IsConnectionNotAllowed() bool {
+	return r.Reply == RepConnectionNotAllowed
+}
+
+// IsNetworkUnreachable reports whether the target network was unreachable (RepNetworkUnreachable).
+func (r *Reply) IsNetworkUnreachable() bool {
+	return r.Reply == RepNetworkUnreachable
+}
+
+// IsHostUnreachable reports whether the target host was unreachable (RepHostUnreachable).
+func (r *Reply) IsHostUnreachable() bool {
+	return r.Reply == RepHostUnreachable
+}
+
+// IsConnectionRefused reports whether the target refused the connection (RepConnectionRefused).
+func (r *Reply) IsConnectionRefused() bool {
+	return r.Reply == RepConnectionRefused
+}
+
+// IsTTLExpired reports whether the TTL expired (RepTTLExpired).
+func (r *Reply) IsTTLExpired() bool {
+	return r.Reply == RepTTLExpired
+}
+
+// IsCommandNotSupported reports whether the command is not supported (RepCommandNotSupported).
+func (r *Reply) IsCommandNotSupported() bool {
+	return r.Reply == RepCommandNotSupported
+}
+
+// IsAddrTypeNotSupported reports whether the address type is not supported (RepAddrTypeNotSupported).
+func (r *Reply) IsAddrTypeNotSupported() bool {
+	return r.Reply == RepAddrTypeNotSupported
+}
+
+// IsTransient reports whether the failure may clear up on retry: the network
+// or host was unreachable, or the TTL expired.
+func (r *Reply) IsTransient() bool {
+	switch r.Reply {
+	case RepNetworkUnreachable, RepHostUnreachable, RepTTLExpired:
+		return true
+	default:
+		return false
+	}
+}
+
 // GetHost returns the bound host (domain or IP string).
 func (r *Reply) GetHost() string {
 	if r.AddrType == AddrTypeDomain {
@@ -72,31 +179,18 @@ func (r *Reply) Validate() error {
 	if err := r.ValidateHeader(); err != nil {
 		return err
 	}
-	switch r.AddrType {
-	case AddrTypeDomain:
-		if len(r.Domain) == 0 || len(r.Domain) > 255 {
-			return ErrInvalidReplyDomain
-		}
-	case AddrTypeIPv4, AddrTypeIPv6:
-		if r.IP == nil {
-			return ErrInvalidReplyAddr
-		}
-	}
-	return nil
+	return replyAddrErr(r.addr().Validate())
 }
 
 // ReadFrom reads a SOCKS5 reply from a Reader.
 // Implements io.ReaderFrom.
 func (r *Reply) ReadFrom(src io.Reader) (int64, error) {
-	var (
-		hdr   [4]byte
-		total int64
-	)
+	var total int64
 
-	n, err := io.ReadFull(src, hdr[:])
-	total += int64(n)
+	hdr, err := internal.ReadN(src, 4)
+	total += int64(len(hdr))
 	if err != nil {
-		return total, err
+		return total, parseError(msgReply, "header", total, err)
 	}
 
 	r.Version = hdr[0]
@@ -105,132 +199,204 @@ func (r *Reply) ReadFrom(src io.Reader) (int64, error) {
 	r.AddrType = hdr[3]
 
 	if err := r.ValidateHeader(); err != nil {
-		return total, err
+		return total, parseError(msgReply, "", total, err)
 	}
 
-	switch r.AddrType {
-	case AddrTypeIPv4:
-		var ip [4]byte
-		n, err = io.ReadFull(src, ip[:])
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		r.IP = net.IP(ip[:])
-
-	case AddrTypeIPv6:
-		var ip [16]byte
-		n, err = io.ReadFull(src, ip[:])
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		r.IP = net.IP(ip[:])
-
-	case AddrTypeDomain:
-		var ln [1]byte
-		n, err = io.ReadFull(src, ln[:])
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		buf := make([]byte, ln[0])
-		n, err = io.ReadFull(src, buf)
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		r.Domain = string(buf)
-	}
-
-	var portBuf [2]byte
-	n, err = io.ReadFull(src, portBuf[:])
-	total += int64(n)
+	a := Addr{AddrType: r.AddrType, IP: r.IP, Domain: r.Domain}
+	n2, err := a.readBody(src)
+	total += n2
 	if err != nil {
-		return total, err
+		return total, parseError(msgReply, "BND.ADDR", total, replyAddrErr(err))
 	}
-	r.Port = binary.BigEndian.Uint16(portBuf[:])
+	r.IP, r.Domain, r.Port = a.IP, a.Domain, a.Port
 
-	return total, r.Validate()
+	return total, parseError(msgReply, "", total, r.Validate())
 }
 
 // WriteTo writes a SOCKS5 reply to a Writer.
+// The reply is validated first; nothing is written if it is malformed.
 // Implements io.WriterTo.
 func (r *Reply) WriteTo(dst io.Writer) (int64, error) {
+	if err := r.Validate(); err != nil {
+		return 0, err
+	}
+
 	var bufArr [264]byte
-	buf := bufArr[:0]
 
-	// Header
-	buf = append(buf, r.Version, r.Reply, r.Reserved, r.AddrType)
+	// Header and address
+	buf := append(bufArr[:0], r.Version, r.Reply, r.Reserved)
+	buf = r.addr().appendTo(buf)
+
+	// Single write
+	n, err := dst.Write(buf)
+	return int64(n), err
+}
+
+// WriteToBuffer encodes the reply into dst as WriteTo writes it and returns the
+// number of bytes used, without allocating. IPv4 and IPv6 replies and domains of
+// up to 25 bytes fit; longer replies fail with io.ErrShortBuffer and must be
+// written with WriteTo. The reply is validated first.
+func (r *Reply) WriteToBuffer(dst *[32]byte) (n int, err error) {
+	if err := r.Validate(); err != nil {
+		return 0, err
+	}
+
+	a := r.addr()
+	if 3+a.Size() > len(dst) {
+		return 0, io.ErrShortBuffer
+	}
+
+	buf := append(dst[:0], r.Version, r.Reply, r.Reserved)
+	buf = a.appendTo(buf)
+	return len(buf), nil
+}
 
-	// Address
+// WriteBuffersTo writes the same bytes as WriteTo as a net.Buffers, so the
+// header, address and port go out in one vectored write (writev) on
+// connections that support it, such as *net.TCPConn, without being copied into
+// one buffer first. Other writers receive one Write per part. It returns the
+// total number of bytes written. Replies are small, so WriteTo's single Write
+// is usually as fast; see BenchmarkReply_WriteBuffersTo.
+func (r *Reply) WriteBuffersTo(dst io.Writer) (int64, error) {
+	if err := r.Validate(); err != nil {
+		return 0, err
+	}
+
+	// VER REP RSV ATYP, the length byte of a domain, and PORT
+	var fixed [7]byte
+	hdr := append(fixed[:0], r.Version, r.Reply, r.Reserved, r.AddrType)
+
+	var addr []byte
 	switch r.AddrType {
 	case AddrTypeIPv4:
-		buf = append(buf, r.IP.To4()...)
-
+		addr = r.IP.To4()
 	case AddrTypeIPv6:
-		buf = append(buf, r.IP.To16()...)
-
+		addr = r.IP.To16()
 	case AddrTypeDomain:
-		l := len(r.Domain)
-		if l > 255 {
-			return 0, fmt.Errorf("domain too long")
-		}
-		buf = append(buf, byte(l))
-		buf = append(buf, r.Domain...)
+		hdr = append(hdr, byte(len(r.Domain)))
+		addr = []byte(r.Domain)
+	}
 
-	default:
-		return 0, fmt.Errorf("invalid address type")
+	port := binary.BigEndian.AppendUint16(fixed[5:5], r.Port)
+
+	bufs := net.Buffers{hdr, addr, port}
+	return bufs.WriteTo(dst)
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler for Reply.
+func (r *Reply) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for Reply.
+func (r *Reply) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(r, data)
+}
+
+// ResolvedAddr returns BND.ADDR and BND.PORT as a *net.TCPAddr, looking up a
+// DOMAIN BND.ADDR with resolver (nil=net.DefaultResolver).
+func (r *Reply) ResolvedAddr(ctx context.Context, resolver *net.Resolver) (*net.TCPAddr, error) {
+	if r.AddrType != AddrTypeDomain {
+		return &net.TCPAddr{IP: r.IP, Port: int(r.Port)}, nil
 	}
 
-	// Port
-	buf = binary.BigEndian.AppendUint16(buf, r.Port)
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	ips, err := resolver.LookupIP(ctx, "ip", r.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: r.Domain, IsNotFound: true}
+	}
+	return &net.TCPAddr{IP: ips[0], Port: int(r.Port)}, nil
+}
 
-	// Single write
-	n, err := dst.Write(buf)
-	return int64(n), err
+// AddrPort returns the bound address as a netip.AddrPort, or the zero AddrPort if ATYP is DOMAIN.
+func (r *Reply) AddrPort() netip.AddrPort {
+	return r.addr().AddrPort()
 }
 
-// String returns a human-readable representation of the reply.
-func (r *Reply) String() string {
-	var rep string
-	switch r.Reply {
-	case RepSuccess:
-		rep = "SUCCESS"
-	case RepGeneralFailure:
-		rep = "GENERAL_FAILURE"
-	case RepConnectionNotAllowed:
-		rep = "CONNECTION_NOT_ALLOWED"
-	case RepNetworkUnreachable:
-		rep = "NETWORK_UNREACHABLE"
-	case RepHostUnreachable:
-		rep = "HOST_UNREACHABLE"
-	case RepConnectionRefused:
-		rep = "CONNECTION_REFUSED"
-	case RepTTLExpired:
-		rep = "TTL_EXPIRED"
-	case RepCommandNotSupported:
-		rep = "COMMAND_NOT_SUPPORTED"
-	case RepAddrTypeNotSupported:
-		rep = "ADDR_TYPE_NOT_SUPPORTED"
+// SetAddrPort sets the bound address from ap, choosing ATYP automatically.
+// IPv4-mapped IPv6 addresses are stored as AddrTypeIPv4.
+func (r *Reply) SetAddrPort(ap netip.AddrPort) {
+	r.AddrType, r.IP = addrFromNetip(ap.Addr())
+	r.Domain = ""
+	r.Port = ap.Port()
+}
+
+// DomainPort returns the bound address domain and port if ATYP is DOMAIN.
+func (r *Reply) DomainPort() (domain string, port uint16, ok bool) {
+	return r.addr().DomainPort()
+}
+
+// addr returns the bound address of the reply.
+func (r *Reply) addr() *Addr {
+	return &Addr{AddrType: r.AddrType, IP: r.IP, Domain: r.Domain, Port: r.Port}
+}
+
+// replyAddrErr maps address codec errors to their reply counterparts.
+func replyAddrErr(err error) error {
+	switch err {
+	case ErrInvalidAddr:
+		return ErrInvalidReplyAddr
+	case ErrInvalidDomain:
+		return ErrInvalidReplyDomain
 	default:
-		rep = fmt.Sprintf("UNKNOWN(0x%02X)", r.Reply)
+		return err
 	}
+}
 
-	var atype string
-	switch r.AddrType {
-	case AddrTypeIPv4:
-		atype = "IPv4"
-	case AddrTypeDomain:
-		atype = "DOMAIN"
-	case AddrTypeIPv6:
-		atype = "IPv6"
-	default:
-		atype = fmt.Sprintf("0x%02X", r.AddrType)
+// Equal reports whether Reply r and other have the same code and bound address.
+// IPs are compared as in Addr.Equal.
+func (r *Reply) Equal(other *Reply) bool {
+	if r == nil || other == nil {
+		return r == other
 	}
+	return r.Version == other.Version &&
+		r.Reply == other.Reply &&
+		r.Reserved == other.Reserved &&
+		r.AddrType == other.AddrType &&
+		r.IP.Equal(other.IP) &&
+		r.Domain == other.Domain &&
+		r.Port == other.Port
+}
+
+// Clone returns a deep copy of r.
+func (r *Reply) Clone() *Reply {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.IP = slices.Clone(r.IP)
+	return &c
+}
 
+// String returns a human-readable representation of the reply.
+func (r *Reply) String() string {
 	return fmt.Sprintf(
 		"SOCKS5 Reply{Reply=%s, AddrType=%s, Host=%s, Port=%d, Version=%d, RSV=%#02x}",
-		rep, atype, r.GetHost(), r.Port, r.Version, r.Reserved,
+		ReplyCode(r.Reply), AddrType(r.AddrType), r.GetHost(), r.Port, r.Version, r.Reserved,
 	)
 }
+
+// LogValue implements slog.LogValuer.
+func (r *Reply) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("rep", ReplyCode(r.Reply).String()),
+		slog.String("atyp", AddrType(r.AddrType).String()),
+		slog.String("host", r.GetHost()),
+		slog.Int("port", int(r.Port)),
+	)
+}
+
+// MarshalJSON implements json.Marshaler with stable field names for audit logs.
+func (r *Reply) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Reply string `json:"reply"`
+		Atyp  string `json:"atyp"`
+		Host  string `json:"host"`
+		Port  uint16 `json:"port"`
+	}{ReplyCode(r.Reply).String(), AddrType(r.AddrType).String(), r.GetHost(), r.Port})
+}
@@ -2,7 +2,11 @@ package socks5_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/33TU/socks/socks5"
@@ -122,17 +126,8 @@ func Test_Reply_WriteTo_ReadFrom_RoundTrip(t *testing.T) {
 			if nw != nr {
 				t.Errorf("expected %d bytes written == %d bytes read", nw, nr)
 			}
-			if got.Reply != orig.Reply {
-				t.Errorf("reply mismatch: got %d, want %d", got.Reply, orig.Reply)
-			}
-			if got.Port != orig.Port {
-				t.Errorf("port mismatch: got %d, want %d", got.Port, orig.Port)
-			}
-			if got.AddrType == socks5.AddrTypeDomain && got.Domain != orig.Domain {
-				t.Errorf("domain mismatch: got %q, want %q", got.Domain, orig.Domain)
-			}
-			if (got.AddrType == socks5.AddrTypeIPv4 || got.AddrType == socks5.AddrTypeIPv6) && !got.IP.Equal(orig.IP) {
-				t.Errorf("IP mismatch: got %v, want %v", got.IP, orig.IP)
+			if !got.Equal(&orig) {
+				t.Errorf("reply mismatch: got %v, want %v", &got, &orig)
 			}
 		})
 	}
@@ -161,6 +156,70 @@ func Test_Reply_WriteTo_InvalidDomain(t *testing.T) {
 	}
 }
 
+func Test_Reply_Domain_LengthBoundary(t *testing.T) {
+	// labels of at most 63 bytes joined to the given length
+	domainOfLen := func(n int) string {
+		var b strings.Builder
+		for b.Len() < n {
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(strings.Repeat("a", min(63, n-b.Len())))
+		}
+		return b.String()
+	}
+
+	for _, domain := range []string{domainOfLen(253), domainOfLen(253) + "."} {
+		orig := socks5.NewDomainSuccessReply(domain, 1080)
+
+		var buf bytes.Buffer
+		if _, err := orig.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo(%d-byte domain): %v", len(domain), err)
+		}
+		if got := int(buf.Bytes()[4]); got != len(domain) {
+			t.Fatalf("encoded length %d, want %d", got, len(domain))
+		}
+
+		var got socks5.Reply
+		if _, err := got.ReadFrom(&buf); err != nil {
+			t.Fatalf("ReadFrom(%d-byte domain): %v", len(domain), err)
+		}
+		if !got.Equal(orig) {
+			t.Fatalf("round-trip mismatch: got %v, want %v", &got, orig)
+		}
+	}
+
+	for _, domain := range []string{"", domainOfLen(254), domainOfLen(255), strings.Repeat("a", 256)} {
+		var buf bytes.Buffer
+		if _, err := socks5.NewDomainSuccessReply(domain, 1080).WriteTo(&buf); !errors.Is(err, socks5.ErrInvalidReplyDomain) {
+			t.Errorf("WriteTo(%d-byte domain): got %v, want ErrInvalidReplyDomain", len(domain), err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("WriteTo(%d-byte domain) wrote %d bytes", len(domain), buf.Len())
+		}
+	}
+
+	// the 255-byte maximum of the wire format is not a valid host name
+	wire := append([]byte{socks5.SocksVersion, socks5.RepSuccess, 0x00, socks5.AddrTypeDomain, 255}, domainOfLen(255)...)
+	wire = append(wire, 0x04, 0x38)
+	var r socks5.Reply
+	if _, err := r.ReadFrom(bytes.NewReader(wire)); !errors.Is(err, socks5.ErrInvalidReplyDomain) {
+		t.Errorf("ReadFrom(255-byte domain): got %v, want ErrInvalidReplyDomain", err)
+	}
+}
+
+func Test_NewSuccessReply_Addr(t *testing.T) {
+	r := socks5.NewSuccessReply(&socks5.Addr{AddrType: socks5.AddrTypeDomain, Domain: "proxy.example.com", Port: 1080})
+	if r.AddrType != socks5.AddrTypeDomain || r.Addr() != "proxy.example.com:1080" || !r.IsSuccess() {
+		t.Fatalf("unexpected domain reply %v", r)
+	}
+
+	r = socks5.NewSuccessReply(&socks5.Addr{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1).To4(), Port: 80})
+	if r.AddrType != socks5.AddrTypeIPv4 || r.Addr() != "10.0.0.1:80" {
+		t.Fatalf("unexpected IPv4 reply %v", r)
+	}
+}
+
 func Test_Reply_String(t *testing.T) {
 	r := &socks5.Reply{}
 	r.Init(5, socks5.RepHostUnreachable, 0x00, socks5.AddrTypeIPv4, net.IPv4(10, 0, 0, 2), "", 9999)
@@ -169,3 +228,237 @@ func Test_Reply_String(t *testing.T) {
 		t.Errorf("expected non-empty String() output")
 	}
 }
+
+func Test_NewErrorReply_Bytes(t *testing.T) {
+	for _, code := range []byte{socks5.RepGeneralFailure, socks5.RepConnectionNotAllowed, socks5.RepCommandNotSupported} {
+		r := socks5.NewErrorReply(code)
+		if err := r.Validate(); err != nil {
+			t.Fatalf("NewErrorReply(%d).Validate() error: %v", code, err)
+		}
+
+		var buf bytes.Buffer
+		if _, err := r.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo() error: %v", err)
+		}
+
+		want := []byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("NewErrorReply(%d) = % x, want % x", code, buf.Bytes(), want)
+		}
+	}
+}
+
+func Test_NewSuccessReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		bound net.Addr
+		want  []byte
+	}{
+		{"tcp4", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1080}, []byte{0x05, 0x00, 0x00, 0x01, 10, 0, 0, 1, 0x04, 0x38}},
+		{"udp6", &net.UDPAddr{IP: net.ParseIP("::1"), Port: 53}, []byte{0x05, 0x00, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x00, 0x35}},
+		{"other", &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := socks5.NewSuccessReply(tt.bound)
+			if err := r.Validate(); err != nil {
+				t.Fatalf("Validate() error: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if _, err := r.WriteTo(&buf); err != nil {
+				t.Fatalf("WriteTo() error: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.want) {
+				t.Errorf("got % x, want % x", buf.Bytes(), tt.want)
+			}
+		})
+	}
+}
+
+func Test_Reply_WriteTo_MislabeledAddrType(t *testing.T) {
+	var r socks5.Reply
+	r.Init(socks5.SocksVersion, socks5.RepSuccess, 0x00, socks5.AddrTypeIPv4, net.ParseIP("2001:db8::1"), "", 1080)
+
+	if err := r.Validate(); !errors.Is(err, socks5.ErrInvalidReplyAddr) {
+		t.Fatalf("Validate() error = %v, want ErrInvalidReplyAddr", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); !errors.Is(err, socks5.ErrInvalidReplyAddr) {
+		t.Fatalf("WriteTo() error = %v, want ErrInvalidReplyAddr", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("WriteTo() wrote %d bytes for a malformed reply", buf.Len())
+	}
+}
+
+func Test_Reply_Predicates(t *testing.T) {
+	predicates := map[byte]func(*socks5.Reply) bool{
+		socks5.RepSuccess:              (*socks5.Reply).IsSuccess,
+		socks5.RepGeneralFailure:       (*socks5.Reply).IsGeneralFailure,
+		socks5.RepConnectionNotAllowed: (*socks5.Reply).IsConnectionNotAllowed,
+		socks5.RepNetworkUnreachable:   (*socks5.Reply).IsNetworkUnreachable,
+		socks5.RepHostUnreachable:      (*socks5.Reply).IsHostUnreachable,
+		socks5.RepConnectionRefused:    (*socks5.Reply).IsConnectionRefused,
+		socks5.RepTTLExpired:           (*socks5.Reply).IsTTLExpired,
+		socks5.RepCommandNotSupported:  (*socks5.Reply).IsCommandNotSupported,
+		socks5.RepAddrTypeNotSupported: (*socks5.Reply).IsAddrTypeNotSupported,
+	}
+	transient := map[byte]bool{
+		socks5.RepNetworkUnreachable: true,
+		socks5.RepHostUnreachable:    true,
+		socks5.RepTTLExpired:         true,
+	}
+
+	// every predicate holds for its own code only, including unknown codes
+	for code := byte(0); code <= 9; code++ {
+		r := socks5.NewErrorReply(code)
+		for pcode, pred := range predicates {
+			if got, want := pred(r), code == pcode; got != want {
+				t.Errorf("code %d: predicate for %v = %v, want %v", code, socks5.ReplyCode(pcode), got, want)
+			}
+		}
+		if got := r.IsTransient(); got != transient[code] {
+			t.Errorf("code %d: IsTransient() = %v, want %v", code, got, transient[code])
+		}
+	}
+}
+
+func Test_Reply_WriteBuffersTo(t *testing.T) {
+	replies := []*socks5.Reply{
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 1080}),
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 9050}),
+		{Version: socks5.SocksVersion, Reply: socks5.RepSuccess, AddrType: socks5.AddrTypeDomain, Domain: "example.org", Port: 443},
+	}
+
+	for _, r := range replies {
+		var want, got bytes.Buffer
+		if _, err := r.WriteTo(&want); err != nil {
+			t.Fatalf("WriteTo(%v) failed: %v", r, err)
+		}
+
+		n, err := r.WriteBuffersTo(&got)
+		if err != nil {
+			t.Fatalf("WriteBuffersTo(%v) failed: %v", r, err)
+		}
+		if n != int64(want.Len()) {
+			t.Errorf("%v: expected %d bytes written, got %d", r, want.Len(), n)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("%v: expected % x, got % x", r, want.Bytes(), got.Bytes())
+		}
+	}
+
+	invalid := &socks5.Reply{Version: socks5.SocksVersion, AddrType: socks5.AddrTypeIPv4}
+	if n, err := invalid.WriteBuffersTo(io.Discard); err == nil || n != 0 {
+		t.Errorf("expected validation error and no bytes, got n=%d err=%v", n, err)
+	}
+}
+
+func Test_Reply_WriteToBuffer(t *testing.T) {
+	replies := []*socks5.Reply{
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1080}),
+		socks5.NewSuccessReply(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 9050}),
+		socks5.NewErrorReply(socks5.RepHostUnreachable),
+		socks5.NewDomainSuccessReply("proxy.example.com", 443),
+		socks5.NewDomainSuccessReply(strings.Repeat("a", 25), 443), // longest that fits
+	}
+
+	for _, r := range replies {
+		var want bytes.Buffer
+		if _, err := r.WriteTo(&want); err != nil {
+			t.Fatalf("WriteTo(%v): %v", r, err)
+		}
+
+		var buf [32]byte
+		n, err := r.WriteToBuffer(&buf)
+		if err != nil {
+			t.Fatalf("WriteToBuffer(%v): %v", r, err)
+		}
+		if !bytes.Equal(buf[:n], want.Bytes()) {
+			t.Errorf("WriteToBuffer(%v) = %x, want %x", r, buf[:n], want.Bytes())
+		}
+	}
+
+	var buf [32]byte
+	if _, err := socks5.NewDomainSuccessReply(strings.Repeat("a", 26), 443).WriteToBuffer(&buf); err != io.ErrShortBuffer {
+		t.Errorf("26-byte domain: got %v, want io.ErrShortBuffer", err)
+	}
+	if _, err := socks5.NewDomainSuccessReply("", 443).WriteToBuffer(&buf); !errors.Is(err, socks5.ErrInvalidReplyDomain) {
+		t.Errorf("empty domain: got %v, want ErrInvalidReplyDomain", err)
+	}
+}
+
+// benchmarkReplyWrite writes a reply to a loopback TCP connection, where
+// WriteBuffersTo can use writev.
+func benchmarkReplyWrite(b *testing.B, write func(r *socks5.Reply, conn net.Conn) (int64, error)) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(io.Discard, c)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := socks5.NewSuccessReply(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 9050})
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := write(r, conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReply_WriteTo(b *testing.B) {
+	benchmarkReplyWrite(b, func(r *socks5.Reply, conn net.Conn) (int64, error) { return r.WriteTo(conn) })
+}
+
+func BenchmarkReply_WriteBuffersTo(b *testing.B) {
+	benchmarkReplyWrite(b, func(r *socks5.Reply, conn net.Conn) (int64, error) { return r.WriteBuffersTo(conn) })
+}
+
+func BenchmarkReplyWriteIPv4(b *testing.B) {
+	r := socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1080})
+
+	b.ReportAllocs()
+	for b.Loop() {
+		var buf [32]byte
+		if _, err := r.WriteToBuffer(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_Reply_ResolvedAddr(t *testing.T) {
+	ctx := context.Background()
+
+	addr, err := socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1080}).ResolvedAddr(ctx, nil)
+	if err != nil || !addr.IP.Equal(net.IPv4(10, 0, 0, 1)) || addr.Port != 1080 {
+		t.Fatalf("IPv4 reply: ResolvedAddr = (%v, %v), want 10.0.0.1:1080", addr, err)
+	}
+
+	addr, err = socks5.NewDomainSuccessReply("localhost", 1080).ResolvedAddr(ctx, nil)
+	if err != nil || !addr.IP.IsLoopback() || addr.Port != 1080 {
+		t.Fatalf("domain reply: ResolvedAddr = (%v, %v), want loopback:1080", addr, err)
+	}
+
+	if _, err := socks5.NewDomainSuccessReply("proxy.invalid", 1080).ResolvedAddr(ctx, nil); err == nil {
+		t.Fatal("expected error resolving proxy.invalid")
+	}
+}
@@ -1,11 +1,16 @@
 package socks5
 
 import (
-	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Common validation errors.
@@ -13,8 +18,15 @@ var (
 	ErrInvalidVersion = errors.New("invalid SOCKS version (must be 5)")
 	ErrInvalidCommand = errors.New("invalid command (must be 1=CONNECT, 2=BIND, 3=UDP ASSOCIATE, F0=RESOLVE, or F1=RESOLVE_PTR)")
 	ErrInvalidAddr    = errors.New("invalid address or address type")
-	ErrInvalidDomain  = errors.New("invalid domain (empty or too long)")
+	ErrInvalidDomain  = errors.New("invalid domain name")
 	ErrInvalidRSV     = errors.New("invalid reserved byte (must be 0x00)")
+
+	ErrInvalidResolveTarget = errors.New("invalid RESOLVE target (RESOLVE requires a domain, RESOLVE_PTR an IP address)")
+
+	ErrRequestTooLarge = errors.New("request exceeds size limit")
+
+	// ErrTrailingData is returned by UnmarshalBinary when data continues past the end of the message.
+	ErrTrailingData = internal.ErrTrailingData
 )
 
 // Request represents a SOCKS5 CONNECT/BIND/UDP ASSOCIATE/RESOLVE request.
@@ -60,6 +72,12 @@ func (r *Request) Init(
 	r.Port = port
 }
 
+// Reset clears the request for reuse. The capacity of IP is kept and filled by
+// the next ReadFrom of an IPv4 or IPv6 address.
+func (r *Request) Reset() {
+	*r = Request{IP: r.IP[:0]}
+}
+
 // ValidateHeader validates the SOCKS5 request header.
 func (r *Request) ValidateHeader() error {
 	if r.Version != SocksVersion {
@@ -83,19 +101,31 @@ func (r *Request) ValidateHeader() error {
 
 // Validate validates the full SOCKS5 request.
 func (r *Request) Validate() error {
+	return r.validate(false)
+}
+
+// validate is Validate, accepting '_' in DST.ADDR labels if allowUnderscore is set.
+func (r *Request) validate(allowUnderscore bool) error {
 	if err := r.ValidateHeader(); err != nil {
 		return err
 	}
 
-	switch r.AddrType {
-	case AddrTypeDomain:
-		if len(r.Domain) == 0 || len(r.Domain) > 255 {
-			return ErrInvalidDomain
+	if err := r.addr().validate(allowUnderscore); err != nil {
+		return err
+	}
+	return r.validateResolveTarget()
+}
+
+// validateResolveTarget checks that RESOLVE carries a domain and RESOLVE_PTR an IP address.
+func (r *Request) validateResolveTarget() error {
+	switch r.Command {
+	case CmdResolve:
+		if r.AddrType != AddrTypeDomain {
+			return ErrInvalidResolveTarget
 		}
-		return nil // domain is valid, IP may be nil
-	case AddrTypeIPv4, AddrTypeIPv6:
-		if r.IP == nil {
-			return ErrInvalidAddr
+	case CmdResolvePTR:
+		if r.AddrType != AddrTypeIPv4 && r.AddrType != AddrTypeIPv6 {
+			return ErrInvalidResolveTarget
 		}
 	}
 	return nil
@@ -104,15 +134,17 @@ func (r *Request) Validate() error {
 // ReadFrom reads a SOCKS5 request from a Reader.
 // Implements the io.ReaderFrom interface.
 func (r *Request) ReadFrom(src io.Reader) (int64, error) {
-	var (
-		total int64
-		hdr   [4]byte
-	)
+	return r.readFrom(src, false)
+}
+
+// readFrom is ReadFrom, validating the request with validate(allowUnderscore).
+func (r *Request) readFrom(src io.Reader, allowUnderscore bool) (int64, error) {
+	var total int64
 
-	n, err := io.ReadFull(src, hdr[:])
-	total += int64(n)
+	hdr, err := internal.ReadN(src, 4)
+	total += int64(len(hdr))
 	if err != nil {
-		return total, err
+		return total, parseError(msgRequest, "header", total, err)
 	}
 
 	r.Version = hdr[0]
@@ -121,124 +153,150 @@ func (r *Request) ReadFrom(src io.Reader) (int64, error) {
 	r.AddrType = hdr[3]
 
 	if err := r.ValidateHeader(); err != nil {
-		return total, err
-	}
-
-	switch r.AddrType {
-	case AddrTypeIPv4:
-		var buf [4]byte
-		n, err = io.ReadFull(src, buf[:])
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		r.IP = net.IP(buf[:])
-
-	case AddrTypeIPv6:
-		var buf [16]byte
-		n, err = io.ReadFull(src, buf[:])
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		r.IP = net.IP(buf[:])
-
-	case AddrTypeDomain:
-		var ln [1]byte
-		n, err = io.ReadFull(src, ln[:])
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		buf := make([]byte, ln[0])
-		n, err = io.ReadFull(src, buf)
-		total += int64(n)
-		if err != nil {
-			return total, err
-		}
-		r.Domain = string(buf)
+		return total, parseError(msgRequest, "", total, err)
 	}
 
-	var portBuf [2]byte
-	n, err = io.ReadFull(src, portBuf[:])
-	total += int64(n)
+	a := Addr{AddrType: r.AddrType, IP: r.IP, Domain: r.Domain}
+	n2, err := a.readBody(src)
+	total += n2
 	if err != nil {
-		return total, err
+		return total, parseError(msgRequest, "DST.ADDR", total, err)
 	}
-	r.Port = binary.BigEndian.Uint16(portBuf[:])
+	r.IP, r.Domain, r.Port = a.IP, a.Domain, a.Port
 
-	return total, r.Validate()
+	return total, parseError(msgRequest, "", total, r.validate(allowUnderscore))
+}
+
+// ReadFromLimited is like ReadFrom but reads at most maxBytes from src, failing
+// with ErrRequestTooLarge if the request is not complete by then.
+func (r *Request) ReadFromLimited(src io.Reader, maxBytes int64) (int64, error) {
+	return r.readFromLimited(src, maxBytes, false)
+}
+
+// readFromLimited is ReadFromLimited, validating as readFrom does.
+func (r *Request) readFromLimited(src io.Reader, maxBytes int64, allowUnderscore bool) (int64, error) {
+	var lr internal.LimitedReader
+	lr.Init(src, maxBytes)
+
+	n, err := r.readFrom(&lr, allowUnderscore)
+	if lr.Exceeded() && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return n, parseError(msgRequest, "", n, ErrRequestTooLarge)
+	}
+	return n, err
 }
 
 // WriteTo writes a SOCKS5 request to a Writer.
+// The request is validated first; nothing is written if it is malformed.
 // Implements the io.WriterTo interface.
 func (r *Request) WriteTo(dst io.Writer) (int64, error) {
-	var bufArr [264]byte
-	buf := bufArr[:0]
+	return r.writeTo(dst, false)
+}
+
+// writeTo is WriteTo, validating the request with validate(allowUnderscore).
+func (r *Request) writeTo(dst io.Writer, allowUnderscore bool) (int64, error) {
+	if err := r.validate(allowUnderscore); err != nil {
+		return 0, err
+	}
 
-	// Header
-	buf = append(buf, r.Version, r.Command, r.Reserved, r.AddrType)
+	bw := internal.GetWriter(dst)
+	defer internal.PutWriter(bw)
 
-	// Address
-	switch r.AddrType {
-	case AddrTypeIPv4:
-		buf = append(buf, r.IP.To4()...)
+	// Header and address
+	buf := append(bw.AvailableBuffer(), r.Version, r.Command, r.Reserved)
+	buf = r.addr().appendTo(buf)
 
-	case AddrTypeIPv6:
-		buf = append(buf, r.IP.To16()...)
+	// Single write
+	bw.Write(buf)
+	return internal.FlushWriter(bw)
+}
 
-	case AddrTypeDomain:
-		l := len(r.Domain)
-		if l > 255 {
-			return 0, fmt.Errorf("domain too long")
-		}
-		buf = append(buf, byte(l))
-		buf = append(buf, r.Domain...)
+// MarshalBinary implements encoding.BinaryMarshaler for Request.
+func (r *Request) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(r)
+}
 
-	default:
-		return 0, fmt.Errorf("invalid address type")
-	}
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for Request.
+func (r *Request) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(r, data)
+}
 
-	// Port
-	buf = binary.BigEndian.AppendUint16(buf, r.Port)
+// AddrPort returns the destination address as a netip.AddrPort, or the zero AddrPort if ATYP is DOMAIN.
+func (r *Request) AddrPort() netip.AddrPort {
+	return r.addr().AddrPort()
+}
 
-	// Single write
-	n, err := dst.Write(buf)
-	return int64(n), err
+// SetAddrPort sets the destination address from ap, choosing ATYP automatically.
+// IPv4-mapped IPv6 addresses are stored as AddrTypeIPv4.
+func (r *Request) SetAddrPort(ap netip.AddrPort) {
+	r.AddrType, r.IP = addrFromNetip(ap.Addr())
+	r.Domain = ""
+	r.Port = ap.Port()
 }
 
-// String returns a string representation of the SOCKS5 Request.
-func (r *Request) String() string {
-	var cmd string
-	switch r.Command {
-	case CmdConnect:
-		cmd = "CONNECT"
-	case CmdBind:
-		cmd = "BIND"
-	case CmdUDPAssociate:
-		cmd = "UDP_ASSOCIATE"
-	case CmdResolve:
-		cmd = "RESOLVE"
-	case CmdResolvePTR:
-		cmd = "RESOLVE_PTR"
-	default:
-		cmd = fmt.Sprintf("UNKNOWN(0x%02X)", r.Command)
+// DomainPort returns the destination address domain and port if ATYP is DOMAIN.
+func (r *Request) DomainPort() (domain string, port uint16, ok bool) {
+	return r.addr().DomainPort()
+}
+
+// CommandType returns the request command as a Command.
+func (r *Request) CommandType() Command {
+	return Command(r.Command)
+}
+
+// addr returns the destination address of the request.
+func (r *Request) addr() *Addr {
+	return &Addr{AddrType: r.AddrType, IP: r.IP, Domain: r.Domain, Port: r.Port}
+}
+
+// Equal reports whether Request r and other have the same command and target.
+// IPs are compared as in Addr.Equal.
+func (r *Request) Equal(other *Request) bool {
+	if r == nil || other == nil {
+		return r == other
 	}
+	return r.Version == other.Version &&
+		r.Command == other.Command &&
+		r.Reserved == other.Reserved &&
+		r.AddrType == other.AddrType &&
+		r.IP.Equal(other.IP) &&
+		r.Domain == other.Domain &&
+		r.Port == other.Port
+}
 
-	var atype string
-	switch r.AddrType {
-	case AddrTypeIPv4:
-		atype = "IPv4"
-	case AddrTypeDomain:
-		atype = "DOMAIN"
-	case AddrTypeIPv6:
-		atype = "IPv6"
-	default:
-		atype = fmt.Sprintf("0x%02X", r.AddrType)
+// Clone returns a deep copy of r.
+func (r *Request) Clone() *Request {
+	if r == nil {
+		return nil
 	}
+	c := *r
+	c.IP = slices.Clone(r.IP)
+	return &c
+}
 
+// String returns a string representation of the SOCKS5 Request.
+func (r *Request) String() string {
 	return fmt.Sprintf(
 		"SOCKS5 Request{Cmd=%s, AddrType=%s, Host=%s, Port=%d, Version=%d, RSV=%#02x}",
-		cmd, atype, r.GetHost(), r.Port, r.Version, r.Reserved,
+		r.CommandType(), AddrType(r.AddrType), r.GetHost(), r.Port, r.Version, r.Reserved,
+	)
+}
+
+// LogValue implements slog.LogValuer.
+func (r *Request) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("cmd", r.CommandType().String()),
+		slog.String("atyp", AddrType(r.AddrType).String()),
+		slog.String("host", r.GetHost()),
+		slog.Int("port", int(r.Port)),
 	)
 }
+
+// MarshalJSON implements json.Marshaler with stable field names for audit logs.
+func (r *Request) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Cmd  string `json:"cmd"`
+		Atyp string `json:"atyp"`
+		Host string `json:"host"`
+		Port uint16 `json:"port"`
+	}{r.CommandType().String(), AddrType(r.AddrType).String(), r.GetHost(), r.Port})
+}
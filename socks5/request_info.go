@@ -0,0 +1,37 @@
+package socks5
+
+import (
+	"context"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// RequestInfo is a version-agnostic view of a request (see socksnet.RequestInfo).
+type RequestInfo = socksnet.RequestInfo
+
+// NewRequestInfo returns a RequestInfo for req. The username is taken from ctx
+// as set during authentication (see UsernameFromContext).
+func NewRequestInfo(ctx context.Context, req *Request) RequestInfo {
+	return &requestInfo{req: req, user: contextUser(ctx)}
+}
+
+// requestInfo adapts a Request to RequestInfo.
+type requestInfo struct {
+	req  *Request
+	user string
+}
+
+func (r *requestInfo) Version() int     { return SocksVersion }
+func (r *requestInfo) Command() string  { return r.req.CommandType().String() }
+func (r *requestInfo) Host() string     { return r.req.GetHost() }
+func (r *requestInfo) Port() uint16     { return r.req.Port }
+func (r *requestInfo) Username() string { return r.user }
+
+// contextUser returns the authenticated username or GSSAPI client name from ctx.
+func contextUser(ctx context.Context) string {
+	if user, ok := UsernameFromContext(ctx); ok {
+		return user
+	}
+	name, _ := GSSAPIClientNameFromContext(ctx)
+	return name
+}
@@ -1,9 +1,12 @@
 package socks5_test
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
+	"io"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/33TU/socks/socks5"
@@ -42,11 +45,8 @@ func Test_Request_WriteTo_ReadFrom_RoundTrip_IPv4(t *testing.T) {
 	if n1 != n2 {
 		t.Errorf("expected %d bytes read, got %d", n1, n2)
 	}
-	if !parsed.IP.Equal(orig.IP) {
-		t.Errorf("expected IP %v, got %v", orig.IP, parsed.IP)
-	}
-	if parsed.Port != orig.Port {
-		t.Errorf("expected port %d, got %d", orig.Port, parsed.Port)
+	if !parsed.Equal(orig) {
+		t.Errorf("expected %v, got %v", orig, &parsed)
 	}
 }
 
@@ -66,11 +66,8 @@ func Test_Request_WriteTo_ReadFrom_RoundTrip_Domain(t *testing.T) {
 		t.Fatalf("ReadFrom failed: %v", err)
 	}
 
-	if parsed.Domain != orig.Domain {
-		t.Errorf("expected domain %q, got %q", orig.Domain, parsed.Domain)
-	}
-	if parsed.Port != orig.Port {
-		t.Errorf("expected port %d, got %d", orig.Port, parsed.Port)
+	if !parsed.Equal(orig) {
+		t.Errorf("expected %v, got %v", orig, &parsed)
 	}
 }
 
@@ -91,11 +88,8 @@ func Test_Request_WriteTo_ReadFrom_RoundTrip_IPv6(t *testing.T) {
 		t.Fatalf("ReadFrom failed: %v", err)
 	}
 
-	if !parsed.IP.Equal(ip) {
-		t.Errorf("expected IP %v, got %v", ip, parsed.IP)
-	}
-	if parsed.Port != 9050 {
-		t.Errorf("expected port 9050, got %d", parsed.Port)
+	if !parsed.Equal(orig) {
+		t.Errorf("expected %v, got %v", orig, &parsed)
 	}
 }
 
@@ -117,6 +111,25 @@ func Test_Request_Validate_Invalid(t *testing.T) {
 	}
 }
 
+func Test_Request_ReadFrom_ZeroLengthDomain(t *testing.T) {
+	// The domain length is rejected before anything after it is read
+	src := bytes.NewReader([]byte{socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeDomain, 0x00, 0x00, 0x50})
+
+	var r socks5.Request
+	n, err := r.ReadFrom(src)
+	if !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Fatalf("expected ErrInvalidDomain, got %v", err)
+	}
+	if n != 5 || src.Len() != 2 {
+		t.Fatalf("read %d bytes leaving %d, want 5 leaving 2", n, src.Len())
+	}
+
+	var pe *socks5.ParseError
+	if !errors.As(err, &pe) || pe.Field != "ADDR" || pe.Offset != 5 {
+		t.Fatalf("expected ParseError at ADDR offset 5, got %v", err)
+	}
+}
+
 func Test_Request_ResolveCommands(t *testing.T) {
 	r := &socks5.Request{}
 	r.Init(5, socks5.CmdResolve, 0x00, socks5.AddrTypeDomain, nil, "example.com", 0)
@@ -130,6 +143,36 @@ func Test_Request_ResolveCommands(t *testing.T) {
 	}
 }
 
+func Test_Request_ResolveCommands_InvalidTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      byte
+		addrType byte
+		ip       net.IP
+		domain   string
+	}{
+		{"RESOLVE with IPv4", socks5.CmdResolve, socks5.AddrTypeIPv4, net.IPv4(8, 8, 8, 8), ""},
+		{"RESOLVE with IPv6", socks5.CmdResolve, socks5.AddrTypeIPv6, net.ParseIP("2001:db8::1"), ""},
+		{"RESOLVE_PTR with domain", socks5.CmdResolvePTR, socks5.AddrTypeDomain, nil, "example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &socks5.Request{}
+			r.Init(5, tt.cmd, 0x00, tt.addrType, tt.ip, tt.domain, 0)
+			if err := r.Validate(); !errors.Is(err, socks5.ErrInvalidResolveTarget) {
+				t.Errorf("expected ErrInvalidResolveTarget, got %v", err)
+			}
+		})
+	}
+
+	r := &socks5.Request{}
+	r.Init(5, socks5.CmdResolvePTR, 0x00, socks5.AddrTypeIPv6, net.ParseIP("2001:db8::1"), "", 0)
+	if err := r.Validate(); err != nil {
+		t.Errorf("expected valid IPv6 RESOLVE_PTR request, got %v", err)
+	}
+}
+
 func Test_Request_String(t *testing.T) {
 	r := &socks5.Request{}
 	r.Init(socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeDomain, nil, "user.example.com", 8080)
@@ -138,3 +181,154 @@ func Test_Request_String(t *testing.T) {
 		t.Errorf("expected non-empty String() output")
 	}
 }
+
+func BenchmarkRequestWriteTo(b *testing.B) {
+	var r socks5.Request
+	r.Init(socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeDomain, nil, "www.example.com", 443)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := r.WriteTo(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRequestReadFrom(b *testing.B) {
+	var orig socks5.Request
+	orig.Init(socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeDomain, nil, "www.example.com", 443)
+	raw, err := orig.MarshalBinary()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// The server reads requests through a bufio.Reader
+	b.Run("bufio", func(b *testing.B) {
+		var (
+			r   socks5.Request
+			src bytes.Reader
+		)
+		br := bufio.NewReader(&src)
+
+		b.ReportAllocs()
+		for b.Loop() {
+			src.Reset(raw)
+			br.Reset(&src)
+			if _, err := r.ReadFrom(br); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("reader", func(b *testing.B) {
+		var (
+			r   socks5.Request
+			src bytes.Reader
+		)
+
+		b.ReportAllocs()
+		for b.Loop() {
+			src.Reset(raw)
+			if _, err := r.ReadFrom(&src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func Test_Request_WriteTo_MislabeledAddrType(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrType byte
+		ip       net.IP
+		wantErr  bool
+	}{
+		{"IPv4 16-byte form", socks5.AddrTypeIPv4, net.IPv4(127, 0, 0, 1), false},
+		{"IPv6 labeled IPv4", socks5.AddrTypeIPv4, net.ParseIP("2001:db8::1"), true},
+		{"4-byte IP labeled IPv6", socks5.AddrTypeIPv6, net.IPv4(127, 0, 0, 1).To4(), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r socks5.Request
+			r.Init(socks5.SocksVersion, socks5.CmdConnect, 0x00, tt.addrType, tt.ip, "", 80)
+
+			if err := r.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var buf bytes.Buffer
+			_, err := r.WriteTo(&buf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteTo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && buf.Len() != 0 {
+				t.Fatalf("WriteTo() wrote %d bytes for a malformed request", buf.Len())
+			}
+			if !tt.wantErr && buf.Len() != 10 {
+				t.Fatalf("WriteTo() wrote %d bytes, want 10", buf.Len())
+			}
+		})
+	}
+}
+
+func Test_Request_ReadFromLimited(t *testing.T) {
+	req := socks5.Request{Version: 5, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeDomain, Domain: strings.Repeat("a.", 99) + "a", Port: 80}
+	b, err := req.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	var got socks5.Request
+	if _, err := got.ReadFromLimited(bytes.NewReader(b), 64); !errors.Is(err, socks5.ErrRequestTooLarge) {
+		t.Fatalf("over limit: got %v, want ErrRequestTooLarge", err)
+	}
+	if n, err := got.ReadFromLimited(bytes.NewReader(b), int64(len(b))); err != nil || n != int64(len(b)) {
+		t.Fatalf("at limit: got (%d, %v), want (%d, nil)", n, err, len(b))
+	}
+	if _, err := got.ReadFromLimited(bytes.NewReader(b[:len(b)-1]), 1024); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated: got %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func Test_Request_Reset(t *testing.T) {
+	orig := &socks5.Request{}
+	orig.Init(socks5.SocksVersion, socks5.CmdConnect, 0x00, socks5.AddrTypeIPv4, net.IPv4(192, 168, 0, 10).To4(), "", 1080)
+
+	data, err := orig.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	var r socks5.Request
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	ip := r.IP
+
+	r.Reset()
+	if len(r.IP) != 0 || r.Version != 0 || r.Port != 0 {
+		t.Fatalf("expected cleared request, got %+v", r)
+	}
+
+	// the retained IP capacity is reused
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary after Reset failed: %v", err)
+	}
+	if &r.IP[0] != &ip[0] {
+		t.Error("expected IP backing array to be reused after Reset")
+	}
+	if !r.Equal(orig) {
+		t.Errorf("expected %v, got %v", orig, &r)
+	}
+
+	// an IP set by the caller is never written to
+	shared := net.IPv4(10, 0, 0, 1).To4()
+	r.IP = shared
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if !shared.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("caller's IP was overwritten: %v", shared)
+	}
+}
@@ -0,0 +1,112 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strings"
+	"sync"
+)
+
+// ErrNoRoute is returned by Router.Connect when no route matches a request and
+// the Router has no Default handler.
+var ErrNoRoute = errors.New("no route for destination")
+
+// Router dispatches CONNECT requests to handlers by destination. Routes are
+// tried in registration order and the first match handles the request; requests
+// matching no route go to Default. Use Connect as BaseServerHandler.ConnectHandler:
+//
+//	router := &socks5.Router{Default: handler.DefaultConnect}
+//	router.Handle("192.168.0.0/16", reject)
+//	router.Handle("*.example.com", handler.DefaultConnect)
+//	handler.ConnectHandler = router.Connect
+type Router struct {
+	// Default handles requests that match no route (nil=reject with
+	// RepConnectionNotAllowed and return ErrNoRoute).
+	Default ConnectHandler
+
+	mu     sync.RWMutex
+	routes []route
+}
+
+// route is a Router entry.
+type route struct {
+	match   func(req *Request) bool
+	handler ConnectHandler
+}
+
+// Handle routes requests whose destination matches pattern to handler.
+//
+// A pattern containing "/" is a CIDR prefix such as "10.0.0.0/8" and matches
+// requests for an IP address within it. Any other pattern is a glob in the
+// syntax of path.Match, such as "*.example.com", matched case-insensitively
+// against the requested domain name or IP address. Domain names are not
+// resolved, so a CIDR route never matches a DOMAIN request.
+func (r *Router) Handle(pattern string, handler ConnectHandler) error {
+	if strings.Contains(pattern, "/") {
+		prefix, err := netip.ParsePrefix(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid route pattern %q: %w", pattern, err)
+		}
+		prefix = prefix.Masked()
+
+		r.add(func(req *Request) bool {
+			ap := req.AddrPort()
+			return ap.IsValid() && prefix.Contains(ap.Addr().Unmap())
+		}, handler)
+		return nil
+	}
+
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid route pattern %q: %w", pattern, err)
+	}
+
+	r.add(func(req *Request) bool {
+		host := strings.ToLower(strings.TrimSuffix(req.GetHost(), "."))
+		ok, _ := path.Match(pattern, host)
+		return ok
+	}, handler)
+	return nil
+}
+
+// HandlePort routes requests for the destination port to handler.
+func (r *Router) HandlePort(port uint16, handler ConnectHandler) {
+	r.add(func(req *Request) bool {
+		return req.Port == port
+	}, handler)
+}
+
+// Connect handles req with the first matching route, or with Default.
+// It is a ConnectHandler.
+func (r *Router) Connect(ctx context.Context, conn net.Conn, req *Request) error {
+	if handler := r.lookup(req); handler != nil {
+		return handler(ctx, conn, req)
+	}
+
+	WriteRejectReply(conn, RepConnectionNotAllowed)
+	return ErrNoRoute
+}
+
+// add appends a route.
+func (r *Router) add(match func(req *Request) bool, handler ConnectHandler) {
+	r.mu.Lock()
+	r.routes = append(r.routes, route{match: match, handler: handler})
+	r.mu.Unlock()
+}
+
+// lookup returns the handler of the first route matching req, or Default.
+func (r *Router) lookup(req *Request) ConnectHandler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, rt := range r.routes {
+		if rt.match(req) {
+			return rt.handler
+		}
+	}
+	return r.Default
+}
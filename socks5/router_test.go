@@ -0,0 +1,85 @@
+package socks5_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func TestRouter(t *testing.T) {
+	var called []string
+	record := func(name string) socks5.ConnectHandler {
+		return func(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+
+	router := &socks5.Router{Default: record("default")}
+	if err := router.Handle("192.168.0.0/16", func(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+		called = append(called, "reject")
+		socks5.WriteRejectReply(conn, socks5.RepConnectionNotAllowed)
+		return socks5.ReplyCode(socks5.RepConnectionNotAllowed)
+	}); err != nil {
+		t.Fatalf("Handle CIDR: %v", err)
+	}
+	if err := router.Handle("*.allowed.com", record("allow")); err != nil {
+		t.Fatalf("Handle glob: %v", err)
+	}
+	router.HandlePort(25, record("smtp"))
+
+	tests := []struct {
+		host string
+		port uint16
+		want string
+	}{
+		{"192.168.1.10", 80, "reject"},
+		{"::ffff:192.168.0.1", 80, "reject"},
+		{"www.allowed.com", 443, "allow"},
+		{"WWW.Allowed.COM.", 443, "allow"},
+		{"allowed.com", 443, "default"},
+		{"10.0.0.1", 80, "default"},
+		{"mail.example.org", 25, "smtp"},
+		{"192.168.5.5", 25, "reject"}, // first match wins
+	}
+
+	for _, tt := range tests {
+		called = called[:0]
+
+		req := &socks5.Request{Version: socks5.SocksVersion, Command: socks5.CmdConnect, Port: tt.port}
+		if ip := net.ParseIP(tt.host); ip != nil {
+			req.AddrType, req.IP = socks5.AddrTypeIPv6, ip
+			if ip4 := ip.To4(); ip4 != nil {
+				req.AddrType, req.IP = socks5.AddrTypeIPv4, ip4
+			}
+		} else {
+			req.AddrType, req.Domain = socks5.AddrTypeDomain, tt.host
+		}
+
+		router.Connect(context.Background(), &replayConn{}, req)
+		if len(called) != 1 || called[0] != tt.want {
+			t.Errorf("%s:%d: expected %s handler, got %v", tt.host, tt.port, tt.want, called)
+		}
+	}
+}
+
+func TestRouter_NoDefault(t *testing.T) {
+	router := &socks5.Router{}
+	req := &socks5.Request{Version: socks5.SocksVersion, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 80}
+
+	if err := router.Connect(context.Background(), &replayConn{}, req); !errors.Is(err, socks5.ErrNoRoute) {
+		t.Fatalf("expected ErrNoRoute, got %v", err)
+	}
+}
+
+func TestRouter_InvalidPattern(t *testing.T) {
+	router := &socks5.Router{}
+	for _, pattern := range []string{"10.0.0.0/33", "host/name", "[a-"} {
+		if err := router.Handle(pattern, nil); err == nil {
+			t.Errorf("expected error for pattern %q", pattern)
+		}
+	}
+}
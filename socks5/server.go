@@ -3,31 +3,68 @@ package socks5
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/33TU/socks/internal"
+	socksnet "github.com/33TU/socks/net"
+)
+
+// ErrNotSOCKS is passed to OnError and returned by ServeConn when a client is
+// not speaking SOCKS5 at all, e.g. an HTTP or TLS client (see socksnet.SniffNotSOCKS).
+type ErrNotSOCKS = socksnet.ErrNotSOCKS
+
+// PhaseError is passed to OnError and OnClose and returned by ServeConn. It
+// tags an error with the connection phase it occurred in (see socksnet.Phase).
+type PhaseError = socksnet.PhaseError
+
+// AuditRecord describes a proxied connection for the audit hook (see
+// BaseServerHandler.OnAudit). Passwords and GSSAPI tokens are never recorded.
+type AuditRecord = socksnet.AuditRecord
+
+// PanicEntry describes a panic recovered while serving a connection, for the
+// panic hook (see BaseServerHandler.OnPanicEntry).
+type PanicEntry = socksnet.PanicEntry
+
+// Per-connection messages reused by ServeConn.
+var (
+	handshakeRequestPool = sync.Pool{New: func() any { return new(HandshakeRequest) }}
+	requestPool          = sync.Pool{New: func() any { return new(Request) }}
 )
 
 // DefaultServerHandler is a default implementation used when no custom ServerHandler is provided to Serve or ListenAndServe.
-var DefaultServerHandler ServerHandler = &BaseServerHandler{
-	RequestTimeout:         10 * time.Second,
-	BindAcceptTimeout:      10 * time.Second,
-	BindConnTimeout:        60 * time.Second,
-	ConnectConnTimeout:     60 * time.Second,
-	UDPAssociateTimeout:    300 * time.Second,
-	ConnectBufferSize:      1024 * 32,
-	UDPAssociateBufferSize: 1024 * 64,
-	AllowConnect:           true,
-	AllowBind:              false,
-	AllowUDPAssociate:      false,
-	SupportedMethods:       []byte{MethodNoAuth},
-	UserPassAuthenticator:  nil,
-	GSSAPIAuthenticator:    nil,
+var DefaultServerHandler ServerHandler = newDefaultServerHandler()
+
+// newDefaultServerHandler returns a BaseServerHandler with the default settings.
+func newDefaultServerHandler() *BaseServerHandler {
+	return &BaseServerHandler{
+		RequestTimeout:         10 * time.Second,
+		BindAcceptTimeout:      10 * time.Second,
+		BindConnTimeout:        60 * time.Second,
+		ConnectConnTimeout:     60 * time.Second,
+		UDPAssociateTimeout:    300 * time.Second,
+		ConnectBufferSize:      1024 * 32,
+		UDPAssociateBufferSize: 1024 * 64,
+		AllowConnect:           true,
+		AllowBind:              false,
+		AllowUDPAssociate:      false,
+		SupportedMethods:       []byte{MethodNoAuth},
+		UserPassAuthenticator:  nil,
+		GSSAPIAuthenticator:    nil,
+	}
 }
 
 // ServerHandler handles SOCKS5 server events.
+//
+// The *HandshakeRequest and *Request passed to the handler are pooled by
+// ServeConn and reused for later connections once it returns. Handlers and
+// the hooks they call must not keep them, or slices such as Methods and IP,
+// past ServeConn; use Clone to retain a copy.
 type ServerHandler interface {
 	// OnAccept is called for each accepted connection.
 	OnAccept(ctx context.Context, conn net.Conn) error
@@ -60,7 +97,8 @@ type ServerHandler interface {
 	// OnResolve is called for each RESOLVE request.
 	OnResolve(ctx context.Context, conn net.Conn, req *Request) error
 
-	// OnError is called for each connection error.
+	// OnError is called for each connection error. Errors are *PhaseError,
+	// telling e.g. a failed dial from a malformed request or a client timeout.
 	OnError(ctx context.Context, conn net.Conn, err error)
 
 	// OnPanic is called when a panic occurs in any handler goroutine.
@@ -78,6 +116,8 @@ func Serve(ctx context.Context, listener net.Listener, handler ServerHandler) er
 		listener.Close()
 	}()
 
+	backoff := socksnet.AcceptBackoff{Max: acceptMaxBackoff(handler)}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -85,15 +125,104 @@ func Serve(ctx context.Context, listener net.Listener, handler ServerHandler) er
 		default:
 			conn, err := listener.Accept()
 			if err != nil {
-				handler.OnError(ctx, nil, err)
-				continue
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				// Retry temporary errors (e.g. EMFILE) with backoff, give up on permanent ones
+				handler.OnError(ctx, nil, socksnet.WithPhase(socksnet.PhaseAccept, err))
+				if backoff.Wait(ctx, err) || ctx.Err() != nil {
+					continue
+				}
+				return err
 			}
 
+			backoff.Reset()
 			go ServeConn(ctx, handler, conn)
 		}
 	}
 }
 
+// acceptBackoffHandler is implemented by handlers that configure the accept backoff.
+type acceptBackoffHandler interface {
+	GetAcceptMaxBackoff() time.Duration
+}
+
+// acceptMaxBackoff returns the handler's maximum accept backoff, or 0 for the default.
+func acceptMaxBackoff(handler ServerHandler) time.Duration {
+	if h, ok := handler.(acceptBackoffHandler); ok {
+		return h.GetAcceptMaxBackoff()
+	}
+	return 0
+}
+
+// handshakeTimeoutHandler is implemented by handlers that time the handshake separately from the request.
+type handshakeTimeoutHandler interface {
+	GetHandshakeTimeout() time.Duration
+	GetRequestTimeout() time.Duration
+}
+
+// maxRequestSizeHandler is implemented by handlers that cap the size of the request read.
+type maxRequestSizeHandler interface {
+	GetMaxRequestSize() int64
+}
+
+// domainUnderscoreHandler is implemented by handlers that accept '_' in request domain names.
+type domainUnderscoreHandler interface {
+	GetAllowDomainUnderscore() bool
+}
+
+// authFailureHandler is implemented by handlers that slow down or limit failed authentication.
+type authFailureHandler interface {
+	GetAuthFailureDelay() time.Duration
+	GetMaxAuthAttempts() int
+}
+
+// authFailurePolicy returns the handler's delay after a failed authentication
+// and the number of attempts allowed per connection (at least 1).
+func authFailurePolicy(handler ServerHandler) (time.Duration, int) {
+	if h, ok := handler.(authFailureHandler); ok {
+		return h.GetAuthFailureDelay(), max(h.GetMaxAuthAttempts(), 1)
+	}
+	return 0, 1
+}
+
+// waitAuthFailure sleeps for delay after a failure reply so that each guess costs
+// the client time. It returns early if ctx is done.
+func waitAuthFailure(ctx context.Context, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// gssapiProtectionHandler is implemented by handlers that protect GSSAPI-authenticated
+// connections with per-message wrapping. A nil mechanism disables the sub-negotiation.
+type gssapiProtectionHandler interface {
+	GetGSSAPIProtection(ctx context.Context, conn net.Conn) (mech GSSAPIMechanism, level byte)
+}
+
+// gssapiTokenLimitHandler is implemented by handlers that cap the GSSAPI tokens read from clients.
+type gssapiTokenLimitHandler interface {
+	GetGSSAPIMaxTokenLen() int
+}
+
+// gssapiReadOptions returns the limits GSSAPI tokens from clients of handler are read with.
+func gssapiReadOptions(handler ServerHandler) GSSAPIValidateOptions {
+	var opts GSSAPIValidateOptions
+	if h, ok := handler.(gssapiTokenLimitHandler); ok {
+		opts.MaxTokenLength = h.GetGSSAPIMaxTokenLen()
+	}
+	return opts
+}
+
 // ListenAndServe listens on the network address and serves SOCKS5 requests.
 func ListenAndServe(ctx context.Context, network, address string, handler ServerHandler) error {
 	ln, err := net.Listen(network, address)
@@ -110,21 +239,71 @@ func ServeConn(ctx context.Context, handler ServerHandler, conn net.Conn) (err e
 		return fmt.Errorf("nil handler provided")
 	}
 
+	start := time.Now()
+	connID := socksnet.NewConnID()
+	audit := auditHook(handler)
+	onPanic := panicHook(handler)
+	states := newConnStates(conn, stateChangeHook(handler))
+	lenient := newLeniency(handler)
+
+	// Messages are pooled across connections (see ServerHandler)
+	handshakeReq := handshakeRequestPool.Get().(*HandshakeRequest)
+	req := requestPool.Get().(*Request)
+
+	var (
+		hasReq  bool
+		auditor *socksnet.AuditConn
+	)
+
 	defer func() {
 		if r := recover(); r != nil {
-			handler.OnPanic(ctx, conn, r)
+			if onPanic != nil {
+				onPanic(ctx, conn, socksnet.NewPanicEntry(r, connID))
+			} else {
+				handler.OnPanic(ctx, conn, r)
+			}
 		}
 
 		handler.OnClose(ctx, conn, err)
 		_ = conn.Close()
+		states.set(ConnStateClosed)
+
+		if audit != nil {
+			var r *Request
+			if hasReq {
+				r = req
+			}
+			audit(ctx, newAuditRecord(ctx, start, conn, r, auditor, err))
+		}
+
+		handshakeReq.Reset()
+		handshakeRequestPool.Put(handshakeReq)
+		req.Reset()
+		requestPool.Put(req)
 	}()
 
-	// OnAccept callback
-	if err = handler.OnAccept(ctx, conn); err != nil {
+	// fail tags err with the phase it occurred in and reports it
+	fail := func(phase socksnet.Phase, cause error) error {
+		err = socksnet.WithPhase(phase, cause)
 		handler.OnError(ctx, conn, err)
 		return err
 	}
 
+	// OnAccept callback
+	if err = handler.OnAccept(ctx, conn); err != nil {
+		return fail(socksnet.PhaseAccept, err)
+	}
+	states.set(ConnStateHandshaking)
+
+	// Mirror negotiation and the request to the debug capture, if any
+	var tee *socksnet.TeeConn
+	if h, ok := handler.(debugCaptureHandler); ok {
+		if w := h.GetDebugCapture(); w != nil {
+			tee = socksnet.NewTeeConn(conn, w, w)
+			conn = tee
+		}
+	}
+
 	// Use reused reader to reduce allocations
 	reader := internal.GetReader(conn)
 	released := false
@@ -139,120 +318,316 @@ func ServeConn(ctx context.Context, handler ServerHandler, conn net.Conn) (err e
 	}
 	defer release()
 
+	// Handshake and authentication may have their own deadline
+	var handshakeTimeout, requestTimeout time.Duration
+	if h, ok := handler.(handshakeTimeoutHandler); ok {
+		handshakeTimeout, requestTimeout = h.GetHandshakeTimeout(), h.GetRequestTimeout()
+	}
+	if handshakeTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	}
+
+	// Tell clients speaking another protocol apart from malformed handshakes
+	if err = socksnet.SniffNotSOCKS(reader, SocksVersion); err != nil {
+		return fail(socksnet.PhaseHandshake, err)
+	}
+
 	// Phase 1: Handshake (method negotiation)
-	var handshakeReq HandshakeRequest
 	if _, err = handshakeReq.ReadFrom(reader); err != nil {
 		// Send "No acceptable methods" reply for malformed handshake
-		WriteHandshake(conn, MethodNoAcceptable)
-		handler.OnError(ctx, conn, err)
-		return err
+		if isProtocolErr(err) {
+			WriteHandshake(conn, MethodNoAcceptable)
+		}
+		return fail(socksnet.PhaseHandshake, err)
 	}
 
 	var selectedMethod byte
-	selectedMethod, err = handler.OnHandshake(ctx, conn, &handshakeReq)
+	selectedMethod, err = handler.OnHandshake(ctx, conn, handshakeReq)
 	if err != nil {
 		// Send "No acceptable methods" reply
 		WriteHandshake(conn, MethodNoAcceptable)
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseHandshake, err)
 	}
 
 	// Send handshake reply
 	if err = WriteHandshake(conn, selectedMethod); err != nil {
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseHandshake, err)
 	}
 
 	if selectedMethod == MethodNoAcceptable {
 		err = fmt.Errorf("no acceptable authentication methods")
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseHandshake, err)
 	}
 
 	// Phase 2: Authentication (if required)
+	if selectedMethod != MethodNoAuth {
+		states.set(ConnStateAuthenticating)
+	}
 	switch selectedMethod {
 	case MethodNoAuth:
 		// No authentication required, proceed to request phase
 	case MethodUserPass:
-		if err = handleUserPassAuth(ctx, handler, conn, reader); err != nil {
+		var username string
+		if username, err = handleUserPassAuth(ctx, handler, conn, reader, &lenient); err != nil {
 			// Auth function already sent UserPassReply with failure status
-			handler.OnError(ctx, conn, err)
-			return err
+			return fail(socksnet.PhaseAuth, err)
 		}
+		ctx = contextWithUsername(ctx, username)
 	case MethodGSSAPI:
-		if err = handleGSSAPIAuth(ctx, handler, conn, reader); err != nil {
+		if ctx, err = handleGSSAPIAuth(ctx, handler, conn, reader); err != nil {
 			// Auth function already sent GSSAPIReply with failure/abort
-			handler.OnError(ctx, conn, err)
-			return err
+			return fail(socksnet.PhaseAuth, err)
+		}
+
+		// Everything after a protection-level agreement is encapsulated
+		if h, ok := handler.(gssapiProtectionHandler); ok {
+			if mech, level := h.GetGSSAPIProtection(ctx, conn); mech != nil {
+				opts := gssapiReadOptions(handler)
+				if err = acceptGSSAPIProtection(conn, reader, mech, level, opts); err != nil {
+					return fail(socksnet.PhaseAuth, err)
+				}
+				wrapped := NewGSSAPIWrappedConn(conn, mech)
+				wrapped.opts = opts
+				conn = wrapped
+				reader.Reset(conn)
+			}
 		}
 	default:
 		WriteRejectReply(conn, RepGeneralFailure)
 		err = fmt.Errorf("unsupported authentication method: %d", selectedMethod)
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseAuth, err)
+	}
+
+	// The request read gets its own deadline once authentication is done
+	if handshakeTimeout > 0 {
+		var deadline time.Time
+		if requestTimeout > 0 {
+			deadline = time.Now().Add(requestTimeout)
+		}
+		conn.SetDeadline(deadline)
+	}
+
+	// Record replies and relayed bytes for the audit hook
+	if audit != nil {
+		auditor = socksnet.NewAuditConn(conn)
+		conn = auditor
 	}
 
 	// Phase 3: Request processing
-	var req Request
-	if _, err = req.ReadFrom(reader); err != nil {
+	states.set(ConnStateProcessingRequest)
+
+	var maxRequestSize int64
+	if h, ok := handler.(maxRequestSizeHandler); ok {
+		maxRequestSize = h.GetMaxRequestSize()
+	}
+	var allowUnderscore bool
+	if h, ok := handler.(domainUnderscoreHandler); ok {
+		allowUnderscore = h.GetAllowDomainUnderscore()
+	}
+
+	_, err = lenient.read(ctx, conn, reader, TolerateRequestRSV, 2, 0x00, func(src io.Reader) (int64, error) {
+		if maxRequestSize > 0 {
+			return req.readFromLimited(src, maxRequestSize, allowUnderscore)
+		}
+		return req.readFrom(src, allowUnderscore)
+	})
+	if err != nil {
 		WriteRejectReply(conn, RepGeneralFailure)
-		handler.OnError(ctx, conn, err)
-		return err
+		return fail(socksnet.PhaseRequest, err)
+	}
+	hasReq = true
+	if auditor != nil && req.Command == CmdBind {
+		auditor.Replies = 2
+	}
+	if tee != nil {
+		tee.Reads, tee.Writes = nil, nil
+	}
+
+	// Watch the replies for the start of the relay
+	if states != nil {
+		conn = &stateConn{Conn: conn, states: states, replies: relayReplies(req.Command)}
 	}
 
 	// Release reader/writer resources before handling request
 	release()
 
 	// Handle the request through the handler
-	if err = handler.OnRequest(ctx, conn, &req); err != nil {
-		handler.OnError(ctx, conn, err)
-		return err
+	if err = handler.OnRequest(ctx, conn, req); err != nil {
+		return fail(socksnet.PhaseRequest, err)
 	}
 
 	return nil
 }
 
-// handleUserPassAuth handles username/password authentication.
-func handleUserPassAuth(ctx context.Context, handler ServerHandler, conn net.Conn, reader *bufio.Reader) error {
-	var userPassReq UserPassRequest
-	if _, err := userPassReq.ReadFrom(reader); err != nil {
-		return err
+// debugCaptureHandler is implemented by handlers that capture the negotiation
+// and request of each connection for debugging.
+type debugCaptureHandler interface {
+	GetDebugCapture() io.Writer
+}
+
+// auditHookHandler is implemented by handlers that receive an AuditRecord for
+// each connection.
+type auditHookHandler interface {
+	GetAuditHook() func(ctx context.Context, rec *AuditRecord)
+}
+
+// auditHook returns the handler's audit hook, or nil if auditing is disabled.
+func auditHook(handler ServerHandler) func(ctx context.Context, rec *AuditRecord) {
+	if h, ok := handler.(auditHookHandler); ok {
+		return h.GetAuditHook()
 	}
+	return nil
+}
 
-	err := handler.OnAuthUserPass(ctx, conn, userPassReq.Username, userPassReq.Password)
-	var status byte = UserPassStatusSuccess
-	if err != nil {
-		status = UserPassStatusFailure
+// panicHookHandler is implemented by handlers that receive a PanicEntry,
+// including the stack trace, instead of calling OnPanic.
+type panicHookHandler interface {
+	GetPanicHook() func(ctx context.Context, conn net.Conn, e *PanicEntry)
+}
+
+// panicHook returns the handler's panic hook, or nil to call OnPanic.
+func panicHook(handler ServerHandler) func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+	if h, ok := handler.(panicHookHandler); ok {
+		return h.GetPanicHook()
 	}
+	return nil
+}
 
-	var userPassReply UserPassReply
-	userPassReply.Init(AuthVersionUserPass, status)
-	if _, err := userPassReply.WriteTo(conn); err != nil {
-		return err
+// newAuditRecord assembles the AuditRecord of a connection accepted at start.
+// req is nil if no request was read, and auditor is nil if the connection ended
+// before the request phase.
+func newAuditRecord(ctx context.Context, start time.Time, conn net.Conn, req *Request, auditor *socksnet.AuditConn, err error) *AuditRecord {
+	rec := &AuditRecord{
+		Time:     start,
+		ID:       socksnet.NewAuditID(),
+		Version:  SocksVersion,
+		Duration: time.Since(start),
+	}
+	if addr := conn.RemoteAddr(); addr != nil {
+		rec.Client = addr.String()
+	}
+	if req != nil {
+		rec.Command = req.CommandType().String()
+		rec.Target = req.Addr()
+	}
+	rec.User = contextUser(ctx)
+	if auditor != nil {
+		if code, ok := auditor.Reply(); ok {
+			rec.Reply = ReplyCode(code).String()
+		}
+		rec.BytesUp, rec.BytesDown = auditor.Snapshot()
 	}
+	if err != nil {
+		rec.Error = err.Error()
+		if phase, ok := socksnet.ErrorPhase(err); ok {
+			rec.Phase = phase.String()
+		}
+	}
+	return rec
+}
+
+// handleUserPassAuth handles username/password authentication and returns the authenticated username.
+// Each failure is followed by the handler's auth failure delay; further attempts on the same
+// connection are read only if the handler allows more than one.
+func handleUserPassAuth(ctx context.Context, handler ServerHandler, conn net.Conn, reader *bufio.Reader, lenient *leniency) (string, error) {
+	delay, attempts := authFailurePolicy(handler)
+
+	for attempt := 1; ; attempt++ {
+		var userPassReq UserPassRequest
+		if _, err := lenient.read(ctx, conn, reader, TolerateAuthVersion, 0, AuthVersionUserPass, userPassReq.ReadFrom); err != nil {
+			return "", err
+		}
+
+		err := handler.OnAuthUserPass(ctx, conn, userPassReq.Username, userPassReq.Password)
+		var status byte = UserPassStatusSuccess
+		if err != nil {
+			status = UserPassStatusFailure
+		}
+
+		var userPassReply UserPassReply
+		userPassReply.Init(AuthVersionUserPass, status)
+		if _, err := userPassReply.WriteTo(conn); err != nil {
+			return "", err
+		}
+
+		if status == UserPassStatusSuccess {
+			return userPassReq.Username, nil
+		}
 
-	if status != UserPassStatusSuccess {
-		return fmt.Errorf("username/password authentication failed: %w", err)
+		waitAuthFailure(ctx, delay)
+		if attempt >= attempts {
+			return "", fmt.Errorf("username/password authentication failed: %w", err)
+		}
 	}
+}
 
-	return nil
+// usernameKey is the context key for the authenticated username.
+type usernameKey struct{}
+
+// contextWithUsername returns a copy of ctx carrying the authenticated username.
+func contextWithUsername(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, usernameKey{}, username)
+}
+
+// UsernameFromContext returns the username authenticated on the connection, if any.
+// The context passed to handler callbacks after username/password authentication carries it.
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(usernameKey{}).(string)
+	return username, ok
 }
 
-// handleGSSAPIAuth handles GSSAPI authentication.
-func handleGSSAPIAuth(ctx context.Context, handler ServerHandler, conn net.Conn, reader *bufio.Reader) error {
+// GSSAPIServerContext is the acceptor side of a GSSAPI security context,
+// e.g. a wrapper around gss_accept_sec_context. One is created per connection.
+type GSSAPIServerContext interface {
+	// AcceptSecContext processes a client token and returns the token to send back.
+	AcceptSecContext(clientToken []byte) (serverToken []byte, err error)
+
+	// IsComplete reports whether the security context is established.
+	IsComplete() bool
+
+	// ClientName returns the authenticated client principal once complete.
+	ClientName() string
+}
+
+// gssapiServerContextHandler is implemented by handlers that authenticate GSSAPI
+// clients with a per-connection GSSAPIServerContext instead of OnAuthGSSAPI.
+// A nil context falls back to OnAuthGSSAPI.
+type gssapiServerContextHandler interface {
+	NewGSSAPIServerContext(ctx context.Context, conn net.Conn) GSSAPIServerContext
+}
+
+// handleGSSAPIAuth handles GSSAPI authentication and returns ctx carrying the
+// client name if a GSSAPIServerContext established it.
+func handleGSSAPIAuth(ctx context.Context, handler ServerHandler, conn net.Conn, reader *bufio.Reader) (context.Context, error) {
+	accept := func(token []byte) ([]byte, bool, error) {
+		return handler.OnAuthGSSAPI(ctx, conn, token)
+	}
+
+	var sc GSSAPIServerContext
+	if h, ok := handler.(gssapiServerContextHandler); ok {
+		sc = h.NewGSSAPIServerContext(ctx, conn)
+	}
+	if sc != nil {
+		accept = func(token []byte) ([]byte, bool, error) {
+			resp, err := sc.AcceptSecContext(token)
+			return resp, sc.IsComplete(), err
+		}
+	}
+
 	// GSSAPI authentication can involve multiple round-trips
+	opts := gssapiReadOptions(handler)
 	for {
 		var gssapiReq GSSAPIRequest
-		if _, err := gssapiReq.ReadFrom(reader); err != nil {
-			return err
+		if _, err := gssapiReq.ReadFromWithOptions(reader, opts); err != nil {
+			return ctx, err
 		}
 
 		// Check for abort message
 		if gssapiReq.MsgType == GSSAPITypeAbort {
-			return fmt.Errorf("GSSAPI authentication aborted by client")
+			return ctx, fmt.Errorf("GSSAPI authentication aborted by client")
 		}
 
-		responseToken, done, err := handler.OnAuthGSSAPI(ctx, conn, gssapiReq.Token)
+		responseToken, done, err := accept(gssapiReq.Token)
 		var msgType byte = GSSAPITypeReply
 		if err != nil {
 			msgType = GSSAPITypeAbort
@@ -261,11 +636,13 @@ func handleGSSAPIAuth(ctx context.Context, handler ServerHandler, conn net.Conn,
 		var gssapiReply GSSAPIReply
 		gssapiReply.Init(GSSAPIVersion, msgType, responseToken)
 		if _, err := gssapiReply.WriteTo(conn); err != nil {
-			return err
+			return ctx, err
 		}
 
 		if msgType == GSSAPITypeAbort {
-			return fmt.Errorf("GSSAPI authentication failed: %w", err)
+			delay, _ := authFailurePolicy(handler)
+			waitAuthFailure(ctx, delay)
+			return ctx, fmt.Errorf("GSSAPI authentication failed: %w", err)
 		}
 
 		// Authentication is complete when done is true
@@ -274,55 +651,77 @@ func handleGSSAPIAuth(ctx context.Context, handler ServerHandler, conn net.Conn,
 		}
 	}
 
+	if sc != nil {
+		ctx = contextWithGSSAPIClientName(ctx, sc.ClientName())
+	}
+	return ctx, nil
+}
+
+// gssapiClientNameKey is the context key for the authenticated GSSAPI client name.
+type gssapiClientNameKey struct{}
+
+// contextWithGSSAPIClientName returns a copy of ctx carrying the GSSAPI client name.
+func contextWithGSSAPIClientName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, gssapiClientNameKey{}, name)
+}
+
+// GSSAPIClientNameFromContext returns the client principal authenticated on the
+// connection by a GSSAPIServerContext, if any.
+func GSSAPIClientNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(gssapiClientNameKey{}).(string)
+	return name, ok
+}
+
+// acceptGSSAPIProtection runs the server side of the protection-level sub-negotiation
+// on conn, reading through reader. The client must wait for the reply before sending
+// encapsulated data, so nothing may remain buffered afterwards.
+func acceptGSSAPIProtection(conn net.Conn, reader *bufio.Reader, mech GSSAPIMechanism, level byte, opts GSSAPIValidateOptions) error {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{reader, conn}
+	if _, err := acceptGSSAPIProtectionLevel(rw, mech, level, opts); err != nil {
+		return fmt.Errorf("GSSAPI protection negotiation failed: %w", err)
+	}
+	if reader.Buffered() > 0 {
+		return ErrUnexpectedGSSAPIMessage
+	}
 	return nil
 }
 
 // WriteRejectReply sends a SOCKS5 reply with the given rejection code.
 func WriteRejectReply(conn net.Conn, code byte) {
-	var resp Reply
-	resp.Init(SocksVersion, code, 0, AddrTypeIPv4, net.IPv4zero, "", 0)
-	resp.WriteTo(conn)
+	writeReply(conn, NewErrorReply(code))
 }
 
 // WriteSuccessReply writes a SOCKS5 success reply with the given network address.
 func WriteSuccessReply(conn net.Conn, addr net.Addr) error {
-	var ip net.IP
-	var port uint16
-	var domain string
-	var addrType byte
-
-	switch a := addr.(type) {
-	case *net.TCPAddr:
-		ip = a.IP
-		port = uint16(a.Port)
-
-	case *net.UDPAddr:
-		ip = a.IP
-		port = uint16(a.Port)
-
-	default:
-		ip = net.IPv4zero
-		port = 0
-	}
+	resp := NewSuccessReply(addr)
 
 	// Replace 0.0.0.0 with actual interface IP
-	if ip.IsUnspecified() {
+	if resp.IP.IsUnspecified() {
 		if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
-			ip = tcpAddr.IP
+			resp.SetAddrPort(netip.AddrPortFrom(tcpAddr.AddrPort().Addr(), resp.Port))
 		}
 	}
 
-	// Determine address type
-	if ip4 := ip.To4(); ip4 != nil {
-		addrType = AddrTypeIPv4
-		ip = ip4
-	} else {
-		addrType = AddrTypeIPv6
+	return writeReply(conn, resp)
+}
+
+// writeReply writes r in one Write, encoding replies that fit with
+// WriteToBuffer rather than WriteTo's buffer sized for the longest domain.
+func writeReply(conn net.Conn, r *Reply) error {
+	var buf [32]byte
+	n, err := r.WriteToBuffer(&buf)
+	if err == io.ErrShortBuffer {
+		_, err = r.WriteTo(conn)
+		return err
+	}
+	if err != nil {
+		return err
 	}
 
-	var resp Reply
-	resp.Init(SocksVersion, RepSuccess, 0, addrType, ip, domain, port)
-	_, err := resp.WriteTo(conn)
+	_, err = conn.Write(buf[:n])
 	return err
 }
 
@@ -333,3 +732,9 @@ func WriteHandshake(conn net.Conn, code byte) error {
 	_, err := handshakeReply.WriteTo(conn)
 	return err
 }
+
+// isProtocolErr reports whether err is a malformed message rather than a closed or timed out connection.
+func isProtocolErr(err error) bool {
+	var ne net.Error
+	return !errors.As(err, &ne) && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF)
+}
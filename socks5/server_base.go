@@ -7,42 +7,134 @@ import (
 	"io"
 	"log/slog"
 	"net"
-	"slices"
 	"strconv"
 	"time"
 
-	"github.com/33TU/socks/internal"
 	socksnet "github.com/33TU/socks/net"
-	"golang.org/x/sync/errgroup"
 )
 
+// HandlerFunc handles an allowed request, including the reply and the relay.
+type HandlerFunc func(ctx context.Context, conn net.Conn, req *Request) error
+
+// Middleware wraps a HandlerFunc with cross-cutting behavior such as logging,
+// metrics or rate limiting. It may handle the request itself, e.g. to reject it,
+// instead of calling next.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// ConnectHandler is a HandlerFunc for CONNECT requests.
+type ConnectHandler = HandlerFunc
+
+// ConnectMiddleware is a Middleware for CONNECT requests.
+type ConnectMiddleware = Middleware
+
+// BeforeRelayFunc is called once the CONNECT target has been dialed, before the
+// success reply is sent and data is relayed. Returning an error rejects the request
+// with RepConnectionNotAllowed and closes both connections.
+type BeforeRelayFunc func(ctx context.Context, clientConn, targetConn net.Conn, req *Request) error
+
+// AfterRelayFunc is called once both directions of a CONNECT relay have finished.
+// bytesUp counts client-to-target bytes and bytesDown target-to-client bytes.
+type AfterRelayFunc func(ctx context.Context, clientConn net.Conn, req *Request, bytesUp, bytesDown int64, relayErr error)
+
 // BaseServerHandler provides a basic implementation of ServerHandler with configurable options.
 type BaseServerHandler struct {
+	// Dialer connects to request targets (nil=socksnet.DefaultDialer, which times
+	// out after socksnet.DefaultDialTimeout; set &net.Dialer{} for no timeout).
 	Dialer socksnet.Dialer
 
 	RequestTimeout         time.Duration
+	HandshakeTimeout       time.Duration // Deadline for method negotiation and authentication (0=covered by RequestTimeout)
 	BindAcceptTimeout      time.Duration
 	BindConnTimeout        time.Duration
 	ConnectConnTimeout     time.Duration
 	UDPAssociateTimeout    time.Duration
-	ConnectBufferSize      int
+	ConnectBufferSize      int // Relay copy buffer per direction (0=32KB, clamped to 4KB-4MB)
 	UDPAssociateBufferSize int
 	AllowConnect           bool
 	AllowBind              bool
 	AllowUDPAssociate      bool
 	AllowResolve           bool
 	ResolveResolver        *net.Resolver
-	ResolvePreferIPv4      bool // When true, prefer IPv4 addresses over IPv6 for DNS resolution
+	ResolvePreferIPv4      bool          // When true, prefer IPv4 addresses over IPv6 for DNS resolution
+	AcceptMaxBackoff       time.Duration // Maximum delay between retries of temporary Accept errors (0=1s)
+	UDPAllowFragments      bool          // Reassemble fragmented UDP datagrams instead of dropping them
+	UDPReadBufferSize      int           // Socket receive buffer of the UDP relay, also capping UDPAssociateBufferSize (0=system default)
+	UDPWriteBufferSize     int           // Socket send buffer of the UDP relay (0=system default)
+	UDPMaxDatagramSize     int           // Largest encoded datagram relayed by the UDP relay (0=MaxDatagramSize)
+	MaxRequestSize         int64         // Maximum bytes read for the request after authentication (0=unlimited)
+	AllowDomainUnderscore  bool          // Accept '_' in the labels of request domain names (see ValidateDomainName)
+	AuthFailureDelay       time.Duration // Delay after a failed authentication before the connection is closed
+	MaxAuthAttempts        int           // Username/password attempts per connection (0=1; RFC 1929 allows only one)
+	LogIdentifiers         bool          // Log usernames verbatim instead of as a short hash
 
 	SupportedMethods []byte
 
 	UserPassAuthenticator func(ctx context.Context, username, password string) error
 	GSSAPIAuthenticator   func(ctx context.Context, token []byte) (resp []byte, done bool, err error)
+	GSSAPIServerContext   func(ctx context.Context, conn net.Conn) GSSAPIServerContext // Per-connection GSSAPI acceptor (nil=GSSAPIAuthenticator)
+	GSSAPIMechanism       func(ctx context.Context, conn net.Conn) GSSAPIMechanism     // Per-message protection after GSSAPI auth (nil=none)
+	GSSAPIProtectionLevel byte                                                         // Level granted in the sub-negotiation (GSSAPIProtNone=client's choice)
+	GSSAPIMaxTokenLen     int                                                          // Largest GSSAPI token read from clients (0=MaxGSSAPITokenLen)
 	UDPAssociateLocalAddr func(ctx context.Context, conn net.Conn, req *Request) (*net.UDPAddr, error)
+
+	// UDPDropHandler is called for each datagram dropped by the UDP relay with the
+	// reason, e.g. ErrDatagramTooLarge. Use it to count dropped packets.
+	UDPDropHandler func(ctx context.Context, src *net.UDPAddr, err error)
+
+	// ConnectHandler optionally replaces the CONNECT dial and relay (nil=DefaultConnect).
+	// Wrap DefaultConnect with a ConnectMiddleware to extend the default behavior.
+	ConnectHandler ConnectHandler
+
+	// Middleware wraps the handling of every allowed CONNECT, BIND, UDP ASSOCIATE
+	// and RESOLVE request, the first entry outermost (see Use). Check
+	// req.Command to act on some commands only.
+	Middleware []Middleware
+
+	// OnUDPSessionCreated is called once a UDP ASSOCIATE relay starts and
+	// OnUDPSessionClosed once it has ended, with the error that ended it (nil=none).
+	OnUDPSessionCreated func(ctx context.Context, s *UDPSession)
+	OnUDPSessionClosed  func(ctx context.Context, s *UDPSession, err error)
+
+	// BoundDomain is reported as BND.ADDR in DefaultConnect success replies
+	// instead of the bound IP address (""=IP). It must pass ValidateDomainName.
+	BoundDomain string
+
+	BeforeRelay BeforeRelayFunc // Optional hook before a DefaultConnect relay starts
+	AfterRelay  AfterRelayFunc  // Optional hook after a DefaultConnect relay ends
+
+	// Rule is checked before each request is handled; an error rejects the request
+	// (nil=allow all). One RuleFunc can serve both the SOCKS4 and SOCKS5 servers.
+	Rule socksnet.RuleFunc
+
+	// OnAudit is called with an AuditRecord once each connection has closed, including
+	// connections rejected before a request was read (nil=no auditing).
+	OnAudit func(ctx context.Context, rec *AuditRecord)
+
+	// OnPanicEntry is called instead of OnPanic when serving a connection panics,
+	// with the stack trace of the panic (nil=log the panic value only). See
+	// socksnet.DefaultPanicLogger.
+	OnPanicEntry func(ctx context.Context, conn net.Conn, e *PanicEntry)
+
+	// OnStateChange is called on each ConnState transition of a connection
+	// (nil=none). It runs on the connection's goroutine and should not block.
+	OnStateChange StateChangeFunc
+
+	// DebugCapture receives a copy of the bytes read and written during method
+	// negotiation, authentication and the request, for debugging clients
+	// (nil=none). Relayed data is not captured, except payload the client sent
+	// along with the request. It includes credentials; never enable it in
+	// production.
+	DebugCapture io.Writer
+
+	// Strictness selects client protocol deviations to tolerate (0=strict). Each
+	// deviation relied on is logged at debug level once per connection.
+	Strictness Strictness
+
+	Logger *slog.Logger // Logger for connection events (nil=slog.Default())
 }
 
 func (d *BaseServerHandler) OnAccept(ctx context.Context, conn net.Conn) error {
-	slog.InfoContext(ctx, "accepted connection", "from", conn.RemoteAddr())
+	d.logger().InfoContext(ctx, "accepted connection", "from", conn.RemoteAddr())
 
 	if d.RequestTimeout != 0 {
 		conn.SetDeadline(time.Now().Add(d.RequestTimeout))
@@ -51,20 +143,20 @@ func (d *BaseServerHandler) OnAccept(ctx context.Context, conn net.Conn) error {
 }
 
 func (d *BaseServerHandler) OnHandshake(ctx context.Context, conn net.Conn, req *HandshakeRequest) (byte, error) {
-	slog.InfoContext(ctx, "handshake request", "from", conn.RemoteAddr(), "methods", req.Methods)
+	d.logger().InfoContext(ctx, "handshake request", "from", conn.RemoteAddr(), "methods", req.Methods)
 
 	selectedMethod, err := BaseOnHandshake(ctx, conn, req, d.GetSupportedMethods())
 	if err != nil {
-		slog.ErrorContext(ctx, "handshake failed", "error", err)
+		d.logger().ErrorContext(ctx, "handshake failed", "error", err)
 		return MethodNoAcceptable, err
 	}
 
-	slog.InfoContext(ctx, "handshake completed", "from", conn.RemoteAddr(), "selected_method", selectedMethod)
+	d.logger().InfoContext(ctx, "handshake completed", "from", conn.RemoteAddr(), "selected_method", selectedMethod)
 	return selectedMethod, nil
 }
 
 func (d *BaseServerHandler) OnAuthUserPass(ctx context.Context, conn net.Conn, username, password string) error {
-	slog.InfoContext(ctx, "validating username/password", "from", conn.RemoteAddr(), "username", username)
+	d.logger().InfoContext(ctx, "validating username/password", "from", conn.RemoteAddr(), "username", logIdentifier(username, d.LogIdentifiers))
 
 	if d.UserPassAuthenticator != nil {
 		return d.UserPassAuthenticator(ctx, username, password)
@@ -73,7 +165,7 @@ func (d *BaseServerHandler) OnAuthUserPass(ctx context.Context, conn net.Conn, u
 }
 
 func (d *BaseServerHandler) OnAuthGSSAPI(ctx context.Context, conn net.Conn, token []byte) ([]byte, bool, error) {
-	slog.InfoContext(ctx, "validating GSSAPI token", "from", conn.RemoteAddr())
+	d.logger().InfoContext(ctx, "validating GSSAPI token", "from", conn.RemoteAddr())
 
 	if d.GSSAPIAuthenticator != nil {
 		return d.GSSAPIAuthenticator(ctx, token)
@@ -82,9 +174,18 @@ func (d *BaseServerHandler) OnAuthGSSAPI(ctx context.Context, conn net.Conn, tok
 }
 
 func (d *BaseServerHandler) OnRequest(ctx context.Context, conn net.Conn, req *Request) error {
-	err := BaseOnRequest(ctx, d, conn, req)
+	var err error
+	if d.Rule != nil {
+		if err = d.Rule(ctx, NewRequestInfo(ctx, req)); err != nil {
+			WriteRejectReply(conn, RepConnectionNotAllowed)
+			err = fmt.Errorf("request rejected by rule: %w", err)
+		}
+	}
+	if err == nil {
+		err = BaseOnRequest(ctx, d, conn, req)
+	}
 	if err != nil {
-		slog.ErrorContext(ctx, "request handling failed", "error", err, "from", conn.RemoteAddr(), "request", req)
+		d.logger().ErrorContext(ctx, "request handling failed", "error", err, "from", conn.RemoteAddr(), "request", req)
 	}
 	return err
 }
@@ -96,18 +197,42 @@ func (d *BaseServerHandler) OnConnect(ctx context.Context, conn net.Conn, req *R
 	}
 
 	addr := req.Addr()
-	slog.InfoContext(ctx, "CONNECT request", "from", conn.RemoteAddr(), "target", addr)
+	d.logger().InfoContext(ctx, "CONNECT request", "from", conn.RemoteAddr(), "target", addr)
 
-	if err := BaseOnConnect(ctx, conn, req, d.Dialer, d.ConnectConnTimeout, d.ConnectBufferSize); isUnexpectedNetErr(err) {
+	connect := d.ConnectHandler
+	if connect == nil {
+		connect = d.DefaultConnect
+	}
+
+	if err := d.chain(connect)(ctx, conn, req); isUnexpectedNetErr(err) {
 		return fmt.Errorf("CONNECT failed to %s: %w", addr, err)
 	}
 
-	slog.InfoContext(ctx, "CONNECT completed", "from", conn.RemoteAddr(), "target", addr)
+	d.logger().InfoContext(ctx, "CONNECT completed", "from", conn.RemoteAddr(), "target", addr)
 	return nil
 }
 
+// Use appends mw to Middleware, so that it runs inside the middleware already
+// added. Call it before serving.
+func (d *BaseServerHandler) Use(mw ...Middleware) {
+	d.Middleware = append(d.Middleware, mw...)
+}
+
+// chain wraps h in Middleware.
+func (d *BaseServerHandler) chain(h HandlerFunc) HandlerFunc {
+	for i := len(d.Middleware) - 1; i >= 0; i-- {
+		h = d.Middleware[i](h)
+	}
+	return h
+}
+
+// DefaultConnect dials the target and relays data using the handler's settings.
+func (d *BaseServerHandler) DefaultConnect(ctx context.Context, conn net.Conn, req *Request) error {
+	return baseOnConnect(ctx, conn, req, d.Dialer, d.ConnectConnTimeout, d.ConnectBufferSize, d.BeforeRelay, d.AfterRelay, d.BoundDomain)
+}
+
 func (d *BaseServerHandler) OnClose(ctx context.Context, conn net.Conn, errCause error) {
-	slog.InfoContext(ctx, "connection closed", "from", conn.RemoteAddr(), "error", errCause)
+	d.logger().InfoContext(ctx, "connection closed", "from", conn.RemoteAddr(), "error", errCause)
 }
 
 func (d *BaseServerHandler) OnBind(ctx context.Context, conn net.Conn, req *Request) error {
@@ -116,16 +241,21 @@ func (d *BaseServerHandler) OnBind(ctx context.Context, conn net.Conn, req *Requ
 		return fmt.Errorf("BIND command not allowed")
 	}
 
-	slog.InfoContext(ctx, "BIND request", "from", conn.RemoteAddr(), "target", req.Addr())
+	d.logger().InfoContext(ctx, "BIND request", "from", conn.RemoteAddr(), "target", req.Addr())
 
-	if err := BaseOnBind(ctx, conn, req, d.BindAcceptTimeout, d.BindConnTimeout, d.ConnectBufferSize); isUnexpectedNetErr(err) {
+	if err := d.chain(d.bind)(ctx, conn, req); isUnexpectedNetErr(err) {
 		return fmt.Errorf("BIND failed: %w", err)
 	}
 
-	slog.InfoContext(ctx, "BIND completed", "from", conn.RemoteAddr())
+	d.logger().InfoContext(ctx, "BIND completed", "from", conn.RemoteAddr())
 	return nil
 }
 
+// bind accepts one connection for a BIND request and relays data.
+func (d *BaseServerHandler) bind(ctx context.Context, conn net.Conn, req *Request) error {
+	return BaseOnBind(ctx, conn, req, d.BindAcceptTimeout, d.BindConnTimeout, d.ConnectBufferSize)
+}
+
 func (d *BaseServerHandler) OnUDPAssociate(ctx context.Context, conn net.Conn, req *Request) error {
 	if !d.AllowUDPAssociate {
 		WriteRejectReply(conn, RepConnectionNotAllowed)
@@ -133,8 +263,18 @@ func (d *BaseServerHandler) OnUDPAssociate(ctx context.Context, conn net.Conn, r
 	}
 
 	addr := req.Addr()
-	slog.InfoContext(ctx, "UDP ASSOCIATE request", "from", conn.RemoteAddr(), "target", addr)
+	d.logger().InfoContext(ctx, "UDP ASSOCIATE request", "from", conn.RemoteAddr(), "target", addr)
 
+	if err := d.chain(d.udpAssociate)(ctx, conn, req); isUnexpectedNetErr(err) {
+		return fmt.Errorf("UDP ASSOCIATE failed to %s: %w", addr, err)
+	}
+
+	d.logger().InfoContext(ctx, "UDP ASSOCIATE completed", "from", conn.RemoteAddr(), "target", addr)
+	return nil
+}
+
+// udpAssociate relays the datagrams of a UDP ASSOCIATE request.
+func (d *BaseServerHandler) udpAssociate(ctx context.Context, conn net.Conn, req *Request) error {
 	var (
 		laddr *net.UDPAddr
 		err   error
@@ -147,12 +287,22 @@ func (d *BaseServerHandler) OnUDPAssociate(ctx context.Context, conn net.Conn, r
 		}
 	}
 
-	if err = BaseOnUDPAssociate(ctx, conn, req, d.UDPAssociateTimeout, d.UDPAssociateBufferSize, laddr); isUnexpectedNetErr(err) {
-		return fmt.Errorf("UDP ASSOCIATE failed to %s: %w", addr, err)
+	opts := udpAssociateOptions{
+		readBufferSize:  d.UDPReadBufferSize,
+		writeBufferSize: d.UDPWriteBufferSize,
+		maxDatagramSize: d.UDPMaxDatagramSize,
+		onCreated:       d.OnUDPSessionCreated,
+		onClosed:        d.OnUDPSessionClosed,
+		onError:         func(err error) { d.OnError(ctx, conn, err) },
+	}
+	if d.UDPAllowFragments {
+		opts.reassembler = &Reassembler{}
+	}
+	if d.UDPDropHandler != nil {
+		opts.onDrop = func(src *net.UDPAddr, err error) { d.UDPDropHandler(ctx, src, err) }
 	}
 
-	slog.InfoContext(ctx, "UDP ASSOCIATE completed", "from", conn.RemoteAddr(), "target", addr)
-	return nil
+	return baseOnUDPAssociate(ctx, conn, req, d.UDPAssociateTimeout, d.UDPAssociateBufferSize, laddr, opts)
 }
 
 func (d *BaseServerHandler) OnResolve(ctx context.Context, conn net.Conn, req *Request) error {
@@ -162,22 +312,126 @@ func (d *BaseServerHandler) OnResolve(ctx context.Context, conn net.Conn, req *R
 	}
 
 	addr := req.Addr()
-	slog.InfoContext(ctx, "RESOLVE request", "from", conn.RemoteAddr(), "target", addr)
+	d.logger().InfoContext(ctx, "RESOLVE request", "from", conn.RemoteAddr(), "target", addr)
 
-	if err := BaseOnResolve(ctx, conn, req, d.Dialer, d.ResolveResolver, d.ResolvePreferIPv4, d.ConnectConnTimeout, d.ConnectBufferSize); isUnexpectedNetErr(err) {
+	if err := d.chain(d.resolve)(ctx, conn, req); isUnexpectedNetErr(err) {
 		return fmt.Errorf("RESOLVE failed for %s: %w", addr, err)
 	}
 
-	slog.InfoContext(ctx, "RESOLVE completed", "from", conn.RemoteAddr(), "target", addr)
+	d.logger().InfoContext(ctx, "RESOLVE completed", "from", conn.RemoteAddr(), "target", addr)
 	return nil
 }
 
+// resolve answers a RESOLVE request.
+func (d *BaseServerHandler) resolve(ctx context.Context, conn net.Conn, req *Request) error {
+	return BaseOnResolve(ctx, conn, req, d.Dialer, d.ResolveResolver, d.ResolvePreferIPv4, d.ConnectConnTimeout, d.ConnectBufferSize)
+}
+
 func (d *BaseServerHandler) OnError(ctx context.Context, conn net.Conn, err error) {
-	slog.ErrorContext(ctx, "error occurred", "error", err)
+	d.logger().ErrorContext(ctx, "error occurred", "error", err)
 }
 
 func (d *BaseServerHandler) OnPanic(ctx context.Context, conn net.Conn, r any) {
-	slog.WarnContext(ctx, "panic occurred", "error", r)
+	d.logger().WarnContext(ctx, "panic occurred", "error", r)
+}
+
+// GetMaxRequestSize returns the maximum number of bytes read for the request.
+func (d *BaseServerHandler) GetMaxRequestSize() int64 {
+	return d.MaxRequestSize
+}
+
+// GetAllowDomainUnderscore reports whether request domain names may contain '_'.
+func (d *BaseServerHandler) GetAllowDomainUnderscore() bool {
+	return d.AllowDomainUnderscore
+}
+
+// NewGSSAPIServerContext returns a GSSAPI acceptor for conn, or nil to
+// authenticate with GSSAPIAuthenticator instead.
+func (d *BaseServerHandler) NewGSSAPIServerContext(ctx context.Context, conn net.Conn) GSSAPIServerContext {
+	if d.GSSAPIServerContext == nil {
+		return nil
+	}
+	return d.GSSAPIServerContext(ctx, conn)
+}
+
+// GetGSSAPIProtection returns the per-message protection mechanism for a
+// GSSAPI-authenticated connection and the protection level to grant.
+func (d *BaseServerHandler) GetGSSAPIProtection(ctx context.Context, conn net.Conn) (GSSAPIMechanism, byte) {
+	if d.GSSAPIMechanism == nil {
+		return nil, GSSAPIProtNone
+	}
+	return d.GSSAPIMechanism(ctx, conn), d.GSSAPIProtectionLevel
+}
+
+// GetGSSAPIMaxTokenLen returns the largest GSSAPI token read from clients.
+func (d *BaseServerHandler) GetGSSAPIMaxTokenLen() int {
+	return d.GSSAPIMaxTokenLen
+}
+
+// GetAuthFailureDelay returns the delay after a failed authentication.
+func (d *BaseServerHandler) GetAuthFailureDelay() time.Duration {
+	return d.AuthFailureDelay
+}
+
+// GetMaxAuthAttempts returns the number of username/password attempts allowed per connection.
+func (d *BaseServerHandler) GetMaxAuthAttempts() int {
+	return d.MaxAuthAttempts
+}
+
+// GetAuditHook returns the hook called with each connection's AuditRecord.
+func (d *BaseServerHandler) GetAuditHook() func(ctx context.Context, rec *AuditRecord) {
+	return d.OnAudit
+}
+
+// GetPanicHook returns the hook called with the PanicEntry of a recovered panic.
+func (d *BaseServerHandler) GetPanicHook() func(ctx context.Context, conn net.Conn, e *PanicEntry) {
+	return d.OnPanicEntry
+}
+
+// GetStrictness returns the client protocol deviations the server tolerates.
+func (d *BaseServerHandler) GetStrictness() Strictness {
+	return d.Strictness
+}
+
+// OnTolerated logs a client protocol deviation the first time it is tolerated
+// on a connection.
+func (d *BaseServerHandler) OnTolerated(ctx context.Context, conn net.Conn, deviation Strictness) {
+	d.logger().DebugContext(ctx, "tolerated client protocol deviation", "deviation", deviation, "from", conn.RemoteAddr())
+}
+
+// GetDebugCapture returns the writer receiving a copy of each connection's
+// negotiation and request.
+func (d *BaseServerHandler) GetDebugCapture() io.Writer {
+	return d.DebugCapture
+}
+
+// GetStateChangeHook returns the hook called on each connection state transition.
+func (d *BaseServerHandler) GetStateChangeHook() StateChangeFunc {
+	return d.OnStateChange
+}
+
+// GetHandshakeTimeout returns the deadline for method negotiation and authentication.
+// When it is set, RequestTimeout applies only to reading the request that follows.
+func (d *BaseServerHandler) GetHandshakeTimeout() time.Duration {
+	return d.HandshakeTimeout
+}
+
+// GetRequestTimeout returns the deadline for reading the request.
+func (d *BaseServerHandler) GetRequestTimeout() time.Duration {
+	return d.RequestTimeout
+}
+
+// GetAcceptMaxBackoff returns the maximum delay between retries of temporary Accept errors.
+func (d *BaseServerHandler) GetAcceptMaxBackoff() time.Duration {
+	return d.AcceptMaxBackoff
+}
+
+// logger returns the configured logger or slog.Default().
+func (d *BaseServerHandler) logger() *slog.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return slog.Default()
 }
 
 // GetSupportedMethods returns the supported authentication methods.
@@ -188,12 +442,11 @@ func (d *BaseServerHandler) GetSupportedMethods() []byte {
 	return d.SupportedMethods
 }
 
-// BaseOnHandshake provides a default handshake implementation that selects the first matching authentication method.
+// BaseOnHandshake provides a default handshake implementation that selects the
+// first of supportedMethods offered by the client, so the server's order decides.
 func BaseOnHandshake(ctx context.Context, conn net.Conn, req *HandshakeRequest, supportedMethods []byte) (byte, error) {
-	for _, clientMethod := range req.Methods {
-		if slices.Contains(supportedMethods, clientMethod) {
-			return clientMethod, nil
-		}
+	if method, ok := req.ChooseMethod(supportedMethods...); ok {
+		return method, nil
 	}
 
 	return MethodNoAcceptable, fmt.Errorf(
@@ -222,6 +475,22 @@ func BaseOnRequest(ctx context.Context, handler ServerHandler, conn net.Conn, re
 
 // BaseOnConnect provides CONNECT implementation
 func BaseOnConnect(ctx context.Context, conn net.Conn, req *Request, dialer socksnet.Dialer, connTimeout time.Duration, bufferSize int) error {
+	return baseOnConnect(ctx, conn, req, dialer, connTimeout, bufferSize, nil, nil, "")
+}
+
+// baseOnConnect is BaseOnConnect with the relay hooks, which are optional
+// (nil=none), and reporting boundDomain as BND.ADDR (""=bound IP).
+func baseOnConnect(
+	ctx context.Context,
+	conn net.Conn,
+	req *Request,
+	dialer socksnet.Dialer,
+	connTimeout time.Duration,
+	bufferSize int,
+	beforeRelay BeforeRelayFunc,
+	afterRelay AfterRelayFunc,
+	boundDomain string,
+) error {
 	if dialer == nil {
 		dialer = socksnet.DefaultDialer
 	}
@@ -239,27 +508,39 @@ func BaseOnConnect(ctx context.Context, conn net.Conn, req *Request, dialer sock
 			}
 		}
 		WriteRejectReply(conn, code)
-		return fmt.Errorf("failed to connect to target %s: %w", targetAddr, err)
+		return socksnet.WithPhase(socksnet.PhaseDial, fmt.Errorf("failed to connect to target %s: %w", targetAddr, err))
 	}
 	defer remote.Close()
 
+	if beforeRelay != nil {
+		if err := beforeRelay(ctx, conn, remote, req); err != nil {
+			WriteRejectReply(conn, RepConnectionNotAllowed)
+			return fmt.Errorf("relay rejected: %w", err)
+		}
+	}
+
 	// Send success reply with bound address
-	if err := WriteSuccessReply(conn, remote.LocalAddr()); err != nil {
+	bound := remote.LocalAddr()
+	if boundDomain != "" {
+		a := &Addr{AddrType: AddrTypeDomain, Domain: boundDomain}
+		if tcpAddr, ok := bound.(*net.TCPAddr); ok {
+			a.Port = uint16(tcpAddr.Port)
+		}
+		bound = a
+	}
+	if err := WriteSuccessReply(conn, bound); err != nil {
 		return fmt.Errorf("failed to write connect response: %w", err)
 	}
 
-	// Start bidirectional copying with coordinated error handling
-	g, ctx := errgroup.WithContext(ctx)
-
-	g.Go(func() error {
-		return socksnet.CopyConn(remote, conn, connTimeout, bufferSize)
-	})
-
-	g.Go(func() error {
-		return socksnet.CopyConn(conn, remote, connTimeout, bufferSize)
+	// Relay in both directions until both sides are done
+	stats, err := socksnet.Relay(context.WithoutCancel(ctx), conn, remote, &socksnet.RelayOptions{
+		BufferSize:  bufferSize,
+		IdleTimeout: connTimeout,
 	})
-
-	return g.Wait()
+	if afterRelay != nil {
+		afterRelay(ctx, conn, req, stats.BytesUp, stats.BytesDown, err)
+	}
+	return socksnet.WithPhase(socksnet.PhaseRelay, err)
 }
 
 // BaseOnBind provides BIND implementation
@@ -303,18 +584,12 @@ func BaseOnBind(ctx context.Context, conn net.Conn, req *Request, acceptTimeout,
 		return fmt.Errorf("failed to write connection response: %w", err)
 	}
 
-	// Start bidirectional copying with coordinated error handling
-	g, ctx := errgroup.WithContext(ctx)
-
-	g.Go(func() error {
-		return socksnet.CopyConn(incomingConn, conn, connTimeout, bufferSize)
+	// Relay in both directions until both sides are done
+	_, err = socksnet.Relay(context.WithoutCancel(ctx), conn, incomingConn, &socksnet.RelayOptions{
+		BufferSize:  bufferSize,
+		IdleTimeout: connTimeout,
 	})
-
-	g.Go(func() error {
-		return socksnet.CopyConn(conn, incomingConn, connTimeout, bufferSize)
-	})
-
-	return g.Wait()
+	return socksnet.WithPhase(socksnet.PhaseRelay, err)
 }
 
 // BaseOnUDPAssociate provides UDP ASSOCIATE implementation
@@ -325,6 +600,34 @@ func BaseOnUDPAssociate(
 	timeout time.Duration,
 	bufferSize int,
 	laddr *net.UDPAddr,
+) error {
+	return baseOnUDPAssociate(ctx, conn, req, timeout, bufferSize, laddr, udpAssociateOptions{})
+}
+
+// udpAssociateOptions are the BaseServerHandler settings of a UDP ASSOCIATE
+// beyond those of BaseOnUDPAssociate. The zero value is its behavior.
+type udpAssociateOptions struct {
+	readBufferSize  int // UDP socket receive buffer (0=system default)
+	writeBufferSize int // UDP socket send buffer (0=system default)
+	maxDatagramSize int // Largest encoded datagram relayed (0=MaxDatagramSize)
+
+	reassembler *Reassembler                      // Reassembles fragmented datagrams (nil=drop them)
+	onDrop      func(src *net.UDPAddr, err error) // Called for each datagram the relay rejects (nil=none)
+	onError     func(err error)                   // Called for each datagram longer than the read buffer (nil=none)
+
+	onCreated func(ctx context.Context, s *UDPSession)            // Called once the session starts (nil=none)
+	onClosed  func(ctx context.Context, s *UDPSession, err error) // Called once the session has ended (nil=none)
+}
+
+// baseOnUDPAssociate is BaseOnUDPAssociate with opts.
+func baseOnUDPAssociate(
+	ctx context.Context,
+	conn net.Conn,
+	req *Request,
+	timeout time.Duration,
+	bufferSize int,
+	laddr *net.UDPAddr,
+	opts udpAssociateOptions,
 ) error {
 	// Create UDP listener
 	udpConn, err := net.ListenUDP("udp", laddr)
@@ -334,140 +637,47 @@ func BaseOnUDPAssociate(
 	}
 	defer udpConn.Close()
 
+	if opts.readBufferSize > 0 {
+		if err := udpConn.SetReadBuffer(opts.readBufferSize); err != nil {
+			WriteRejectReply(conn, RepGeneralFailure)
+			return fmt.Errorf("failed to set UDP read buffer: %w", err)
+		}
+		if bufferSize <= 0 || bufferSize > opts.readBufferSize {
+			bufferSize = opts.readBufferSize
+		}
+	}
+	if opts.writeBufferSize > 0 {
+		if err := udpConn.SetWriteBuffer(opts.writeBufferSize); err != nil {
+			WriteRejectReply(conn, RepGeneralFailure)
+			return fmt.Errorf("failed to set UDP write buffer: %w", err)
+		}
+	}
+
 	// Send success reply with UDP relay address
 	if err := WriteSuccessReply(conn, udpConn.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to write UDP associate reply: %w", err)
 	}
 
-	clientTCPAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
-	if !ok {
-		return fmt.Errorf("unexpected TCP remote addr type %T", conn.RemoteAddr())
+	s, err := NewUDPSession(conn, udpConn)
+	if err != nil {
+		return err
 	}
+	s.IdleTimeout = timeout
+	s.BufferSize = bufferSize
+	s.MaxDatagramSize = opts.maxDatagramSize
+	s.Reassembler = opts.reassembler
+	s.OnDrop = opts.onDrop
+	s.OnError = opts.onError
 
-	g, ctx := errgroup.WithContext(ctx)
-
-	// Close UDP relay when TCP association ends
-	g.Go(func() error {
-		defer udpConn.Close()
-
-		if _, err := io.Copy(io.Discard, conn); isUnexpectedNetErr(err) {
-			return err
-		}
-		return nil
-	})
-
-	// UDP relay loop
-	g.Go(func() error {
-		defer conn.Close()
-
-		if bufferSize <= 0 {
-			bufferSize = 64 * 1024
-		}
-
-		inBuf := internal.GetBytes(bufferSize)
-		defer internal.PutBytes(inBuf)
-
-		outBuf := internal.GetBytes(bufferSize)
-		defer internal.PutBytes(outBuf)
-
-		// Lock onto the actual UDP client after first valid packet.
-		var clientUDPAddr *net.UDPAddr
-
-		for {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-
-			if timeout > 0 {
-				if err := udpConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
-					return err
-				}
-			}
-
-			n, srcAddr, err := udpConn.ReadFromUDP(inBuf)
-			if err != nil {
-				if ne, ok := err.(net.Error); ok && ne.Timeout() {
-					return err
-				}
-				if errors.Is(err, net.ErrClosed) {
-					return nil
-				}
-				return err
-			}
-
-			// First valid client packet must come from same IP as TCP peer.
-			if clientUDPAddr == nil {
-				var pkt UDPPacket
-				if _, err := pkt.UnmarshalFrom(inBuf[:n]); err == nil && srcAddr.IP.Equal(clientTCPAddr.IP) {
-					clientUDPAddr = cloneUDPAddr(srcAddr)
-				}
-			}
-
-			// Client -> target
-			if clientUDPAddr != nil &&
-				srcAddr.IP.Equal(clientUDPAddr.IP) &&
-				srcAddr.Port == clientUDPAddr.Port {
-
-				var pkt UDPPacket
-				if _, err := pkt.UnmarshalFrom(inBuf[:n]); err != nil {
-					continue
-				}
-
-				// Fragmentation not supported; RFC says drop if unsupported.
-				if pkt.Frag != 0x00 {
-					continue
-				}
-
-				targetAddr, err := resolveUDPPacketTarget(&pkt)
-				if err != nil {
-					continue
-				}
-
-				if _, err := udpConn.WriteToUDP(pkt.Data, targetAddr); err != nil {
-					continue
-				}
-
-				continue
-			}
-
-			// Target -> client
-			if clientUDPAddr == nil {
-				continue
-			}
-
-			var resp UDPPacket
-
-			addrType := AddrTypeIPv6
-			ip := srcAddr.IP
-			if ip4 := ip.To4(); ip4 != nil {
-				addrType = AddrTypeIPv4
-				ip = ip4
-			}
-
-			resp.Init(
-				[2]byte{0x00, 0x00},
-				0x00,
-				byte(addrType),
-				ip,
-				"",
-				uint16(srcAddr.Port),
-				inBuf[:n],
-			)
-
-			nOut, err := resp.MarshalTo(outBuf)
-			if err != nil {
-				continue
-			}
-
-			if _, err := udpConn.WriteToUDP(outBuf[:nOut], clientUDPAddr); err != nil {
-				continue
-			}
-		}
-	})
+	if opts.onCreated != nil {
+		opts.onCreated(ctx, s)
+	}
+	err = s.Run(ctx)
+	if opts.onClosed != nil {
+		opts.onClosed(ctx, s, err)
+	}
 
-	return g.Wait()
+	return socksnet.WithPhase(socksnet.PhaseRelay, err)
 }
 
 // resolveUDPPacketTarget resolves the target address from a UDPPacket, handling different address types.
@@ -528,25 +738,8 @@ func BaseOnResolve(
 	// Select the best IP address based on preference
 	ip := ResolveSelectBestIP(ips, preferIPv4)
 
-	var addrType byte
-	if ip4 := ip.To4(); ip4 != nil {
-		addrType = AddrTypeIPv4
-		ip = ip4
-	} else {
-		addrType = AddrTypeIPv6
-	}
-
 	// Send success reply
-	var resp Reply
-	resp.Init(
-		SocksVersion,
-		RepSuccess,
-		0,
-		addrType,
-		ip,
-		"",
-		req.Port, // or 0
-	)
+	resp := NewSuccessReply(&net.TCPAddr{IP: ip, Port: int(req.Port)})
 
 	if _, err := resp.WriteTo(conn); err != nil {
 		return fmt.Errorf("failed to write resolve response: %w", err)
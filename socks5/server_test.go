@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	socksnet "github.com/33TU/socks/net"
 	"github.com/33TU/socks/socks5"
 )
 
@@ -183,6 +187,283 @@ func TestBaseServerHandler_OnConnect_TargetUnreachable(t *testing.T) {
 	t.Log("Target unreachable test passed")
 }
 
+func TestBaseServerHandler_RelayHooks(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	type relayResult struct {
+		up, down int64
+		err      error
+	}
+	results := make(chan relayResult, 1)
+
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		BeforeRelay: func(ctx context.Context, clientConn, targetConn net.Conn, req *socks5.Request) error {
+			if user, _ := socks5.UsernameFromContext(ctx); user == "blocked" {
+				return errors.New("denied")
+			}
+			return nil
+		},
+		AfterRelay: func(ctx context.Context, clientConn net.Conn, req *socks5.Request, bytesUp, bytesDown int64, relayErr error) {
+			results <- relayResult{bytesUp, bytesDown, relayErr}
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// BeforeRelay rejects the request
+	blocked := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "blocked", Password: "pw"}, nil)
+	_, err := blocked.DialContext(ctx, "tcp", echoLn.Addr().String())
+	var code socks5.ReplyCode
+	if !errors.As(err, &code) || code != socks5.RepConnectionNotAllowed {
+		t.Fatalf("Expected RepConnectionNotAllowed from BeforeRelay, got %v", err)
+	}
+
+	// AfterRelay reports the relayed byte counts
+	dialer := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "alice", Password: "pw"}, nil)
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect through SOCKS5 proxy: %v", err)
+	}
+
+	payload := genRandom(1000)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case r := <-results:
+		if r.up != 1000 || r.down != 1000 {
+			t.Fatalf("AfterRelay got up=%d down=%d, want 1000 each", r.up, r.down)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("AfterRelay was not called")
+	}
+
+	// the rejected request never reached the relay
+	select {
+	case r := <-results:
+		t.Fatalf("unexpected AfterRelay call: %+v", r)
+	default:
+	}
+}
+
+func TestBaseServerHandler_Audit(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	records := make(chan *socks5.AuditRecord, 1)
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			if username == "mallory" {
+				return errors.New("invalid credentials")
+			}
+			return nil
+		},
+		OnAudit: func(ctx context.Context, rec *socks5.AuditRecord) {
+			records <- rec
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	nextRecord := func() *socks5.AuditRecord {
+		select {
+		case rec := <-records:
+			return rec
+		case <-time.After(2 * time.Second):
+			t.Fatal("OnAudit was not called")
+			return nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// a failed authentication is audited without a request
+	rejected := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "mallory", Password: "s3cret"}, nil)
+	if _, err := rejected.DialContext(ctx, "tcp", echoLn.Addr().String()); err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+	rec := nextRecord()
+	if rec.Command != "" || rec.Reply != "" || rec.User != "" || rec.Error == "" || rec.Phase != "auth" {
+		t.Fatalf("unexpected record for failed auth: %+v", rec)
+	}
+
+	dialer := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "alice", Password: "s3cret"}, nil)
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect through SOCKS5 proxy: %v", err)
+	}
+
+	payload := genRandom(1000)
+	if _, err := conn.Write(payload); err != nil {
+		t.Fatalf("Failed to write test data: %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	conn.Close()
+
+	rec = nextRecord()
+	if rec.Version != 5 || rec.ID == "" || rec.Client == "" || rec.Time.IsZero() || rec.Duration <= 0 {
+		t.Fatalf("incomplete record: %+v", rec)
+	}
+	if rec.Command != "CONNECT" || rec.Target != echoLn.Addr().String() || rec.User != "alice" || rec.Reply != "SUCCESS" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if rec.BytesUp != 1000 || rec.BytesDown != 1000 {
+		t.Fatalf("record has up=%d down=%d, want 1000 each", rec.BytesUp, rec.BytesDown)
+	}
+
+	var line bytes.Buffer
+	if err := socksnet.NewAuditLog(&line).Write(rec); err != nil {
+		t.Fatalf("AuditLog.Write failed: %v", err)
+	}
+	if strings.Contains(line.String(), "s3cret") || !strings.Contains(line.String(), `"reply":"SUCCESS"`) {
+		t.Fatalf("unexpected audit line: %s", line.String())
+	}
+}
+
+func TestBaseServerHandler_DebugCapture(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	var capture syncBuffer
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+		DebugCapture:     &capture,
+	})
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	payload := []byte("relayed-payload")
+	conn.Write(payload)
+	if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+		t.Fatalf("echo failed: %v", err)
+	}
+	conn.Close()
+
+	// greeting and method selection, then the request
+	got := []byte(capture.String())
+	if !bytes.HasPrefix(got, []byte{socks5.SocksVersion, 1, socks5.MethodNoAuth}) {
+		t.Fatalf("capture %x does not start with the greeting", got)
+	}
+	if !bytes.Contains(got, []byte{socks5.SocksVersion, socks5.MethodNoAuth, socks5.SocksVersion, socks5.CmdConnect}) {
+		t.Fatalf("capture %x lacks the method selection and request", got)
+	}
+	if bytes.Contains(got, payload) {
+		t.Fatalf("capture %x includes relayed data", got)
+	}
+}
+
+func TestBaseServerHandler_OnPanicEntry(t *testing.T) {
+	entries := make(chan *socks5.PanicEntry, 1)
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+		ConnectHandler: func(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+			panic("boom")
+		},
+		OnPanicEntry: func(ctx context.Context, conn net.Conn, e *socks5.PanicEntry) {
+			entries <- e
+		},
+	})
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+	if _, err := dialer.DialContext(ctx, "tcp", "127.0.0.1:9"); err == nil {
+		t.Fatal("expected CONNECT to fail after the handler panicked")
+	}
+
+	select {
+	case e := <-entries:
+		if e.PanicValue != "boom" || e.ConnID == 0 {
+			t.Fatalf("unexpected entry: value=%v conn=%d", e.PanicValue, e.ConnID)
+		}
+		if !bytes.Contains(e.Stack, []byte("TestBaseServerHandler_OnPanicEntry")) {
+			t.Fatalf("stack does not contain the panicking function:\n%s", e.Stack)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnPanicEntry was not called")
+	}
+}
+
+func TestBaseServerHandler_AllowDomainUnderscore(t *testing.T) {
+	newHandler := func(allow bool, domains chan<- string) *socks5.BaseServerHandler {
+		return &socks5.BaseServerHandler{
+			RequestTimeout:        2 * time.Second,
+			AllowConnect:          true,
+			AllowDomainUnderscore: allow,
+			SupportedMethods:      []byte{socks5.MethodNoAuth},
+			ConnectHandler: func(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+				domains <- req.Domain
+				_, err := socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}).WriteTo(conn)
+				return err
+			},
+		}
+	}
+
+	domains := make(chan string, 1)
+	strictLn := startSOCKS5Server(t, newHandler(false, domains))
+	defer strictLn.Close()
+	lenientLn := startSOCKS5Server(t, newHandler(true, domains))
+	defer lenientLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// the dialer option only lets the name be sent; the server decides
+	d := socks5.NewDialer(strictLn.Addr().String(), nil, nil)
+	d.AllowDomainUnderscore = true
+	if _, err := d.DialContext(ctx, "tcp", "my_host.example:80"); err == nil {
+		t.Fatal("expected the strict server to reject the request")
+	}
+
+	d.ProxyAddr = lenientLn.Addr().String()
+	conn, err := d.DialContext(ctx, "tcp", "my_host.example:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case got := <-domains:
+		if got != "my_host.example" {
+			t.Fatalf("handler received domain %q, want %q", got, "my_host.example")
+		}
+	default:
+		t.Fatal("ConnectHandler was not called")
+	}
+}
+
 func TestBaseServerHandler_OnBind_Success(t *testing.T) {
 	// Start SOCKS5 server with BIND enabled
 	handler := &socks5.BaseServerHandler{
@@ -483,6 +764,147 @@ func TestBaseServerHandler_UserPassAuth(t *testing.T) {
 	}
 }
 
+// userPassAttempt sends a username/password request on conn and returns the reply status.
+func userPassAttempt(t *testing.T, conn net.Conn, username, password string) byte {
+	t.Helper()
+
+	var req socks5.UserPassRequest
+	req.Init(socks5.AuthVersionUserPass, username, password)
+	if _, err := req.WriteTo(conn); err != nil {
+		t.Fatalf("write auth: %v", err)
+	}
+
+	var reply socks5.UserPassReply
+	if _, err := reply.ReadFrom(conn); err != nil {
+		t.Fatalf("read auth reply: %v", err)
+	}
+	return reply.Status
+}
+
+// dialUserPass connects to the server and negotiates username/password authentication.
+func dialUserPass(t *testing.T, addr string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var hs socks5.HandshakeRequest
+	hs.Init(socks5.SocksVersion, socks5.MethodUserPass)
+	hs.WriteTo(conn)
+
+	var hsReply socks5.HandshakeReply
+	if _, err := hsReply.ReadFrom(conn); err != nil || hsReply.Method != socks5.MethodUserPass {
+		t.Fatalf("handshake: %v, method %d", err, hsReply.Method)
+	}
+	return conn
+}
+
+func TestBaseServerHandler_AuthFailureDelay(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   5 * time.Second,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		AuthFailureDelay: delay,
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			return errors.New("invalid credentials")
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	conn := dialUserPass(t, socksLn.Addr().String())
+	defer conn.Close()
+
+	if status := userPassAttempt(t, conn, "user", "wrong"); status == socks5.UserPassStatusSuccess {
+		t.Fatal("expected authentication to fail")
+	}
+
+	// the failure reply arrives at once, but the connection stays open for the delay
+	failedAt := time.Now()
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF, got %d bytes, %v", n, err)
+	}
+	if elapsed := time.Since(failedAt); elapsed < delay-20*time.Millisecond {
+		t.Fatalf("connection closed after %v, want at least %v", elapsed, delay)
+	}
+}
+
+func TestBaseServerHandler_LogIdentifiers(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		logIdentifiers bool
+		want           string
+	}{
+		{"redacted by default", false, "username=sha256:"},
+		{"verbatim", true, "username=alice"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs syncBuffer
+			socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+				RequestTimeout:   5 * time.Second,
+				SupportedMethods: []byte{socks5.MethodUserPass},
+				LogIdentifiers:   tt.logIdentifiers,
+				Logger:           slog.New(slog.NewTextHandler(&logs, nil)),
+				UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+					return nil
+				},
+			})
+			defer socksLn.Close()
+
+			conn := dialUserPass(t, socksLn.Addr().String())
+			defer conn.Close()
+
+			if status := userPassAttempt(t, conn, "alice", "hunter2"); status != socks5.UserPassStatusSuccess {
+				t.Fatalf("authentication failed with status %d", status)
+			}
+			if line := logs.String(); !strings.Contains(line, tt.want) || strings.Contains(line, "hunter2") {
+				t.Fatalf("log %q does not contain %q or leaks the password", line, tt.want)
+			}
+		})
+	}
+}
+
+func TestBaseServerHandler_MaxAuthAttempts(t *testing.T) {
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   5 * time.Second,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		MaxAuthAttempts:  2,
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			if password != "secret" {
+				return errors.New("invalid credentials")
+			}
+			return nil
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	// a second attempt on the same connection is accepted
+	conn := dialUserPass(t, socksLn.Addr().String())
+	if userPassAttempt(t, conn, "user", "wrong") == socks5.UserPassStatusSuccess {
+		t.Fatal("expected first attempt to fail")
+	}
+	if status := userPassAttempt(t, conn, "user", "secret"); status != socks5.UserPassStatusSuccess {
+		t.Fatalf("second attempt status = %d, want success", status)
+	}
+	conn.Close()
+
+	// but no more than MaxAuthAttempts
+	conn = dialUserPass(t, socksLn.Addr().String())
+	defer conn.Close()
+	userPassAttempt(t, conn, "user", "wrong")
+	userPassAttempt(t, conn, "user", "wrong")
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after %d failures, got %d bytes, %v", handler.MaxAuthAttempts, n, err)
+	}
+}
+
 func TestBaseServerHandler_MethodNegotiation(t *testing.T) {
 	// Start an echo server
 	echoLn := echoServer(t)
@@ -866,69 +1288,318 @@ func TestBaseServerHandler_GSSAPI_Failed(t *testing.T) {
 	t.Log("GSSAPI failure test passed")
 }
 
-func TestBaseServerHandler_Resolve_Success(t *testing.T) {
+func TestBaseServerHandler_GSSAPI_MaxTokenLen(t *testing.T) {
+	called := make(chan struct{}, 1)
 	handler := &socks5.BaseServerHandler{
-		AllowResolve:     true,
-		RequestTimeout:   2 * time.Second,
-		SupportedMethods: []byte{socks5.MethodNoAuth},
+		RequestTimeout:    2 * time.Second,
+		AllowConnect:      true,
+		SupportedMethods:  []byte{socks5.MethodGSSAPI},
+		GSSAPIMaxTokenLen: 8,
+		GSSAPIAuthenticator: func(ctx context.Context, token []byte) ([]byte, bool, error) {
+			called <- struct{}{}
+			return nil, true, nil
+		},
 	}
 
 	socksLn := startSOCKS5Server(t, handler)
 	defer socksLn.Close()
 
-	// Create SOCKS5 dialer
-	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
-
-	// Test resolving localhost
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	ip, err := dialer.ResolveContext(ctx, "tcp", "localhost")
+	conn, err := net.Dial("tcp", socksLn.Addr().String())
 	if err != nil {
-		t.Fatalf("Failed to resolve localhost: %v", err)
+		t.Fatalf("dial failed: %v", err)
 	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
 
-	// Verify we got a valid IP
-	if ip == nil {
-		t.Fatal("Resolved IP is nil")
+	var hsReq socks5.HandshakeRequest
+	hsReq.Init(socks5.SocksVersion, socks5.MethodGSSAPI)
+	if _, err := hsReq.WriteTo(conn); err != nil {
+		t.Fatalf("handshake write failed: %v", err)
+	}
+	var hsReply socks5.HandshakeReply
+	if _, err := hsReply.ReadFrom(conn); err != nil || hsReply.Method != socks5.MethodGSSAPI {
+		t.Fatalf("handshake reply = %+v, %v", hsReply, err)
 	}
 
-	// localhost should resolve to a loopback address
-	if !ip.IsLoopback() {
-		t.Errorf("Expected loopback IP for localhost, got %v", ip)
+	// a token over the handler's limit ends the connection unread
+	req := socks5.GSSAPIRequest{Version: socks5.GSSAPIVersion, MsgType: socks5.GSSAPITypeInit, Token: make([]byte, 16)}
+	if _, err := req.WriteTo(conn); err != nil {
+		t.Fatalf("GSSAPI request write failed: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the server to close the connection")
 	}
 
-	t.Logf("Successfully resolved localhost to %v", ip)
+	select {
+	case <-called:
+		t.Fatal("GSSAPIAuthenticator called for an oversized token")
+	default:
+	}
 }
 
-func TestBaseServerHandler_Resolve_Disabled(t *testing.T) {
-	handler := &socks5.BaseServerHandler{
-		AllowResolve:     false, // Disable RESOLVE command
-		RequestTimeout:   2 * time.Second,
-		SupportedMethods: []byte{socks5.MethodNoAuth},
+// serverMockGSSAPIAcceptor is a two-step GSSAPI acceptor: it expects token A,
+// replies B, then expects C and replies "established".
+type serverMockGSSAPIAcceptor struct {
+	mu       sync.Mutex
+	step     int
+	complete bool
+}
+
+func (m *serverMockGSSAPIAcceptor) AcceptSecContext(clientToken []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case m.step == 0 && string(clientToken) == "token-A":
+		m.step = 1
+		return []byte("token-B"), nil
+	case m.step == 1 && string(clientToken) == "token-C":
+		m.step = 2
+		m.complete = true
+		return []byte("established"), nil
 	}
+	return nil, fmt.Errorf("unexpected token %q at step %d", clientToken, m.step)
+}
 
-	socksLn := startSOCKS5Server(t, handler)
-	defer socksLn.Close()
+func (m *serverMockGSSAPIAcceptor) IsComplete() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.complete
+}
 
-	// Create SOCKS5 dialer
-	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+func (m *serverMockGSSAPIAcceptor) ClientName() string {
+	return "alice@EXAMPLE.COM"
+}
 
-	// Test resolving localhost - should fail
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// serverMockGSSAPIContext_TwoStep is the initiator matching serverMockGSSAPIAcceptor.
+type serverMockGSSAPIContext_TwoStep struct {
+	complete bool
+}
 
-	ip, err := dialer.ResolveContext(ctx, "tcp", "localhost")
-	if err == nil {
-		t.Fatalf("Expected resolve to fail when disabled, but got IP: %v", ip)
+func (m *serverMockGSSAPIContext_TwoStep) InitSecContext() ([]byte, error) {
+	return []byte("token-A"), nil
+}
+
+func (m *serverMockGSSAPIContext_TwoStep) AcceptSecContext(serverToken []byte) ([]byte, bool, error) {
+	switch string(serverToken) {
+	case "token-B":
+		return []byte("token-C"), false, nil
+	case "established":
+		m.complete = true
+		return nil, true, nil
 	}
+	return nil, false, fmt.Errorf("unexpected server token %q", serverToken)
+}
 
-	t.Logf("RESOLVE correctly rejected: %v", err)
-	t.Log("RESOLVE disabled test passed")
+func (m *serverMockGSSAPIContext_TwoStep) IsComplete() bool {
+	return m.complete
 }
 
-func TestBaseServerHandler_Resolve_InvalidDomain(t *testing.T) {
-	handler := &socks5.BaseServerHandler{
+func TestBaseServerHandler_GSSAPI_ServerContext(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	acceptor := &serverMockGSSAPIAcceptor{}
+	clientName := make(chan string, 1)
+
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:     2 * time.Second,
+		ConnectConnTimeout: 2 * time.Second,
+		AllowConnect:       true,
+		SupportedMethods:   []byte{socks5.MethodGSSAPI},
+		GSSAPIServerContext: func(ctx context.Context, conn net.Conn) socks5.GSSAPIServerContext {
+			return acceptor
+		},
+		GSSAPIAuthenticator: func(ctx context.Context, token []byte) ([]byte, bool, error) {
+			return nil, false, errors.New("GSSAPIAuthenticator must not be used")
+		},
+		BeforeRelay: func(ctx context.Context, clientConn, targetConn net.Conn, req *socks5.Request) error {
+			name, _ := socks5.GSSAPIClientNameFromContext(ctx)
+			clientName <- name
+			return nil
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	gssapiAuth := &socks5.GSSAPIAuth{Context: &serverMockGSSAPIContext_TwoStep{}}
+	dialer := socks5.NewDialerWithGSSAPI(socksLn.Addr().String(), nil, gssapiAuth, nil)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	if !acceptor.IsComplete() {
+		t.Fatal("server GSSAPI context not complete")
+	}
+	if name := <-clientName; name != "alice@EXAMPLE.COM" {
+		t.Fatalf("client name in context = %q, want %q", name, "alice@EXAMPLE.COM")
+	}
+
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo failed: %q, %v", buf, err)
+	}
+}
+
+func TestBaseServerHandler_GSSAPI_ServerContext_Abort(t *testing.T) {
+	acceptor := &serverMockGSSAPIAcceptor{}
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		SupportedMethods: []byte{socks5.MethodGSSAPI},
+		GSSAPIServerContext: func(ctx context.Context, conn net.Conn) socks5.GSSAPIServerContext {
+			return acceptor
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	conn, err := net.Dial("tcp", socksLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	(&socks5.HandshakeRequest{Version: socks5.SocksVersion, NMethods: 1, Methods: []byte{socks5.MethodGSSAPI}}).WriteTo(conn)
+	var hsReply socks5.HandshakeReply
+	if _, err := hsReply.ReadFrom(conn); err != nil || hsReply.Method != socks5.MethodGSSAPI {
+		t.Fatalf("handshake: %v, method %d", err, hsReply.Method)
+	}
+
+	// first step, then abort instead of the second token
+	(&socks5.GSSAPIRequest{Version: socks5.GSSAPIVersion, MsgType: socks5.GSSAPITypeInit, Token: []byte("token-A")}).WriteTo(conn)
+	var reply socks5.GSSAPIReply
+	if _, err := reply.ReadFrom(conn); err != nil || string(reply.Token) != "token-B" {
+		t.Fatalf("first step: %v, token %q", err, reply.Token)
+	}
+	(&socks5.GSSAPIRequest{Version: socks5.GSSAPIVersion, MsgType: socks5.GSSAPITypeAbort}).WriteTo(conn)
+
+	// the server closes the connection without replying
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after abort, got %d bytes, %v", n, err)
+	}
+	if acceptor.IsComplete() {
+		t.Fatal("server GSSAPI context completed despite abort")
+	}
+}
+
+// sniffErrorHandler records the errors passed to OnError.
+type sniffErrorHandler struct {
+	*socks5.BaseServerHandler
+	errs chan error
+}
+
+func (h *sniffErrorHandler) OnError(ctx context.Context, conn net.Conn, err error) {
+	h.errs <- err
+}
+
+func TestServeConn_NotSOCKS(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		detected string
+	}{
+		{"http GET", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", "http"},
+		{"tls ClientHello", "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03", "tls"},
+		{"socks4 request", "\x04\x01\x00\x50\x7f\x00\x00\x01\x00", "socks4-on-socks5-port"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			handler := &sniffErrorHandler{BaseServerHandler: &socks5.BaseServerHandler{}, errs: make(chan error, 1)}
+			go client.Write([]byte(tt.input))
+
+			err := socks5.ServeConn(context.Background(), handler, server)
+
+			var notSOCKS *socks5.ErrNotSOCKS
+			if !errors.As(err, &notSOCKS) || notSOCKS.Detected != tt.detected {
+				t.Fatalf("ServeConn error = %v, want ErrNotSOCKS{%q}", err, tt.detected)
+			}
+			if got := <-handler.errs; !errors.As(got, &notSOCKS) {
+				t.Fatalf("OnError got %v, want *ErrNotSOCKS", got)
+			}
+
+			// nothing is written back to a client that is not speaking SOCKS
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			if n, err := client.Read(make([]byte, 16)); err != io.EOF {
+				t.Fatalf("expected EOF, got %d bytes, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestBaseServerHandler_Resolve_Success(t *testing.T) {
+	handler := &socks5.BaseServerHandler{
+		AllowResolve:     true,
+		RequestTimeout:   2 * time.Second,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	// Create SOCKS5 dialer
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	// Test resolving localhost
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ip, err := dialer.ResolveContext(ctx, "tcp", "localhost")
+	if err != nil {
+		t.Fatalf("Failed to resolve localhost: %v", err)
+	}
+
+	// Verify we got a valid IP
+	if ip == nil {
+		t.Fatal("Resolved IP is nil")
+	}
+
+	// localhost should resolve to a loopback address
+	if !ip.IsLoopback() {
+		t.Errorf("Expected loopback IP for localhost, got %v", ip)
+	}
+
+	t.Logf("Successfully resolved localhost to %v", ip)
+}
+
+func TestBaseServerHandler_Resolve_Disabled(t *testing.T) {
+	handler := &socks5.BaseServerHandler{
+		AllowResolve:     false, // Disable RESOLVE command
+		RequestTimeout:   2 * time.Second,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	// Create SOCKS5 dialer
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	// Test resolving localhost - should fail
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ip, err := dialer.ResolveContext(ctx, "tcp", "localhost")
+	if err == nil {
+		t.Fatalf("Expected resolve to fail when disabled, but got IP: %v", ip)
+	}
+
+	t.Logf("RESOLVE correctly rejected: %v", err)
+	t.Log("RESOLVE disabled test passed")
+}
+
+func TestBaseServerHandler_Resolve_InvalidDomain(t *testing.T) {
+	handler := &socks5.BaseServerHandler{
 		AllowResolve:     true,
 		RequestTimeout:   2 * time.Second,
 		SupportedMethods: []byte{socks5.MethodNoAuth},
@@ -1158,3 +1829,525 @@ func TestBaseServerHandler_UDPAssociate_Echo_WithDialer(t *testing.T) {
 
 	t.Logf("UDP ASSOCIATE test passed (%d bytes echoed)", len(testData))
 }
+
+func TestBaseServerHandler_UDPAssociate_EmptyAndOversized(t *testing.T) {
+	udpEcho, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to start UDP echo server: %v", err)
+	}
+	defer udpEcho.Close()
+
+	// echo empty datagrams; answer anything else with a datagram over the limit
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, clientAddr, err := udpEcho.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				n = 2000
+			}
+			_, _ = udpEcho.WriteToUDP(buf[:n], clientAddr)
+		}
+	}()
+
+	dropped := make(chan error, 1)
+	handler := &socks5.BaseServerHandler{
+		AllowUDPAssociate:   true,
+		UDPAssociateTimeout: 10 * time.Second,
+		RequestTimeout:      5 * time.Second,
+		SupportedMethods:    []byte{socks5.MethodNoAuth},
+		UDPMaxDatagramSize:  1024,
+		UDPDropHandler: func(ctx context.Context, src *net.UDPAddr, err error) {
+			select {
+			case dropped <- err:
+			default:
+			}
+		},
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pc, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).ListenPacket(ctx, "tcp", nil)
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer pc.Close()
+
+	// empty payloads are relayed
+	if _, err := pc.WriteTo(nil, udpEcho.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo(empty) failed: %v", err)
+	}
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil || n != 0 {
+		t.Fatalf("ReadFrom = (%d, %v), want empty datagram", n, err)
+	}
+
+	// the client refuses to send oversized datagrams
+	if _, err := pc.WriteTo(make([]byte, socks5.MaxDatagramSize), udpEcho.LocalAddr()); !errors.Is(err, socks5.ErrDatagramTooLarge) {
+		t.Fatalf("WriteTo(oversized) error = %v, want ErrDatagramTooLarge", err)
+	}
+
+	// oversized replies from the target are dropped and reported by the relay
+	if _, err := pc.WriteTo([]byte("ping"), udpEcho.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, socks5.ErrDatagramTooLarge) {
+			t.Fatalf("dropped with %v, want ErrDatagramTooLarge", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("UDPDropHandler not called for oversized datagram")
+	}
+}
+
+func TestBaseServerHandler_UDPAssociate_BufferSizes(t *testing.T) {
+	udpEcho, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to start UDP echo server: %v", err)
+	}
+	defer udpEcho.Close()
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, clientAddr, err := udpEcho.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udpEcho.WriteToUDP(buf[:n], clientAddr)
+		}
+	}()
+
+	dropped := make(chan error, 1)
+	listen := func(handler *socks5.BaseServerHandler) net.PacketConn {
+		handler.AllowUDPAssociate = true
+		handler.UDPAssociateTimeout = 10 * time.Second
+		handler.RequestTimeout = 5 * time.Second
+		handler.SupportedMethods = []byte{socks5.MethodNoAuth}
+		handler.UDPDropHandler = func(ctx context.Context, src *net.UDPAddr, err error) {
+			select {
+			case dropped <- err:
+			default:
+			}
+		}
+
+		socksLn := startSOCKS5Server(t, handler)
+		t.Cleanup(func() { socksLn.Close() })
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		pc, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).ListenPacket(ctx, "tcp", nil)
+		if err != nil {
+			t.Fatalf("ListenPacket failed: %v", err)
+		}
+		t.Cleanup(func() { pc.Close() })
+		return pc
+	}
+
+	// a near-maximum datagram is relayed intact
+	pc := listen(&socks5.BaseServerHandler{UDPReadBufferSize: 256 * 1024, UDPWriteBufferSize: 256 * 1024})
+
+	payload := genRandom(65000)
+	if _, err := pc.WriteTo(payload, udpEcho.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64*1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if !bytes.Equal(buf[:n], payload) {
+		t.Fatalf("UDP echo mismatch: got %d bytes, want %d", n, len(payload))
+	}
+
+	// UDPReadBufferSize also sizes the datagram buffer; longer datagrams are
+	// reported through OnError instead of being relayed truncated
+	var logs syncBuffer
+	pc = listen(&socks5.BaseServerHandler{
+		UDPReadBufferSize: 1024,
+		Logger:            slog.New(slog.NewTextHandler(&logs, nil)),
+	})
+
+	if _, err := pc.WriteTo(genRandom(2000), udpEcho.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	select {
+	case err := <-dropped:
+		if !errors.Is(err, socks5.ErrDatagramTooLarge) {
+			t.Fatalf("dropped with %v, want ErrDatagramTooLarge", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("UDPDropHandler not called for truncated datagram")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(logs.String(), "relay: UDP datagram from") {
+		if time.Now().After(deadline) {
+			t.Fatalf("truncated datagram not logged through OnError, got %q", logs.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a datagram that fills the 1024-byte buffer is relayed both ways
+	payload = genRandom(1024 - 10)
+	if _, err := pc.WriteTo(payload, udpEcho.LocalAddr()); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	pc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err = pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if !bytes.Equal(buf[:n], payload) {
+		t.Fatalf("UDP echo mismatch: got %d bytes, want %d", n, len(payload))
+	}
+}
+
+func TestBaseServerHandler_MaxRequestSize(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+		MaxRequestSize:   16,
+	}
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	// an IPv4 request fits in 10 bytes
+	conn, err := d.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext within limit failed: %v", err)
+	}
+	conn.Close()
+
+	// a long domain does not
+	_, port, _ := net.SplitHostPort(echoLn.Addr().String())
+	var code socks5.ReplyCode
+	_, err = d.DialContext(ctx, "tcp", net.JoinHostPort(strings.Repeat("a", 60)+".localhost", port))
+	if !errors.As(err, &code) || code != socks5.RepGeneralFailure {
+		t.Fatalf("expected RepGeneralFailure for oversized request, got %v", err)
+	}
+}
+
+func TestBaseServerHandler_HandshakeTimeout(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	handler := &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		HandshakeTimeout: 100 * time.Millisecond,
+		RequestTimeout:   time.Second,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+	}
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	t.Run("slow handshake", func(t *testing.T) {
+		conn, err := net.Dial("tcp", socksLn.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+		start := time.Now()
+
+		// the handshake is only sent after 200ms
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			conn.Write([]byte{socks5.SocksVersion, 0x01, socks5.MethodNoAuth})
+		}()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(make([]byte, 16))
+		elapsed := time.Since(start)
+
+		if n != 0 || err != io.EOF {
+			t.Fatalf("Read = (%d, %v), want connection closed without a response", n, err)
+		}
+		if elapsed > 150*time.Millisecond {
+			t.Fatalf("connection closed after %v, want within 150ms", elapsed)
+		}
+	})
+
+	t.Run("request deadline follows handshake", func(t *testing.T) {
+		conn, err := net.Dial("tcp", socksLn.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+		var hs socks5.HandshakeRequest
+		hs.Init(socks5.SocksVersion, socks5.MethodNoAuth)
+		if _, err := hs.WriteTo(conn); err != nil {
+			t.Fatalf("Failed to write handshake: %v", err)
+		}
+		var hsReply socks5.HandshakeReply
+		if _, err := hsReply.ReadFrom(conn); err != nil {
+			t.Fatalf("Failed to read handshake reply: %v", err)
+		}
+
+		// longer than HandshakeTimeout but within RequestTimeout
+		time.Sleep(200 * time.Millisecond)
+
+		echoAddr := echoLn.Addr().(*net.TCPAddr)
+		var req socks5.Request
+		req.Init(socks5.SocksVersion, socks5.CmdConnect, 0, socks5.AddrTypeIPv4, echoAddr.IP.To4(), "", uint16(echoAddr.Port))
+		if _, err := req.WriteTo(conn); err != nil {
+			t.Fatalf("Failed to write request: %v", err)
+		}
+		var reply socks5.Reply
+		if _, err := reply.ReadFrom(conn); err != nil {
+			t.Fatalf("Failed to read reply: %v", err)
+		}
+		if reply.Reply != socks5.RepSuccess {
+			t.Fatalf("Reply = %d, want success", reply.Reply)
+		}
+	})
+}
+
+// tempError is a temporary net.Error such as EMFILE.
+type tempError struct{}
+
+func (tempError) Error() string   { return "too many open files" }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+// flakyListener fails Accept with err fails times before delegating to the wrapped listener.
+type flakyListener struct {
+	net.Listener
+	err   error
+	fails int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.fails > 0 {
+		l.fails--
+		return nil, l.err
+	}
+	return l.Listener.Accept()
+}
+
+func TestServe_AcceptBackoff(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	flaky := &flakyListener{Listener: ln, err: tempError{}, fails: 4}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := &socks5.BaseServerHandler{AllowConnect: true, AcceptMaxBackoff: 20 * time.Millisecond}
+	start := time.Now()
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- socks5.Serve(ctx, flaky, handler) }()
+
+	// Serve keeps running through temporary errors and serves the next connection
+	d := socks5.NewDialer(ln.Addr().String(), nil, nil)
+	conn, err := d.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial through proxy: %v", err)
+	}
+	conn.Close()
+
+	// 5ms + 10ms + 20ms + 20ms (capped)
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Fatalf("expected backoff between accept retries, served after %v", elapsed)
+	}
+
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Fatalf("expected nil from Serve after cancel, got %v", err)
+	}
+}
+
+func TestServe_AcceptPermanentError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	permanent := fmt.Errorf("listener broken")
+	flaky := &flakyListener{Listener: ln, err: permanent, fails: 1}
+
+	done := make(chan error, 1)
+	go func() { done <- socks5.Serve(context.Background(), flaky, &socks5.BaseServerHandler{}) }()
+
+	select {
+	case err := <-done:
+		if err != permanent {
+			t.Fatalf("expected permanent error from Serve, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return on permanent accept error")
+	}
+}
+
+// replayConn replays a client's bytes to the server and discards its replies.
+type replayConn struct {
+	net.Conn // unused methods panic
+	r        bytes.Reader
+	addr     net.TCPAddr
+}
+
+func (c *replayConn) Read(p []byte) (int, error)         { return c.r.Read(p) }
+func (c *replayConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *replayConn) Close() error                       { return nil }
+func (c *replayConn) RemoteAddr() net.Addr               { return &c.addr }
+func (c *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (c *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// parseOnlyHandler stops after the request has been read.
+type parseOnlyHandler struct {
+	socks5.BaseServerHandler
+}
+
+func (h *parseOnlyHandler) OnRequest(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+	return nil
+}
+
+// BenchmarkServeConn measures a full handshake and request parse.
+func BenchmarkServeConn(b *testing.B) {
+	var client bytes.Buffer
+	(&socks5.HandshakeRequest{Version: socks5.SocksVersion, NMethods: 2, Methods: []byte{socks5.MethodNoAuth, socks5.MethodUserPass}}).WriteTo(&client)
+	(&socks5.Request{Version: socks5.SocksVersion, Command: socks5.CmdConnect, AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 443}).WriteTo(&client)
+
+	handler := &parseOnlyHandler{socks5.BaseServerHandler{
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+		Logger:           slog.New(slog.DiscardHandler),
+	}}
+	conn := &replayConn{}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		conn.r.Reset(client.Bytes())
+		if err := socks5.ServeConn(ctx, handler, conn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestServer_BoundDomain(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+
+	proxy := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodNoAuth},
+		BoundDomain:      "proxy.example.com",
+		Logger:           slog.New(slog.DiscardHandler),
+	})
+	defer proxy.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := socks5.NewDialer(proxy.Addr().String(), nil, nil).DialContext(ctx, "tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	defer conn.Close()
+
+	bound, ok := conn.(*socks5.Conn).BoundAddr().(*socks5.Addr)
+	if !ok || bound.AddrType != socks5.AddrTypeDomain || bound.Domain != "proxy.example.com" || bound.Port == 0 {
+		t.Fatalf("BoundAddr = %v, want proxy.example.com with the bound port", conn.(*socks5.Conn).BoundAddr())
+	}
+}
+
+func TestBaseServerHandler_Use(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	events := make(chan string, 16)
+	trace := func(name string) socks5.Middleware {
+		return func(next socks5.HandlerFunc) socks5.HandlerFunc {
+			return func(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+				events <- name + ">" + socks5.Command(req.Command).String()
+				err := next(ctx, conn, req)
+				events <- "<" + name
+				return err
+			}
+		}
+	}
+	// rejects UDP ASSOCIATE without calling the default handler
+	noUDP := func(next socks5.HandlerFunc) socks5.HandlerFunc {
+		return func(ctx context.Context, conn net.Conn, req *socks5.Request) error {
+			if req.Command == socks5.CmdUDPAssociate {
+				socks5.WriteRejectReply(conn, socks5.RepConnectionNotAllowed)
+				return errors.New("UDP not allowed")
+			}
+			return next(ctx, conn, req)
+		}
+	}
+
+	handler := &socks5.BaseServerHandler{
+		AllowConnect:      true,
+		AllowUDPAssociate: true,
+		SupportedMethods:  []byte{socks5.MethodNoAuth},
+	}
+	handler.Use(trace("log"), trace("limit"))
+	handler.Use(noUDP)
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+	conn, err := d.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	conn.Write([]byte("ping"))
+	io.ReadFull(conn, make([]byte, 4))
+	conn.Close()
+	expectEvents(t, events, "log>CONNECT", "limit>CONNECT", "<limit", "<log")
+
+	var code socks5.ReplyCode
+	if _, err := d.OpenUDPAssociation(ctx, "tcp", nil); !errors.As(err, &code) || code != socks5.RepConnectionNotAllowed {
+		t.Fatalf("OpenUDPAssociation = %v, want RepConnectionNotAllowed from the middleware", err)
+	}
+	expectEvents(t, events, "log>UDP_ASSOCIATE", "limit>UDP_ASSOCIATE", "<limit", "<log")
+}
+
+// expectEvents receives len(want) events and checks they are want, in order.
+func expectEvents(t *testing.T, events <-chan string, want ...string) {
+	t.Helper()
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if got != w {
+				t.Fatalf("event %d = %q, want %q (all: %q)", i, got, w, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("missing event %d %q", i, w)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package socks5
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// ErrServerClosed is returned by Server.Serve and Server.ListenAndServe after Shutdown or Close.
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval is how often Shutdown checks for remaining connections.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// ServerOption configures a Server.
+type ServerOption func(*Server)
+
+// WithAuth requires username/password authentication against the given credentials.
+func WithAuth(creds ...Auth) ServerOption {
+	return func(s *Server) {
+		s.handler.SupportedMethods = []byte{MethodUserPass}
+		s.handler.UserPassAuthenticator = func(ctx context.Context, username, password string) error {
+			for _, c := range creds {
+				userOK := subtle.ConstantTimeCompare([]byte(c.Username), []byte(username))
+				passOK := subtle.ConstantTimeCompare([]byte(c.Password), []byte(password))
+				if userOK&passOK == 1 {
+					return nil
+				}
+			}
+			return errors.New("invalid username or password")
+		}
+	}
+}
+
+// WithResolver sets the resolver used for RESOLVE requests and enables them.
+func WithResolver(resolver *net.Resolver) ServerOption {
+	return func(s *Server) {
+		s.handler.ResolveResolver = resolver
+		s.handler.AllowResolve = true
+	}
+}
+
+// WithLogger sets the logger used for connection events.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.handler.Logger = logger
+	}
+}
+
+// WithIdleTimeout sets how long a relayed CONNECT or BIND connection may stay idle.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.handler.ConnectConnTimeout = d
+		s.handler.BindConnTimeout = d
+	}
+}
+
+// WithAudit calls hook with an AuditRecord once each connection has closed.
+// Use socksnet.NewAuditLog to write the records as JSON lines.
+func WithAudit(hook func(ctx context.Context, rec *AuditRecord)) ServerOption {
+	return func(s *Server) {
+		s.handler.OnAudit = hook
+	}
+}
+
+// WithStateChange calls hook on each ConnState transition of a connection.
+func WithStateChange(hook StateChangeFunc) ServerOption {
+	return func(s *Server) {
+		s.handler.OnStateChange = hook
+	}
+}
+
+// WithRule rejects requests for which rule returns an error.
+func WithRule(rule socksnet.RuleFunc) ServerOption {
+	return func(s *Server) {
+		s.handler.Rule = rule
+	}
+}
+
+// WithMaxConns limits the number of concurrently served connections (0=unlimited).
+// Accepting pauses while the limit is reached.
+func WithMaxConns(n int) ServerOption {
+	return func(s *Server) {
+		s.maxConns = n
+	}
+}
+
+// Server is a SOCKS5 proxy server configured with functional options.
+// Use Serve, ListenAndServe and ServeConn directly with a ServerHandler for full control.
+type Server struct {
+	handler  *BaseServerHandler
+	maxConns int
+	sem      chan struct{}
+
+	done chan struct{} // closed by Shutdown or Close
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]ConnState
+	closed    bool
+}
+
+// serverHandler is the handler a Server serves connections with: its
+// BaseServerHandler, with state changes also reported to the Server.
+type serverHandler struct {
+	*BaseServerHandler
+	s *Server
+}
+
+// GetStateChangeHook records each state in the Server before calling the
+// handler's own OnStateChange, if any.
+func (h serverHandler) GetStateChangeHook() StateChangeFunc {
+	hook := h.BaseServerHandler.GetStateChangeHook()
+	return func(conn net.Conn, from, to ConnState) {
+		h.s.setConnState(conn, to)
+		if hook != nil {
+			hook(conn, from, to)
+		}
+	}
+}
+
+// NewServer creates a Server with the default handler settings and the given options applied.
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		handler:   newDefaultServerHandler(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]ConnState),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.maxConns > 0 {
+		s.sem = make(chan struct{}, s.maxConns)
+	}
+	return s
+}
+
+// Handler returns the handler used by the server.
+// It may be adjusted before the server starts serving.
+func (s *Server) Handler() *BaseServerHandler {
+	return s.handler
+}
+
+// ListenAndServe listens on the network address and serves SOCKS5 requests.
+func (s *Server) ListenAndServe(network, address string) error {
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts incoming connections on the listener and serves SOCKS5 requests.
+// It always returns a non-nil error; after Shutdown the error is ErrServerClosed.
+func (s *Server) Serve(listener net.Listener) error {
+	if !s.trackListener(listener) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer s.untrackListener(listener)
+
+	backoff := socksnet.AcceptBackoff{Max: s.handler.AcceptMaxBackoff}
+
+	for {
+		if s.sem != nil {
+			select {
+			case s.sem <- struct{}{}:
+			case <-s.done:
+				return ErrServerClosed
+			}
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			s.release()
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+
+			// Retry temporary errors (e.g. EMFILE) with backoff, give up on permanent ones
+			s.handler.OnError(context.Background(), nil, socksnet.WithPhase(socksnet.PhaseAccept, err))
+			if backoff.Wait(context.Background(), err) {
+				continue
+			}
+			return err
+		}
+		backoff.Reset()
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			s.release()
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer s.release()
+			defer s.untrackConn(conn)
+			ServeConn(context.Background(), serverHandler{s.handler, s}, conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting new connections, closes connections that have not
+// yet sent their request (still negotiating or authenticating), and waits for
+// the others to finish, letting in-flight relays drain. A connection reading
+// its request is left to the handler's RequestTimeout. If ctx expires first,
+// its error is returned and the remaining connections are left open; call
+// Close to terminate them.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	err := s.closeListenersLocked()
+	for conn, state := range s.conns {
+		if state < ConnStateProcessingRequest {
+			conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.numConns() == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and all tracked connections,
+// interrupting in-flight relays.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.closeListenersLocked()
+	for conn := range s.conns {
+		conn.Close()
+		delete(s.conns, conn)
+	}
+	return err
+}
+
+// closeListenersLocked marks the server closed and closes its listeners.
+// s.mu must be held.
+func (s *Server) closeListenersLocked() error {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+
+	var err error
+	for ln := range s.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil && !errors.Is(cerr, net.ErrClosed) {
+			err = cerr
+		}
+		delete(s.listeners, ln)
+	}
+	return err
+}
+
+// trackConn registers conn, reporting false if the server is shut down.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = ConnStateNew
+	return true
+}
+
+// setConnState records the state of a tracked connection.
+func (s *Server) setConnState(conn net.Conn, state ConnState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = state
+	}
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *Server) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// trackListener registers ln, reporting false if the server is shut down.
+func (s *Server) trackListener(ln net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.listeners[ln] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackListener(ln net.Listener) {
+	s.mu.Lock()
+	delete(s.listeners, ln)
+	s.mu.Unlock()
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// release frees a connection slot when WithMaxConns is set.
+func (s *Server) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+}
@@ -0,0 +1,380 @@
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// startServer serves srv on a local listener and returns the listener and Serve's result.
+func startServer(t *testing.T, srv *socks5.Server) (net.Listener, <-chan error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ln) }()
+
+	t.Cleanup(func() { srv.Close() })
+	return ln, errCh
+}
+
+// pingEcho writes and reads back a short message.
+func pingEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("Expected echo, got %q", buf)
+	}
+}
+
+func TestNewServer_Options(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	resolver := &net.Resolver{}
+
+	srv := socks5.NewServer(
+		socks5.WithAuth(socks5.Auth{Username: "user", Password: "pass"}),
+		socks5.WithResolver(resolver),
+		socks5.WithLogger(logger),
+		socks5.WithIdleTimeout(5*time.Second),
+	)
+	h := srv.Handler()
+
+	if !bytes.Equal(h.SupportedMethods, []byte{socks5.MethodUserPass}) {
+		t.Errorf("SupportedMethods = %v, want [MethodUserPass]", h.SupportedMethods)
+	}
+	if h.UserPassAuthenticator == nil {
+		t.Fatal("UserPassAuthenticator not set")
+	}
+	if err := h.UserPassAuthenticator(context.Background(), "user", "pass"); err != nil {
+		t.Errorf("valid credentials rejected: %v", err)
+	}
+	if err := h.UserPassAuthenticator(context.Background(), "user", "wrong"); err == nil {
+		t.Error("invalid credentials accepted")
+	}
+	if h.ResolveResolver != resolver || !h.AllowResolve {
+		t.Error("WithResolver not applied")
+	}
+	if h.Logger != logger {
+		t.Error("WithLogger not applied")
+	}
+	if h.ConnectConnTimeout != 5*time.Second || h.BindConnTimeout != 5*time.Second {
+		t.Error("WithIdleTimeout not applied")
+	}
+
+	// defaults are kept for options not given
+	if def := socks5.NewServer().Handler(); !def.AllowConnect || def.RequestTimeout != 10*time.Second {
+		t.Error("NewServer() did not apply default settings")
+	}
+}
+
+func TestServer_AuthAndLogger(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	var logs syncBuffer
+	srv := socks5.NewServer(
+		socks5.WithAuth(socks5.Auth{Username: "user", Password: "pass"}),
+		socks5.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	socksLn, _ := startServer(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// wrong password is rejected
+	bad := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "user", Password: "nope"}, nil)
+	if conn, err := bad.DialContext(ctx, "tcp", echoLn.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("Expected authentication failure")
+	}
+
+	good := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "user", Password: "pass"}, nil)
+	conn, err := good.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect through server: %v", err)
+	}
+	defer conn.Close()
+	pingEcho(t, conn)
+
+	if !strings.Contains(logs.String(), "accepted connection") {
+		t.Errorf("Expected connection events in custom logger, got %q", logs.String())
+	}
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	srv := socks5.NewServer(socks5.WithMaxConns(1), socks5.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	socksLn, _ := startServer(t, srv)
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	first, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("First dial failed: %v", err)
+	}
+	pingEcho(t, first)
+
+	// second connection is not served while the first is active
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer shortCancel()
+	if conn, err := dialer.DialContext(shortCtx, "tcp", echoLn.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("Expected second connection to wait for a free slot")
+	}
+
+	// freeing the slot lets new connections through
+	first.Close()
+
+	second, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial after slot freed failed: %v", err)
+	}
+	defer second.Close()
+	pingEcho(t, second)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	srv := socks5.NewServer(socks5.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	socksLn, serveErr := startServer(t, srv)
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Shutdown waits for the active connection
+	shortCtx, shortCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer shortCancel()
+	if err := srv.Shutdown(shortCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected DeadlineExceeded while connection active, got %v", err)
+	}
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, socks5.ErrServerClosed) {
+			t.Fatalf("Expected ErrServerClosed from Serve, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after Shutdown")
+	}
+
+	// active connection keeps working during shutdown
+	pingEcho(t, conn)
+
+	// new connections are refused
+	if c, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("Expected dial to fail after Shutdown")
+	}
+
+	conn.Close()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown after connection closed: %v", err)
+	}
+
+	if err := srv.Serve(socksLn); !errors.Is(err, socks5.ErrServerClosed) {
+		t.Fatalf("Expected ErrServerClosed from Serve after Shutdown, got %v", err)
+	}
+}
+
+func TestServer_Shutdown_ClosesHandshakingConns(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	var states sync.Map // client address -> last ConnState
+	srv := socks5.NewServer(
+		socks5.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+		socks5.WithAuth(socks5.Auth{Username: "alice", Password: "s3cret"}),
+		socks5.WithStateChange(func(conn net.Conn, from, to socks5.ConnState) {
+			states.Store(conn.RemoteAddr().String(), to)
+		}),
+	)
+	socksLn, _ := startServer(t, srv)
+
+	waitState := func(conn net.Conn, want socks5.ConnState) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if s, ok := states.Load(conn.LocalAddr().String()); ok && s == want {
+				return
+			}
+		}
+		t.Fatalf("connection did not reach state %v", want)
+	}
+
+	// one client never sends its greeting, another stops before authenticating
+	silent, err := net.Dial("tcp", socksLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer silent.Close()
+	waitState(silent, socks5.ConnStateHandshaking)
+
+	stalled, err := net.Dial("tcp", socksLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stalled.Close()
+	stalled.Write([]byte{socks5.SocksVersion, 1, socks5.MethodUserPass})
+	waitState(stalled, socks5.ConnStateAuthenticating)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown = %v, want the handshaking connections closed", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %v", elapsed)
+	}
+
+	for _, conn := range []net.Conn{silent, stalled} {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Errorf("read after Shutdown = %v, want EOF", err)
+		}
+	}
+}
+
+func TestServer_Shutdown_DrainsTransfer(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	srv := socks5.NewServer(socks5.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	socksLn, _ := startServer(t, srv)
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	payload := genRandom(256 * 1024)
+	transferErr := make(chan error, 1)
+	go func() {
+		// echo the payload in chunks while Shutdown is in progress, then hang up
+		response := make([]byte, len(payload))
+		for off := 0; off < len(payload); off += 16 * 1024 {
+			chunk := payload[off : off+16*1024]
+			if _, err := conn.Write(chunk); err != nil {
+				transferErr <- err
+				return
+			}
+			if _, err := io.ReadFull(conn, response[off:off+len(chunk)]); err != nil {
+				transferErr <- err
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if !bytes.Equal(payload, response) {
+			transferErr <- errors.New("echo data mismatch")
+			return
+		}
+		transferErr <- conn.Close()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if err := <-transferErr; err != nil {
+		t.Fatalf("Transfer interrupted by Shutdown: %v", err)
+	}
+}
+
+func TestServer_Close(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	srv := socks5.NewServer(socks5.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	socksLn, serveErr := startServer(t, srv)
+	dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	pingEcho(t, conn)
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// the active relay is interrupted
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Expected read to fail after Close")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("Connection was not closed by Close")
+	}
+
+	if err := <-serveErr; !errors.Is(err, socks5.ErrServerClosed) {
+		t.Fatalf("Expected ErrServerClosed from Serve, got %v", err)
+	}
+
+	// nothing is left to drain
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown after Close: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// Session errors.
+var (
+	ErrSessionBusy   = errors.New("session busy: previous connection is still open")
+	ErrSessionClosed = errors.New("session closed")
+)
+
+// Session is an authenticated connection to a SOCKS5 proxy that carries several
+// requests in turn without repeating the handshake. Each Connect returns a
+// connection that relays over the session until it is closed; closing it hands
+// the underlying connection back to the session for the next request.
+//
+// Sessions require a proxy that reads a new request once a relay ends, e.g. a
+// tunnelling endpoint. Standard SOCKS5 servers, including this package's, close
+// the connection after one request.
+type Session struct {
+	d    *Dialer
+	conn net.Conn
+
+	mu     sync.Mutex
+	busy   bool
+	closed bool
+}
+
+// OpenSession connects to the proxy at proxyAddr (""=ProxyAddr) and performs
+// method negotiation and authentication once for all requests of the Session.
+func (d *Dialer) OpenSession(ctx context.Context, proxyAddr string) (*Session, error) {
+	if proxyAddr == "" {
+		proxyAddr = d.ProxyAddr
+	}
+
+	dialer := d.Dialer
+	if dialer == nil {
+		dialer = socksnet.DefaultDialer
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// cancellation and deadline handling
+	cleanup := bindConnToContext(ctx, conn)
+	defer cleanup()
+
+	if conn, _, err = d.handshakeOrClose(conn); err != nil {
+		return nil, err
+	}
+	return &Session{d: d, conn: conn}, nil
+}
+
+// Connect sends a CONNECT request for address over the session and returns the
+// relayed connection once the proxy accepts it. It fails with ErrSessionBusy
+// while the connection returned by the previous Connect is still open.
+func (s *Session) Connect(ctx context.Context, address string) (net.Conn, error) {
+	host, port, err := splitHostPort(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.acquire(); err != nil {
+		return nil, err
+	}
+
+	// cancellation and deadline handling
+	cleanup := bindConnToContext(ctx, s.conn)
+	reply, err := s.d.doRequest(s.conn, CmdConnect, host, port)
+	cleanup()
+
+	if err != nil {
+		// The stream may be out of step with the proxy, so it cannot be reused
+		s.Close()
+		return nil, err
+	}
+
+	if reply.Reply != RepSuccess {
+		s.release()
+		return nil, replyToError(reply.Reply)
+	}
+
+	return &sessionConn{Conn: s.conn, s: s}, nil
+}
+
+// Close closes the session and its underlying connection.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.conn.Close()
+}
+
+// acquire marks the session busy for a new request.
+func (s *Session) acquire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed:
+		return ErrSessionClosed
+	case s.busy:
+		return ErrSessionBusy
+	}
+	s.busy = true
+	return nil
+}
+
+// release makes the session available for the next request.
+func (s *Session) release() {
+	s.mu.Lock()
+	s.busy = false
+	s.mu.Unlock()
+}
+
+// sessionConn is the connection returned by Session.Connect. Closing it releases
+// the session instead of closing the underlying connection.
+type sessionConn struct {
+	net.Conn
+	s      *Session
+	closed atomic.Bool
+}
+
+func (c *sessionConn) Read(p []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *sessionConn) Write(p []byte) (int, error) {
+	if c.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(p)
+}
+
+// Close ends this relay and hands the connection back to the session.
+func (c *sessionConn) Close() error {
+	if c.closed.Swap(true) {
+		return net.ErrClosed
+	}
+	c.s.release()
+	return nil
+}
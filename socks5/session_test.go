@@ -0,0 +1,122 @@
+package socks5_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// startMockSessionServer starts a proxy that negotiates once per connection and
+// then serves requests in turn, answering one "ping" with "pong" per relay.
+func startMockSessionServer(t *testing.T, handshakes *atomic.Int32, ports chan<- uint16) (string, func()) {
+	return startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		if _, err := hsReq.ReadFrom(c); err != nil {
+			return
+		}
+		handshakes.Add(1)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		for {
+			var req socks5.Request
+			if _, err := req.ReadFrom(c); err != nil {
+				return
+			}
+			ports <- req.Port
+
+			if req.Port == 9 {
+				socks5.NewErrorReply(socks5.RepConnectionRefused).WriteTo(c)
+				continue
+			}
+			socks5.NewSuccessReply(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(req.Port)}).WriteTo(c)
+
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			c.Write([]byte("pong"))
+		}
+	})
+}
+
+func pingPong(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("expected pong, got %q", buf)
+	}
+}
+
+func TestSession_Connect(t *testing.T) {
+	var handshakes atomic.Int32
+	ports := make(chan uint16, 4)
+	proxyAddr, stop := startMockSessionServer(t, &handshakes, ports)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	d := socks5.NewDialer("", nil, nil)
+	s, err := d.OpenSession(ctx, proxyAddr)
+	if err != nil {
+		t.Fatalf("OpenSession failed: %v", err)
+	}
+	defer s.Close()
+
+	c1, err := s.Connect(ctx, "127.0.0.1:1001")
+	if err != nil {
+		t.Fatalf("first Connect failed: %v", err)
+	}
+	pingPong(t, c1)
+
+	// the first relay is still open
+	if _, err := s.Connect(ctx, "127.0.0.1:1002"); !errors.Is(err, socks5.ErrSessionBusy) {
+		t.Fatalf("expected ErrSessionBusy, got %v", err)
+	}
+	c1.Close()
+	if _, err := c1.Read(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("read after Close: expected net.ErrClosed, got %v", err)
+	}
+
+	c2, err := s.Connect(ctx, "127.0.0.1:1002")
+	if err != nil {
+		t.Fatalf("second Connect failed: %v", err)
+	}
+	pingPong(t, c2)
+	c2.Close()
+
+	// a refused request leaves the session usable
+	var code socks5.ReplyCode
+	if _, err := s.Connect(ctx, "127.0.0.1:9"); !errors.As(err, &code) || code != socks5.RepConnectionRefused {
+		t.Fatalf("expected RepConnectionRefused, got %v", err)
+	}
+
+	if n := handshakes.Load(); n != 1 {
+		t.Fatalf("proxy saw %d handshakes, want 1", n)
+	}
+	for _, want := range []uint16{1001, 1002, 9} {
+		if got := <-ports; got != want {
+			t.Fatalf("proxy received port %d, want %d", got, want)
+		}
+	}
+
+	s.Close()
+	if _, err := s.Connect(ctx, "127.0.0.1:1003"); !errors.Is(err, socks5.ErrSessionClosed) {
+		t.Fatalf("expected ErrSessionClosed, got %v", err)
+	}
+}
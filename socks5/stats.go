@@ -0,0 +1,135 @@
+package socks5
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	socksnet "github.com/33TU/socks/net"
+)
+
+// Stats counts the activity of a server for monitoring. Attach it to a
+// BaseServerHandler, and serve it over HTTP with StartAdminServer or as an
+// http.Handler. The zero value is ready to use and safe for concurrent use.
+//
+// Connections are counted as they open and close; bytes, commands and
+// authentication failures are counted once a connection has closed.
+type Stats struct {
+	active       atomic.Int64
+	total        atomic.Int64
+	bytesUp      atomic.Int64
+	bytesDown    atomic.Int64
+	authFailures atomic.Int64
+
+	mu       sync.Mutex
+	commands map[string]int64
+}
+
+// StatsSnapshot is a copy of the counters of Stats, as served by ServeHTTP.
+type StatsSnapshot struct {
+	ActiveConnections int64            `json:"active_connections"`
+	TotalConnections  int64            `json:"total_connections"`
+	BytesUpstream     int64            `json:"total_bytes_upstream"`   // Client-to-target bytes
+	BytesDownstream   int64            `json:"total_bytes_downstream"` // Target-to-client bytes
+	AuthFailures      int64            `json:"auth_failures"`          // Connections ended in the auth phase
+	CommandCounts     map[string]int64 `json:"command_counts"`         // Requests by command, e.g. "CONNECT"
+}
+
+// Attach makes h report to s through its OnStateChange and OnAudit hooks.
+// Hooks already set on h are kept and called after s is updated.
+func (s *Stats) Attach(h *BaseServerHandler) {
+	onStateChange, onAudit := h.OnStateChange, h.OnAudit
+
+	h.OnStateChange = func(conn net.Conn, from, to ConnState) {
+		s.stateChange(from, to)
+		if onStateChange != nil {
+			onStateChange(conn, from, to)
+		}
+	}
+	h.OnAudit = func(ctx context.Context, rec *AuditRecord) {
+		s.audit(rec)
+		if onAudit != nil {
+			onAudit(ctx, rec)
+		}
+	}
+}
+
+// Snapshot returns the current counters.
+func (s *Stats) Snapshot() StatsSnapshot {
+	s.mu.Lock()
+	commands := maps.Clone(s.commands)
+	s.mu.Unlock()
+
+	if commands == nil {
+		commands = map[string]int64{}
+	}
+	return StatsSnapshot{
+		ActiveConnections: s.active.Load(),
+		TotalConnections:  s.total.Load(),
+		BytesUpstream:     s.bytesUp.Load(),
+		BytesDownstream:   s.bytesDown.Load(),
+		AuthFailures:      s.authFailures.Load(),
+		CommandCounts:     commands,
+	}
+}
+
+// ServeHTTP writes the Snapshot as JSON.
+func (s *Stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Snapshot())
+}
+
+// stateChange counts connections as they open and close.
+func (s *Stats) stateChange(from, to ConnState) {
+	if from == ConnStateNew {
+		s.total.Add(1)
+		s.active.Add(1)
+	}
+	if to == ConnStateClosed {
+		s.active.Add(-1)
+	}
+}
+
+// audit counts the traffic and outcome of a closed connection.
+func (s *Stats) audit(rec *AuditRecord) {
+	s.bytesUp.Add(rec.BytesUp)
+	s.bytesDown.Add(rec.BytesDown)
+	if rec.Phase == socksnet.PhaseAuth.String() {
+		s.authFailures.Add(1)
+	}
+
+	if rec.Command != "" {
+		s.mu.Lock()
+		if s.commands == nil {
+			s.commands = make(map[string]int64)
+		}
+		s.commands[rec.Command]++
+		s.mu.Unlock()
+	}
+}
+
+// StartAdminServer serves stats as JSON at GET /stats on addr, in the
+// background. Addr of the returned server is set to the address listened on,
+// which differs from addr if its port is 0. Stop it with its Shutdown method.
+func StartAdminServer(addr string, stats *Stats) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /stats", stats)
+
+	srv := &http.Server{
+		Addr:              ln.Addr().String(),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go srv.Serve(ln)
+	return srv, nil
+}
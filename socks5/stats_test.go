@@ -0,0 +1,103 @@
+package socks5_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func TestStats_AdminServer(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+
+	var stats socks5.Stats
+	audited := make(chan struct{}, 8)
+	handler := &socks5.BaseServerHandler{
+		RequestTimeout:   2 * time.Second,
+		AllowConnect:     true,
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			if password != "s3cret" {
+				return errors.New("invalid credentials")
+			}
+			return nil
+		},
+		// hooks set before Attach keep working
+		OnAudit: func(ctx context.Context, rec *socks5.AuditRecord) { audited <- struct{}{} },
+	}
+	stats.Attach(handler)
+
+	socksLn := startSOCKS5Server(t, handler)
+	defer socksLn.Close()
+
+	admin, err := socks5.StartAdminServer("127.0.0.1:0", &stats)
+	if err != nil {
+		t.Fatalf("StartAdminServer failed: %v", err)
+	}
+	defer admin.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	dialer := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "alice", Password: "s3cret"}, nil)
+	for range 3 {
+		conn, err := dialer.DialContext(ctx, "tcp", echoLn.Addr().String())
+		if err != nil {
+			t.Fatalf("DialContext failed: %v", err)
+		}
+		payload := genRandom(100)
+		conn.Write(payload)
+		if _, err := io.ReadFull(conn, make([]byte, len(payload))); err != nil {
+			t.Fatalf("echo failed: %v", err)
+		}
+		conn.Close()
+	}
+
+	rejected := socks5.NewDialer(socksLn.Addr().String(), &socks5.Auth{Username: "mallory", Password: "guess"}, nil)
+	if _, err := rejected.DialContext(ctx, "tcp", echoLn.Addr().String()); err == nil {
+		t.Fatal("expected authentication to fail")
+	}
+
+	for range 4 {
+		select {
+		case <-audited:
+		case <-ctx.Done():
+			t.Fatal("connections were not audited")
+		}
+	}
+
+	resp, err := http.Get("http://" + admin.Addr + "/stats")
+	if err != nil {
+		t.Fatalf("GET /stats failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var got socks5.StatsSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("decode /stats: %v", err)
+	}
+
+	if got.ActiveConnections != 0 || got.TotalConnections != 4 || got.AuthFailures != 1 {
+		t.Fatalf("connections active=%d total=%d auth failures=%d, want 0, 4 and 1",
+			got.ActiveConnections, got.TotalConnections, got.AuthFailures)
+	}
+	if got.BytesUpstream != 300 || got.BytesDownstream != 300 {
+		t.Fatalf("bytes up=%d down=%d, want 300 each", got.BytesUpstream, got.BytesDownstream)
+	}
+	if len(got.CommandCounts) != 1 || got.CommandCounts["CONNECT"] != 3 {
+		t.Fatalf("command counts = %v, want 3 CONNECT", got.CommandCounts)
+	}
+
+	if err := admin.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if _, err := http.Get("http://" + admin.Addr + "/stats"); err == nil {
+		t.Fatal("admin server still serving after Shutdown")
+	}
+}
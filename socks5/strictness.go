@@ -0,0 +1,109 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+)
+
+// Strictness selects deviations from RFC 1928 and RFC 1929 that the server
+// tolerates from buggy clients. The zero value is strict. Relaxations apply
+// only to what ServeConn reads; the messages' Validate methods stay strict.
+type Strictness uint8
+
+// Tolerated client deviations.
+const (
+	// TolerateRequestRSV accepts a request whose RSV byte is not 0x00. The
+	// request is handled as if RSV were 0x00.
+	TolerateRequestRSV Strictness = 1 << iota
+
+	// TolerateAuthVersion accepts a username/password sub-negotiation whose
+	// VER byte is not 0x01, e.g. clients that send the SOCKS version 0x05.
+	TolerateAuthVersion
+)
+
+func (s Strictness) String() string {
+	if s == 0 {
+		return "strict"
+	}
+
+	var names []string
+	if s&TolerateRequestRSV != 0 {
+		names = append(names, "request-rsv")
+	}
+	if s&TolerateAuthVersion != 0 {
+		names = append(names, "auth-version")
+	}
+	if s&^(TolerateRequestRSV|TolerateAuthVersion) != 0 {
+		names = append(names, "unknown")
+	}
+	return strings.Join(names, "|")
+}
+
+// strictnessHandler is implemented by handlers that tolerate client deviations.
+// OnTolerated is called at most once per connection and deviation, when the
+// deviation is first relied on.
+type strictnessHandler interface {
+	GetStrictness() Strictness
+	OnTolerated(ctx context.Context, conn net.Conn, deviation Strictness)
+}
+
+// leniency applies a handler's Strictness to one connection.
+type leniency struct {
+	handler  strictnessHandler // nil=strict
+	allowed  Strictness
+	reported Strictness
+}
+
+// newLeniency returns the leniency of handler.
+func newLeniency(handler ServerHandler) leniency {
+	if h, ok := handler.(strictnessHandler); ok {
+		return leniency{handler: h, allowed: h.GetStrictness()}
+	}
+	return leniency{}
+}
+
+// read calls read with src. If deviation is tolerated, the byte at offset at
+// is replaced by want on the way, and the deviation is reported the first
+// time the replaced byte differed.
+func (l *leniency) read(
+	ctx context.Context,
+	conn net.Conn,
+	src io.Reader,
+	deviation Strictness,
+	at int64,
+	want byte,
+	read func(src io.Reader) (int64, error),
+) (int64, error) {
+	if l.allowed&deviation == 0 {
+		return read(src)
+	}
+
+	fr := byteFixReader{r: src, at: at, want: want}
+	n, err := read(&fr)
+	if fr.fixed && l.reported&deviation == 0 {
+		l.reported |= deviation
+		l.handler.OnTolerated(ctx, conn, deviation)
+	}
+	return n, err
+}
+
+// byteFixReader reads from r, replacing the byte at offset at with want.
+type byteFixReader struct {
+	r     io.Reader
+	at    int64 // Offset of the replaced byte
+	want  byte
+	n     int64 // Bytes read so far
+	fixed bool  // The original byte differed from want
+}
+
+func (f *byteFixReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if i := f.at - f.n; i >= 0 && i < int64(n) && p[i] != f.want {
+		p[i] = f.want
+		f.fixed = true
+	}
+	f.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,183 @@
+package socks5_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// toleranceRecorder records the deviations reported by ServeConn.
+type toleranceRecorder struct {
+	*socks5.BaseServerHandler
+
+	mu       sync.Mutex
+	reported []socks5.Strictness
+}
+
+func (r *toleranceRecorder) OnTolerated(ctx context.Context, conn net.Conn, deviation socks5.Strictness) {
+	r.mu.Lock()
+	r.reported = append(r.reported, deviation)
+	r.mu.Unlock()
+}
+
+func (r *toleranceRecorder) deviations() []socks5.Strictness {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reported
+}
+
+func TestStrictness(t *testing.T) {
+	echoLn := echoServer(t)
+	defer echoLn.Close()
+	echoAddr := echoLn.Addr().(*net.TCPAddr)
+
+	connect := func(rsv byte) []byte {
+		return []byte{
+			socks5.SocksVersion, socks5.CmdConnect, rsv, socks5.AddrTypeIPv4,
+			127, 0, 0, 1, byte(echoAddr.Port >> 8), byte(echoAddr.Port),
+		}
+	}
+	userPass := func(ver byte) []byte {
+		return []byte{ver, 1, 'u', 1, 'p'}
+	}
+
+	tests := []struct {
+		name       string
+		strictness socks5.Strictness
+		method     byte
+		auth       []byte // username/password sub-negotiation (nil=no auth)
+		request    []byte
+		wantOK     bool
+		reported   socks5.Strictness // deviation reported (0=none)
+	}{
+		{"rsv strict", 0, socks5.MethodNoAuth, nil, connect(1), false, 0},
+		{"rsv tolerated", socks5.TolerateRequestRSV, socks5.MethodNoAuth, nil, connect(1), true, socks5.TolerateRequestRSV},
+		{"rsv zero not reported", socks5.TolerateRequestRSV, socks5.MethodNoAuth, nil, connect(0), true, 0},
+		{"auth version strict", 0, socks5.MethodUserPass, userPass(5), connect(0), false, 0},
+		{"auth version tolerated", socks5.TolerateAuthVersion, socks5.MethodUserPass, userPass(5), connect(0), true, socks5.TolerateAuthVersion},
+		{"auth version only", socks5.TolerateAuthVersion, socks5.MethodNoAuth, nil, connect(1), false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &toleranceRecorder{BaseServerHandler: &socks5.BaseServerHandler{
+				AllowConnect:     true,
+				SupportedMethods: []byte{tt.method},
+				Strictness:       tt.strictness,
+				UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+					return nil
+				},
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}}
+			socksLn := startSOCKS5Server(t, rec)
+			defer socksLn.Close()
+
+			conn, err := net.Dial("tcp", socksLn.Addr().String())
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+			ok := func() bool {
+				conn.Write([]byte{socks5.SocksVersion, 1, tt.method})
+				var hs socks5.HandshakeReply
+				if _, err := hs.ReadFrom(conn); err != nil || hs.Method != tt.method {
+					return false
+				}
+
+				if tt.auth != nil {
+					conn.Write(tt.auth)
+					var up socks5.UserPassReply
+					if _, err := up.ReadFrom(conn); err != nil || up.Status != socks5.UserPassStatusSuccess {
+						return false
+					}
+				}
+
+				conn.Write(tt.request)
+				var reply socks5.Reply
+				_, err := reply.ReadFrom(conn)
+				return err == nil && reply.Reply == socks5.RepSuccess
+			}()
+			if ok != tt.wantOK {
+				t.Fatalf("request succeeded=%v, want %v", ok, tt.wantOK)
+			}
+
+			// the deviation is reported once the request has been read
+			conn.Close()
+			time.Sleep(50 * time.Millisecond)
+
+			got := rec.deviations()
+			switch {
+			case tt.reported == 0 && len(got) != 0:
+				t.Fatalf("unexpected deviations reported: %v", got)
+			case tt.reported != 0 && (len(got) != 1 || got[0] != tt.reported):
+				t.Fatalf("reported %v, want [%v]", got, tt.reported)
+			}
+		})
+	}
+}
+
+func TestStrictness_ReportedOncePerConnection(t *testing.T) {
+	rec := &toleranceRecorder{BaseServerHandler: &socks5.BaseServerHandler{
+		SupportedMethods: []byte{socks5.MethodUserPass},
+		Strictness:       socks5.TolerateAuthVersion,
+		MaxAuthAttempts:  3,
+		UserPassAuthenticator: func(ctx context.Context, username, password string) error {
+			if password != "right" {
+				return io.EOF
+			}
+			return nil
+		},
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}}
+	socksLn := startSOCKS5Server(t, rec)
+	defer socksLn.Close()
+
+	conn, err := net.Dial("tcp", socksLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	conn.Write([]byte{socks5.SocksVersion, 1, socks5.MethodUserPass})
+	io.ReadFull(conn, make([]byte, 2))
+
+	// two attempts with the wrong version, both tolerated
+	for _, password := range []string{"wrong", "right"} {
+		msg := append([]byte{5, 1, 'u', byte(len(password))}, password...)
+		conn.Write(msg)
+
+		var reply socks5.UserPassReply
+		if _, err := reply.ReadFrom(conn); err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+	}
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	if got := rec.deviations(); len(got) != 1 || got[0] != socks5.TolerateAuthVersion {
+		t.Fatalf("reported %v, want one TolerateAuthVersion", got)
+	}
+}
+
+func TestStrictness_String(t *testing.T) {
+	tests := map[socks5.Strictness]string{
+		0:                          "strict",
+		socks5.TolerateRequestRSV:  "request-rsv",
+		socks5.TolerateAuthVersion: "auth-version",
+		socks5.TolerateRequestRSV | socks5.TolerateAuthVersion: "request-rsv|auth-version",
+	}
+	for s, want := range tests {
+		if got := s.String(); got != want {
+			t.Errorf("%d: got %q, want %q", s, got, want)
+		}
+	}
+}
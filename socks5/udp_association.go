@@ -0,0 +1,62 @@
+package socks5
+
+import (
+	"io"
+	"net"
+)
+
+// UDPAssociation is a UDP association opened by Dialer.OpenUDPAssociation. It
+// bundles the TCP control connection with the UDP socket to the relay: the
+// association lives as long as the control connection, so closing either end
+// of it tears down both sockets.
+//
+// ReadFrom, WriteTo, LocalAddr and the deadline methods are those of the
+// embedded UDPConn.
+type UDPAssociation struct {
+	*UDPConn
+	done chan struct{}
+}
+
+// newUDPAssociation wraps conn and starts watching its control connection.
+func newUDPAssociation(conn *UDPConn) *UDPAssociation {
+	a := &UDPAssociation{
+		UDPConn: conn,
+		done:    make(chan struct{}),
+	}
+	go a.watch()
+	return a
+}
+
+// watch waits for the control connection to end and then closes the UDP
+// socket, unblocking pending reads.
+func (a *UDPAssociation) watch() {
+	defer close(a.done)
+
+	// the proxy sends nothing on the control connection once it has replied
+	io.Copy(io.Discard, a.tcpConn)
+	a.udpConn.Close()
+}
+
+// ControlConn returns the TCP control connection of the association.
+func (a *UDPAssociation) ControlConn() net.Conn {
+	return a.tcpConn
+}
+
+// RelayAddr returns the UDP relay address announced by the proxy.
+func (a *UDPAssociation) RelayAddr() *net.UDPAddr {
+	return a.relayAddr
+}
+
+// Done returns a channel that is closed once the association has ended, either
+// by Close or because the proxy closed the control connection.
+func (a *UDPAssociation) Done() <-chan struct{} {
+	return a.done
+}
+
+// Close closes the UDP socket and the control connection, ending the
+// association.
+func (a *UDPAssociation) Close() error {
+	err := a.UDPConn.Close()
+	<-a.done
+	return err
+}
@@ -0,0 +1,136 @@
+package socks5_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+// udpEchoServer starts a UDP server echoing every datagram back to its sender.
+func udpEchoServer(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn
+}
+
+func TestUDPAssociation(t *testing.T) {
+	echo := udpEchoServer(t)
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowUDPAssociate: true,
+		SupportedMethods:  []byte{socks5.MethodNoAuth},
+	})
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assoc, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).OpenUDPAssociation(ctx, "tcp", nil)
+	if err != nil {
+		t.Fatalf("OpenUDPAssociation failed: %v", err)
+	}
+	defer assoc.Close()
+
+	if assoc.LocalAddr() == nil || assoc.RelayAddr() == nil || assoc.ControlConn() == nil {
+		t.Fatalf("association is missing an address or the control connection")
+	}
+	assoc.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// several queries over the same association
+	buf := make([]byte, 2048)
+	for _, msg := range []string{"query-1", "query-2", "query-3"} {
+		if _, err := assoc.WriteTo([]byte(msg), echoAddr); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+
+		n, addr, err := assoc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		if string(buf[:n]) != msg {
+			t.Errorf("expected %q, got %q", msg, buf[:n])
+		}
+		if from := addr.(*net.UDPAddr); !from.IP.Equal(echoAddr.IP) || from.Port != echoAddr.Port {
+			t.Errorf("expected reply from %v, got %v", echoAddr, addr)
+		}
+	}
+
+	if err := assoc.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	select {
+	case <-assoc.Done():
+	default:
+		t.Fatalf("Done not closed after Close")
+	}
+	if _, _, err := assoc.ReadFrom(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("UDP socket: expected net.ErrClosed, got %v", err)
+	}
+	if _, err := assoc.ControlConn().Read(buf); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("control connection: expected net.ErrClosed, got %v", err)
+	}
+}
+
+func TestUDPAssociation_ControlConnClosedByProxy(t *testing.T) {
+	drop := make(chan struct{})
+	proxyAddr, stop := startMockSOCKS5Server(t, func(c net.Conn) {
+		defer c.Close()
+
+		var hsReq socks5.HandshakeRequest
+		hsReq.ReadFrom(c)
+		(&socks5.HandshakeReply{Version: socks5.SocksVersion, Method: socks5.MethodNoAuth}).WriteTo(c)
+
+		var req socks5.Request
+		req.ReadFrom(c)
+		socks5.NewSuccessReply(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}).WriteTo(c)
+
+		<-drop
+	})
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assoc, err := socks5.NewDialer(proxyAddr, nil, nil).OpenUDPAssociation(ctx, "tcp", nil)
+	if err != nil {
+		t.Fatalf("OpenUDPAssociation failed: %v", err)
+	}
+	defer assoc.Close()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := assoc.ReadFrom(make([]byte, 64))
+		readErr <- err
+	}()
+
+	close(drop)
+
+	select {
+	case <-assoc.Done():
+	case <-ctx.Done():
+		t.Fatalf("association did not end after the proxy closed the control connection")
+	}
+	if err := <-readErr; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("pending ReadFrom: expected net.ErrClosed, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package socks5
 
 import (
 	"net"
+	"slices"
 	"time"
 
 	"github.com/33TU/socks/internal"
@@ -12,6 +13,9 @@ type UDPConn struct {
 	tcpConn   net.Conn     // control connection (UDP ASSOCIATE)
 	udpConn   *net.UDPConn // actual UDP socket to proxy
 	relayAddr *net.UDPAddr // proxy UDP endpoint
+
+	fragmenter  *Fragmenter  // splits oversized payloads (nil=fragmentation disabled)
+	reassembler *Reassembler // rebuilds fragmented datagrams (nil=fragments are rejected)
 }
 
 // NewUDPConn creates a new UDPConn for the given TCP control connection, UDP socket, and proxy relay address.
@@ -23,6 +27,15 @@ func NewUDPConn(tcpConn net.Conn, udpConn *net.UDPConn, relayAddr *net.UDPAddr)
 	}
 }
 
+// EnableFragmentation makes the connection split payloads that do not fit in
+// maxDatagramSize bytes (0=DefaultUDPFragmentSize) into fragments and reassemble
+// fragmented datagrams received from the proxy. It must be called before the
+// connection is used.
+func (c *UDPConn) EnableFragmentation(maxDatagramSize int) {
+	c.fragmenter = &Fragmenter{MaxDatagramSize: maxDatagramSize}
+	c.reassembler = &Reassembler{}
+}
+
 // LocalAddr implements [net.PacketConn].
 func (c *UDPConn) LocalAddr() net.Addr {
 	return c.udpConn.LocalAddr()
@@ -45,22 +58,19 @@ func (c *UDPConn) SetWriteDeadline(t time.Time) error {
 
 // WriteTo implements [net.PacketConn].
 func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) {
-	udpAddr := addr.(*net.UDPAddr)
-
-	ip := udpAddr.IP
-	addrType := AddrTypeIPv6
-	if ip4 := ip.To4(); ip4 != nil {
-		addrType = AddrTypeIPv4
-		ip = ip4
-	}
-
-	pkt := UDPPacket{
-		Reserved: [2]byte{0, 0},
-		Frag:     0,
-		AddrType: byte(addrType),
-		IP:       ip,
-		Port:     uint16(udpAddr.Port),
-		Data:     p,
+	pkt := udpPacketTo(addr.(*net.UDPAddr), p)
+
+	if c.fragmenter != nil {
+		frags, err := c.fragmenter.Fragment(&pkt)
+		if err != nil {
+			return 0, err
+		}
+		for _, frag := range frags {
+			if err := c.write(frag); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
 	}
 
 	buf := internal.GetBytes(pkt.Size())
@@ -71,23 +81,31 @@ func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) {
 		return 0, err
 	}
 
+	if err := c.write(buf[:n]); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// write sends an encoded datagram to the relay.
+func (c *UDPConn) write(b []byte) (err error) {
 	if c.udpConn.RemoteAddr() != nil {
 		// connected socket
-		_, err = c.udpConn.Write(buf[:n])
+		_, err = c.udpConn.Write(b)
 	} else {
 		// unconnected socket
-		_, err = c.udpConn.WriteToUDP(buf[:n], c.relayAddr)
-	}
-
-	if err != nil {
-		return 0, err
+		_, err = c.udpConn.WriteToUDP(b, c.relayAddr)
 	}
-
-	return len(p), nil
+	return err
 }
 
 // ReadFrom implements [net.PacketConn].
 func (c *UDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if c.reassembler != nil {
+		return c.readFragmented(p)
+	}
+
 	n, _, err := c.udpConn.ReadFromUDP(p)
 	if err != nil {
 		return 0, nil, err
@@ -99,18 +117,60 @@ func (c *UDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
 		return 0, nil, err
 	}
 
-	copy(p, pkt.Data)
-
+	// IP refers to p, so take it before the payload is moved to the front
 	addr := &net.UDPAddr{
-		IP:   pkt.IP,
+		IP:   slices.Clone(pkt.IP),
 		Port: int(pkt.Port),
 	}
 
+	copy(p, pkt.Data)
+
 	return len(pkt.Data), addr, nil
 }
 
+// readFragmented reads datagrams until a whole one has been reassembled.
+// Payloads larger than p are truncated.
+func (c *UDPConn) readFragmented(p []byte) (int, net.Addr, error) {
+	buf := internal.GetBytes(64 * 1024)
+	defer internal.PutBytes(buf)
+
+	for {
+		n, _, err := c.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		var frag UDPPacket
+		if _, err := frag.UnmarshalFragment(buf[:n]); err != nil {
+			return 0, nil, err
+		}
+
+		pkt, err := c.reassembler.Add(&frag)
+		if err != nil {
+			return 0, nil, err
+		}
+		if pkt == nil {
+			continue
+		}
+
+		addr := &net.UDPAddr{
+			IP:   slices.Clone(pkt.IP),
+			Port: int(pkt.Port),
+		}
+
+		return copy(p, pkt.Data), addr, nil
+	}
+}
+
 // Close implements [net.PacketConn].
 func (c *UDPConn) Close() error {
 	c.udpConn.Close()
 	return c.tcpConn.Close() // MUST close control connection
 }
+
+// udpPacketTo builds a UDPPacket carrying data addressed to addr.
+func udpPacketTo(addr *net.UDPAddr, data []byte) UDPPacket {
+	pkt := UDPPacket{Data: data}
+	pkt.SetAddr(addr)
+	return pkt
+}
@@ -0,0 +1,194 @@
+package socks5
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"time"
+)
+
+// UDP fragmentation constants (RFC 1928 section 7).
+const (
+	UDPFragEnd      = 0x80 // high-order bit of FRAG; set on the last fragment of a sequence
+	MaxUDPFragments = 127  // highest fragment position
+
+	DefaultReassemblyTimeout = 5 * time.Second
+	DefaultReassemblyMaxSize = 64 * 1024
+	DefaultUDPFragmentSize   = 1472 // Ethernet MTU minus IPv4 and UDP headers
+)
+
+// Errors returned by Reassembler and Fragmenter.
+var (
+	ErrInvalidFragment  = errors.New("invalid UDP fragment position")
+	ErrFragmentTooLarge = errors.New("reassembled UDP datagram too large")
+	ErrTooManyFragments = errors.New("UDP payload needs more than 127 fragments")
+)
+
+// Reassembler rebuilds datagrams from SOCKS5 UDP fragments.
+// A sequence is complete once the fragment with the end-of-sequence bit and all
+// fragments before it have been received. As RFC 1928 section 7 requires, the
+// current sequence is abandoned and a new one started when it times out or when
+// a fragment arrives whose position is not above the highest one received, so
+// fragments must arrive in order. A fragment past the end of the sequence or for
+// another destination also starts a new sequence.
+//
+// The zero value is ready to use. A Reassembler is not safe for concurrent use.
+type Reassembler struct {
+	Timeout time.Duration // Time allowed to receive a whole sequence (0=DefaultReassemblyTimeout)
+	MaxSize int           // Maximum size of a reassembled payload (0=DefaultReassemblyMaxSize)
+
+	frags [][]byte  // payloads by position-1
+	count int       // number of fragments held
+	size  int       // total payload size held
+	high  int       // highest position held
+	last  int       // position of the end fragment (0=not seen)
+	dst   UDPPacket // destination of the current sequence
+	start time.Time // arrival of the first fragment
+}
+
+// Add adds pkt to the current sequence and returns the reassembled datagram once
+// the sequence is complete, or nil while more fragments are needed.
+// Standalone datagrams (FRAG=0x00) are returned as-is. Fragment data is copied,
+// so pkt may refer to a reused buffer.
+func (r *Reassembler) Add(pkt *UDPPacket) (*UDPPacket, error) {
+	if pkt.Frag == 0x00 {
+		return pkt, nil
+	}
+
+	pos := int(pkt.Frag &^ UDPFragEnd)
+	end := pkt.Frag&UDPFragEnd != 0
+	if pos == 0 {
+		return nil, ErrInvalidFragment
+	}
+
+	if r.count > 0 && (time.Since(r.start) > r.timeout() ||
+		pos <= r.high ||
+		(r.last != 0 && pos > r.last) ||
+		!sameUDPDestination(&r.dst, pkt)) {
+		r.Reset()
+	}
+
+	if r.size+len(pkt.Data) > r.maxSize() {
+		r.Reset()
+		return nil, ErrFragmentTooLarge
+	}
+
+	if r.count == 0 {
+		if r.frags == nil {
+			r.frags = make([][]byte, MaxUDPFragments)
+		}
+		r.start = time.Now()
+		r.dst = UDPPacket{
+			AddrType: pkt.AddrType,
+			IP:       slices.Clone(pkt.IP),
+			Domain:   pkt.Domain,
+			Port:     pkt.Port,
+		}
+	}
+
+	r.frags[pos-1] = slices.Clone(pkt.Data)
+	r.count++
+	r.size += len(pkt.Data)
+	r.high = max(r.high, pos)
+	if end {
+		r.last = pos
+	}
+
+	if r.last == 0 || r.count != r.last {
+		return nil, nil
+	}
+
+	data := make([]byte, 0, r.size)
+	for _, frag := range r.frags[:r.last] {
+		data = append(data, frag...)
+	}
+
+	out := r.dst
+	out.Data = data
+	r.Reset()
+	return &out, nil
+}
+
+// Reset abandons the current sequence.
+func (r *Reassembler) Reset() {
+	clear(r.frags)
+	r.count = 0
+	r.size = 0
+	r.high = 0
+	r.last = 0
+	r.dst = UDPPacket{}
+}
+
+func (r *Reassembler) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return DefaultReassemblyTimeout
+	}
+	return r.Timeout
+}
+
+func (r *Reassembler) maxSize() int {
+	if r.MaxSize <= 0 {
+		return DefaultReassemblyMaxSize
+	}
+	return r.MaxSize
+}
+
+// sameUDPDestination reports whether a and b are addressed to the same destination.
+func sameUDPDestination(a, b *UDPPacket) bool {
+	return a.AddrType == b.AddrType &&
+		a.IP.Equal(b.IP) &&
+		a.Domain == b.Domain &&
+		a.Port == b.Port
+}
+
+// Fragmenter splits UDP payloads that do not fit in a single SOCKS5 datagram.
+// The zero value is ready to use.
+type Fragmenter struct {
+	MaxDatagramSize int // Maximum encoded size of each datagram (0=DefaultUDPFragmentSize)
+}
+
+// Fragment encodes pkt as one or more SOCKS5 UDP datagrams.
+// If pkt fits within MaxDatagramSize it is encoded as a single standalone datagram;
+// otherwise its payload is split into fragments numbered from 1, the last one
+// carrying the end-of-sequence bit.
+func (f *Fragmenter) Fragment(pkt *UDPPacket) ([][]byte, error) {
+	maxSize := f.MaxDatagramSize
+	if maxSize <= 0 {
+		maxSize = DefaultUDPFragmentSize
+	}
+
+	if pkt.Size() <= maxSize {
+		b, err := pkt.AppendTo(nil)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{b}, nil
+	}
+
+	chunk := maxSize - pkt.HeaderLen()
+	if chunk <= 0 {
+		return nil, io.ErrShortBuffer
+	}
+
+	n := (len(pkt.Data) + chunk - 1) / chunk
+	if n > MaxUDPFragments {
+		return nil, ErrTooManyFragments
+	}
+
+	out := make([][]byte, 0, n)
+	frag := *pkt
+	for i := range n {
+		frag.Frag = byte(i + 1)
+		if i == n-1 {
+			frag.Frag |= UDPFragEnd
+		}
+		frag.Data = pkt.Data[i*chunk : min((i+1)*chunk, len(pkt.Data))]
+
+		b, err := frag.appendTo(make([]byte, 0, frag.Size()), true)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
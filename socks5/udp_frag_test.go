@@ -0,0 +1,225 @@
+package socks5_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func fragment(t *testing.T, data []byte, maxSize int) []*socks5.UDPPacket {
+	t.Helper()
+
+	pkt := socks5.UDPPacket{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1).To4(), Port: 53, Data: data}
+	f := socks5.Fragmenter{MaxDatagramSize: maxSize}
+	encoded, err := f.Fragment(&pkt)
+	if err != nil {
+		t.Fatalf("Fragment failed: %v", err)
+	}
+
+	frags := make([]*socks5.UDPPacket, len(encoded))
+	for i, b := range encoded {
+		if len(b) > maxSize {
+			t.Fatalf("fragment %d is %d bytes, max %d", i, len(b), maxSize)
+		}
+		frags[i] = &socks5.UDPPacket{}
+		if _, err := frags[i].UnmarshalFragment(b); err != nil {
+			t.Fatalf("UnmarshalFragment failed: %v", err)
+		}
+	}
+	return frags
+}
+
+func Test_Fragmenter_SingleDatagram(t *testing.T) {
+	frags := fragment(t, []byte("small"), 512)
+	if len(frags) != 1 || frags[0].Frag != 0x00 {
+		t.Fatalf("expected one standalone datagram, got %d (frag=%#x)", len(frags), frags[0].Frag)
+	}
+}
+
+func Test_Fragmenter_TooManyFragments(t *testing.T) {
+	pkt := socks5.UDPPacket{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(10, 0, 0, 1).To4(), Port: 53, Data: genRandom(200 * 10)}
+	f := socks5.Fragmenter{MaxDatagramSize: 20}
+	if _, err := f.Fragment(&pkt); !errors.Is(err, socks5.ErrTooManyFragments) {
+		t.Fatalf("expected ErrTooManyFragments, got %v", err)
+	}
+}
+
+func Test_Reassembler_InOrder(t *testing.T) {
+	data := genRandom(1000)
+	frags := fragment(t, data, 310)
+	if len(frags) != 4 {
+		t.Fatalf("expected 4 fragments, got %d", len(frags))
+	}
+	if frags[3].Frag != 4|socks5.UDPFragEnd {
+		t.Fatalf("last fragment FRAG = %#x, want end bit", frags[3].Frag)
+	}
+
+	var r socks5.Reassembler
+	for i, frag := range frags[:3] {
+		if pkt, err := r.Add(frag); err != nil || pkt != nil {
+			t.Fatalf("fragment %d: got (%v, %v), want incomplete", i, pkt, err)
+		}
+	}
+
+	pkt, err := r.Add(frags[3])
+	if err != nil || pkt == nil {
+		t.Fatalf("expected reassembled datagram, got (%v, %v)", pkt, err)
+	}
+	if !bytes.Equal(pkt.Data, data) {
+		t.Fatal("reassembled data mismatch")
+	}
+	if pkt.Frag != 0x00 || pkt.Port != 53 || !pkt.IP.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Errorf("unexpected header: %v", pkt)
+	}
+}
+
+func Test_Reassembler_Timeout(t *testing.T) {
+	frags := fragment(t, genRandom(500), 310)
+
+	r := socks5.Reassembler{Timeout: 20 * time.Millisecond}
+	if pkt, _ := r.Add(frags[0]); pkt != nil {
+		t.Fatal("unexpected datagram after first fragment")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	// the first fragment was discarded, so the sequence cannot complete
+	if pkt, err := r.Add(frags[1]); err != nil || pkt != nil {
+		t.Fatalf("expected timed out sequence to be discarded, got (%v, %v)", pkt, err)
+	}
+}
+
+func Test_Reassembler_SequenceRestart(t *testing.T) {
+	first := fragment(t, genRandom(500), 310)
+	second := fragment(t, genRandom(500), 310)
+
+	var r socks5.Reassembler
+	if pkt, _ := r.Add(first[0]); pkt != nil {
+		t.Fatal("unexpected datagram after first fragment")
+	}
+
+	// a repeated position starts a new sequence
+	if pkt, _ := r.Add(second[0]); pkt != nil {
+		t.Fatal("unexpected datagram after restart")
+	}
+	pkt, err := r.Add(second[1])
+	if err != nil || pkt == nil {
+		t.Fatalf("expected reassembled datagram, got (%v, %v)", pkt, err)
+	}
+	if !bytes.Equal(pkt.Data, append(bytes.Clone(second[0].Data), second[1].Data...)) {
+		t.Fatal("restarted sequence mixed in data from the abandoned one")
+	}
+}
+
+func Test_Reassembler_LowerFragRestarts(t *testing.T) {
+	first := fragment(t, genRandom(1000), 310)
+	data := genRandom(1000)
+	second := fragment(t, data, 310)
+
+	// FRAG 1, 2, then 1 again: the lower position discards the first queue
+	var r socks5.Reassembler
+	for _, frag := range []*socks5.UDPPacket{first[0], first[1], second[0], second[1], second[2]} {
+		if pkt, err := r.Add(frag); err != nil || pkt != nil {
+			t.Fatalf("FRAG %#x: got (%v, %v), want incomplete", frag.Frag, pkt, err)
+		}
+	}
+
+	pkt, err := r.Add(second[3])
+	if err != nil || pkt == nil {
+		t.Fatalf("expected reassembled datagram, got (%v, %v)", pkt, err)
+	}
+	if !bytes.Equal(pkt.Data, data) {
+		t.Fatal("restarted sequence mixed in data from the discarded one")
+	}
+
+	// out of order fragments never complete a sequence
+	for _, frag := range []*socks5.UDPPacket{second[1], second[0], second[3], second[2]} {
+		if pkt, err := r.Add(frag); err != nil || pkt != nil {
+			t.Fatalf("FRAG %#x: got (%v, %v), want incomplete", frag.Frag, pkt, err)
+		}
+	}
+}
+
+func Test_Reassembler_MaxSize(t *testing.T) {
+	frags := fragment(t, genRandom(1000), 310)
+
+	r := socks5.Reassembler{MaxSize: 500}
+	r.Add(frags[0])
+	if _, err := r.Add(frags[1]); !errors.Is(err, socks5.ErrFragmentTooLarge) {
+		t.Fatalf("expected ErrFragmentTooLarge, got %v", err)
+	}
+}
+
+func TestBaseServerHandler_UDPAssociate_Fragmented(t *testing.T) {
+	udpEcho, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to start UDP echo server: %v", err)
+	}
+	defer udpEcho.Close()
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, clientAddr, err := udpEcho.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udpEcho.WriteToUDP(buf[:n], clientAddr)
+		}
+	}()
+
+	for _, allow := range []bool{false, true} {
+		handler := &socks5.BaseServerHandler{
+			AllowUDPAssociate:   true,
+			UDPAssociateTimeout: 10 * time.Second,
+			RequestTimeout:      5 * time.Second,
+			SupportedMethods:    []byte{socks5.MethodNoAuth},
+			UDPAllowFragments:   allow,
+		}
+
+		socksLn := startSOCKS5Server(t, handler)
+		defer socksLn.Close()
+
+		dialer := socks5.NewDialer(socksLn.Addr().String(), nil, nil)
+		dialer.UDPFragmentation = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		pc, err := dialer.ListenPacket(ctx, "tcp", nil)
+		if err != nil {
+			t.Fatalf("ListenPacket failed: %v", err)
+		}
+		defer pc.Close()
+
+		// larger than DefaultUDPFragmentSize, so it is sent as fragments
+		payload := genRandom(4000)
+		if _, err := pc.WriteTo(payload, udpEcho.LocalAddr()); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+
+		pc.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		buf := make([]byte, 8192)
+		n, _, err := pc.ReadFrom(buf)
+
+		if !allow {
+			// fragments are dropped by default
+			if err == nil {
+				t.Fatal("Expected fragmented datagram to be dropped")
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		if !bytes.Equal(buf[:n], payload) {
+			t.Fatalf("UDP echo mismatch: got %d bytes, want %d", n, len(payload))
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package socks5
 
 import (
-	"encoding/binary"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/netip"
+	"slices"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Common validation errors for UDP packets.
@@ -13,14 +18,27 @@ var (
 	ErrInvalidUDPReserved = errors.New("invalid UDP reserved bytes (must be 0x0000)")
 	ErrUnsupportedFrag    = errors.New("unsupported UDP fragmentation (FRAG must be 0x00)")
 	ErrInvalidUDPAddrType = errors.New("invalid UDP address type")
-	ErrInvalidUDPDomain   = errors.New("invalid UDP domain (empty or too long)")
-	ErrMissingUDPData     = errors.New("missing UDP payload data")
+	ErrInvalidUDPDomain   = fmt.Errorf("invalid UDP domain name: %w", ErrInvalidDomain) // also matches ErrInvalidDomain
+	ErrDatagramTooLarge   = errors.New("UDP datagram too large")
+	ErrNilUDPAddr         = errors.New("nil UDP address")
+
+	// Deprecated: empty payloads are valid; ErrMissingUDPData is no longer returned.
+	ErrMissingUDPData = errors.New("missing UDP payload data")
 )
 
+// MaxDatagramSize is the largest encoded SOCKS5 UDP datagram accepted by
+// Unmarshal and ReadFrom and produced by AppendTo: the largest UDP payload
+// over IPv4. UDPSession.MaxDatagramSize lowers the limit for one relay.
+const MaxDatagramSize = 65507
+
+// maxUDPHeaderLen is the longest UDP request header: RSV, FRAG, ATYP, a
+// 255-byte domain with its length byte, and PORT.
+const maxUDPHeaderLen = 2 + 1 + 1 + 1 + 255 + 2
+
 // UDPPacket represents a SOCKS5 UDP ASSOCIATE packet.
 type UDPPacket struct {
 	Reserved [2]byte // RSV; must be 0x0000
-	Frag     byte    // FRAG; must be 0x00 (no fragmentation)
+	Frag     byte    // FRAG; 0x00 for standalone datagrams (see Reassembler for fragments)
 	AddrType byte    // ATYP; IPv4, DOMAIN, or IPv6
 	IP       net.IP  // Destination IP (if ATYP=IPv4 or IPv6)
 	Domain   string  // Destination domain (if ATYP=DOMAIN)
@@ -49,35 +67,46 @@ func (p *UDPPacket) Init(
 
 // Validate checks for protocol correctness.
 func (p *UDPPacket) Validate() error {
+	return p.validate(false)
+}
+
+// validate checks for protocol correctness, accepting non-zero FRAG values if allowFrag is set.
+func (p *UDPPacket) validate(allowFrag bool) error {
 	if p.Reserved != [2]byte{0x00, 0x00} {
 		return ErrInvalidUDPReserved
 	}
-	if p.Frag != 0x00 {
+	if p.Frag != 0x00 && !allowFrag {
 		return ErrUnsupportedFrag
 	}
 
-	switch p.AddrType {
-	case AddrTypeIPv4, AddrTypeIPv6:
-		if p.IP == nil {
-			return ErrInvalidUDPAddrType
-		}
-	case AddrTypeDomain:
-		if len(p.Domain) == 0 || len(p.Domain) > 255 {
-			return ErrInvalidUDPDomain
-		}
-	default:
-		return ErrInvalidUDPAddrType
+	if err := p.addr().Validate(); err != nil {
+		return udpAddrErr(err)
 	}
 
-	if len(p.Data) == 0 {
-		return ErrMissingUDPData
+	if p.Size() > MaxDatagramSize {
+		return ErrDatagramTooLarge
 	}
 
 	return nil
 }
 
-// UnmarshalFrom parses a SOCKS5 UDP packet from raw bytes.
-func (p *UDPPacket) UnmarshalFrom(b []byte) (int, error) {
+// Unmarshal parses a SOCKS5 UDP packet in place and returns the header length.
+// IP and Data are sub-slices of b; no allocation is made unless ATYP is DOMAIN.
+func (p *UDPPacket) Unmarshal(b []byte) (headerLen int, err error) {
+	return p.unmarshal(b, false, MaxDatagramSize)
+}
+
+// UnmarshalFragment is like Unmarshal but also accepts fragments (FRAG != 0x00).
+// Use a Reassembler to rebuild the original datagram.
+func (p *UDPPacket) UnmarshalFragment(b []byte) (headerLen int, err error) {
+	return p.unmarshal(b, true, MaxDatagramSize)
+}
+
+// unmarshal rejects datagrams longer than maxSize with ErrDatagramTooLarge.
+func (p *UDPPacket) unmarshal(b []byte, allowFrag bool, maxSize int) (headerLen int, err error) {
+	if len(b) > maxSize {
+		return 0, ErrDatagramTooLarge
+	}
 	if len(b) < 4 {
 		return 0, io.ErrUnexpectedEOF
 	}
@@ -87,57 +116,52 @@ func (p *UDPPacket) UnmarshalFrom(b []byte) (int, error) {
 	p.Frag = b[2]
 	p.AddrType = b[3]
 
-	if err := p.ValidateHeader(); err != nil {
+	if err := p.validateHeader(allowFrag); err != nil {
 		return 0, err
 	}
 
-	i := 4
-
-	// Address
-	switch p.AddrType {
-	case AddrTypeIPv4:
-		if len(b) < i+4 {
-			return 0, io.ErrUnexpectedEOF
-		}
-		p.IP = net.IP(b[i : i+4])
-		i += 4
-
-	case AddrTypeIPv6:
-		if len(b) < i+16 {
-			return 0, io.ErrUnexpectedEOF
-		}
-		p.IP = net.IP(b[i : i+16])
-		i += 16
-
-	case AddrTypeDomain:
-		if len(b) < i+1 {
-			return 0, io.ErrUnexpectedEOF
-		}
-		dlen := int(b[i])
-		i++
-
-		if dlen == 0 || len(b) < i+dlen {
-			return 0, ErrInvalidUDPDomain
-		}
-
-		p.Domain = string(b[i : i+dlen])
-		i += dlen
+	// Address (zero-copy IP); a domain shorter than its length prefix is
+	// io.ErrUnexpectedEOF and an empty or malformed one ErrInvalidUDPDomain.
+	a := Addr{AddrType: p.AddrType, Domain: p.Domain}
+	n, err := a.unmarshalBody(b[4:])
+	if err != nil {
+		return 0, udpAddrErr(err)
 	}
+	p.IP, p.Domain, p.Port = a.IP, a.Domain, a.Port
+	i := 4 + n
 
-	// Port
-	if len(b) < i+2 {
-		return 0, io.ErrUnexpectedEOF
+	// Data (zero-copy slice; may be empty)
+	p.Data = b[i:]
+
+	return i, p.validate(allowFrag)
+}
+
+// UnmarshalFrom parses a SOCKS5 UDP packet from raw bytes and returns the number of bytes consumed.
+func (p *UDPPacket) UnmarshalFrom(b []byte) (int, error) {
+	if _, err := p.Unmarshal(b); err != nil {
+		return 0, err
 	}
-	p.Port = binary.BigEndian.Uint16(b[i : i+2])
-	i += 2
+	return len(b), nil
+}
 
-	// Data (zero-copy slice)
-	if len(b) <= i {
-		return 0, ErrMissingUDPData
+// AppendTo appends the encoded packet to dst.
+func (p *UDPPacket) AppendTo(dst []byte) ([]byte, error) {
+	return p.appendTo(dst, false)
+}
+
+func (p *UDPPacket) appendTo(dst []byte, allowFrag bool) ([]byte, error) {
+	if err := p.validate(allowFrag); err != nil {
+		return dst, err
 	}
-	p.Data = b[i:]
 
-	return len(b), p.Validate()
+	// Header
+	buf := append(dst, p.Reserved[0], p.Reserved[1], p.Frag)
+
+	// Address
+	buf = p.addr().appendTo(buf)
+
+	// Data
+	return append(buf, p.Data...), nil
 }
 
 // MarshalTo writes the packet into b and returns bytes written.
@@ -146,70 +170,78 @@ func (p *UDPPacket) MarshalTo(b []byte) (int, error) {
 		return 0, err
 	}
 
-	i := 0
-
-	// Header
-	if len(b) < 4 {
+	if len(b) < p.Size() {
 		return 0, io.ErrShortBuffer
 	}
-	b[0] = p.Reserved[0]
-	b[1] = p.Reserved[1]
-	b[2] = p.Frag
-	b[3] = p.AddrType
-	i += 4
 
-	// Address
-	switch p.AddrType {
-	case AddrTypeIPv4:
-		ip := p.IP.To4()
-		if ip == nil || len(b) < i+4 {
-			return 0, io.ErrShortBuffer
-		}
-		copy(b[i:], ip)
-		i += 4
-
-	case AddrTypeIPv6:
-		ip := p.IP.To16()
-		if ip == nil || len(b) < i+16 {
-			return 0, io.ErrShortBuffer
-		}
-		copy(b[i:], ip)
-		i += 16
-
-	case AddrTypeDomain:
-		dlen := len(p.Domain)
-		if len(b) < i+1+dlen {
-			return 0, io.ErrShortBuffer
-		}
-		b[i] = byte(dlen)
-		i++
-		copy(b[i:], p.Domain)
-		i += dlen
+	// b has room for the whole packet, so AppendTo encodes in place
+	buf, err := p.AppendTo(b[:0])
+	if err != nil {
+		return 0, err
 	}
+	return len(buf), nil
+}
 
-	// Port
-	if len(b) < i+2 {
-		return 0, io.ErrShortBuffer
+// MarshalBinary implements encoding.BinaryMarshaler for UDPPacket.
+func (p *UDPPacket) MarshalBinary() ([]byte, error) {
+	return p.AppendTo(make([]byte, 0, p.Size()))
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for UDPPacket. Unlike
+// Unmarshal it copies the payload, so data may be reused afterwards.
+func (p *UDPPacket) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return io.ErrUnexpectedEOF
 	}
-	binary.BigEndian.PutUint16(b[i:], p.Port)
-	i += 2
+	_, err := p.Unmarshal(bytes.Clone(data))
+	return err
+}
 
-	// Data
-	if len(b) < i+len(p.Data) {
-		return 0, io.ErrShortBuffer
+// ReadFrom reads a whole SOCKS5 UDP packet from a Reader until EOF.
+// Data refers to a newly allocated buffer. Implements io.ReaderFrom.
+// At most MaxDatagramSize+1 bytes are read; longer input fails with ErrDatagramTooLarge.
+func (p *UDPPacket) ReadFrom(src io.Reader) (int64, error) {
+	b, err := io.ReadAll(io.LimitReader(src, int64(MaxDatagramSize)+1))
+	if err != nil {
+		return int64(len(b)), err
+	}
+	if len(b) == 0 {
+		return 0, io.EOF
+	}
+
+	// Past the header only the address can be cut short; the rest is payload.
+	field := ""
+	if _, err = p.Unmarshal(b); err == io.ErrUnexpectedEOF && len(b) >= 4 {
+		field = "ADDR"
+	}
+	return int64(len(b)), parseError(msgUDPPacket, field, int64(len(b)), err)
+}
+
+// WriteTo writes the packet to a Writer in a single Write call.
+// Implements io.WriterTo.
+func (p *UDPPacket) WriteTo(dst io.Writer) (int64, error) {
+	buf := internal.GetBytes(p.Size())
+	defer internal.PutBytes(buf)
+
+	b, err := p.AppendTo(buf[:0])
+	if err != nil {
+		return 0, err
 	}
-	copy(b[i:], p.Data)
-	i += len(p.Data)
 
-	return i, nil
+	n, err := dst.Write(b)
+	return int64(n), err
 }
 
 // ValidateHeader checks RSV/FRAG/ATYP fields before full read.
 func (p *UDPPacket) ValidateHeader() error {
+	return p.validateHeader(false)
+}
+
+func (p *UDPPacket) validateHeader(allowFrag bool) error {
 	if p.Reserved != [2]byte{0x00, 0x00} {
 		return ErrInvalidUDPReserved
 	}
-	if p.Frag != 0x00 {
+	if p.Frag != 0x00 && !allowFrag {
 		return ErrUnsupportedFrag
 	}
 	switch p.AddrType {
@@ -220,23 +252,48 @@ func (p *UDPPacket) ValidateHeader() error {
 	return nil
 }
 
-// String returns a human-readable representation.
-func (p *UDPPacket) String() string {
-	var atype string
-	switch p.AddrType {
-	case AddrTypeIPv4:
-		atype = "IPv4"
-	case AddrTypeDomain:
-		atype = "DOMAIN"
-	case AddrTypeIPv6:
-		atype = "IPv6"
-	default:
-		atype = fmt.Sprintf("0x%02X", p.AddrType)
+// Equal reports whether UDPPacket p and other have the same header and payload.
+// IPs are compared as in Addr.Equal, and a nil and an empty Data are equal.
+func (p *UDPPacket) Equal(other *UDPPacket) bool {
+	if p == nil || other == nil {
+		return p == other
+	}
+	return p.Reserved == other.Reserved &&
+		p.Frag == other.Frag &&
+		p.AddrType == other.AddrType &&
+		p.IP.Equal(other.IP) &&
+		p.Domain == other.Domain &&
+		p.Port == other.Port &&
+		bytes.Equal(p.Data, other.Data)
+}
+
+// Clone returns a deep copy of p.
+func (p *UDPPacket) Clone() *UDPPacket {
+	if p == nil {
+		return nil
 	}
+	c := *p
+	c.IP = slices.Clone(p.IP)
+	c.Data = bytes.Clone(p.Data)
+	return &c
+}
 
+// String returns a human-readable representation.
+func (p *UDPPacket) String() string {
 	return fmt.Sprintf(
 		"UDPPacket{AddrType=%s, Host=%s, Port=%d, DataLen=%d, Frag=%d, RSV=%#02x%#02x}",
-		atype, p.hostString(), p.Port, len(p.Data), p.Frag, p.Reserved[0], p.Reserved[1],
+		AddrType(p.AddrType), p.hostString(), p.Port, len(p.Data), p.Frag, p.Reserved[0], p.Reserved[1],
+	)
+}
+
+// LogValue implements slog.LogValuer. The payload is not included.
+func (p *UDPPacket) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("atyp", AddrType(p.AddrType).String()),
+		slog.String("host", p.hostString()),
+		slog.Int("port", int(p.Port)),
+		slog.Int("frag", int(p.Frag)),
+		slog.Int("data_len", len(p.Data)),
 	)
 }
 
@@ -248,20 +305,78 @@ func (p *UDPPacket) hostString() string {
 	return p.IP.String()
 }
 
+// HeaderLen returns the encoded length of the packet excluding Data.
+func (p *UDPPacket) HeaderLen() int {
+	return 3 + p.addr().Size() // RSV + FRAG + address
+}
+
+// Size returns the encoded length of the packet.
 func (p *UDPPacket) Size() int {
-	size := 4 // RSV + FRAG + ATYP
+	return p.HeaderLen() + len(p.Data)
+}
 
+// GetAddr returns the destination as a *net.UDPAddr, or nil if ATYP is DOMAIN;
+// resolving a domain destination is left to the caller. The IP is not copied.
+func (p *UDPPacket) GetAddr() *net.UDPAddr {
 	switch p.AddrType {
-	case AddrTypeIPv4:
-		size += 4
-	case AddrTypeIPv6:
-		size += 16
-	case AddrTypeDomain:
-		size += 1 + len(p.Domain)
+	case AddrTypeIPv4, AddrTypeIPv6:
+		return &net.UDPAddr{IP: p.IP, Port: int(p.Port)}
+	default:
+		return nil
+	}
+}
+
+// SetAddr sets the destination from addr, choosing ATYP automatically.
+func (p *UDPPacket) SetAddr(addr *net.UDPAddr) error {
+	if addr == nil {
+		return ErrNilUDPAddr
+	}
+
+	ip := addr.IP
+	addrType := AddrTypeIPv6
+	if ip4 := ip.To4(); ip4 != nil {
+		addrType = AddrTypeIPv4
+		ip = ip4
 	}
 
-	size += 2 // PORT
-	size += len(p.Data)
+	p.AddrType = byte(addrType)
+	p.IP = ip
+	p.Domain = ""
+	p.Port = uint16(addr.Port)
+	return nil
+}
 
-	return size
+// AddrPort returns the destination address as a netip.AddrPort, or the zero AddrPort if ATYP is DOMAIN.
+func (p *UDPPacket) AddrPort() netip.AddrPort {
+	return p.addr().AddrPort()
+}
+
+// SetAddrPort sets the destination address from ap, choosing ATYP automatically.
+// IPv4-mapped IPv6 addresses are stored as AddrTypeIPv4.
+func (p *UDPPacket) SetAddrPort(ap netip.AddrPort) {
+	p.AddrType, p.IP = addrFromNetip(ap.Addr())
+	p.Domain = ""
+	p.Port = ap.Port()
+}
+
+// DomainPort returns the destination address domain and port if ATYP is DOMAIN.
+func (p *UDPPacket) DomainPort() (domain string, port uint16, ok bool) {
+	return p.addr().DomainPort()
+}
+
+// addr returns the destination address of the packet.
+func (p *UDPPacket) addr() *Addr {
+	return &Addr{AddrType: p.AddrType, IP: p.IP, Domain: p.Domain, Port: p.Port}
+}
+
+// udpAddrErr maps address codec errors to their UDP packet counterparts.
+func udpAddrErr(err error) error {
+	switch err {
+	case ErrInvalidAddr:
+		return ErrInvalidUDPAddrType
+	case ErrInvalidDomain:
+		return ErrInvalidUDPDomain
+	default:
+		return err
+	}
 }
@@ -3,6 +3,7 @@ package socks5_test
 import (
 	"bytes"
 	"errors"
+	"io"
 	"net"
 	"testing"
 
@@ -52,13 +53,13 @@ func Test_UDPPacket_Init_Validate(t *testing.T) {
 			wantErr: true,
 		},
 		{
-			name: "missing data",
+			name: "empty data",
 			packet: func() socks5.UDPPacket {
 				var p socks5.UDPPacket
 				p.Init([2]byte{0, 0}, 0x00, socks5.AddrTypeIPv4, net.IPv4(127, 0, 0, 1), "", 9000, nil)
 				return p
 			}(),
-			wantErr: true,
+			wantErr: false,
 		},
 	}
 
@@ -124,18 +125,8 @@ func Test_UDPPacket_Marshal_Unmarshal_RoundTrip(t *testing.T) {
 			if nw != nr {
 				t.Errorf("expected %d bytes written == %d bytes read", nw, nr)
 			}
-			if got.Port != orig.Port {
-				t.Errorf("port mismatch: got %d, want %d", got.Port, orig.Port)
-			}
-			if got.AddrType == socks5.AddrTypeDomain {
-				if got.Domain != orig.Domain {
-					t.Errorf("domain mismatch: got %q, want %q", got.Domain, orig.Domain)
-				}
-			} else if !got.IP.Equal(orig.IP) {
-				t.Errorf("IP mismatch: got %v, want %v", got.IP, orig.IP)
-			}
-			if !bytes.Equal(got.Data, orig.Data) {
-				t.Errorf("data mismatch: got %x, want %x", got.Data, orig.Data)
+			if !got.Equal(&orig) {
+				t.Errorf("packet mismatch: got %v, want %v", &got, &orig)
 			}
 		})
 	}
@@ -186,6 +177,57 @@ func Test_UDPPacket_Unmarshal_InvalidAddrType(t *testing.T) {
 	}
 }
 
+func Test_UDPPacket_TruncatedDomain(t *testing.T) {
+	// the length prefix says 10 bytes, only 3 follow
+	b := []byte{0x00, 0x00, 0x00, socks5.AddrTypeDomain, 10, 'a', 'b', 'c'}
+
+	var p socks5.UDPPacket
+	if _, err := p.Unmarshal(b); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Unmarshal: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if p.Domain != "" {
+		t.Fatalf("Unmarshal: Domain = %q, want none from a truncated packet", p.Domain)
+	}
+
+	_, err := p.ReadFrom(bytes.NewReader(b))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("ReadFrom: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	var pe *socks5.ParseError
+	if !errors.As(err, &pe) || pe.Field != "ADDR" {
+		t.Fatalf("ReadFrom: expected ParseError at ADDR, got %v", err)
+	}
+
+	// an invalid name of the announced length is still rejected
+	b = []byte{0x00, 0x00, 0x00, socks5.AddrTypeDomain, 3, 'a', ' ', 'c', 0x00, 0x50}
+	if _, err := p.Unmarshal(b); !errors.Is(err, socks5.ErrInvalidUDPDomain) {
+		t.Fatalf("Unmarshal: expected ErrInvalidUDPDomain, got %v", err)
+	}
+}
+
+func Test_UDPPacket_ZeroLengthDomain(t *testing.T) {
+	b := []byte{0x00, 0x00, 0x00, socks5.AddrTypeDomain, 0x00, 0x00, 0x50, 'h', 'i'}
+
+	var p socks5.UDPPacket
+	if _, err := p.Unmarshal(b); !errors.Is(err, socks5.ErrInvalidUDPDomain) || !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Fatalf("Unmarshal: expected ErrInvalidUDPDomain matching ErrInvalidDomain, got %v", err)
+	}
+
+	_, err := p.ReadFrom(bytes.NewReader(b))
+	if !errors.Is(err, socks5.ErrInvalidUDPDomain) || !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Fatalf("ReadFrom: expected ErrInvalidUDPDomain matching ErrInvalidDomain, got %v", err)
+	}
+	var pe *socks5.ParseError
+	if !errors.As(err, &pe) || pe.Field != "ADDR" {
+		t.Fatalf("ReadFrom: expected ParseError at ADDR, got %v", err)
+	}
+
+	p.Init([2]byte{}, 0, socks5.AddrTypeDomain, nil, "", 80, []byte("hi"))
+	if _, err := p.AppendTo(nil); !errors.Is(err, socks5.ErrInvalidDomain) {
+		t.Fatalf("AppendTo: expected ErrInvalidDomain, got %v", err)
+	}
+}
+
 func Test_UDPPacket_String(t *testing.T) {
 	var p socks5.UDPPacket
 	p.Init([2]byte{0, 0}, 0, socks5.AddrTypeIPv4, net.IPv4(8, 8, 8, 8), "", 53, []byte{0xaa, 0xbb})
@@ -210,3 +252,218 @@ func Test_UDPPacket_Size_MatchesMarshal(t *testing.T) {
 		t.Errorf("Size() mismatch: got %d, want %d", n, p.Size())
 	}
 }
+
+func Test_UDPPacket_Unmarshal_InPlace(t *testing.T) {
+	raw := []byte{0, 0, 0, socks5.AddrTypeIPv4, 10, 0, 0, 1, 0x00, 0x35, 'q', 'u', 'e', 'r', 'y'}
+
+	var p socks5.UDPPacket
+	hdrLen, err := p.Unmarshal(raw)
+	if err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	if hdrLen != 10 || p.HeaderLen() != 10 {
+		t.Fatalf("header length = %d (HeaderLen %d), want 10", hdrLen, p.HeaderLen())
+	}
+	if string(p.Data) != "query" || &p.Data[0] != &raw[hdrLen] {
+		t.Fatalf("Data is not a sub-slice of the input: %q", p.Data)
+	}
+
+	// re-encode into a reused buffer
+	buf := make([]byte, 0, 64)
+	out, err := p.AppendTo(buf)
+	if err != nil {
+		t.Fatalf("AppendTo() error: %v", err)
+	}
+	if !bytes.Equal(out, raw) || &out[0] != &buf[:1][0] {
+		t.Fatalf("AppendTo() = %x, want %x in the provided buffer", out, raw)
+	}
+}
+
+func Test_UDPPacket_ReadFrom_WriteTo(t *testing.T) {
+	var p socks5.UDPPacket
+	p.Init([2]byte{0, 0}, 0, socks5.AddrTypeDomain, nil, "example.com", 53, []byte("payload"))
+
+	var buf bytes.Buffer
+	n, err := p.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo() error: %v", err)
+	}
+	if int(n) != p.Size() {
+		t.Fatalf("WriteTo() wrote %d bytes, want %d", n, p.Size())
+	}
+
+	var got socks5.UDPPacket
+	if _, err := got.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom() error: %v", err)
+	}
+	if got.Domain != "example.com" || got.Port != 53 || string(got.Data) != "payload" {
+		t.Fatalf("ReadFrom() = %s", got.String())
+	}
+
+	// invalid packets are not encoded
+	p.Reserved = [2]byte{0x00, 0x01}
+	if _, err := p.AppendTo(nil); !errors.Is(err, socks5.ErrInvalidUDPReserved) {
+		t.Fatalf("AppendTo() error = %v, want ErrInvalidUDPReserved", err)
+	}
+}
+
+func Test_UDPPacket_Codec_NoAlloc(t *testing.T) {
+	raw := []byte{0, 0, 0, socks5.AddrTypeIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x01, 0xBB, 'x'}
+	buf := make([]byte, 0, 64)
+
+	var p socks5.UDPPacket
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := p.Unmarshal(raw); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.AppendTo(buf); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("Unmarshal+AppendTo allocated %v times per packet, want 0", allocs)
+	}
+}
+
+func BenchmarkUDPPacket_Unmarshal(b *testing.B) {
+	raw := append([]byte{0, 0, 0, socks5.AddrTypeIPv4, 127, 0, 0, 1, 0x1F, 0x90}, genRandom(1200)...)
+
+	var p socks5.UDPPacket
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for b.Loop() {
+		if _, err := p.Unmarshal(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUDPPacket_AppendTo(b *testing.B) {
+	var p socks5.UDPPacket
+	p.Init([2]byte{0, 0}, 0, socks5.AddrTypeIPv4, net.IPv4(127, 0, 0, 1), "", 8080, genRandom(1200))
+	buf := make([]byte, 0, p.Size())
+
+	b.ReportAllocs()
+	b.SetBytes(int64(p.Size()))
+	for b.Loop() {
+		if _, err := p.AppendTo(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUDPPacketRoundTrip parses and re-encodes a datagram to a domain, as
+// the UDP relay does for each packet.
+func BenchmarkUDPPacketRoundTrip(b *testing.B) {
+	var orig socks5.UDPPacket
+	orig.Init([2]byte{0, 0}, 0, socks5.AddrTypeDomain, nil, "dns.example.com", 53, genRandom(512))
+	raw, err := orig.AppendTo(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, 0, len(raw))
+
+	var p socks5.UDPPacket
+	b.ReportAllocs()
+	b.SetBytes(int64(len(raw)))
+	for b.Loop() {
+		if _, err := p.Unmarshal(raw); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := p.AppendTo(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Test_UDPPacket_EmptyPayload(t *testing.T) {
+	p := socks5.UDPPacket{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(127, 0, 0, 1).To4(), Port: 9}
+
+	b, err := p.AppendTo(nil)
+	if err != nil {
+		t.Fatalf("AppendTo() error: %v", err)
+	}
+
+	var got socks5.UDPPacket
+	n, err := got.Unmarshal(b)
+	if err != nil {
+		t.Fatalf("Unmarshal() error: %v", err)
+	}
+	if n != len(b) || len(got.Data) != 0 {
+		t.Fatalf("Unmarshal() = (%d, %d bytes of data), want (%d, 0)", n, len(got.Data), len(b))
+	}
+}
+
+func Test_UDPPacket_MaxDatagramSize(t *testing.T) {
+	size := socks5.MaxDatagramSize - 10 + 1 // one byte over with a 10-byte IPv4 header
+
+	p := socks5.UDPPacket{AddrType: socks5.AddrTypeIPv4, IP: net.IPv4(127, 0, 0, 1).To4(), Port: 9, Data: make([]byte, size)}
+	if _, err := p.AppendTo(nil); !errors.Is(err, socks5.ErrDatagramTooLarge) {
+		t.Fatalf("AppendTo() error = %v, want ErrDatagramTooLarge", err)
+	}
+
+	raw := append([]byte{0, 0, 0, socks5.AddrTypeIPv4, 127, 0, 0, 1, 0, 9}, make([]byte, size)...)
+	if _, err := p.Unmarshal(raw); !errors.Is(err, socks5.ErrDatagramTooLarge) {
+		t.Fatalf("Unmarshal() error = %v, want ErrDatagramTooLarge", err)
+	}
+
+	// ReadFrom stops reading once the limit is exceeded
+	src := io.MultiReader(bytes.NewReader(raw), bytes.NewReader(make([]byte, 1<<20)))
+	n, err := p.ReadFrom(src)
+	if !errors.Is(err, socks5.ErrDatagramTooLarge) {
+		t.Fatalf("ReadFrom() error = %v, want ErrDatagramTooLarge", err)
+	}
+	if n > socks5.MaxDatagramSize+1 {
+		t.Fatalf("ReadFrom() read %d bytes, want at most %d", n, socks5.MaxDatagramSize+1)
+	}
+}
+
+func Test_UDPPacket_GetAddr_SetAddr(t *testing.T) {
+	tests := []struct {
+		addr     *net.UDPAddr
+		addrType byte
+		want     string
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}, socks5.AddrTypeIPv4, "192.0.2.1:53"},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, socks5.AddrTypeIPv6, "[2001:db8::1]:443"},
+	}
+
+	for _, tt := range tests {
+		var p socks5.UDPPacket
+		if err := p.SetAddr(tt.addr); err != nil {
+			t.Fatalf("SetAddr(%v) error: %v", tt.addr, err)
+		}
+		if p.AddrType != tt.addrType {
+			t.Errorf("SetAddr(%v) AddrType = %d, want %d", tt.addr, p.AddrType, tt.addrType)
+		}
+		if got := p.GetAddr().String(); got != tt.want {
+			t.Errorf("GetAddr() = %q, want %q", got, tt.want)
+		}
+	}
+
+	var p socks5.UDPPacket
+	if err := p.SetAddr(nil); !errors.Is(err, socks5.ErrNilUDPAddr) {
+		t.Errorf("SetAddr(nil) error = %v, want ErrNilUDPAddr", err)
+	}
+
+	p = socks5.UDPPacket{AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 53}
+	if addr := p.GetAddr(); addr != nil {
+		t.Errorf("GetAddr() for domain = %v, want nil", addr)
+	}
+}
+
+func Test_UDPPacket_WriteTo_MislabeledAddrType(t *testing.T) {
+	p := socks5.UDPPacket{AddrType: socks5.AddrTypeIPv4, IP: net.ParseIP("2001:db8::1"), Port: 53, Data: []byte("x")}
+
+	if err := p.Validate(); !errors.Is(err, socks5.ErrInvalidUDPAddrType) {
+		t.Fatalf("Validate() error = %v, want ErrInvalidUDPAddrType", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := p.WriteTo(&buf); !errors.Is(err, socks5.ErrInvalidUDPAddrType) {
+		t.Fatalf("WriteTo() error = %v, want ErrInvalidUDPAddrType", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("WriteTo() wrote %d bytes for a malformed packet", buf.Len())
+	}
+}
@@ -0,0 +1,149 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/33TU/socks/internal"
+)
+
+// ErrUDPClientUnknown is returned by UDPRelayConn.WriteTo before the client address is known.
+var ErrUDPClientUnknown = errors.New("UDP client address not known yet")
+
+// UDPRelayConn is a net.PacketConn for the server side of a UDP association.
+// ReadFrom returns the payloads sent by the client together with their destination,
+// and WriteTo sends payloads to the client framed as SOCKS5 UDP packets from addr.
+//
+// Datagrams from sources other than the client are ignored.
+type UDPRelayConn struct {
+	conn     net.PacketConn              // relay socket
+	clientIP net.IP                      // expected client IP until the client is known (nil=any)
+	client   atomic.Pointer[net.UDPAddr] // client UDP address (nil=not known yet)
+}
+
+// NewUDPRelayConn wraps the relay socket conn. If client is nil or has port 0, the
+// sender of the first valid datagram becomes the client; a non-nil client IP must
+// still match.
+func NewUDPRelayConn(conn net.PacketConn, client *net.UDPAddr) *UDPRelayConn {
+	c := &UDPRelayConn{conn: conn}
+	switch {
+	case client == nil:
+	case client.Port != 0:
+		c.client.Store(cloneUDPAddr(client))
+	case !client.IP.IsUnspecified():
+		c.clientIP = slices.Clone(client.IP)
+	}
+	return c
+}
+
+// ClientAddr returns the client address, or nil if it is not known yet.
+func (c *UDPRelayConn) ClientAddr() *net.UDPAddr {
+	return c.client.Load()
+}
+
+// ReadFrom implements [net.PacketConn]. The returned address is the destination
+// requested by the client: a *net.UDPAddr, or an *Addr if ATYP is DOMAIN.
+// Payloads larger than p are truncated.
+func (c *UDPRelayConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := internal.GetBytes(64 * 1024)
+	defer internal.PutBytes(buf)
+
+	for {
+		n, src, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		srcAddr, ok := src.(*net.UDPAddr)
+		if !ok || !c.fromClient(srcAddr) {
+			continue
+		}
+
+		var pkt UDPPacket
+		if _, err := pkt.Unmarshal(buf[:n]); err != nil {
+			continue
+		}
+
+		if c.client.Load() == nil {
+			c.client.CompareAndSwap(nil, cloneUDPAddr(srcAddr))
+		}
+
+		var addr net.Addr
+		if pkt.AddrType == AddrTypeDomain {
+			addr = &Addr{AddrType: AddrTypeDomain, Domain: pkt.Domain, Port: pkt.Port}
+		} else {
+			addr = &net.UDPAddr{IP: slices.Clone(pkt.IP), Port: int(pkt.Port)}
+		}
+
+		return copy(p, pkt.Data), addr, nil
+	}
+}
+
+// fromClient reports whether src may be the client.
+func (c *UDPRelayConn) fromClient(src *net.UDPAddr) bool {
+	if client := c.client.Load(); client != nil {
+		return client.IP.Equal(src.IP) && client.Port == src.Port
+	}
+	return c.clientIP == nil || c.clientIP.Equal(src.IP)
+}
+
+// WriteTo implements [net.PacketConn]. It sends p to the client as a datagram from
+// addr, which must be a *net.UDPAddr or an *Addr.
+func (c *UDPRelayConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	client := c.ClientAddr()
+	if client == nil {
+		return 0, ErrUDPClientUnknown
+	}
+
+	var pkt UDPPacket
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		pkt = udpPacketTo(a, p)
+	case *Addr:
+		pkt = UDPPacket{AddrType: a.AddrType, IP: a.IP, Domain: a.Domain, Port: a.Port, Data: p}
+	default:
+		return 0, fmt.Errorf("unsupported address type %T", addr)
+	}
+
+	buf := internal.GetBytes(pkt.Size())
+	defer internal.PutBytes(buf)
+
+	n, err := pkt.MarshalTo(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := c.conn.WriteTo(buf[:n], client); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close implements [net.PacketConn].
+func (c *UDPRelayConn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr implements [net.PacketConn].
+func (c *UDPRelayConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// SetDeadline implements [net.PacketConn].
+func (c *UDPRelayConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements [net.PacketConn].
+func (c *UDPRelayConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements [net.PacketConn].
+func (c *UDPRelayConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,120 @@
+package socks5_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func TestUDPRelayConn_RoundTrip(t *testing.T) {
+	relaySock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	relay := socks5.NewUDPRelayConn(relaySock, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer relay.Close()
+	relay.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// no client yet
+	target := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 5353}
+	if _, err := relay.WriteTo([]byte("early"), target); !errors.Is(err, socks5.ErrUDPClientUnknown) {
+		t.Fatalf("WriteTo before client known: got %v, want ErrUDPClientUnknown", err)
+	}
+
+	// client side of the association
+	clientSock, err := net.DialUDP("udp", nil, relaySock.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Failed to dial relay: %v", err)
+	}
+	ctrl, peer := net.Pipe()
+	defer peer.Close()
+	client := socks5.NewUDPConn(ctrl, clientSock, relaySock.LocalAddr().(*net.UDPAddr))
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// client -> relay: payload with its destination
+	if _, err := client.WriteTo([]byte("query"), target); err != nil {
+		t.Fatalf("client WriteTo: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	n, dst, err := relay.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("relay ReadFrom: %v", err)
+	}
+	if string(buf[:n]) != "query" || dst.String() != target.String() {
+		t.Fatalf("relay ReadFrom = (%q, %v), want (%q, %v)", buf[:n], dst, "query", target)
+	}
+	if got := relay.ClientAddr(); got == nil || got.String() != clientSock.LocalAddr().String() {
+		t.Fatalf("ClientAddr() = %v, want %v", got, clientSock.LocalAddr())
+	}
+
+	// relay -> client: framed as coming from the target
+	if _, err := relay.WriteTo([]byte("answer"), dst); err != nil {
+		t.Fatalf("relay WriteTo: %v", err)
+	}
+
+	n, src, err := client.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("client ReadFrom: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("answer")) || src.String() != target.String() {
+		t.Fatalf("client ReadFrom = (%q, %v), want (%q, %v)", buf[:n], src, "answer", target)
+	}
+}
+
+func TestUDPRelayConn_DomainAddr(t *testing.T) {
+	relaySock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	relay := socks5.NewUDPRelayConn(relaySock, nil)
+	defer relay.Close()
+	relay.SetDeadline(time.Now().Add(2 * time.Second))
+
+	clientSock, err := net.DialUDP("udp", nil, relaySock.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("Failed to dial relay: %v", err)
+	}
+	defer clientSock.Close()
+	clientSock.SetDeadline(time.Now().Add(2 * time.Second))
+
+	pkt := socks5.UDPPacket{AddrType: socks5.AddrTypeDomain, Domain: "example.com", Port: 53, Data: []byte("q")}
+	raw, err := pkt.AppendTo(nil)
+	if err != nil {
+		t.Fatalf("AppendTo: %v", err)
+	}
+	if _, err := clientSock.Write(raw); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 1024)
+	_, dst, err := relay.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("relay ReadFrom: %v", err)
+	}
+	addr, ok := dst.(*socks5.Addr)
+	if !ok || addr.Domain != "example.com" || addr.Port != 53 {
+		t.Fatalf("relay ReadFrom addr = %#v, want example.com:53", dst)
+	}
+
+	// replies keep the domain form
+	if _, err := relay.WriteTo([]byte("a"), addr); err != nil {
+		t.Fatalf("relay WriteTo: %v", err)
+	}
+	n, err := clientSock.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	var resp socks5.UDPPacket
+	if _, err := resp.Unmarshal(buf[:n]); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if resp.Domain != "example.com" || string(resp.Data) != "a" {
+		t.Fatalf("reply = %s", resp.String())
+	}
+}
@@ -0,0 +1,251 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/33TU/socks/internal"
+	socksnet "github.com/33TU/socks/net"
+	"golang.org/x/sync/errgroup"
+)
+
+// UDPSession relays the datagrams of one UDP ASSOCIATE between the client and
+// the targets it addresses. It ends when the TCP control connection closes,
+// the context is cancelled or no datagram arrives within IdleTimeout.
+type UDPSession struct {
+	LocalAddr  *net.UDPAddr // Address of the relay socket, sent to the client as BND.ADDR
+	ClientAddr *net.TCPAddr // Remote address of the TCP control connection
+	StartTime  time.Time
+
+	IdleTimeout     time.Duration                     // Ends the session after this long without datagrams (0=none)
+	BufferSize      int                               // Largest datagram relayed (0=64KB)
+	MaxDatagramSize int                               // Largest encoded SOCKS5 datagram relayed either way (0=MaxDatagramSize)
+	Reassembler     *Reassembler                      // Reassembles fragmented client datagrams (nil=drop them)
+	OnDrop          func(src *net.UDPAddr, err error) // Called for each datagram the relay rejects (nil=none)
+	OnError         func(err error)                   // Called with a *PhaseError for each datagram longer than BufferSize (nil=none)
+
+	// LimitUp and LimitDown limit the payload rates relayed to targets and to
+	// the client (nil=unlimited). Each datagram is waited for before it is
+	// sent. Set them before Run, e.g. in BaseServerHandler.OnUDPSessionCreated.
+	LimitUp   socksnet.RateLimiter
+	LimitDown socksnet.RateLimiter
+
+	conn    net.Conn
+	udpConn *net.UDPConn
+
+	bytesUp   atomic.Int64
+	bytesDown atomic.Int64
+}
+
+// NewUDPSession returns a session relaying over udpConn for the client of the
+// TCP control connection conn. The success reply must already have been sent.
+func NewUDPSession(conn net.Conn, udpConn *net.UDPConn) (*UDPSession, error) {
+	clientAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected TCP remote addr type %T", conn.RemoteAddr())
+	}
+
+	return &UDPSession{
+		LocalAddr:  udpConn.LocalAddr().(*net.UDPAddr),
+		ClientAddr: clientAddr,
+		StartTime:  time.Now(),
+		conn:       conn,
+		udpConn:    udpConn,
+	}, nil
+}
+
+// BytesUpstream returns the payload bytes relayed from the client to targets.
+func (s *UDPSession) BytesUpstream() int64 {
+	return s.bytesUp.Load()
+}
+
+// BytesDownstream returns the payload bytes relayed from targets to the client.
+func (s *UDPSession) BytesDownstream() int64 {
+	return s.bytesDown.Load()
+}
+
+// Run relays datagrams until the session ends and closes both connections.
+// It returns nil when the control connection is closed.
+func (s *UDPSession) Run(ctx context.Context) error {
+	// Unblock the relay loop when ctx is cancelled
+	stop := context.AfterFunc(ctx, func() { s.udpConn.Close() })
+	defer stop()
+
+	g, gctx := errgroup.WithContext(ctx)
+
+	// Close UDP relay when TCP association ends
+	g.Go(func() error {
+		defer s.udpConn.Close()
+
+		if _, err := io.Copy(io.Discard, s.conn); isUnexpectedNetErr(err) {
+			return err
+		}
+		return nil
+	})
+
+	// UDP relay loop
+	g.Go(func() error {
+		defer s.conn.Close()
+
+		err := s.relay(gctx)
+		if errors.Is(err, net.ErrClosed) {
+			return ctx.Err() // nil unless closed by cancellation
+		}
+		return err
+	})
+
+	return g.Wait()
+}
+
+func (s *UDPSession) drop(src *net.UDPAddr, err error) {
+	if s.OnDrop != nil {
+		s.OnDrop(src, err)
+	}
+}
+
+// relay forwards datagrams between the client and targets.
+func (s *UDPSession) relay(ctx context.Context) error {
+	bufferSize := s.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 64 * 1024
+	}
+	maxSize := s.MaxDatagramSize
+	if maxSize <= 0 || maxSize > MaxDatagramSize {
+		maxSize = MaxDatagramSize
+	}
+
+	// One spare byte tells datagrams that fill the buffer from truncated ones.
+	inBuf := internal.GetBytes(bufferSize + 1)
+	defer internal.PutBytes(inBuf)
+
+	// Replies to the client carry a SOCKS header on top of the target's datagram.
+	outBuf := internal.GetBytes(bufferSize + maxUDPHeaderLen)
+	defer internal.PutBytes(outBuf)
+
+	// Lock onto the actual UDP client after first valid packet.
+	var clientUDPAddr *net.UDPAddr
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if s.IdleTimeout > 0 {
+			if err := s.udpConn.SetReadDeadline(time.Now().Add(s.IdleTimeout)); err != nil {
+				return err
+			}
+		}
+
+		n, srcAddr, err := s.udpConn.ReadFromUDP(inBuf)
+		if err != nil {
+			return err
+		}
+		if n > bufferSize {
+			s.drop(srcAddr, ErrDatagramTooLarge)
+			if s.OnError != nil {
+				err := fmt.Errorf("UDP datagram from %s truncated by the %d-byte read buffer: %w", srcAddr, bufferSize, ErrDatagramTooLarge)
+				s.OnError(socksnet.WithPhase(socksnet.PhaseRelay, err))
+			}
+			continue
+		}
+
+		// First valid client packet must come from same IP as TCP peer.
+		if clientUDPAddr == nil {
+			var pkt UDPPacket
+			if _, err := pkt.unmarshal(inBuf[:n], true, maxSize); err == nil && srcAddr.IP.Equal(s.ClientAddr.IP) {
+				clientUDPAddr = cloneUDPAddr(srcAddr)
+			}
+		}
+
+		// Client -> target
+		if clientUDPAddr != nil &&
+			srcAddr.IP.Equal(clientUDPAddr.IP) &&
+			srcAddr.Port == clientUDPAddr.Port {
+
+			var frag UDPPacket
+			if _, err := frag.unmarshal(inBuf[:n], true, maxSize); err != nil {
+				s.drop(srcAddr, err)
+				continue
+			}
+
+			pkt := &frag
+			if frag.Frag != 0x00 {
+				// RFC says drop fragments if fragmentation is unsupported.
+				if s.Reassembler == nil {
+					s.drop(srcAddr, ErrUnsupportedFrag)
+					continue
+				}
+				if pkt, err = s.Reassembler.Add(&frag); err != nil {
+					s.drop(srcAddr, err)
+					continue
+				}
+				if pkt == nil {
+					continue
+				}
+			}
+
+			targetAddr, err := resolveUDPPacketTarget(pkt)
+			if err != nil {
+				continue
+			}
+
+			if err := socksnet.WaitN(ctx, s.LimitUp, len(pkt.Data)); err != nil {
+				return err
+			}
+			if _, err := s.udpConn.WriteToUDP(pkt.Data, targetAddr); err != nil {
+				continue
+			}
+			s.bytesUp.Add(int64(len(pkt.Data)))
+
+			continue
+		}
+
+		// Target -> client
+		if clientUDPAddr == nil {
+			continue
+		}
+
+		var resp UDPPacket
+
+		addrType := AddrTypeIPv6
+		ip := srcAddr.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			addrType = AddrTypeIPv4
+			ip = ip4
+		}
+
+		resp.Init(
+			[2]byte{0x00, 0x00},
+			0x00,
+			byte(addrType),
+			ip,
+			"",
+			uint16(srcAddr.Port),
+			inBuf[:n],
+		)
+
+		nOut, err := resp.MarshalTo(outBuf)
+		if err == nil && nOut > maxSize {
+			err = ErrDatagramTooLarge
+		}
+		if err != nil {
+			s.drop(srcAddr, err)
+			continue
+		}
+
+		if err := socksnet.WaitN(ctx, s.LimitDown, n); err != nil {
+			return err
+		}
+		if _, err := s.udpConn.WriteToUDP(outBuf[:nOut], clientUDPAddr); err != nil {
+			continue
+		}
+		s.bytesDown.Add(int64(n))
+	}
+}
@@ -0,0 +1,127 @@
+package socks5_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/33TU/socks/socks5"
+)
+
+func TestUDPSession_Lifecycle(t *testing.T) {
+	echo := udpEchoServer(t)
+	echoAddr := echo.LocalAddr().(*net.UDPAddr)
+
+	created := make(chan *socks5.UDPSession, 1)
+	closed := make(chan error, 1)
+	var limitUp, limitDown countingLimiter
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowUDPAssociate: true,
+		SupportedMethods:  []byte{socks5.MethodNoAuth},
+		OnUDPSessionCreated: func(ctx context.Context, s *socks5.UDPSession) {
+			s.LimitUp, s.LimitDown = &limitUp, &limitDown
+			created <- s
+		},
+		OnUDPSessionClosed: func(ctx context.Context, s *socks5.UDPSession, err error) { closed <- err },
+	})
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assoc, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).OpenUDPAssociation(ctx, "tcp", nil)
+	if err != nil {
+		t.Fatalf("OpenUDPAssociation failed: %v", err)
+	}
+	defer assoc.Close()
+
+	var s *socks5.UDPSession
+	select {
+	case s = <-created:
+	case <-time.After(time.Second):
+		t.Fatal("OnUDPSessionCreated not called")
+	}
+
+	if s.LocalAddr.Port != assoc.RelayAddr().Port {
+		t.Errorf("LocalAddr = %v, want port of relay %v", s.LocalAddr, assoc.RelayAddr())
+	}
+	if local := assoc.ControlConn().LocalAddr().(*net.TCPAddr); s.ClientAddr.Port != local.Port {
+		t.Errorf("ClientAddr = %v, want %v", s.ClientAddr, local)
+	}
+	if s.StartTime.IsZero() || time.Since(s.StartTime) > 5*time.Second {
+		t.Errorf("unexpected StartTime %v", s.StartTime)
+	}
+
+	// each echo is read back so the relay has counted the datagram
+	assoc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 2048)
+	var total int64
+	for _, msg := range []string{"a", "bb", "cccc"} {
+		if _, err := assoc.WriteTo([]byte(msg), echoAddr); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		if _, _, err := assoc.ReadFrom(buf); err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		total += int64(len(msg))
+	}
+
+	if got := s.BytesUpstream(); got != total {
+		t.Errorf("BytesUpstream = %d, want %d", got, total)
+	}
+	if got := s.BytesDownstream(); got != total {
+		t.Errorf("BytesDownstream = %d, want %d", got, total)
+	}
+	if limitUp.n.Load() != total || limitDown.n.Load() != total {
+		t.Errorf("limiters allowed up=%d down=%d, want %d each", limitUp.n.Load(), limitDown.n.Load(), total)
+	}
+
+	assoc.ControlConn().Close()
+
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("OnUDPSessionClosed error = %v, want nil", err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("OnUDPSessionClosed not called within 100ms of closing the control connection")
+	}
+}
+
+func TestUDPSession_IdleTimeout(t *testing.T) {
+	closed := make(chan error, 1)
+
+	socksLn := startSOCKS5Server(t, &socks5.BaseServerHandler{
+		AllowUDPAssociate:   true,
+		UDPAssociateTimeout: 50 * time.Millisecond,
+		SupportedMethods:    []byte{socks5.MethodNoAuth},
+		OnUDPSessionClosed:  func(ctx context.Context, s *socks5.UDPSession, err error) { closed <- err },
+	})
+	defer socksLn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assoc, err := socks5.NewDialer(socksLn.Addr().String(), nil, nil).OpenUDPAssociation(ctx, "tcp", nil)
+	if err != nil {
+		t.Fatalf("OpenUDPAssociation failed: %v", err)
+	}
+	defer assoc.Close()
+
+	select {
+	case err := <-closed:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("OnUDPSessionClosed error = %v, want timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnUDPSessionClosed not called after idle timeout")
+	}
+
+	select {
+	case <-assoc.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("association not ended after idle timeout")
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Errors for username/password authentication replies.
@@ -38,13 +40,13 @@ func (r *UserPassReply) ReadFrom(src io.Reader) (int64, error) {
 
 	n, err := io.ReadFull(src, buf[:])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgUserPassReply, "header", int64(n), err)
 	}
 
 	r.Version = buf[0]
 	r.Status = buf[1]
 
-	return int64(n), r.Validate()
+	return int64(n), parseError(msgUserPassReply, "", int64(n), r.Validate())
 }
 
 // WriteTo writes the authentication reply to an io.Writer.
@@ -56,11 +58,38 @@ func (r *UserPassReply) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for UserPassReply.
+func (r *UserPassReply) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for UserPassReply.
+func (r *UserPassReply) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(r, data)
+}
+
 // Success returns true if STATUS == 0x00.
 func (r *UserPassReply) Success() bool {
 	return r.Status == 0x00
 }
 
+// Equal reports whether UserPassReply r and other have the same version and status.
+func (r *UserPassReply) Equal(other *UserPassReply) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return *r == *other
+}
+
+// Clone returns a copy of r.
+func (r *UserPassReply) Clone() *UserPassReply {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
 // String returns a human-readable representation.
 func (r *UserPassReply) String() string {
 	var status string
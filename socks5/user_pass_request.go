@@ -1,9 +1,13 @@
 package socks5
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+
+	"github.com/33TU/socks/internal"
 )
 
 // Errors for username/password authentication requests.
@@ -12,8 +16,16 @@ var (
 	ErrEmptyUserPassUsername  = errors.New("username cannot be empty")
 	ErrEmptyUserPassPassword  = errors.New("password cannot be empty")
 	ErrUserPassTooLong        = errors.New("username or password too long (max 255)")
+	ErrUsernameTooLong        = fmt.Errorf("username too long (max 255): %w", ErrUserPassTooLong) // also matches ErrUserPassTooLong
+	ErrPasswordTooLong        = fmt.Errorf("password too long (max 255): %w", ErrUserPassTooLong) // also matches ErrUserPassTooLong
+	ErrPasswordTooShort       = errors.New("password shorter than the required minimum")
 )
 
+// UserPassValidateOptions adds server policy to UserPassRequest.ValidateWith.
+type UserPassValidateOptions struct {
+	MinPasswordLength int // Fewest password bytes accepted (0=1, the protocol minimum)
+}
+
 // UserPassRequest represents a username/password authentication request.
 type UserPassRequest struct {
 	Version  byte   // VER (should always be AuthVersionUserPass = 0x01)
@@ -28,6 +40,11 @@ func (r *UserPassRequest) Init(version byte, username, password string) {
 	r.Password = password
 }
 
+// Reset clears the request for reuse.
+func (r *UserPassRequest) Reset() {
+	*r = UserPassRequest{}
+}
+
 // Validate checks for protocol correctness.
 func (r *UserPassRequest) Validate() error {
 	if r.Version != AuthVersionUserPass {
@@ -39,8 +56,24 @@ func (r *UserPassRequest) Validate() error {
 	if len(r.Password) == 0 {
 		return ErrEmptyUserPassPassword
 	}
-	if len(r.Username) > 255 || len(r.Password) > 255 {
-		return ErrUserPassTooLong
+	if len(r.Username) > 255 {
+		return ErrUsernameTooLong
+	}
+	if len(r.Password) > 255 {
+		return ErrPasswordTooLong
+	}
+	return nil
+}
+
+// ValidateWith is Validate that also enforces opts, e.g. for servers requiring
+// a minimum password length. It fails with ErrPasswordTooShort if the password
+// is shorter than opts.MinPasswordLength.
+func (r *UserPassRequest) ValidateWith(opts UserPassValidateOptions) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	if len(r.Password) < opts.MinPasswordLength {
+		return ErrPasswordTooShort
 	}
 	return nil
 }
@@ -53,13 +86,13 @@ func (r *UserPassRequest) ReadFrom(src io.Reader) (int64, error) {
 	// Read VER and ULEN
 	n, err := io.ReadFull(src, hdr[:])
 	if err != nil {
-		return int64(n), err
+		return int64(n), parseError(msgUserPassRequest, "header", int64(n), err)
 	}
 
 	r.Version = hdr[0]
 	ulen := int(hdr[1])
 	if ulen == 0 {
-		return int64(n), ErrEmptyUserPassUsername
+		return int64(n), parseError(msgUserPassRequest, "ULEN", int64(n), ErrEmptyUserPassUsername)
 	}
 
 	// Read username
@@ -67,7 +100,7 @@ func (r *UserPassRequest) ReadFrom(src io.Reader) (int64, error) {
 	n2, err := io.ReadFull(src, username)
 	total := int64(n + n2)
 	if err != nil {
-		return total, err
+		return total, parseError(msgUserPassRequest, "UNAME", total, internal.UnexpectedEOF(err))
 	}
 	r.Username = string(username)
 
@@ -76,24 +109,24 @@ func (r *UserPassRequest) ReadFrom(src io.Reader) (int64, error) {
 	n3, err := io.ReadFull(src, plen[:])
 	total += int64(n3)
 	if err != nil {
-		return total, err
+		return total, parseError(msgUserPassRequest, "PLEN", total, internal.UnexpectedEOF(err))
 	}
 
 	// Read password
 	pwlen := int(plen[0])
 	if pwlen == 0 {
-		return total, ErrEmptyUserPassPassword
+		return total, parseError(msgUserPassRequest, "PLEN", total, ErrEmptyUserPassPassword)
 	}
 
 	password := make([]byte, pwlen)
 	n4, err := io.ReadFull(src, password)
 	total += int64(n4)
 	if err != nil {
-		return total, err
+		return total, parseError(msgUserPassRequest, "PASSWD", total, internal.UnexpectedEOF(err))
 	}
 	r.Password = string(password)
 
-	return total, r.Validate()
+	return total, parseError(msgUserPassRequest, "", total, r.Validate())
 }
 
 // WriteTo writes the username/password request to a writer.
@@ -114,6 +147,33 @@ func (r *UserPassRequest) WriteTo(dst io.Writer) (int64, error) {
 	return int64(n), err
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler for UserPassRequest.
+func (r *UserPassRequest) MarshalBinary() ([]byte, error) {
+	return internal.MarshalBinary(r)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler for UserPassRequest.
+func (r *UserPassRequest) UnmarshalBinary(data []byte) error {
+	return internal.UnmarshalBinary(r, data)
+}
+
+// Equal reports whether UserPassRequest r and other carry the same credentials.
+func (r *UserPassRequest) Equal(other *UserPassRequest) bool {
+	if r == nil || other == nil {
+		return r == other
+	}
+	return *r == *other
+}
+
+// Clone returns a copy of r.
+func (r *UserPassRequest) Clone() *UserPassRequest {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
 // String returns a human-readable representation.
 func (r *UserPassRequest) String() string {
 	return fmt.Sprintf(
@@ -121,3 +181,21 @@ func (r *UserPassRequest) String() string {
 		r.Version, r.Username, len(r.Password),
 	)
 }
+
+// LogValue implements slog.LogValuer. The password is never included, and the
+// username is hashed; see Unredacted.
+func (r *UserPassRequest) LogValue() slog.Value {
+	return r.logValue(false)
+}
+
+func (r *UserPassRequest) logValue(verbatim bool) slog.Value {
+	return slog.GroupValue(slog.String("user", logIdentifier(r.Username, verbatim)))
+}
+
+// MarshalJSON implements json.Marshaler. Only the username is included; the
+// password is never encoded.
+func (r *UserPassRequest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		User string `json:"user"`
+	}{r.Username})
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/33TU/socks/socks5"
@@ -113,3 +114,56 @@ func Test_UserPassRequest_String(t *testing.T) {
 		t.Errorf("expected non-empty String() output")
 	}
 }
+
+func Test_UserPassRequest_Validate_Lengths(t *testing.T) {
+	long := strings.Repeat("u", 255)
+
+	r := &socks5.UserPassRequest{}
+	r.Init(socks5.AuthVersionUserPass, long, "p")
+	if err := r.Validate(); err != nil {
+		t.Fatalf("255-byte username with 1-byte password: expected valid, got %v", err)
+	}
+
+	r.Username = long + "u"
+	err := r.Validate()
+	if !errors.Is(err, socks5.ErrUsernameTooLong) || errors.Is(err, socks5.ErrPasswordTooLong) {
+		t.Errorf("256-byte username: expected ErrUsernameTooLong only, got %v", err)
+	}
+
+	r.Username = "u"
+	r.Password = long + "p"
+	err = r.Validate()
+	if !errors.Is(err, socks5.ErrPasswordTooLong) || errors.Is(err, socks5.ErrUsernameTooLong) {
+		t.Errorf("256-byte password: expected ErrPasswordTooLong only, got %v", err)
+	}
+	if !errors.Is(err, socks5.ErrUserPassTooLong) {
+		t.Errorf("expected %v to still match ErrUserPassTooLong", err)
+	}
+
+	if errors.Is(socks5.ErrUsernameTooLong, socks5.ErrPasswordTooLong) || errors.Is(socks5.ErrPasswordTooLong, socks5.ErrUsernameTooLong) {
+		t.Error("ErrUsernameTooLong and ErrPasswordTooLong must be distinct")
+	}
+}
+
+func Test_UserPassRequest_ValidateWith(t *testing.T) {
+	r := &socks5.UserPassRequest{}
+	r.Init(socks5.AuthVersionUserPass, "alice", "secret")
+
+	opts := socks5.UserPassValidateOptions{MinPasswordLength: 6}
+	if err := r.ValidateWith(opts); err != nil {
+		t.Fatalf("6-byte password with minimum 6: expected valid, got %v", err)
+	}
+
+	r.Password = "short"
+	if err := r.ValidateWith(opts); !errors.Is(err, socks5.ErrPasswordTooShort) {
+		t.Errorf("5-byte password with minimum 6: expected ErrPasswordTooShort, got %v", err)
+	}
+	if err := r.ValidateWith(socks5.UserPassValidateOptions{}); err != nil {
+		t.Errorf("no minimum: expected valid, got %v", err)
+	}
+
+	r.Version = 0x02
+	if err := r.ValidateWith(opts); !errors.Is(err, socks5.ErrInvalidUserPassVersion) {
+		t.Errorf("expected Validate errors first, got %v", err)
+	}
+}